@@ -13,12 +13,14 @@ type MockRPC struct {
 	BlockCount      int64
 	BestBlockHash   string
 	SubmittedBlocks []string
+	Blocks          map[string]*BlockInfo
 
 	// Error overrides
 	GetBlockTemplateErr error
 	SubmitBlockErr      error
 	GetBlockCountErr    error
 	GetBestBlockHashErr error
+	GetBlockErr         error
 }
 
 // NewMockRPC creates a new mock Bitcoin RPC client with sensible defaults.
@@ -34,6 +36,7 @@ func NewMockRPC() *MockRPC {
 			Bits:              "1d00ffff",
 			Height:            800000,
 		},
+		Blocks:        make(map[string]*BlockInfo),
 		BlockCount:    799999,
 		BestBlockHash: "0000000000000003fa0d845513ea5014a7859d411f5f4a91eaab24eb47a18f39",
 	}
@@ -75,3 +78,23 @@ func (m *MockRPC) GetBestBlockHash(_ context.Context) (string, error) {
 	}
 	return m.BestBlockHash, nil
 }
+
+func (m *MockRPC) GetBlock(_ context.Context, hash string) (*BlockInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.GetBlockErr != nil {
+		return nil, m.GetBlockErr
+	}
+	info, ok := m.Blocks[hash]
+	if !ok {
+		return nil, &RPCError{Code: RPCErrBlockNotFound, Message: "Block not found"}
+	}
+	return info, nil
+}
+
+// SetBlock sets the block info returned by GetBlock for hash.
+func (m *MockRPC) SetBlock(hash string, info *BlockInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Blocks[hash] = info
+}
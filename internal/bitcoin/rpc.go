@@ -17,6 +17,7 @@ type BitcoinRPC interface {
 	SubmitBlock(ctx context.Context, blockHex string) error
 	GetBlockCount(ctx context.Context) (int64, error)
 	GetBestBlockHash(ctx context.Context) (string, error)
+	GetBlock(ctx context.Context, hash string) (*BlockInfo, error)
 }
 
 // RPCClient implements BitcoinRPC using JSON-RPC over HTTP.
@@ -100,6 +101,7 @@ func (c *RPCClient) GetBlockTemplate(ctx context.Context) (*BlockTemplate, error
 	if err := json.Unmarshal(result, &tmpl); err != nil {
 		return nil, fmt.Errorf("unmarshal block template: %w", err)
 	}
+	tmpl.resetTxDataCache()
 
 	return &tmpl, nil
 }
@@ -159,3 +161,19 @@ func (c *RPCClient) GetBestBlockHash(ctx context.Context) (string, error) {
 
 	return hash, nil
 }
+
+// GetBlock returns header-level information about a block, including its
+// confirmation count (-1 if it is not on the main chain).
+func (c *RPCClient) GetBlock(ctx context.Context, hash string) (*BlockInfo, error) {
+	result, err := c.call(ctx, "getblock", hash, 1)
+	if err != nil {
+		return nil, fmt.Errorf("getblock: %w", err)
+	}
+
+	var info BlockInfo
+	if err := json.Unmarshal(result, &info); err != nil {
+		return nil, fmt.Errorf("unmarshal block: %w", err)
+	}
+
+	return &info, nil
+}
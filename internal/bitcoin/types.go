@@ -1,13 +1,16 @@
 package bitcoin
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"sync"
 )
 
 // BlockTemplate represents the response from getblocktemplate RPC.
 type BlockTemplate struct {
 	Version                  int32                 `json:"version"`
+	Rules                    []string              `json:"rules"`
 	PreviousBlockHash        string                `json:"previousblockhash"`
 	Transactions             []TemplateTransaction `json:"transactions"`
 	CoinbaseAux              *CoinbaseAux          `json:"coinbaseaux"`
@@ -23,6 +26,65 @@ type BlockTemplate struct {
 	Bits                     string                `json:"bits"`
 	Height                   int64                 `json:"height"`
 	DefaultWitnessCommitment string                `json:"default_witness_commitment"`
+
+	// txData caches the decoded transaction data; see TransactionData.
+	// It sits behind a pointer so BlockTemplate stays copyable, and each
+	// template decodes under its own lock.
+	txData *txDataCache
+}
+
+// txDataCache holds a template's decoded transactions, decoded once.
+type txDataCache struct {
+	once sync.Once
+	data [][]byte
+	err  error
+}
+
+// resetTxDataCache gives t an empty transaction data cache. Templates
+// without one, such as literals built in tests, decode on every call.
+func (t *BlockTemplate) resetTxDataCache() {
+	t.txData = &txDataCache{}
+}
+
+// TransactionData returns the decoded raw bytes of the template's
+// transactions, in template order. They are decoded once and cached, so
+// repeated block reconstructions from the same template don't re-decode
+// megabytes of hex. The returned slices are shared and must not be
+// modified.
+func (t *BlockTemplate) TransactionData() ([][]byte, error) {
+	c := t.txData
+	if c == nil {
+		return decodeTransactions(t.Transactions)
+	}
+	c.once.Do(func() {
+		c.data, c.err = decodeTransactions(t.Transactions)
+	})
+	return c.data, c.err
+}
+
+func decodeTransactions(txs []TemplateTransaction) ([][]byte, error) {
+	if len(txs) == 0 {
+		return nil, nil
+	}
+	data := make([][]byte, len(txs))
+	for i, tx := range txs {
+		raw, err := hex.DecodeString(tx.Data)
+		if err != nil {
+			return nil, fmt.Errorf("decode template tx %s: %w", tx.TxID, err)
+		}
+		data[i] = raw
+	}
+	return data, nil
+}
+
+// WithTransactions returns a copy of t carrying txs in place of its
+// transactions. Fields that depend on the transaction set, such as
+// CoinbaseValue and DefaultWitnessCommitment, are copied unchanged.
+func (t *BlockTemplate) WithTransactions(txs []TemplateTransaction) *BlockTemplate {
+	c := *t
+	c.Transactions = txs
+	c.resetTxDataCache()
+	return &c
 }
 
 // TemplateTransaction represents a transaction in a block template.
@@ -33,6 +95,9 @@ type TemplateTransaction struct {
 	Fee    int64  `json:"fee"`
 	SigOps int    `json:"sigops"`
 	Weight int    `json:"weight"`
+	// Depends lists the 1-based template indexes of the transactions this
+	// one spends outputs of.
+	Depends []int `json:"depends"`
 }
 
 // CoinbaseAux contains auxiliary data for the coinbase.
@@ -68,6 +133,10 @@ type RPCResponse struct {
 	Error   *RPCError       `json:"error"`
 }
 
+// RPCErrBlockNotFound is bitcoind's RPC_INVALID_ADDRESS_OR_KEY code, returned
+// by getblock for unknown block hashes.
+const RPCErrBlockNotFound = -5
+
 // RPCError represents a JSON-RPC error.
 type RPCError struct {
 	Code    int    `json:"code"`
@@ -44,6 +44,42 @@ var (
 		Help:      "Estimated local miner hashrate in H/s.",
 	})
 
+	MinerHashrate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "p2pool",
+		Name:      "miner_hashrate",
+		Help:      "Estimated hashrate of each connected worker in H/s.",
+	}, []string{"miner", "worker"})
+
+	PeersBanned = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "p2pool",
+		Name:      "peers_banned",
+		Help:      "Number of P2P peers currently banned for misbehavior.",
+	})
+
+	PeersProtected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "p2pool",
+		Name:      "peers_protected",
+		Help:      "Number of useful P2P peers protected from connection trims.",
+	})
+
+	P2PBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "p2pool",
+		Name:      "p2p_bytes",
+		Help:      "Total P2P bytes received (in) and sent (out) since start.",
+	}, []string{"direction"})
+
+	P2PProtocolBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "p2pool",
+		Name:      "p2p_protocol_bytes",
+		Help:      "P2P stream bytes received and sent since start, by libp2p protocol.",
+	}, []string{"protocol", "direction"})
+
+	GossipPeerScore = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "p2pool",
+		Name:      "gossip_peer_score",
+		Help:      "Gossipsub peer score distribution (min, median, max).",
+	}, []string{"stat"})
+
 	BlocksFound = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: "p2pool",
 		Name:      "blocks_found_total",
@@ -56,18 +92,50 @@ var (
 		Help:      "Total valid stratum shares accepted.",
 	})
 
-	SharesRejected = prometheus.NewCounter(prometheus.CounterOpts{
+	SharesRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "p2pool",
 		Name:      "stratum_shares_rejected_total",
-		Help:      "Total stratum shares rejected.",
-	})
+		Help:      "Stratum shares rejected, by reason (stale, duplicate, low_difficulty, bad_extranonce, ...).",
+	}, []string{"reason"})
 
 	BlockSubmissions = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "p2pool",
 		Name:      "block_submissions_total",
-		Help:      "Block submission attempts by result.",
+		Help:      "Block submission attempts by result, plus the eventual confirmed or orphaned outcome of successful submissions.",
 	}, []string{"result"})
 
+	SharesPruned = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "p2pool",
+		Name:      "sharechain_pruned_total",
+		Help:      "Total shares pruned from the sharechain store.",
+	})
+
+	DuplicateSharesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "p2pool",
+		Name:      "p2p_duplicate_shares_dropped_total",
+		Help:      "Gossiped shares dropped because their header was already seen.",
+	})
+
+	SyncRequestsLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "p2pool",
+		Name:      "p2p_sync_requests_limited_total",
+		Help:      "Inbound sync requests refused because the peer exceeded its request rate.",
+	})
+
+	ShareLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "p2pool",
+		Name:      "stratum_share_latency_seconds",
+		Help:      "Time from issuing a job to accepting a share submitted against it.",
+		Buckets:   prometheus.ExponentialBucketsRange(0.001, 10, 13),
+	})
+
+	P2PShareDelay = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "p2pool",
+		Name:      "p2p_share_delay_seconds",
+		Help:      "Local receive time minus the timestamp of accepted P2P shares; negative values indicate clock skew.",
+		Buckets:   []float64{-60, -10, -1, 0, 1, 5, 10, 30, 60, 120, 300, 600},
+	})
+
 	UptimeSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: "p2pool",
 		Name:      "uptime_seconds",
@@ -80,13 +148,24 @@ func init() {
 		SharechainHeight,
 		MinersConnected,
 		PeersConnected,
+		PeersBanned,
+		PeersProtected,
+		P2PBytes,
+		P2PProtocolBytes,
+		GossipPeerScore,
 		ShareDifficulty,
 		PoolHashrate,
 		LocalHashrate,
+		MinerHashrate,
 		BlocksFound,
 		SharesAccepted,
 		SharesRejected,
 		BlockSubmissions,
+		SharesPruned,
+		DuplicateSharesDropped,
+		SyncRequestsLimited,
+		ShareLatency,
+		P2PShareDelay,
 		UptimeSeconds,
 	)
 }
@@ -0,0 +1,160 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/djkazic/p2pool-go/internal/bitcoin"
+	"github.com/djkazic/p2pool-go/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// confirmationDepth is how many confirmations a found block needs before
+	// we stop watching it; matches coinbase maturity.
+	confirmationDepth = 100
+	// confirmationPollInterval is how often submitted blocks are checked.
+	confirmationPollInterval = time.Minute
+	// confirmationNotFoundLimit is how many polls in a row must fail to find
+	// a block before it is taken as orphaned. A node that is reindexing or
+	// still catching up can briefly not know a block it accepted.
+	confirmationNotFoundLimit = 3
+)
+
+// confirmationWatcher follows blocks we submitted until they are deeply
+// confirmed or orphaned.
+type confirmationWatcher struct {
+	rpc    bitcoin.BitcoinRPC
+	logger *zap.Logger
+
+	mu     sync.Mutex
+	blocks map[string]*watchedBlock
+}
+
+// watchedBlock is a submitted block awaiting confirmation.
+type watchedBlock struct {
+	height        int64
+	confirmations int64
+	// notFound counts consecutive polls that could not find the block.
+	notFound int
+	// resolved, if set, is called once the block is confirmed or orphaned.
+	resolved func(orphaned bool)
+}
+
+func newConfirmationWatcher(rpc bitcoin.BitcoinRPC, logger *zap.Logger) *confirmationWatcher {
+	return &confirmationWatcher{
+		rpc:    rpc,
+		logger: logger,
+		blocks: make(map[string]*watchedBlock),
+	}
+}
+
+// watch starts following a submitted block. resolved may be nil.
+func (w *confirmationWatcher) watch(hash string, height int64, resolved func(orphaned bool)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.blocks[hash] = &watchedBlock{height: height, resolved: resolved}
+}
+
+// pending returns the number of blocks still being watched.
+func (w *confirmationWatcher) pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.blocks)
+}
+
+// run checks watched blocks every confirmationPollInterval until ctx is done.
+func (w *confirmationWatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(confirmationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check(ctx)
+		}
+	}
+}
+
+// check polls bitcoind once for every watched block.
+func (w *confirmationWatcher) check(ctx context.Context) {
+	w.mu.Lock()
+	hashes := make([]string, 0, len(w.blocks))
+	for hash := range w.blocks {
+		hashes = append(hashes, hash)
+	}
+	w.mu.Unlock()
+
+	for _, hash := range hashes {
+		rpcCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		info, err := w.rpc.GetBlock(rpcCtx, hash)
+		cancel()
+
+		var rpcErr *bitcoin.RPCError
+		switch {
+		case errors.As(err, &rpcErr) && rpcErr.Code == bitcoin.RPCErrBlockNotFound:
+			w.mu.Lock()
+			var missing int
+			if b, ok := w.blocks[hash]; ok {
+				b.notFound++
+				missing = b.notFound
+			}
+			w.mu.Unlock()
+			if missing >= confirmationNotFoundLimit {
+				w.orphaned(hash, "not found")
+			}
+		case err != nil:
+			w.logger.Debug("block confirmation check failed", zap.String("hash", hash), zap.Error(err))
+		case info.Confirmations < 0:
+			w.orphaned(hash, "not on main chain")
+		case info.Confirmations >= confirmationDepth:
+			w.mu.Lock()
+			b, ok := w.blocks[hash]
+			delete(w.blocks, hash)
+			w.mu.Unlock()
+			if ok && b.resolved != nil {
+				b.resolved(false)
+			}
+			metrics.BlockSubmissions.WithLabelValues("confirmed").Inc()
+			w.logger.Info("found block confirmed",
+				zap.String("hash", hash),
+				zap.Int64("height", info.Height),
+				zap.Int64("confirmations", info.Confirmations),
+			)
+		default:
+			w.mu.Lock()
+			if b, ok := w.blocks[hash]; ok {
+				b.confirmations = info.Confirmations
+				b.notFound = 0
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// orphaned stops watching a block that fell out of the main chain.
+func (w *confirmationWatcher) orphaned(hash, reason string) {
+	w.mu.Lock()
+	b, ok := w.blocks[hash]
+	delete(w.blocks, hash)
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	metrics.BlockSubmissions.WithLabelValues("orphaned").Inc()
+	w.logger.Error("FOUND BLOCK ORPHANED — its payouts are lost",
+		zap.String("hash", hash),
+		zap.Int64("height", b.height),
+		zap.Int64("last_confirmations", b.confirmations),
+		zap.String("reason", reason),
+	)
+	if b.resolved != nil {
+		b.resolved(true)
+	}
+}
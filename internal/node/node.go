@@ -2,14 +2,18 @@ package node
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
 	"math/big"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/djkazic/p2pool-go/internal/bitcoin"
@@ -40,24 +44,44 @@ type Node struct {
 	logger *zap.Logger
 
 	bitcoinRPC bitcoin.BitcoinRPC
+	submitter  *bitcoin.BlockSubmitter
+	blockWatch *confirmationWatcher
 	store      sharechain.ShareStore
 	chain      *sharechain.ShareChain
+	diffCalc   *sharechain.DifficultyCalculator
 	pplnsCalc  *pplns.Calculator
+	ledger     *pplns.Ledger // nil unless dust is carried forward
 	stratumSrv *stratum.Server
 	workGen    *work.Generator
 	p2pNode    *p2p.Node
 
+	// broadcastShare publishes a local share to peers; p2pNode.BroadcastShare
+	// once Start has run.
+	broadcastShare func(*p2p.ShareMsg) error
+
 	minerAddress string
 
 	// Sync: only one sync cycle runs at a time
 	syncMu sync.Mutex
 
+	// Shares received before their parent, waiting to be connected, and
+	// the per-peer budget for fetching what they miss
+	orphans   *orphanPool
+	backfills *backfillLimiter
+
 	// Reorg tracking: skip duplicate EventNewTip after reorg
 	lastReorgTip [32]byte
 
+	// Last tip broadcast in a TipAnnounce (event loop only)
+	lastAnnouncedTip [32]byte
+
 	// Diagnostics
-	shareRejectCount uint64
-	startTime        time.Time
+	shareRejectCount atomic.Uint64
+
+	// started is set once Start has brought up every subsystem, and
+	// cleared when Stop begins.
+	started   atomic.Bool
+	startTime time.Time
 
 	// Local hashrate tracking (rolling window of valid stratum shares)
 	localShares   []localShareEvent
@@ -66,8 +90,13 @@ type Node struct {
 	// Last Bitcoin block found by the pool
 	lastBlockTime time.Time
 	lastBlockHash string
+	blocksFound   int // since startup
 	lastBlockMu   sync.RWMutex
 
+	// Worker label pairs currently exported on metrics.MinerHashrate
+	// (event loop only)
+	minerHashrateLabels map[[2]string]struct{}
+
 	// Dashboard graph history (ring buffer, recorded every status tick)
 	graphHistory   []web.HistoryPoint
 	graphHistoryMu sync.Mutex
@@ -88,11 +117,22 @@ func NewNode(cfg *config.Config, minerAddress string, logger *zap.Logger) *Node
 		config:       cfg,
 		logger:       logger,
 		minerAddress: minerAddress,
+		orphans:      newOrphanPool(),
+		backfills:    newBackfillLimiter(),
 	}
 }
 
 // Start initializes and starts all subsystems.
 func (n *Node) Start(ctx context.Context) error {
+	network, err := types.ParseNetwork(n.config.BitcoinNetwork)
+	if err != nil {
+		return err
+	}
+	network, err = sharechain.WithMinShareDifficulty(network, n.config.MinShareDifficulty)
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 	n.cancel = cancel
 
@@ -110,69 +150,211 @@ func (n *Node) Start(ctx context.Context) error {
 	}
 	n.logger.Info("connected to bitcoind", zap.Int64("height", height))
 
+	endpoints := []bitcoin.SubmitEndpoint{{
+		Name: fmt.Sprintf("%s:%d", n.config.BitcoinRPCHost, n.config.BitcoinRPCPort),
+		RPC:  n.bitcoinRPC,
+	}}
+	for _, rawURL := range n.config.SubmitBlockRPC {
+		ep, err := bitcoin.ParseSubmitEndpoint(rawURL)
+		if err != nil {
+			return fmt.Errorf("submit-block-rpc: %w", err)
+		}
+		endpoints = append(endpoints, ep)
+	}
+	n.submitter = bitcoin.NewBlockSubmitter(endpoints...)
+	n.blockWatch = newConfirmationWatcher(n.bitcoinRPC, n.logger)
+	go n.blockWatch.run(ctx)
+
 	// Sharechain
 	if err := os.MkdirAll(n.config.DataDir, 0700); err != nil {
 		return fmt.Errorf("create data dir: %w", err)
 	}
-	store, err := sharechain.NewBoltStore(filepath.Join(n.config.DataDir, "sharechain.db"), n.logger)
-	if err != nil {
-		return fmt.Errorf("open sharechain store: %w", err)
+	var store sharechain.ShareStore
+	if n.config.MemoryStore {
+		n.logger.Warn("sharechain kept in memory only; it will be lost on exit")
+		store = sharechain.NewMemoryStore()
+	} else {
+		store, err = sharechain.NewBoltStore(filepath.Join(n.config.DataDir, "sharechain.db"), n.logger)
+		if err != nil {
+			return fmt.Errorf("open sharechain store: %w", err)
+		}
 	}
 	n.store = store
-	diffCalc := sharechain.NewDifficultyCalculator(n.config.ShareTargetTime)
-	n.chain = sharechain.NewShareChain(store, diffCalc, n.config.PPLNSWindowSize, n.config.BitcoinNetwork, n.logger)
+	diffAlgo, err := sharechain.DifficultyAlgoByName(n.config.DifficultyAlgo)
+	if err != nil {
+		return fmt.Errorf("select difficulty algorithm: %w", err)
+	}
+	n.diffCalc = sharechain.NewDifficultyCalculator(n.config.ShareTargetTime, n.config.DifficultyWindow, diffAlgo)
+	n.chain = sharechain.NewShareChain(store, n.diffCalc, n.config.PPLNSWindowSize, network, n.logger)
 
+	checkpoints := sharechain.DefaultCheckpoints(n.config.BitcoinNetwork)
+	for _, raw := range n.config.Checkpoints {
+		cp, err := sharechain.ParseCheckpoint(raw)
+		if err != nil {
+			return err
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	n.chain.SetShareV2Height(n.config.ShareV2Height)
+	if !types.IsSegwitAddress(n.minerAddress, network) {
+		// Version 1 shares paying a legacy address are invalid.
+		if n.config.ShareV2Height < 0 {
+			return fmt.Errorf("legacy payout address %s needs version 2 shares: set share-v2-height", n.minerAddress)
+		}
+		n.logger.Warn("legacy payout address: shares only count once version 2 activates",
+			zap.Int64("share_v2_height", n.config.ShareV2Height))
+	}
+	if err := n.chain.SetCheckpoints(checkpoints); err != nil {
+		return fmt.Errorf("set checkpoints: %w", err)
+	}
+
+	if _, err := n.chain.RebuildTip(); err != nil {
+		return fmt.Errorf("rebuild sharechain tip: %w", err)
+	}
 	if err := n.chain.ValidateLoaded(); err != nil {
 		return fmt.Errorf("sharechain validation failed: %w", err)
 	}
+	if cp, ok := n.chain.PinBase(); ok {
+		n.logger.Info("pinned sharechain base",
+			zap.Int64("height", cp.Height),
+			zap.String("hash", util.HashToHex(cp.Hash)))
+	}
 
 	// Scan chain for the most recent Bitcoin block found
 	n.initLastBlock()
 
 	// PPLNS Calculator
 	n.pplnsCalc = pplns.NewCalculator(n.config.FinderFeePercent, n.config.DustThresholdSats)
-
-	// Stratum Server
-	n.stratumSrv = stratum.NewServer(n.config.StartDifficulty, n.logger)
-	n.startTime = time.Now()
-
-	// Web dashboard (served on the same port as stratum)
-	webHandler := web.NewHandler(n.dashboardData, n.lookupShare)
-	n.stratumSrv.SetHTTPHandler(webHandler)
-
-	if err := n.stratumSrv.Start(fmt.Sprintf("0.0.0.0:%d", n.config.StratumPort)); err != nil {
-		return fmt.Errorf("stratum server: %w", err)
+	if n.config.CarryDust {
+		balances, _ := n.store.(pplns.BalanceStore)
+		n.ledger, err = pplns.NewLedger(balances)
+		if err != nil {
+			return fmt.Errorf("open payout ledger: %w", err)
+		}
+		n.pplnsCalc.SetLedger(n.ledger)
 	}
 
-	// Work Generator
+	// Work Generator (created before the stratum server so sessions can
+	// validate job IDs; polling starts once the server is listening)
 	n.workGen = work.NewGenerator(
 		n.bitcoinRPC,
-		n.config.BitcoinNetwork,
+		network,
 		8, // extranonce1 (4 bytes) + extranonce2 (4 bytes)
 		n.getPayouts,
 		n.getPrevShareHash,
 		n.logger,
 	)
+	n.workGen.SetStaleGrace(n.config.StaleJobGrace)
+	n.workGen.SetQuickJobTxs(n.config.QuickJobTxs)
+	layout, err := n.config.ExtranonceLayout()
+	if err != nil {
+		return err
+	}
+	if err := n.workGen.SetExtranonceLayout(layout); err != nil {
+		return err
+	}
+	n.workGen.SetUnclesFunc(n.chain.SelectUncles)
+	n.workGen.SetShareVersion(n.chain.ShareVersionFor, n.minerAddress)
+	n.chain.SetMinTimeFunc(n.templateMinTime)
+	n.chain.SetCoinbaseValueFunc(n.templateCoinbaseValue)
+
+	// Build one block from a live template and check it reconstructs, so a
+	// broken coinbase or merkle path stops startup instead of costing a
+	// found block. A template that can't be fetched yet (bitcoind still
+	// syncing) only skips the check.
+	if tmpl, err := n.bitcoinRPC.GetBlockTemplate(ctx); err != nil {
+		n.logger.Warn("skipping block reconstruction self-check", zap.Error(err))
+	} else if err := n.workGen.SelfCheck(tmpl); err != nil {
+		return fmt.Errorf("block reconstruction self-check failed: %w", err)
+	}
+
+	// Stratum Server
+	n.stratumSrv = stratum.NewServer(n.config.StartDifficulty, n.logger)
+	n.stratumSrv.SetJobValidator(func(jobID string) bool {
+		return n.workGen.GetJob(jobID) != nil
+	})
+	n.stratumSrv.SetShareValidator(n.validateSubmission)
+	n.stratumSrv.SetRejectHandler(n.recordRejectedShare)
+	n.startTime = time.Now()
+
+	// Web dashboard (served on the same port as stratum)
+	webHandler := web.NewHandler(n.dashboardData, n.statsData, n.lookupShare)
+	httpMux := http.NewServeMux()
+	httpMux.Handle("/admin/", web.NewAdminHandler(web.Admin{
+		ConnectPeer: n.ConnectPeer,
+		Token:       n.config.AdminToken,
+	}))
+	healthHandler := web.NewHealthHandler(n.readiness)
+	httpMux.Handle("/healthz", healthHandler)
+	httpMux.Handle("/readyz", healthHandler)
+	httpMux.Handle("/stats/window", web.NewWindowHandler(n.windowShares))
+	httpMux.Handle("/", webHandler)
+	n.stratumSrv.SetHTTPHandler(httpMux)
+	n.stratumSrv.SetIdleTimeout(n.config.StratumIdleTimeout)
+	n.stratumSrv.SetKeepaliveInterval(n.config.StratumKeepalive)
+
+	stratumPort := stratum.PortConfig{
+		Addr:            fmt.Sprintf("0.0.0.0:%d", n.config.StratumPort),
+		StartDifficulty: n.config.StartDifficulty,
+		ProxyProtocol:   n.config.StratumProxyProtocol,
+	}
+	stratumPorts := []stratum.PortConfig{stratumPort}
+	if n.config.StratumTLSPort != 0 {
+		cert, err := tls.LoadX509KeyPair(n.config.StratumTLSCert, n.config.StratumTLSKey)
+		if err != nil {
+			return fmt.Errorf("load stratum TLS certificate: %w", err)
+		}
+		tlsPort := stratumPort
+		tlsPort.Addr = fmt.Sprintf("0.0.0.0:%d", n.config.StratumTLSPort)
+		tlsPort.TLS = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		stratumPorts = append(stratumPorts, tlsPort)
+	}
+	if err := n.stratumSrv.StartPorts(stratumPorts); err != nil {
+		return fmt.Errorf("stratum server: %w", err)
+	}
+
 	n.workGen.Start(ctx)
 
 	// P2P Node — create host and register handlers before discovery starts
-	n.p2pNode, err = p2p.NewNode(ctx, n.config.P2PPort, n.config.DataDir, n.logger)
+	var peerFilter *p2p.PeerFilter
+	switch {
+	case n.config.PeerAllowlist != "":
+		peerFilter, err = p2p.NewPeerFilter(n.config.PeerAllowlist, true)
+	case n.config.PeerDenylist != "":
+		peerFilter, err = p2p.NewPeerFilter(n.config.PeerDenylist, false)
+	}
+	if err != nil {
+		return fmt.Errorf("load peer list: %w", err)
+	}
+	n.p2pNode, err = p2p.NewNode(ctx, n.config.P2PPort, n.config.DataDir, n.config.BitcoinNetwork, n.config.EnableNAT,
+		n.config.PoolSecret, n.config.PoolPSK, peerFilter, n.logger)
 	if err != nil {
 		return fmt.Errorf("p2p node: %w", err)
 	}
+	n.broadcastShare = n.p2pNode.BroadcastShare
 
 	// Register sync protocol BEFORE discovery so peers can't connect
 	// before the handler is ready (fixes "protocols not supported" race)
-	n.p2pNode.InitSyncer(n.handleInvRequest, n.handleDataRequest)
+	n.p2pNode.InitSyncer(n.handleInvRequest, n.handleDataRequest, n.handleShareRequest)
+	n.p2pNode.InitHandshake(sharechain.ChainID(network, n.diffCalc))
 
-	// Now start discovery — peers will find us with all handlers registered
-	allBootnodes := append(config.DefaultBootnodes(n.config.BitcoinNetwork), n.config.P2PBootnodes...)
+	// Now start discovery — peers will find us with all handlers registered.
+	// Private pools don't use the public bootnodes.
+	var allBootnodes []string
+	if n.config.PoolSecret == "" {
+		allBootnodes = config.DefaultBootnodes(n.config.BitcoinNetwork)
+	}
+	allBootnodes = append(allBootnodes, n.config.P2PBootnodes...)
 	if err := n.p2pNode.StartDiscovery(ctx, n.config.EnableMDNS, allBootnodes); err != nil {
 		return fmt.Errorf("p2p discovery: %w", err)
 	}
 
 	// Start event loop
 	go n.eventLoop(ctx)
+	n.started.Store(true)
 
 	n.logger.Info("p2pool node started",
 		zap.String("miner_address", n.minerAddress),
@@ -183,15 +365,33 @@ func (n *Node) Start(ctx context.Context) error {
 	return nil
 }
 
+// readiness is the /readyz check: the node is ready to take miners once it
+// has started, has a block template that bitcoind still refreshes, and can
+// find sharechain peers. It only reads cached state.
+func (n *Node) readiness() error {
+	if !n.started.Load() {
+		return errors.New("node not running")
+	}
+	if err := n.workGen.TemplateHealth(); err != nil {
+		return err
+	}
+	if n.p2pNode.PeerCount() == 0 && !n.config.EnableMDNS {
+		return errors.New("no p2p peers")
+	}
+	return nil
+}
+
 // Stop gracefully stops all subsystems.
 func (n *Node) Stop() {
 	n.logger.Info("shutting down p2pool node...")
+	n.started.Store(false)
 
 	if n.cancel != nil {
 		n.cancel()
 	}
 	if n.stratumSrv != nil {
-		n.stratumSrv.Stop()
+		host, port, _ := n.config.ShutdownReconnectAddr()
+		n.stratumSrv.Shutdown(host, port, stratum.ShutdownDrainDelay)
 	}
 	if n.p2pNode != nil {
 		n.p2pNode.Close()
@@ -214,6 +414,9 @@ func (n *Node) eventLoop(ctx context.Context) {
 	pruneTicker := time.NewTicker(5 * time.Minute)
 	defer pruneTicker.Stop()
 
+	tipTicker := time.NewTicker(n.config.TipAnnounceInterval)
+	defer tipTicker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -229,7 +432,11 @@ func (n *Node) eventLoop(ctx context.Context) {
 
 		// Share from P2P network
 		case shareMsg := <-n.p2pNode.IncomingShares():
-			n.handleP2PShare(shareMsg)
+			n.handleP2PShare(ctx, shareMsg)
+
+		// Tip announcement from P2P network
+		case tip := <-n.p2pNode.IncomingTips():
+			n.handleTipAnnounce(ctx, tip)
 
 		// Sharechain events (new tip, new block, reorg)
 		case event := <-chainEvents:
@@ -239,16 +446,24 @@ func (n *Node) eventLoop(ctx context.Context) {
 		case <-n.p2pNode.PeerConnected():
 			go n.syncFromAllPeers(ctx)
 
+		// Periodic tip announcement
+		case <-tipTicker.C:
+			n.announceTip()
+
 		// Periodic status log
 		case <-statusTicker.C:
 			n.logStatus()
 
 		// Periodic orphan and old-share pruning
 		case <-pruneTicker.C:
-			if pruned := n.chain.PruneOrphans(); pruned > 0 {
-				n.logger.Info("pruned orphan shares", zap.Int("count", pruned))
+			pruned, err := n.chain.Prune(n.config.PPLNSWindowSize * 2)
+			if err != nil {
+				n.logger.Warn("sharechain prune failed", zap.Error(err))
+			}
+			metrics.SharesPruned.Add(float64(pruned))
+			if expired := n.orphans.expire(); expired > 0 {
+				n.logger.Debug("expired orphan shares", zap.Int("count", expired))
 			}
-			n.chain.PruneOldShares(n.config.PPLNSWindowSize * 2)
 		}
 	}
 }
@@ -274,24 +489,38 @@ func (n *Node) handleNewJob(job *work.JobData) {
 	)
 }
 
-func (n *Node) handleSubmission(sub *stratum.ShareSubmission) {
-	n.logger.Debug("share submission",
-		zap.String("worker", sub.WorkerName),
-		zap.String("job", sub.JobID),
-		zap.String("nonce", sub.Nonce),
-	)
+// checkedSubmission is a stratum submission whose proof of work has been
+// verified against the miner's stratum difficulty.
+type checkedSubmission struct {
+	job                *work.JobData
+	header             []byte
+	coinbase           []byte
+	headerHash         [32]byte
+	version            string
+	acceptedDifficulty float64
+}
 
+// checkSubmission reconstructs a submission's header and checks it against
+// the miner's stratum difficulty. It has no side effects, and the returned
+// errors are stratum errors meant for the miner.
+func (n *Node) checkSubmission(sub *stratum.ShareSubmission) (*checkedSubmission, error) {
 	// 1. Look up the job
 	job := n.workGen.GetJob(sub.JobID)
 	if job == nil {
-		n.logger.Debug("rejected share: stale or unknown job", zap.String("job_id", sub.JobID))
-		return
+		return nil, stratum.ErrJobNotFound
 	}
 
-	// 2. Compute the actual block version (apply BIP 310 version rolling if used)
+	// 2. Compute the actual block version (apply BIP 310 version rolling if used).
+	// Whatever mask the session negotiated, only bits in the server's mask
+	// may differ from the template version.
 	version := job.Version
+	var rollingMask uint32
 	if sub.VersionBits != "" {
-		version = applyVersionRolling(job.Version, sub.VersionBits)
+		version = applyVersionRolling(job.Version, sub.VersionBits, sub.VersionMask)
+		rollingMask = serverVersionRollingMask
+	}
+	if err := work.CheckVersionRolling(job, version, rollingMask); err != nil {
+		return nil, stratum.NewError(fmt.Sprintf("Invalid version: %v", err))
 	}
 
 	// 3. Reconstruct the block header and coinbase from the submission
@@ -304,8 +533,7 @@ func (n *Node) handleSubmission(sub *stratum.ShareSubmission) {
 		sub.Nonce,
 	)
 	if err != nil {
-		n.logger.Warn("failed to reconstruct header from submission", zap.Error(err))
-		return
+		return nil, stratum.NewError(fmt.Sprintf("Invalid share: %v", err))
 	}
 
 	// 4. Hash the header (double-SHA256)
@@ -325,72 +553,139 @@ func (n *Node) handleSubmission(sub *stratum.ShareSubmission) {
 			acceptedDifficulty = sub.PrevDifficulty
 		}
 	}
+
+	checked := &checkedSubmission{
+		job:                job,
+		header:             header,
+		coinbase:           coinbaseBytes,
+		headerHash:         headerHash,
+		version:            version,
+		acceptedDifficulty: acceptedDifficulty,
+	}
 	if !meetsTarget {
-		n.shareRejectCount++
-		metrics.SharesRejected.Inc()
-		if n.shareRejectCount == 1 || n.shareRejectCount%1000 == 0 {
+		return checked, stratum.ErrLowDifficulty
+	}
+	return checked, nil
+}
+
+// validateSubmission is the stratum share validator. It runs on the miner's
+// session goroutine so the miner gets an accurate verdict, and accounts for
+// the share in metrics and worker stats. Accepted shares then reach
+// handleSubmission through the submit channel, carrying their
+// checkedSubmission.
+func (n *Node) validateSubmission(sub *stratum.ShareSubmission) error {
+	n.logger.Debug("share submission",
+		zap.String("worker", sub.WorkerName),
+		zap.String("job", sub.JobID),
+		zap.String("nonce", sub.Nonce),
+	)
+
+	checked, err := n.checkSubmission(sub)
+	switch {
+	case errors.Is(err, stratum.ErrJobNotFound):
+		n.stratumSrv.RecordShareResult(sub.WorkerName, sub.Difficulty, false)
+		return err
+	case errors.Is(err, stratum.ErrLowDifficulty):
+		rejected := n.shareRejectCount.Add(1)
+		n.stratumSrv.RecordShareResult(sub.WorkerName, sub.Difficulty, false)
+		if rejected == 1 || rejected%1000 == 0 {
 			n.logger.Info("share below stratum difficulty (possible header reconstruction mismatch)",
 				zap.String("worker", sub.WorkerName),
 				zap.Float64("difficulty", sub.Difficulty),
-				zap.String("hash", util.HashToHex(headerHash)),
-				zap.String("version", version),
+				zap.String("hash", util.HashToHex(checked.headerHash)),
+				zap.String("version", checked.version),
 				zap.String("version_bits", sub.VersionBits),
 				zap.String("nonce", sub.Nonce),
 				zap.String("extranonce1", sub.Extranonce1),
 				zap.String("extranonce2", sub.Extranonce2),
 				zap.String("ntime", sub.NTime),
 				zap.String("job_id", sub.JobID),
-				zap.Uint64("total_rejected", n.shareRejectCount),
+				zap.Uint64("total_rejected", rejected),
 			)
 		}
-		return
+		return err
+	case err != nil:
+		n.logger.Warn("failed to reconstruct header from submission", zap.Error(err))
+		return err
 	}
+
 	n.logger.Debug("valid stratum share",
 		zap.String("worker", sub.WorkerName),
-		zap.String("hash", util.HashToHex(headerHash)),
+		zap.String("hash", util.HashToHex(checked.headerHash)),
 	)
 
 	// Record for local hashrate estimation using the difficulty the share
 	// actually met, not the current vardiff (which may have just increased).
 	metrics.SharesAccepted.Inc()
-	n.recordLocalShare(acceptedDifficulty, sub.WorkerName)
+	if !checked.job.CreatedAt.IsZero() {
+		metrics.ShareLatency.Observe(time.Since(checked.job.CreatedAt).Seconds())
+	}
+	n.recordLocalShare(checked.acceptedDifficulty, sub.WorkerName)
+	n.stratumSrv.RecordShareResult(sub.WorkerName, checked.acceptedDifficulty, true)
+	sub.Checked = checked
+	return nil
+}
+
+// recordRejectedShare is the stratum reject handler: it counts the
+// rejection by reason and logs it, so a miner sending garbage can be told
+// apart from jobs going stale on the pool side.
+func (n *Node) recordRejectedShare(rej stratum.ShareRejection) {
+	metrics.SharesRejected.WithLabelValues(rej.Reason).Inc()
+	n.logger.Debug("rejected share",
+		zap.String("reason", rej.Reason),
+		zap.Int("code", rej.Err.Code),
+		zap.String("error", rej.Err.Message),
+		zap.String("worker", rej.Worker),
+		zap.String("job_id", rej.JobID),
+	)
+}
+
+// handleSubmission checks a share accepted by validateSubmission against
+// the sharechain and Bitcoin targets, reusing the header validation built.
+func (n *Node) handleSubmission(sub *stratum.ShareSubmission) {
+	checked, ok := sub.Checked.(*checkedSubmission)
+	if !ok {
+		n.logger.Warn("dropping unchecked submission", zap.String("job", sub.JobID))
+		return
+	}
+	job, header, coinbaseBytes, headerHash := checked.job, checked.header, checked.coinbase, checked.headerHash
 
 	// 6. Check against sharechain difficulty.
-	// Use the share's actual parent (from coinbase commitment) rather than the
-	// current tip — the tip may have moved since job creation, especially during
-	// rapid difficulty ramps where many shares are added per second.
-	prevShareHash, err := types.ExtractShareCommitment(coinbaseBytes)
+	// Use the share's actual parent (as committed by the job's coinbase) rather
+	// than the current tip — the tip may have moved since job creation,
+	// especially during rapid difficulty ramps where many shares are added per
+	// second.
+	committed, err := types.ExtractShareCommitment(coinbaseBytes)
 	if err != nil {
 		n.logger.Warn("failed to extract share commitment for target check", zap.Error(err))
 		return
 	}
-	shareTarget := n.chain.GetExpectedTargetForParent(prevShareHash)
-	if !util.HashMeetsTarget(headerHash, shareTarget) {
-		return // Valid stratum share but doesn't meet sharechain difficulty
+	if committed != types.VersionedCommitmentHash(job.ShareVersion, job.PrevShareHash, job.Uncles, n.minerAddress, job.ShareHeight) {
+		n.logger.Warn("coinbase commitment does not match job", zap.String("job", sub.JobID))
+		return
 	}
-
-	// This share meets the sharechain target - add to chain and broadcast
+	shareTarget := n.chain.GetExpectedTargetForParent(job.PrevShareHash)
 	share := n.buildShareFromHeader(header, coinbaseBytes, shareTarget, job)
 	if share == nil {
 		return
 	}
-	if err := n.chain.AddShare(share); err != nil {
-		n.logger.Warn("failed to add local share to chain", zap.Error(err))
-		return
-	}
-
-	n.logger.Debug("sharechain share found",
-		zap.String("hash", util.HashToHex(headerHash)),
-		zap.String("miner", sub.WorkerName),
-		zap.Int64("height", job.Height),
-	)
+	meetsShare := util.HashMeetsTarget(headerHash, shareTarget)
 
-	// Broadcast via P2P
-	n.p2pNode.BroadcastShare(shareToP2PMsg(share))
+	// 7. Check against Bitcoin network difficulty. A block is submitted even
+	// if it misses the sharechain target, which can happen on test networks
+	// where the share target may be harder than the block target, or if the
+	// sharechain refuses the share: its coinbase pays the window either way.
+	isBlock := util.HashMeetsTarget(headerHash, util.CompactToTarget(share.Header.Bits))
+	if !meetsShare && !isBlock {
+		return // Valid stratum share but doesn't meet sharechain difficulty
+	}
 
-	// 7. Check against Bitcoin network difficulty
-	btcTarget := util.CompactToTarget(share.Header.Bits)
-	if util.HashMeetsTarget(headerHash, btcTarget) {
+	// Publish the share before submitting a block, so peers credit the
+	// finder and build on it without waiting on bitcoind.
+	if meetsShare {
+		n.publishLocalShare(share, sub, job)
+	}
+	if isBlock {
 		hashHex := util.HashToHex(headerHash)
 		n.logger.Info("BITCOIN BLOCK FOUND!",
 			zap.String("hash", hashHex),
@@ -399,21 +694,217 @@ func (n *Node) handleSubmission(sub *stratum.ShareSubmission) {
 		)
 		metrics.BlocksFound.Inc()
 		n.recordBlockFound(hashHex)
-		n.submitBlock(header, coinbaseBytes, job.Template)
+		// The job's payouts name n.minerAddress as the PPLNS finder, the same
+		// address the share carries.
+		n.saveBlockRecord(hashHex, share.MinerAddress, job)
+		n.submitBlock(header, coinbaseBytes, job)
 	}
 }
 
-func (n *Node) handleP2PShare(msg *p2p.ShareMsg) {
-	share := p2pShareToShare(msg)
-	if share == nil {
-		n.logger.Debug("rejected P2P share: failed to decompress coinbase")
+// publishLocalShare adds a share mined by our own miners to the chain and
+// broadcasts it to peers, once. A share the chain refuses is not broadcast.
+func (n *Node) publishLocalShare(share *types.Share, sub *stratum.ShareSubmission, job *work.JobData) {
+	if err := n.chain.AddShare(share); err != nil {
+		fields := []zap.Field{zap.String("worker", sub.WorkerName), zap.String("job_id", sub.JobID), zap.Error(err)}
+		var verr *sharechain.ValidationError
+		if errors.As(err, &verr) {
+			fields = append(fields, zap.String("reason", verr.Reason))
+		}
+		n.logger.Warn("failed to add local share to chain", fields...)
+		return
+	}
+	n.connectOrphans(share.Hash())
+
+	n.logger.Debug("sharechain share found",
+		zap.String("hash", share.HashHex()),
+		zap.String("miner", sub.WorkerName),
+		zap.Int64("height", job.Height),
+	)
+
+	if err := n.broadcastShare(p2p.ShareToShareMsg(share)); err != nil {
+		n.logger.Warn("failed to broadcast local share", zap.String("hash", share.HashHex()), zap.Error(err))
+	}
+}
+
+func (n *Node) handleP2PShare(ctx context.Context, msg *p2p.ShareMsg) {
+	share, err := p2p.ShareMsgToShare(msg, n.chain.MaxShareTarget())
+	if err != nil {
+		n.logger.Debug("rejected malformed P2P share", zap.Error(err))
+		n.p2pNode.PenalizePeer(msg.ReceivedFrom, p2p.PenaltyMalformedMessage)
 		return
 	}
 	if err := n.chain.AddShare(share); err != nil {
+		// A missing parent or uncle just means we're behind: hold the
+		// share and fetch what it is missing from the sender. Anything
+		// else is the sender's fault.
+		if missing, ok := n.missingShare(share, err); ok {
+			// Only the PoW can be checked without the parent; a share
+			// short of even the easiest target is invalid anywhere.
+			if !n.chain.MeetsMinimumWork(share) {
+				n.logger.Debug("rejected orphan P2P share below minimum work", zap.String("hash", share.HashHex()))
+				n.p2pNode.PenalizePeer(msg.ReceivedFrom, p2p.PenaltyInvalidShare)
+				return
+			}
+			n.logger.Debug("holding orphan P2P share", zap.String("hash", share.HashHex()))
+			if n.orphans.add(share, missing) && n.backfills.allow(msg.ReceivedFrom) {
+				go n.backfillParent(ctx, msg.ReceivedFrom, missing)
+			}
+			return
+		}
 		n.logger.Debug("rejected P2P share", zap.Error(err))
+		var verr *sharechain.ValidationError
+		if errors.As(err, &verr) {
+			n.p2pNode.PenalizePeer(msg.ReceivedFrom, p2p.PenaltyInvalidShare)
+		}
 		return
 	}
 	n.logger.Debug("accepted P2P share", zap.String("hash", share.HashHex()))
+	n.p2pNode.MarkShareSeen(msg)
+	n.p2pNode.ProtectPeer(msg.ReceivedFrom)
+	metrics.P2PShareDelay.Observe(time.Since(share.Time()).Seconds())
+	n.connectOrphans(share.Hash())
+}
+
+// handleTipAnnounce triggers a sync when a peer announces a tip we don't
+// have that fork choice prefers over ours.
+func (n *Node) handleTipAnnounce(ctx context.Context, tip *p2p.TipAnnounce) {
+	if _, ok := n.chain.GetShare(tip.TipHash); ok {
+		return
+	}
+
+	theirWork := p2p.BytesToBigInt(tip.TotalWork)
+	if ourTip, ourWork, ok := n.chain.TipWork(); ok && sharechain.CompareTips(theirWork, tip.TipHash, ourWork, ourTip.Hash()) <= 0 {
+		return
+	}
+
+	n.logger.Debug("peer announced heavier tip, syncing",
+		zap.String("peer", tip.ReceivedFrom.String()),
+		zap.String("tip", util.HashToHex(tip.TipHash)),
+		zap.Int64("height", tip.Height),
+	)
+	go n.syncFromPeer(ctx, tip.ReceivedFrom)
+}
+
+// ConnectPeer dials a peer multiaddr at runtime, for example to bridge two
+// partitioned pools without a restart. With sync set, a share sync from the
+// peer starts as soon as it is connected and outlives ctx.
+func (n *Node) ConnectPeer(ctx context.Context, multiaddr string, sync bool) error {
+	pid, err := n.p2pNode.ConnectPeer(ctx, multiaddr)
+	if errors.Is(err, p2p.ErrInvalidPeerAddr) {
+		return fmt.Errorf("%w: %w", web.ErrBadRequest, err)
+	}
+	if err != nil {
+		return err
+	}
+	if sync {
+		go n.syncFromPeer(context.WithoutCancel(ctx), pid)
+	}
+	return nil
+}
+
+// syncFromPeer catches up with a single peer's chain, resuming in batches
+// until the peer has nothing more to send.
+func (n *Node) syncFromPeer(ctx context.Context, pid peer.ID) {
+	if !n.syncMu.TryLock() {
+		return
+	}
+	defer n.syncMu.Unlock()
+
+	syncer := n.p2pNode.Syncer()
+	if syncer == nil {
+		return
+	}
+
+	added := 0
+	fetched, err := syncer.SyncAll(ctx, pid, n.buildLocator(), 10000, func(msgs []p2p.ShareMsg) error {
+		shares := make([]*types.Share, 0, len(msgs))
+		for i := range msgs {
+			share, err := p2p.ShareMsgToShare(&msgs[i], n.chain.MaxShareTarget())
+			if err != nil {
+				n.logger.Debug("sync: malformed share", zap.Error(err))
+				continue
+			}
+			shares = append(shares, share)
+		}
+		added += n.addSyncedShares(shares)
+		return nil
+	})
+	if err != nil {
+		n.logger.Debug("sync from peer failed", zap.Error(err), zap.String("peer", pid.String()))
+	}
+	if added > 0 {
+		n.p2pNode.MarkGoodPeer(pid)
+		n.p2pNode.ProtectPeer(pid)
+	}
+
+	n.logger.Info("sync from peer complete",
+		zap.String("peer", pid.String()),
+		zap.Int("fetched", fetched),
+		zap.Int("added", added),
+		zap.Int("chain_length", n.chain.Count()),
+	)
+
+	if added > 0 && n.workGen.CurrentTemplate() != nil {
+		if job, err := n.workGen.GenerateJob(); err != nil {
+			n.logger.Error("failed to generate job after sync", zap.Error(err))
+		} else {
+			n.handleNewJob(job)
+		}
+	}
+}
+
+// addSyncedShares adds a batch of synced shares in one store write,
+// connecting any orphans waiting on them, and returns how many were added.
+// If the batch is rejected its shares are retried one at a time so a single
+// bad share does not discard the rest.
+func (n *Node) addSyncedShares(shares []*types.Share) int {
+	if len(shares) == 0 {
+		return 0
+	}
+	added, err := n.chain.AddBatch(shares)
+	if err == nil {
+		for _, share := range shares {
+			n.connectOrphans(share.Hash())
+		}
+		return added
+	}
+	n.logger.Debug("sync: batch rejected, adding shares individually", zap.Error(err))
+
+	added = 0
+	for _, share := range shares {
+		if err := n.chain.AddShareQuiet(share); err != nil {
+			n.logger.Debug("sync: rejected share", zap.Error(err))
+			continue
+		}
+		added++
+		n.connectOrphans(share.Hash())
+	}
+	return added
+}
+
+// announceTip broadcasts our chain tip if it changed since the last
+// announcement.
+func (n *Node) announceTip() {
+	tip, work, ok := n.chain.TipWork()
+	if !ok {
+		return
+	}
+	tipHash := tip.Hash()
+	if tipHash == n.lastAnnouncedTip {
+		return
+	}
+
+	height, _ := n.chain.Height(tipHash)
+	err := n.p2pNode.BroadcastTip(&p2p.TipAnnounce{
+		TipHash:   tipHash,
+		Height:    height,
+		TotalWork: p2p.BigIntToBytes(work),
+	})
+	if err != nil {
+		n.logger.Debug("failed to announce tip", zap.Error(err))
+		return
+	}
+	n.lastAnnouncedTip = tipHash
 }
 
 func (n *Node) handleChainEvent(event sharechain.Event) {
@@ -448,10 +939,13 @@ func (n *Node) handleChainEvent(event sharechain.Event) {
 			zap.String("old_tip", util.HashToHex(event.OldTipHash)),
 			zap.String("new_tip", event.Share.HashHex()),
 			zap.Int("reorg_depth", event.ReorgDepth),
+			zap.Int("disconnected", len(event.Disconnected)),
+			zap.Int("connected", len(event.Connected)),
 			zap.String("miner", event.Share.MinerAddress),
 		)
 
-		// Generate a clean job so miners abandon stale work immediately
+		// Generate a clean job so miners abandon stale work immediately.
+		// GenerateJob recomputes PPLNS payouts from the new tip.
 		job, err := n.workGen.GenerateJob()
 		if err != nil {
 			n.logger.Error("failed to generate job after reorg", zap.Error(err))
@@ -466,40 +960,9 @@ func (n *Node) handleChainEvent(event sharechain.Event) {
 }
 
 // buildLocator builds an exponentially-spaced list of share hashes from our
-// chain tip, used for locator-based sync. Returns hashes at positions:
-// tip, tip-1, tip-2, ..., tip-9, tip-11, tip-15, tip-23, ..., genesis.
+// chain tip back to genesis, used to find the fork point with a peer.
 func (n *Node) buildLocator() [][32]byte {
-	tip, ok := n.chain.Tip()
-	if !ok {
-		return nil
-	}
-
-	tipHash := tip.Hash()
-	ancestors := n.chain.GetAncestors(tipHash, n.chain.Count())
-	if len(ancestors) == 0 {
-		return nil
-	}
-
-	// ancestors[0] = tip, ancestors[len-1] = genesis
-	var locators [][32]byte
-	step := 1
-	idx := 0
-	for idx < len(ancestors) {
-		locators = append(locators, ancestors[idx].Hash())
-		// First 10 hashes at step 1, then double
-		if len(locators) >= 10 {
-			step *= 2
-		}
-		idx += step
-	}
-
-	// Always include genesis if not already included
-	genesisHash := ancestors[len(ancestors)-1].Hash()
-	if len(locators) == 0 || locators[len(locators)-1] != genesisHash {
-		locators = append(locators, genesisHash)
-	}
-
-	return locators
+	return n.chain.Locator()
 }
 
 // handleInvRequest serves a hash inventory to a peer performing inv-based sync.
@@ -571,7 +1034,7 @@ func (n *Node) handleDataRequest(req *p2p.DataReq) *p2p.DataResp {
 		if !ok {
 			continue
 		}
-		shares = append(shares, *shareToP2PMsg(share))
+		shares = append(shares, *p2p.ShareToShareMsg(share))
 	}
 	return &p2p.DataResp{
 		Type:   p2p.MsgTypeDataResp,
@@ -708,7 +1171,7 @@ func (n *Node) syncFromAllPeers(ctx context.Context) {
 						break
 					}
 					for _, msg := range resp.Shares {
-						if s := p2pShareToShare(&msg); s != nil {
+						if s, err := p2p.ShareMsgToShare(&msg, n.chain.MaxShareTarget()); err == nil {
 							allShares = append(allShares, s)
 						}
 					}
@@ -726,20 +1189,21 @@ func (n *Node) syncFromAllPeers(ctx context.Context) {
 				shareByHash[share.Hash()] = share
 			}
 			peerDownloaded[r.peerID] = len(r.shares)
+			if len(r.shares) > 0 {
+				n.p2pNode.MarkGoodPeer(r.peerID)
+				n.p2pNode.ProtectPeer(r.peerID)
+			}
 		}
 
 		// Add shares in chain order (oldest-first) to satisfy parent deps
+		batch := make([]*types.Share, 0, len(shareByHash))
 		for _, h := range needed {
-			share, ok := shareByHash[h]
-			if !ok {
-				continue
+			if share, ok := shareByHash[h]; ok {
+				batch = append(batch, share)
 			}
-			if err := n.chain.AddShareQuiet(share); err != nil {
-				n.logger.Debug("sync: rejected share", zap.Error(err))
-				continue
-			}
-			totalAdded++
 		}
+		added := n.addSyncedShares(batch)
+		totalAdded += added
 
 		// Log per-peer download stats
 		for pid, count := range peerDownloaded {
@@ -751,7 +1215,9 @@ func (n *Node) syncFromAllPeers(ctx context.Context) {
 			}
 		}
 
-		if !anyMore {
+		// Stop if peers keep offering shares we can't connect; asking again
+		// with the same locators would return the same batch.
+		if !anyMore || added == 0 {
 			break
 		}
 	}
@@ -785,7 +1251,7 @@ func (n *Node) logStatus() {
 	shareCount := n.chain.Count()
 	minerCount := n.stratumSrv.SessionCount()
 	peerCount := n.p2pNode.PeerCount()
-	poolHR := n.dashboardPoolHashrate()
+	poolHR := n.chain.PoolHashrate(n.config.PPLNSWindowSize)
 
 	n.logger.Info("status",
 		zap.Int("shares", shareCount),
@@ -799,15 +1265,51 @@ func (n *Node) logStatus() {
 	metrics.SharechainHeight.Set(float64(shareCount))
 	metrics.MinersConnected.Set(float64(minerCount))
 	metrics.PeersConnected.Set(float64(peerCount))
+	metrics.PeersBanned.Set(float64(n.p2pNode.BannedPeerCount()))
+	metrics.PeersProtected.Set(float64(n.p2pNode.ProtectedPeerCount()))
 	metrics.ShareDifficulty.Set(difficulty)
 	metrics.PoolHashrate.Set(poolHR)
 	metrics.LocalHashrate.Set(n.localHashrate())
 	metrics.UptimeSeconds.Set(time.Since(n.startTime).Seconds())
+	n.updateMinerHashrateMetrics()
 
 	// Record graph history point
 	n.recordGraphPoint(poolHR, n.localHashrate())
 }
 
+// updateMinerHashrateMetrics exports per-worker hashrate for connected
+// workers and deletes the series of workers that have disconnected.
+func (n *Node) updateMinerHashrateMetrics() {
+	connected := make(map[string]bool)
+	for _, sess := range n.stratumSrv.MinerStats() {
+		connected[sess.WorkerName] = true
+	}
+
+	current := make(map[[2]string]struct{})
+	for name, stat := range n.stratumSrv.WorkerStats() {
+		if !connected[name] {
+			continue
+		}
+		labels := minerWorkerLabels(name)
+		metrics.MinerHashrate.WithLabelValues(labels[0], labels[1]).Set(stat.Hashrate)
+		current[labels] = struct{}{}
+	}
+
+	for labels := range n.minerHashrateLabels {
+		if _, ok := current[labels]; !ok {
+			metrics.MinerHashrate.DeleteLabelValues(labels[0], labels[1])
+		}
+	}
+	n.minerHashrateLabels = current
+}
+
+// minerWorkerLabels splits a stratum worker name of the form
+// "address.rig" into miner and worker labels.
+func minerWorkerLabels(workerName string) [2]string {
+	miner, worker, _ := strings.Cut(workerName, ".")
+	return [2]string{miner, worker}
+}
+
 const minGraphInterval = 20 * time.Second
 
 func (n *Node) recordGraphPoint(poolHashrate float64, localHashrate float64) {
@@ -981,6 +1483,88 @@ func (n *Node) recordBlockFound(hashHex string) {
 	defer n.lastBlockMu.Unlock()
 	n.lastBlockTime = time.Now()
 	n.lastBlockHash = hashHex
+	n.blocksFound++
+}
+
+// saveBlockRecord persists a found block and the payouts its coinbase
+// made, so they can be audited later.
+func (n *Node) saveBlockRecord(hashHex, finder string, job *work.JobData) {
+	recorder, ok := n.store.(sharechain.BlockRecorder)
+	if !ok {
+		return
+	}
+	rec := &sharechain.BlockRecord{
+		Hash:    hashHex,
+		Height:  job.Height,
+		Finder:  finder,
+		FoundAt: time.Now(),
+		Payouts: job.Payouts,
+	}
+	if job.Template != nil {
+		rec.Reward = job.Template.CoinbaseValue
+	}
+	if err := recorder.SaveBlock(rec); err != nil {
+		n.logger.Error("failed to save found block", zap.String("hash", hashHex), zap.Error(err))
+	}
+}
+
+// commitPayouts records the dust balances carried by an accepted block's
+// coinbase.
+func (n *Node) commitPayouts(blockHash string, job *work.JobData) {
+	if n.ledger == nil {
+		return
+	}
+	if err := n.ledger.Commit(blockHash, job.Payouts); err != nil {
+		n.logger.Error("failed to commit carried dust balances", zap.String("hash", blockHash), zap.Error(err))
+	}
+}
+
+// resolvePayouts settles a committed block's carried dust once the block is
+// confirmed, or reverts it if the block was orphaned.
+func (n *Node) resolvePayouts(blockHash string, orphaned bool) {
+	if n.ledger == nil {
+		return
+	}
+	if !orphaned {
+		n.ledger.Confirm(blockHash)
+		return
+	}
+	if err := n.ledger.Revert(blockHash); err != nil {
+		n.logger.Error("failed to revert carried dust balances", zap.String("hash", blockHash), zap.Error(err))
+	}
+}
+
+// blockHistory returns up to count recently found blocks for the stats
+// endpoint.
+func (n *Node) blockHistory(count int) []web.BlockInfo {
+	recorder, ok := n.store.(sharechain.BlockRecorder)
+	if !ok {
+		return nil
+	}
+	records, err := recorder.GetBlockHistory(count)
+	if err != nil {
+		n.logger.Warn("failed to load block history", zap.Error(err))
+		return nil
+	}
+	blocks := make([]web.BlockInfo, 0, len(records))
+	for _, rec := range records {
+		info := web.BlockInfo{
+			Hash:    rec.Hash,
+			Height:  rec.Height,
+			Reward:  rec.Reward,
+			Finder:  rec.Finder,
+			FoundAt: rec.FoundAt.Unix(),
+		}
+		for _, p := range rec.Payouts {
+			pct := 0.0
+			if rec.Reward > 0 {
+				pct = float64(p.Amount) / float64(rec.Reward) * 100
+			}
+			info.Payouts = append(info.Payouts, web.PayoutInfo{Address: p.Address, Amount: p.Amount, Pct: pct})
+		}
+		blocks = append(blocks, info)
+	}
+	return blocks
 }
 
 // buildTreeData builds the sharechain tree visualization data.
@@ -1067,6 +1651,87 @@ func (n *Node) buildTreeData() []web.TreeShare {
 	return result
 }
 
+// payoutPreview computes the payouts the current block template would pay
+// out for window, along with the template's coinbase value.
+func (n *Node) payoutPreview(window *pplns.Window) ([]web.PayoutInfo, int64) {
+	tmpl := n.workGen.JobTemplate()
+	if tmpl == nil {
+		return nil, 0
+	}
+	coinbaseValue := tmpl.CoinbaseValue
+	var entries []web.PayoutInfo
+	for _, p := range n.pplnsCalc.CalculatePayouts(window, coinbaseValue, n.minerAddress) {
+		pct := 0.0
+		if coinbaseValue > 0 {
+			pct = float64(p.Amount) / float64(coinbaseValue) * 100
+		}
+		entries = append(entries, web.PayoutInfo{
+			Address: p.Address,
+			Amount:  p.Amount,
+			Pct:     pct,
+		})
+	}
+	return entries, coinbaseValue
+}
+
+// statsBlockHistory is how many found blocks /stats lists.
+const statsBlockHistory = 10
+
+// statsData builds the compact /stats response. Like dashboardData it
+// reads the chain and subsystems directly, so it never waits on the event
+// loop.
+func (n *Node) statsData() *web.StatsData {
+	target := n.chain.GetExpectedTarget()
+	stats := &web.StatsData{
+		Difficulty:    util.TargetToDifficulty(target, sharechain.MinShareTarget),
+		Miners:        n.stratumSrv.SessionCount(),
+		Peers:         n.p2pNode.PeerCount(),
+		LocalHashrate: n.localHashrate(),
+	}
+
+	if tip, ok := n.chain.Tip(); ok {
+		stats.TipHash = tip.HashHex()
+		stats.Height, _ = n.chain.Height(tip.Hash())
+		ancestors := n.chain.GetAncestors(tip.Hash(), n.config.PPLNSWindowSize)
+		stats.PoolHashrate = sharechain.EstimateHashrate(ancestors, sharechain.HashrateWindow)
+		window := pplns.NewWindowWithUncles(ancestors, n.chain.GetUncles(ancestors), sharechain.MaxShareTarget)
+		stats.Payouts, _ = n.payoutPreview(window)
+	}
+
+	n.lastBlockMu.RLock()
+	stats.BlocksFound = n.blocksFound
+	n.lastBlockMu.RUnlock()
+	stats.RecentBlocks = n.blockHistory(statsBlockHistory)
+	stats.PeerDetails = n.peerInfos()
+
+	return stats
+}
+
+// peerInfos describes the connected peers for the web handlers.
+func (n *Node) peerInfos() []web.PeerInfo {
+	details := n.p2pNode.PeerDetails()
+	peers := make([]web.PeerInfo, len(details))
+	for i, pd := range details {
+		peers[i] = web.PeerInfo{
+			ID:        pd.ShortID,
+			Latency:   pd.LatencyUs,
+			Address:   pd.Address,
+			Direction: pd.Direction,
+			Agent:     pd.Agent,
+			Protocols: pd.Protocols,
+			BytesIn:   pd.BytesIn,
+			BytesOut:  pd.BytesOut,
+		}
+		if !pd.ConnectedAt.IsZero() {
+			peers[i].ConnectedAt = pd.ConnectedAt.Unix()
+		}
+		if !pd.LastSync.IsZero() {
+			peers[i].LastSync = pd.LastSync.Unix()
+		}
+	}
+	return peers
+}
+
 // dashboardData collects all metrics for the web dashboard.
 func (n *Node) dashboardData() *web.StatusData {
 	target := n.chain.GetExpectedTarget()
@@ -1100,7 +1765,7 @@ func (n *Node) dashboardData() *web.StatusData {
 	var payoutEntries []web.PayoutInfo
 	var coinbaseValue int64
 	if len(pplnsAncestors) > 0 {
-		window := pplns.NewWindow(pplnsAncestors, sharechain.MaxShareTarget)
+		window := pplns.NewWindowWithUncles(pplnsAncestors, n.chain.GetUncles(pplnsAncestors), sharechain.MaxShareTarget)
 		weights := window.MinerWeights()
 		totalWeight := window.TotalWeight()
 		if totalWeight.Sign() > 0 {
@@ -1113,24 +1778,10 @@ func (n *Node) dashboardData() *web.StatusData {
 		}
 
 		// Compute concrete payout amounts for Sankey diagram
-		if tmpl := n.workGen.CurrentTemplate(); tmpl != nil {
-			coinbaseValue = tmpl.CoinbaseValue
-			payouts := n.pplnsCalc.CalculatePayouts(window, coinbaseValue, n.minerAddress)
-			for _, p := range payouts {
-				pct := 0.0
-				if coinbaseValue > 0 {
-					pct = float64(p.Amount) / float64(coinbaseValue) * 100
-				}
-				payoutEntries = append(payoutEntries, web.PayoutInfo{
-					Address: p.Address,
-					Amount:  p.Amount,
-					Pct:     pct,
-				})
-			}
-		}
+		payoutEntries, coinbaseValue = n.payoutPreview(window)
 	}
 
-	poolHashrate := poolHashrateFromShares(pplnsAncestors)
+	poolHashrate := sharechain.EstimateHashrate(pplnsAncestors, sharechain.HashrateWindow)
 	shareCount := n.chain.Count()
 
 	// Record a graph history point on each dashboard poll
@@ -1164,15 +1815,7 @@ func (n *Node) dashboardData() *web.StatusData {
 	n.lastBlockMu.RUnlock()
 
 	// Peer details for network graph
-	peerDetails := n.p2pNode.PeerDetails()
-	peers := make([]web.PeerInfo, len(peerDetails))
-	for i, pd := range peerDetails {
-		peers[i] = web.PeerInfo{
-			ID:      pd.ShortID,
-			Latency: pd.LatencyUs,
-			Address: pd.Address,
-		}
-	}
+	peers := n.peerInfos()
 
 	// Per-miner stats: merge session info with share stats, dedup by worker name.
 	// Multiple sessions with the same worker name collapse into one row using
@@ -1283,142 +1926,76 @@ func (n *Node) lookupShare(hashHex string) *web.ShareDetail {
 	}
 }
 
-// hashrateWindow is the time window used for pool hashrate estimation.
-// Shorter than the PPLNS window so hashrate responds to changes in minutes,
-// not days.
-const hashrateWindow = 30 * time.Minute
-
-// poolHashrateFromShares computes pool hashrate from a slice of shares,
-// using only shares within hashrateWindow of the newest share.
-func poolHashrateFromShares(shares []*types.Share) float64 {
-	if len(shares) < 2 {
-		return 0
-	}
-
-	newest := shares[0].Header.Timestamp
-	cutoff := newest - uint32(hashrateWindow.Seconds())
-
-	// Trim to shares within the hashrate window.
-	end := len(shares)
-	for end > 1 && shares[end-1].Header.Timestamp < cutoff {
-		end--
-	}
-	shares = shares[:end]
-	if len(shares) < 2 {
-		return 0
-	}
-
-	diff1 := util.CompactToTarget(0x1d00ffff)
-	diff1Float := new(big.Float).SetInt(diff1)
-	// Exclude the oldest share's work — it was done before the measurement
-	// window begins (oldest→newest). Only count work within that interval.
-	var totalWork float64
-	for _, s := range shares[:len(shares)-1] {
-		if s.ShareTarget != nil && s.ShareTarget.Sign() > 0 {
-			shareDiff, _ := new(big.Float).Quo(
-				diff1Float,
-				new(big.Float).SetInt(s.ShareTarget),
-			).Float64()
-			totalWork += shareDiff
-		}
-	}
-	oldest := shares[len(shares)-1].Header.Timestamp
-	elapsed := float64(newest - oldest)
-	if elapsed <= 0 {
-		return 0
-	}
-	return totalWork * math.Pow(2, 32) / elapsed
-}
-
-// dashboardPoolHashrate estimates pool hashrate from the PPLNS window.
-// Used by logStatus where pplnsAncestors aren't already loaded.
-func (n *Node) dashboardPoolHashrate() float64 {
-	tip, ok := n.chain.Tip()
-	if !ok {
-		return 0
-	}
-	return poolHashrateFromShares(n.chain.GetAncestors(tip.Hash(), n.config.PPLNSWindowSize))
-}
-
-// getPayouts returns the current PPLNS payouts for the coinbase.
-func (n *Node) getPayouts() []types.PayoutEntry {
+// getPayouts returns the current PPLNS payouts for a coinbase paying
+// totalReward.
+func (n *Node) getPayouts(totalReward int64) []types.PayoutEntry {
 	tip, ok := n.chain.Tip()
 	if !ok {
 		// No shares yet, all reward to our miner
 		return []types.PayoutEntry{
-			{Address: n.minerAddress, Amount: 5000000000}, // placeholder
+			{Address: n.minerAddress, Amount: totalReward},
 		}
 	}
 
 	tipHash := tip.Hash()
 	ancestors := n.chain.GetAncestors(tipHash, n.config.PPLNSWindowSize)
 	maxTarget := sharechain.MaxShareTarget
-	window := pplns.NewWindow(ancestors, maxTarget)
-
-	// Use the current template's coinbase value
-	tmpl := n.workGen.CurrentTemplate()
-	totalReward := int64(5000000000) // fallback
-	if tmpl != nil {
-		totalReward = tmpl.CoinbaseValue
-	}
+	window := pplns.NewWindowWithUncles(ancestors, n.chain.GetUncles(ancestors), maxTarget)
 
 	return n.pplnsCalc.CalculatePayouts(window, totalReward, n.minerAddress)
 }
 
-// getPrevShareHash returns the current chain tip hash for the sharechain commitment.
-func (n *Node) getPrevShareHash() [32]byte {
+// windowShares returns the shares credited in the current PPLNS window for
+// the /stats/window endpoint.
+func (n *Node) windowShares() []web.WindowShare {
 	tip, ok := n.chain.Tip()
 	if !ok {
-		return [32]byte{}
+		return nil
 	}
-	return tip.Hash()
+	ancestors := n.chain.GetAncestors(tip.Hash(), n.config.PPLNSWindowSize)
+	window := pplns.NewWindowWithUncles(ancestors, n.chain.GetUncles(ancestors), sharechain.MaxShareTarget)
+
+	entries := window.Entries()
+	shares := make([]web.WindowShare, len(entries))
+	for i, e := range entries {
+		shares[i] = web.WindowShare{
+			Hash:      util.HashToHex(e.Hash),
+			Miner:     e.Miner,
+			Timestamp: int64(e.Timestamp),
+			Weight:    e.Weight.String(),
+			Uncle:     e.Uncle,
+		}
+	}
+	return shares
 }
 
-// p2pShareToShare converts a P2P share message to a types.Share.
-// Returns nil if the coinbase cannot be decompressed.
-func p2pShareToShare(msg *p2p.ShareMsg) *types.Share {
-	coinbaseTx, err := p2p.DecompressCoinbase(msg.CoinbaseTx)
-	if err != nil {
-		return nil
+// templateMinTime returns the current template's MinTime if the template
+// builds on prevBlockHash. Shares on other Bitcoin blocks can't be checked.
+func (n *Node) templateMinTime(prevBlockHash [32]byte) (uint32, bool) {
+	tmpl := n.workGen.CurrentTemplate()
+	if tmpl == nil || tmpl.PreviousBlockHash != util.HashToHex(prevBlockHash) {
+		return 0, false
 	}
-	return &types.Share{
-		Header: types.ShareHeader{
-			Version:       msg.Version,
-			PrevBlockHash: msg.PrevBlockHash,
-			MerkleRoot:    msg.MerkleRoot,
-			Timestamp:     msg.Timestamp,
-			Bits:          msg.Bits,
-			Nonce:         msg.Nonce,
-		},
-		ShareVersion:  msg.ShareVersion,
-		PrevShareHash: msg.PrevShareHash,
-		ShareTarget:   util.CompactToTarget(msg.ShareTargetBits),
-		MinerAddress:  msg.MinerAddress,
-		CoinbaseTx:    coinbaseTx,
+	return uint32(tmpl.MinTime), true
+}
+
+// templateCoinbaseValue returns the current template's coinbase value if the
+// template builds on prevBlockHash.
+func (n *Node) templateCoinbaseValue(prevBlockHash [32]byte) (int64, bool) {
+	tmpl := n.workGen.CurrentTemplate()
+	if tmpl == nil || tmpl.PreviousBlockHash != util.HashToHex(prevBlockHash) {
+		return 0, false
 	}
+	return tmpl.CoinbaseValue, true
 }
 
-// shareToP2PMsg converts a types.Share to a P2P share message.
-func shareToP2PMsg(share *types.Share) *p2p.ShareMsg {
-	var shareTargetBits uint32
-	if share.ShareTarget != nil && share.ShareTarget.Sign() > 0 {
-		shareTargetBits = util.TargetToCompact(share.ShareTarget)
-	}
-
-	return &p2p.ShareMsg{
-		Type:            p2p.MsgTypeShare,
-		Version:         share.Header.Version,
-		PrevBlockHash:   share.Header.PrevBlockHash,
-		MerkleRoot:      share.Header.MerkleRoot,
-		Timestamp:       share.Header.Timestamp,
-		Bits:            share.Header.Bits,
-		Nonce:           share.Header.Nonce,
-		ShareVersion:    share.ShareVersion,
-		PrevShareHash:   share.PrevShareHash,
-		ShareTargetBits: shareTargetBits,
-		MinerAddress:    share.MinerAddress,
-		CoinbaseTx:      p2p.CompressCoinbase(share.CoinbaseTx),
+// getPrevShareHash returns the current chain tip hash for the sharechain commitment.
+func (n *Node) getPrevShareHash() [32]byte {
+	tip, ok := n.chain.Tip()
+	if !ok {
+		return [32]byte{}
 	}
+	return tip.Hash()
 }
 
 // ShareTarget returns the current sharechain difficulty target.
@@ -1437,27 +2014,25 @@ func (n *Node) buildShareFromHeader(header []byte, coinbase []byte, shareTarget
 	sh.Bits = binary.LittleEndian.Uint32(header[72:76])
 	sh.Nonce = binary.LittleEndian.Uint32(header[76:80])
 
-	// Extract PrevShareHash from the coinbase commitment rather than
-	// re-querying the chain tip, which may have moved since the job was built.
-	prevShareHash, err := types.ExtractShareCommitment(coinbase)
-	if err != nil {
-		n.logger.Warn("failed to extract share commitment from coinbase", zap.Error(err))
-		return nil
-	}
-
+	// Take PrevShareHash and uncles from the job that built the coinbase
+	// commitment rather than re-querying the chain tip, which may have moved
+	// since the job was built.
 	return &types.Share{
 		Header:        sh,
-		ShareVersion:  1,
-		PrevShareHash: prevShareHash,
+		ShareVersion:  job.ShareVersion,
+		PrevShareHash: job.PrevShareHash,
 		ShareTarget:   shareTarget,
 		MinerAddress:  n.minerAddress,
 		CoinbaseTx:    coinbase,
+		Uncles:        job.Uncles,
+		Height:        job.ShareHeight,
 	}
 }
 
 // submitBlock reconstructs the full block from the header, coinbase, and
 // the job's block template transactions, then submits it to bitcoind.
-func (n *Node) submitBlock(header []byte, coinbase []byte, tmpl *bitcoin.BlockTemplate) {
+func (n *Node) submitBlock(header []byte, coinbase []byte, job *work.JobData) {
+	tmpl := job.Template
 	// Pre-submission verification: independently compute the merkle root
 	// and compare with the header's merkle root to catch any issues early.
 	if err := work.VerifyMerkleRoot(header, coinbase, tmpl); err != nil {
@@ -1466,6 +2041,9 @@ func (n *Node) submitBlock(header []byte, coinbase []byte, tmpl *bitcoin.BlockTe
 	} else {
 		n.logger.Info("merkle root verification passed")
 	}
+	if err := work.VerifyWitnessCommitment(coinbase, tmpl); err != nil {
+		n.logger.Error("WITNESS COMMITMENT VERIFICATION FAILED — block will likely be rejected", zap.Error(err))
+	}
 
 	blockHex, err := work.ReconstructBlock(header, coinbase, tmpl)
 	if err != nil {
@@ -1480,16 +2058,23 @@ func (n *Node) submitBlock(header []byte, coinbase []byte, tmpl *bitcoin.BlockTe
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		err := n.bitcoinRPC.SubmitBlock(ctx, blockHex)
+		err := n.submitter.SubmitBlockToAll(ctx, blockHex)
 		cancel()
 
 		if err == nil {
 			n.logger.Info("block submitted to Bitcoin network successfully")
 			metrics.BlockSubmissions.WithLabelValues("success").Inc()
+			blockHash := util.HashToHex(util.DoubleSHA256(header))
+			n.commitPayouts(blockHash, job)
+			if n.blockWatch != nil {
+				n.blockWatch.watch(blockHash, job.Height, func(orphaned bool) {
+					n.resolvePayouts(blockHash, orphaned)
+				})
+			}
 			return
 		}
 
-		// Don't retry if the network explicitly rejected the block
+		// Don't retry if every endpoint explicitly rejected the block
 		var rejected *bitcoin.BlockRejectedError
 		if errors.As(err, &rejected) {
 			n.logger.Error("block rejected by network (not retrying)", zap.Error(err))
@@ -1512,16 +2097,26 @@ func (n *Node) submitBlock(header []byte, coinbase []byte, tmpl *bitcoin.BlockTe
 	}
 }
 
+// serverVersionRollingMask is stratum.VersionRollingMask as an integer.
+var serverVersionRollingMask = func() uint32 {
+	var mask uint32
+	fmt.Sscanf(stratum.VersionRollingMask, "%x", &mask)
+	return mask
+}()
 
 // applyVersionRolling computes the actual block version by merging the miner's
-// rolled version bits into the original job version using the BIP 310 mask.
-// Both jobVersion and versionBits are big-endian hex strings (e.g., "20000000").
-func applyVersionRolling(jobVersion, versionBits string) string {
-	var orig, rolled uint32
+// rolled version bits into the original job version using the mask negotiated
+// via BIP 310. All arguments are big-endian hex strings (e.g., "20000000"); an
+// empty mask falls back to the server's full VersionRollingMask.
+func applyVersionRolling(jobVersion, versionBits, versionMask string) string {
+	var orig, rolled, mask uint32
 	fmt.Sscanf(jobVersion, "%x", &orig)
 	fmt.Sscanf(versionBits, "%x", &rolled)
+	if versionMask == "" {
+		versionMask = stratum.VersionRollingMask
+	}
+	fmt.Sscanf(versionMask, "%x", &mask)
 
-	const mask uint32 = 0x1fffe000 // VersionRollingMask
 	actual := (orig &^ mask) | (rolled & mask)
 	return fmt.Sprintf("%08x", actual)
 }
@@ -1,22 +1,30 @@
 package node
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"math/big"
 	"testing"
 	"time"
 
+	"github.com/djkazic/p2pool-go/internal/bitcoin"
 	"github.com/djkazic/p2pool-go/internal/p2p"
 	"github.com/djkazic/p2pool-go/internal/sharechain"
+	"github.com/djkazic/p2pool-go/internal/stratum"
 	"github.com/djkazic/p2pool-go/internal/types"
+	"github.com/djkazic/p2pool-go/internal/work"
 	"github.com/djkazic/p2pool-go/pkg/util"
 
 	"go.uber.org/zap"
 )
 
+var testNetwork = types.TestNet3Params
+
 const (
-	testNetwork = "testnet3"
-	testMiner1  = "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"
-	testMiner2  = "tb1qrp33g0q5b5698ahp5jnf5yzjmgcea8e0gfk2ts"
+	testMiner1 = "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"
+	testMiner2 = "tb1qrp33g0q5b5698ahp5jnf5yzjmgcea8e0gfk2ts"
 )
 
 // makeTestShare creates a share that passes validation.
@@ -66,7 +74,7 @@ func testNode(t *testing.T) (*Node, []*types.Share) {
 	logger, _ := zap.NewDevelopment()
 
 	store := sharechain.NewMemoryStore()
-	diffCalc := sharechain.NewDifficultyCalculator(30 * time.Second)
+	diffCalc := sharechain.NewDifficultyCalculator(30*time.Second, sharechain.DifficultyAdjustmentWindow, nil)
 	chain := sharechain.NewShareChain(store, diffCalc, 8640, testNetwork, logger)
 
 	// Build a chain of 10 shares
@@ -94,7 +102,7 @@ func testNode(t *testing.T) (*Node, []*types.Share) {
 func TestHandleInvRequest_EmptyChain(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 	store := sharechain.NewMemoryStore()
-	diffCalc := sharechain.NewDifficultyCalculator(30 * time.Second)
+	diffCalc := sharechain.NewDifficultyCalculator(30*time.Second, sharechain.DifficultyAdjustmentWindow, nil)
 	chain := sharechain.NewShareChain(store, diffCalc, 8640, testNetwork, logger)
 
 	n := &Node{logger: logger, chain: chain}
@@ -245,7 +253,10 @@ func TestHandleDataRequest_KnownHashes(t *testing.T) {
 
 	// Verify the returned shares match the requested hashes
 	for i, msg := range resp.Shares {
-		share := p2pShareToShare(&msg)
+		share, err := p2p.ShareMsgToShare(&msg, testNetwork.MaxShareTarget())
+		if err != nil {
+			t.Fatalf("ShareMsgToShare: %v", err)
+		}
 		if share.Hash() != hashes[i] {
 			t.Errorf("share[%d] hash mismatch", i)
 		}
@@ -288,7 +299,7 @@ func TestHandleDataRequest_Empty(t *testing.T) {
 func TestBuildLocator_EmptyChain(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 	store := sharechain.NewMemoryStore()
-	diffCalc := sharechain.NewDifficultyCalculator(30 * time.Second)
+	diffCalc := sharechain.NewDifficultyCalculator(30*time.Second, sharechain.DifficultyAdjustmentWindow, nil)
 	chain := sharechain.NewShareChain(store, diffCalc, 8640, testNetwork, logger)
 
 	n := &Node{logger: logger, chain: chain}
@@ -325,7 +336,7 @@ func TestBuildLocator_ExponentialSpacing(t *testing.T) {
 	// Build a longer chain to test exponential spacing
 	logger, _ := zap.NewDevelopment()
 	store := sharechain.NewMemoryStore()
-	diffCalc := sharechain.NewDifficultyCalculator(30 * time.Second)
+	diffCalc := sharechain.NewDifficultyCalculator(30*time.Second, sharechain.DifficultyAdjustmentWindow, nil)
 	chain := sharechain.NewShareChain(store, diffCalc, 8640, testNetwork, logger)
 
 	now := uint32(time.Now().Unix()) - 3000
@@ -366,13 +377,16 @@ func TestBuildLocator_ExponentialSpacing(t *testing.T) {
 	}
 }
 
-// --- shareToP2PMsg / p2pShareToShare round-trip ---
+// --- ShareToShareMsg / ShareMsgToShare round-trip ---
 
 func TestShareConversion_RoundTrip(t *testing.T) {
 	share := makeTestShare([32]byte{}, testMiner1, 1700000000)
 
-	msg := shareToP2PMsg(share)
-	back := p2pShareToShare(msg)
+	msg := p2p.ShareToShareMsg(share)
+	back, err := p2p.ShareMsgToShare(msg, testNetwork.MaxShareTarget())
+	if err != nil {
+		t.Fatalf("ShareMsgToShare: %v", err)
+	}
 
 	if back.Hash() != share.Hash() {
 		t.Error("hash mismatch after round-trip")
@@ -414,12 +428,16 @@ func TestInvThenData_FullSync(t *testing.T) {
 	// Phase 3: Add to a fresh chain in order — all should succeed
 	logger, _ := zap.NewDevelopment()
 	freshStore := sharechain.NewMemoryStore()
-	freshDiffCalc := sharechain.NewDifficultyCalculator(30 * time.Second)
+	freshDiffCalc := sharechain.NewDifficultyCalculator(30*time.Second, sharechain.DifficultyAdjustmentWindow, nil)
 	freshChain := sharechain.NewShareChain(freshStore, freshDiffCalc, 8640, testNetwork, logger)
 
 	added := 0
 	for _, msg := range dataResp.Shares {
-		s := p2pShareToShare(&msg)
+		s, err := p2p.ShareMsgToShare(&msg, testNetwork.MaxShareTarget())
+		if err != nil {
+			t.Errorf("convert share failed: %v", err)
+			continue
+		}
 		if err := freshChain.AddShareQuiet(s); err != nil {
 			t.Errorf("add share failed: %v", err)
 			continue
@@ -439,24 +457,193 @@ func TestInvThenData_FullSync(t *testing.T) {
 
 func TestApplyVersionRolling(t *testing.T) {
 	// Base version 0x20000000 with rolled bits 0x00004000 (within mask)
-	result := applyVersionRolling("20000000", "00004000")
+	result := applyVersionRolling("20000000", "00004000", "")
 	if result != "20004000" {
 		t.Errorf("expected 20004000, got %s", result)
 	}
 
 	// Bits outside the mask should be ignored
-	result = applyVersionRolling("20000000", "e0001fff")
+	result = applyVersionRolling("20000000", "e0001fff", "")
 	// mask = 0x1fffe000; rolled & mask = 0x00000000; orig &^ mask = 0x20000000
 	if result != "20000000" {
 		t.Errorf("expected 20000000, got %s", result)
 	}
 
 	// Preserve non-mask bits from original
-	result = applyVersionRolling("20800000", "1fffe000")
+	result = applyVersionRolling("20800000", "1fffe000", "")
 	// mask = 0x1fffe000; rolled & mask = 0x1fffe000; orig &^ mask = 0x20800000
 	if result != "3fffe000" {
 		t.Errorf("expected 3fffe000, got %s", result)
 	}
+
+	// A narrower negotiated mask limits which rolled bits apply
+	result = applyVersionRolling("20000000", "1fffe000", "00ffe000")
+	if result != "20ffe000" {
+		t.Errorf("expected 20ffe000, got %s", result)
+	}
+}
+
+func TestCheckSubmission_RolledVersion(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	payouts := func(int64) []types.PayoutEntry {
+		return []types.PayoutEntry{{Address: testMiner1, Amount: 5000000000}}
+	}
+	gen := work.NewGenerator(rpc, testNetwork, 8, payouts, func() [32]byte { return [32]byte{} }, zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gen.Start(ctx)
+
+	var job *work.JobData
+	select {
+	case job = <-gen.JobChannel():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no job generated")
+	}
+	n := &Node{logger: zap.NewNop(), workGen: gen}
+
+	submit := func(versionBits, versionMask string) (*checkedSubmission, error) {
+		return n.checkSubmission(&stratum.ShareSubmission{
+			JobID:       job.ID,
+			Extranonce1: "00000001",
+			Extranonce2: "00000002",
+			NTime:       job.NTime,
+			Nonce:       "00000000",
+			Difficulty:  1e-12,
+			VersionBits: versionBits,
+			VersionMask: versionMask,
+		})
+	}
+
+	// The miner receives the template version and rolls bits in its mask.
+	checked, err := submit("00004000", stratum.VersionRollingMask)
+	if err != nil {
+		t.Fatalf("rolled submission rejected: %v", err)
+	}
+	if checked.version != "20004000" {
+		t.Errorf("version = %s, want 20004000", checked.version)
+	}
+	if v := binary.LittleEndian.Uint32(checked.header[0:4]); v != 0x20004000 {
+		t.Errorf("header version = %08x, want 20004000", v)
+	}
+	if err := work.VerifyMerkleRoot(checked.header, checked.coinbase, job.Template); err != nil {
+		t.Errorf("VerifyMerkleRoot: %v", err)
+	}
+
+	// A session mask wider than the server's must not let the miner roll
+	// bits the server never offered.
+	if _, err := submit("e0004000", "ffffffff"); err == nil {
+		t.Error("expected rejection of version bits outside the server mask")
+	}
+}
+
+// blockRecordingStore adds block records to a MemoryStore.
+type blockRecordingStore struct {
+	*sharechain.MemoryStore
+	blocks []*sharechain.BlockRecord
+}
+
+func (s *blockRecordingStore) SaveBlock(rec *sharechain.BlockRecord) error {
+	s.blocks = append(s.blocks, rec)
+	return nil
+}
+
+func (s *blockRecordingStore) GetBlockHistory(n int) ([]*sharechain.BlockRecord, error) {
+	return s.blocks[:min(n, len(s.blocks))], nil
+}
+
+func TestHandleSubmission_BlockShare(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	rpc.BlockTemplate.Bits = "207fffff" // every share target hash is also a block
+
+	store := &blockRecordingStore{MemoryStore: sharechain.NewMemoryStore()}
+	diffCalc := sharechain.NewDifficultyCalculator(30*time.Second, sharechain.DifficultyAdjustmentWindow, nil)
+	chain := sharechain.NewShareChain(store, diffCalc, 8640, testNetwork, zap.NewNop())
+
+	payouts := func(int64) []types.PayoutEntry {
+		return []types.PayoutEntry{{Address: testMiner1, Amount: 5000000000}}
+	}
+	gen := work.NewGenerator(rpc, testNetwork, 8, payouts, func() [32]byte { return [32]byte{} }, zap.NewNop())
+	gen.SetShareVersion(chain.ShareVersionFor, testMiner1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gen.Start(ctx)
+
+	var job *work.JobData
+	select {
+	case job = <-gen.JobChannel():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no job generated")
+	}
+
+	var broadcast []*p2p.ShareMsg
+	n := &Node{
+		logger:       zap.NewNop(),
+		workGen:      gen,
+		store:        store,
+		chain:        chain,
+		orphans:      newOrphanPool(),
+		submitter:    bitcoin.NewBlockSubmitter(bitcoin.SubmitEndpoint{Name: "mock", RPC: rpc}),
+		minerAddress: testMiner1,
+		broadcastShare: func(msg *p2p.ShareMsg) error {
+			broadcast = append(broadcast, msg)
+			return nil
+		},
+	}
+
+	// Find a nonce whose header meets the share target, and so the block
+	// target too.
+	shareTarget := chain.GetExpectedTargetForParent(job.PrevShareHash)
+	var sub *stratum.ShareSubmission
+	for nonce := uint32(0); sub == nil; nonce++ {
+		s := &stratum.ShareSubmission{
+			WorkerName:  testMiner1 + ".rig",
+			JobID:       job.ID,
+			Extranonce1: "00000001",
+			Extranonce2: "00000002",
+			NTime:       job.NTime,
+			Nonce:       fmt.Sprintf("%08x", nonce),
+			Difficulty:  1e-12,
+		}
+		checked, err := n.checkSubmission(s)
+		if err != nil {
+			t.Fatalf("checkSubmission: %v", err)
+		}
+		if util.HashMeetsTarget(checked.headerHash, shareTarget) {
+			s.Checked = checked
+			sub = s
+		}
+	}
+
+	n.handleSubmission(sub)
+
+	if len(broadcast) != 1 {
+		t.Fatalf("broadcast %d shares, want 1", len(broadcast))
+	}
+	share, err := p2p.ShareMsgToShare(broadcast[0], testNetwork.MaxShareTarget())
+	if err != nil {
+		t.Fatalf("ShareMsgToShare: %v", err)
+	}
+	if !share.IsBlock() {
+		t.Error("broadcast share is not a block")
+	}
+	if share.MinerAddress != testMiner1 {
+		t.Errorf("broadcast miner = %s, want %s", share.MinerAddress, testMiner1)
+	}
+	if tip, ok := chain.Tip(); !ok || tip.Hash() != share.Hash() {
+		t.Error("block share not added to the chain")
+	}
+	if len(rpc.SubmittedBlocks) != 1 {
+		t.Errorf("submitted %d blocks, want 1", len(rpc.SubmittedBlocks))
+	}
+	if len(store.blocks) != 1 {
+		t.Fatalf("recorded %d blocks, want 1", len(store.blocks))
+	}
+	if got := store.blocks[0].Finder; got != testMiner1 {
+		t.Errorf("block finder = %s, want %s", got, testMiner1)
+	}
+	if want := util.HashToHex(share.Hash()); store.blocks[0].Hash != want {
+		t.Errorf("block hash = %s, want %s", store.blocks[0].Hash, want)
+	}
 }
 
 func TestStratumDiffToTarget(t *testing.T) {
@@ -484,36 +671,6 @@ func TestStratumDiffToTarget(t *testing.T) {
 	}
 }
 
-func TestPoolHashrateFromShares(t *testing.T) {
-	// Empty or single share → 0
-	if poolHashrateFromShares(nil) != 0 {
-		t.Error("nil shares should return 0")
-	}
-	if poolHashrateFromShares([]*types.Share{{}}) != 0 {
-		t.Error("single share should return 0")
-	}
-
-	// Two shares 30 seconds apart with known target
-	target := util.CompactToTarget(0x207fffff)
-	shares := []*types.Share{
-		{Header: types.ShareHeader{Timestamp: 1700000030}, ShareTarget: target},
-		{Header: types.ShareHeader{Timestamp: 1700000000}, ShareTarget: target},
-	}
-	hr := poolHashrateFromShares(shares)
-	if hr <= 0 {
-		t.Errorf("expected positive hashrate, got %f", hr)
-	}
-
-	// Same timestamp → 0 (division by zero guard)
-	sameTime := []*types.Share{
-		{Header: types.ShareHeader{Timestamp: 1700000000}, ShareTarget: target},
-		{Header: types.ShareHeader{Timestamp: 1700000000}, ShareTarget: target},
-	}
-	if poolHashrateFromShares(sameTime) != 0 {
-		t.Error("same timestamp should return 0")
-	}
-}
-
 func TestLocalHashrate(t *testing.T) {
 	n := &Node{}
 
@@ -558,7 +715,7 @@ func TestGetPrevShareHash(t *testing.T) {
 	// Empty chain → zero hash
 	logger, _ := zap.NewDevelopment()
 	emptyStore := sharechain.NewMemoryStore()
-	emptyDiffCalc := sharechain.NewDifficultyCalculator(30 * time.Second)
+	emptyDiffCalc := sharechain.NewDifficultyCalculator(30*time.Second, sharechain.DifficultyAdjustmentWindow, nil)
 	emptyChain := sharechain.NewShareChain(emptyStore, emptyDiffCalc, 8640, testNetwork, logger)
 	emptyNode := &Node{logger: logger, chain: emptyChain}
 	if emptyNode.getPrevShareHash() != ([32]byte{}) {
@@ -574,3 +731,231 @@ func TestShareTarget(t *testing.T) {
 		t.Error("ShareTarget should return a positive value")
 	}
 }
+
+// --- getshares / orphan tests ---
+
+func TestHandleShareRequest_ReturnsAncestors(t *testing.T) {
+	n, shares := testNode(t)
+
+	resp := n.handleShareRequest(&p2p.ShareRequest{
+		Type:      p2p.MsgTypeShareReq,
+		StartHash: shares[7].Hash(),
+		Count:     3,
+	})
+
+	if len(resp.Shares) != 3 {
+		t.Fatalf("expected 3 shares, got %d", len(resp.Shares))
+	}
+	// Newest first, starting at the requested hash.
+	for i, msg := range resp.Shares {
+		got, err := p2p.ShareMsgToShare(&msg, testNetwork.MaxShareTarget())
+		if err != nil {
+			t.Fatalf("ShareMsgToShare: %v", err)
+		}
+		if got.Hash() != shares[7-i].Hash() {
+			t.Errorf("share %d should be chain index %d", i, 7-i)
+		}
+	}
+}
+
+func TestConnectOrphans_ConnectsDescendants(t *testing.T) {
+	n, shares := testNode(t)
+	n.orphans = newOrphanPool()
+
+	tip := shares[len(shares)-1]
+	now := tip.Header.Timestamp
+	a := makeTestShare(tip.Hash(), testMiner1, now+30)
+	b := makeTestShare(a.Hash(), testMiner1, now+60)
+	c := makeTestShare(b.Hash(), testMiner1, now+90)
+
+	// c and b arrive before their parents.
+	if !n.orphans.add(c, c.PrevShareHash) {
+		t.Error("first orphan on a parent should need a fetch")
+	}
+	if n.orphans.add(c, c.PrevShareHash) {
+		t.Error("duplicate orphan should not need a fetch")
+	}
+	n.orphans.add(b, b.PrevShareHash)
+	if got := n.orphans.count(); got != 2 {
+		t.Fatalf("orphan count = %d, want 2", got)
+	}
+
+	if err := n.chain.AddShare(a); err != nil {
+		t.Fatalf("add parent: %v", err)
+	}
+	n.connectOrphans(a.Hash())
+
+	for _, s := range []*types.Share{b, c} {
+		if _, ok := n.chain.GetShare(s.Hash()); !ok {
+			t.Errorf("orphan %s not connected", s.HashHex())
+		}
+	}
+	if got := n.orphans.count(); got != 0 {
+		t.Errorf("orphan count after connect = %d, want 0", got)
+	}
+}
+
+func TestConnectOrphans_WaitsForUncle(t *testing.T) {
+	n, shares := testNode(t)
+	n.orphans = newOrphanPool()
+
+	tip := shares[len(shares)-1]
+	now := tip.Header.Timestamp
+	uncle := makeTestShare(shares[len(shares)-2].Hash(), testMiner1, now+1)
+
+	// A version 2 share crediting an uncle we haven't seen yet.
+	child := makeTestShare(tip.Hash(), testMiner1, now+30)
+	child.ShareVersion = types.ShareVersion2
+	child.Height = int64(len(shares))
+	child.Uncles = [][32]byte{uncle.Hash()}
+	coinbaseTx, _, err := types.NewCoinbaseBuilder(testNetwork).BuildCoinbase(800000,
+		types.BuildShareCommitment(child.CommitmentHash()),
+		[]types.PayoutEntry{{Address: testMiner1, Amount: 5000000000}}, "", 8)
+	if err != nil {
+		t.Fatalf("BuildCoinbase: %v", err)
+	}
+	child.CoinbaseTx = coinbaseTx
+
+	err = n.chain.AddShare(child)
+	missing, ok := n.missingShare(child, err)
+	if !ok || missing != uncle.Hash() {
+		t.Fatalf("missingShare = %x, %v; want the uncle (err %v)", missing[:8], ok, err)
+	}
+	n.orphans.add(child, missing)
+
+	if err := n.chain.AddShare(uncle); err != nil {
+		t.Fatalf("add uncle: %v", err)
+	}
+	n.connectOrphans(uncle.Hash())
+	if _, ok := n.chain.GetShare(child.Hash()); !ok {
+		t.Error("share not connected once its uncle arrived")
+	}
+}
+
+func TestOrphanPool_CapEvictsOldest(t *testing.T) {
+	pool := newOrphanPool()
+	now := time.Now()
+	pool.now = func() time.Time { return now }
+
+	var first *types.Share
+	for i := 0; i < maxOrphans+1; i++ {
+		now = now.Add(time.Millisecond)
+		s := makeTestShare([32]byte{byte(i), byte(i >> 8), 1}, testMiner1, uint32(1700000000+i))
+		if i == 0 {
+			first = s
+		}
+		pool.add(s, s.PrevShareHash)
+	}
+
+	if got := pool.count(); got != maxOrphans {
+		t.Errorf("count = %d, want %d", got, maxOrphans)
+	}
+	if children := pool.take(first.PrevShareHash); len(children) != 0 {
+		t.Error("oldest orphan should have been evicted")
+	}
+}
+
+func TestOrphanPool_Expiry(t *testing.T) {
+	pool := newOrphanPool()
+	now := time.Now()
+	pool.now = func() time.Time { return now }
+
+	s := makeTestShare([32]byte{0x01}, testMiner1, 1700000000)
+	pool.add(s, s.PrevShareHash)
+
+	now = now.Add(orphanTTL + time.Second)
+	if children := pool.take(s.PrevShareHash); len(children) != 0 {
+		t.Error("expired orphan should not be returned")
+	}
+
+	pool.add(s, s.PrevShareHash)
+	now = now.Add(orphanTTL + time.Second)
+	if dropped := pool.expire(); dropped != 1 {
+		t.Errorf("expire dropped %d, want 1", dropped)
+	}
+	if got := pool.count(); got != 0 {
+		t.Errorf("count = %d, want 0", got)
+	}
+}
+
+func TestBackfillLimiter(t *testing.T) {
+	l := newBackfillLimiter()
+	for i := 0; i < backfillBurst; i++ {
+		if !l.allow("busy") {
+			t.Fatalf("backfill %d refused within the burst", i)
+		}
+	}
+	if l.allow("busy") {
+		t.Error("backfill past the burst allowed")
+	}
+	if !l.allow("other") {
+		t.Error("another peer's backfill refused")
+	}
+}
+
+func TestMinerWorkerLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels [2]string
+	}{
+		{"tb1qminer.rig1", [2]string{"tb1qminer", "rig1"}},
+		{"tb1qminer", [2]string{"tb1qminer", ""}},
+		{"tb1qminer.rack.rig", [2]string{"tb1qminer", "rack.rig"}},
+	}
+	for _, tt := range tests {
+		if got := minerWorkerLabels(tt.name); got != tt.labels {
+			t.Errorf("minerWorkerLabels(%q) = %v, want %v", tt.name, got, tt.labels)
+		}
+	}
+}
+
+// --- confirmation watcher tests ---
+
+func TestConfirmationWatcher(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	w := newConfirmationWatcher(rpc, zap.NewNop())
+	ctx := context.Background()
+
+	w.watch("maturing", 800000, nil)
+	resolved := make(map[string]bool)
+	w.watch("confirmed", 800001, func(orphaned bool) { resolved["confirmed"] = orphaned })
+	w.watch("stale", 800002, func(orphaned bool) { resolved["stale"] = orphaned })
+	w.watch("missing", 800003, nil)
+
+	rpc.SetBlock("maturing", &bitcoin.BlockInfo{Hash: "maturing", Confirmations: 5})
+	rpc.SetBlock("confirmed", &bitcoin.BlockInfo{Hash: "confirmed", Confirmations: confirmationDepth})
+	rpc.SetBlock("stale", &bitcoin.BlockInfo{Hash: "stale", Confirmations: -1})
+
+	w.check(ctx)
+
+	// A block bitcoind can't find yet stays watched for a while.
+	if got := w.pending(); got != 2 {
+		t.Fatalf("pending = %d, want 2", got)
+	}
+	if w.blocks["maturing"].confirmations != 5 {
+		t.Errorf("confirmations = %d, want 5", w.blocks["maturing"].confirmations)
+	}
+	if orphaned, ok := resolved["confirmed"]; !ok || orphaned {
+		t.Error("confirmed block should resolve as not orphaned")
+	}
+	if orphaned, ok := resolved["stale"]; !ok || !orphaned {
+		t.Error("stale block should resolve as orphaned")
+	}
+
+	for i := 1; i < confirmationNotFoundLimit; i++ {
+		w.check(ctx)
+	}
+	if _, ok := w.blocks["missing"]; ok {
+		t.Errorf("block still watched after %d polls not finding it", confirmationNotFoundLimit)
+	}
+	if got := w.pending(); got != 1 {
+		t.Fatalf("pending = %d, want 1", got)
+	}
+
+	// Transient RPC errors keep the block watched.
+	rpc.GetBlockErr = errors.New("connection refused")
+	w.check(ctx)
+	if got := w.pending(); got != 1 {
+		t.Errorf("pending after RPC error = %d, want 1", got)
+	}
+}
@@ -0,0 +1,302 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/djkazic/p2pool-go/internal/p2p"
+	"github.com/djkazic/p2pool-go/internal/sharechain"
+	"github.com/djkazic/p2pool-go/internal/types"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// backfillCount is how many ancestors we ask a peer for when one of its
+// shares arrives before its parent or an uncle.
+const backfillCount = 50
+
+const (
+	// maxOrphans caps the orphan pool so peers can't fill memory with
+	// shares that never connect.
+	maxOrphans = 256
+	// orphanTTL is how long an orphan waits for its parent.
+	orphanTTL = 2 * time.Minute
+)
+
+const (
+	// backfillRate and backfillBurst limit how many backfills one peer's
+	// orphans may trigger: a peer that is simply ahead needs a few, one
+	// sending shares that never connect could otherwise keep us fetching.
+	backfillRate  = rate.Limit(0.2)
+	backfillBurst = 5
+	// maxBackfillLimiters bounds the per-peer limiter map.
+	maxBackfillLimiters = 500
+)
+
+// orphan is a share held until the share it is missing is connected.
+type orphan struct {
+	share *types.Share
+	added time.Time
+}
+
+// orphanPool holds shares that arrived before their parent or one of their
+// uncles, keyed by the missing share's hash, until it is connected.
+type orphanPool struct {
+	mu        sync.Mutex
+	byMissing map[[32]byte][]orphan
+	size      int
+	now       func() time.Time
+}
+
+func newOrphanPool() *orphanPool {
+	return &orphanPool{
+		byMissing: make(map[[32]byte][]orphan),
+		now:       time.Now,
+	}
+}
+
+// add stores an orphan waiting on missing, expiring old entries and
+// evicting the oldest if the pool is full. Returns true if no other orphan
+// was already waiting on missing, i.e. it still needs to be fetched.
+func (p *orphanPool) add(share *types.Share, missing [32]byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expireLocked()
+
+	waiting := p.byMissing[missing]
+	hash := share.Hash()
+	for _, o := range waiting {
+		if o.share.Hash() == hash {
+			return false
+		}
+	}
+	if p.size >= maxOrphans {
+		p.evictOldestLocked()
+		waiting = p.byMissing[missing]
+	}
+	p.byMissing[missing] = append(waiting, orphan{share: share, added: p.now()})
+	p.size++
+	return len(waiting) == 0
+}
+
+// take removes and returns the unexpired orphans waiting on hash.
+func (p *orphanPool) take(hash [32]byte) []*types.Share {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	waiting := p.byMissing[hash]
+	delete(p.byMissing, hash)
+	p.size -= len(waiting)
+
+	cutoff := p.now().Add(-orphanTTL)
+	var children []*types.Share
+	for _, o := range waiting {
+		if o.added.After(cutoff) {
+			children = append(children, o.share)
+		}
+	}
+	return children
+}
+
+// expire drops orphans that have waited longer than orphanTTL and returns
+// how many were dropped.
+func (p *orphanPool) expire() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.expireLocked()
+}
+
+func (p *orphanPool) expireLocked() int {
+	cutoff := p.now().Add(-orphanTTL)
+	dropped := 0
+	for missing, waiting := range p.byMissing {
+		kept := waiting[:0]
+		for _, o := range waiting {
+			if o.added.After(cutoff) {
+				kept = append(kept, o)
+			}
+		}
+		dropped += len(waiting) - len(kept)
+		if len(kept) == 0 {
+			delete(p.byMissing, missing)
+		} else {
+			p.byMissing[missing] = kept
+		}
+	}
+	p.size -= dropped
+	return dropped
+}
+
+func (p *orphanPool) evictOldestLocked() {
+	var oldestKey [32]byte
+	oldestIdx := -1
+	var oldest time.Time
+	for missing, waiting := range p.byMissing {
+		for i, o := range waiting {
+			if oldestIdx < 0 || o.added.Before(oldest) {
+				oldestKey, oldestIdx, oldest = missing, i, o.added
+			}
+		}
+	}
+	if oldestIdx < 0 {
+		return
+	}
+	waiting := p.byMissing[oldestKey]
+	waiting = append(waiting[:oldestIdx], waiting[oldestIdx+1:]...)
+	if len(waiting) == 0 {
+		delete(p.byMissing, oldestKey)
+	} else {
+		p.byMissing[oldestKey] = waiting
+	}
+	p.size--
+}
+
+// count returns the number of orphans held.
+func (p *orphanPool) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+// backfillLimiter rate-limits the backfills each peer's orphans trigger.
+type backfillLimiter struct {
+	mu       sync.Mutex
+	limiters map[peer.ID]*rate.Limiter
+}
+
+func newBackfillLimiter() *backfillLimiter {
+	return &backfillLimiter{limiters: make(map[peer.ID]*rate.Limiter)}
+}
+
+// allow reports whether pid may trigger another backfill now. A full map
+// first forgets peers whose budget has refilled, as they are idle.
+func (l *backfillLimiter) allow(pid peer.ID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lim, ok := l.limiters[pid]
+	if !ok {
+		if len(l.limiters) >= maxBackfillLimiters {
+			for id, other := range l.limiters {
+				if other.Tokens() >= backfillBurst {
+					delete(l.limiters, id)
+				}
+			}
+		}
+		if len(l.limiters) >= maxBackfillLimiters {
+			return false
+		}
+		lim = rate.NewLimiter(backfillRate, backfillBurst)
+		l.limiters[pid] = lim
+	}
+	return lim.Allow()
+}
+
+// missingShare reports whether err is a validation failure that may only
+// be caused by not having the share's parent or one of its uncles yet, and
+// returns the first such share missing.
+func (n *Node) missingShare(share *types.Share, err error) ([32]byte, bool) {
+	var verr *sharechain.ValidationError
+	if !errors.As(err, &verr) {
+		return [32]byte{}, false
+	}
+	if share.PrevShareHash != ([32]byte{}) {
+		if _, ok := n.chain.GetShare(share.PrevShareHash); !ok {
+			return share.PrevShareHash, true
+		}
+	}
+	for _, u := range share.Uncles {
+		if _, ok := n.chain.GetShare(u); !ok {
+			return u, true
+		}
+	}
+	return [32]byte{}, false
+}
+
+// connectOrphans adds every orphan waiting on hash, and on those in turn,
+// now that hash is in the chain. An orphan still missing another uncle
+// goes back to waiting on it.
+func (n *Node) connectOrphans(hash [32]byte) {
+	queue := [][32]byte{hash}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		for _, share := range n.orphans.take(hash) {
+			if err := n.chain.AddShare(share); err != nil {
+				if missing, ok := n.missingShare(share, err); ok {
+					n.orphans.add(share, missing)
+					continue
+				}
+				n.logger.Debug("rejected orphan share", zap.Error(err))
+				continue
+			}
+			n.logger.Debug("connected orphan share", zap.String("hash", share.HashHex()))
+			queue = append(queue, share.Hash())
+		}
+	}
+}
+
+// backfillParent fetches a missing parent or uncle and its recent
+// ancestors from the peer that sent us the orphan, connecting orphans as
+// the shares they wait on arrive.
+// Falls back to a full sync from that peer if the gap is deeper than one
+// request.
+func (n *Node) backfillParent(ctx context.Context, pid peer.ID, parent [32]byte) {
+	syncer := n.p2pNode.Syncer()
+	if syncer == nil {
+		return
+	}
+
+	resp, err := syncer.RequestShares(ctx, pid, parent, backfillCount)
+	if err != nil {
+		n.logger.Debug("getshares request failed", zap.Error(err), zap.String("peer", pid.String()))
+		return
+	}
+
+	// Response is newest first; add oldest first so parents precede children.
+	var shares []*types.Share
+	for i := len(resp.Shares) - 1; i >= 0; i-- {
+		share, err := p2p.ShareMsgToShare(&resp.Shares[i], n.chain.MaxShareTarget())
+		if err != nil {
+			n.logger.Debug("malformed backfilled share", zap.Error(err))
+			n.p2pNode.PenalizePeer(pid, p2p.PenaltyMalformedMessage)
+			return
+		}
+		shares = append(shares, share)
+	}
+	if len(shares) == 0 {
+		return
+	}
+
+	if _, ok := n.chain.GetShare(shares[0].PrevShareHash); !ok && shares[0].PrevShareHash != ([32]byte{}) {
+		n.logger.Debug("orphan gap deeper than backfill, syncing from peer",
+			zap.String("peer", pid.String()))
+		n.syncFromPeer(ctx, pid)
+	} else {
+		for _, share := range shares {
+			if err := n.chain.AddShareQuiet(share); err != nil {
+				n.logger.Debug("rejected backfilled share", zap.Error(err))
+				break
+			}
+			n.connectOrphans(share.Hash())
+		}
+	}
+}
+
+// handleShareRequest serves a share and its ancestors to a peer filling a gap.
+func (n *Node) handleShareRequest(req *p2p.ShareRequest) *p2p.ShareResponse {
+	ancestors := n.chain.GetAncestors(req.StartHash, req.Count)
+	shares := make([]p2p.ShareMsg, 0, len(ancestors))
+	for _, share := range ancestors {
+		shares = append(shares, *p2p.ShareToShareMsg(share))
+	}
+	return &p2p.ShareResponse{
+		Type:   p2p.MsgTypeShareResp,
+		Shares: shares,
+	}
+}
@@ -0,0 +1,43 @@
+package p2p
+
+import (
+	"context"
+	"time"
+
+	lp2pmetrics "github.com/libp2p/go-libp2p/core/metrics"
+
+	"github.com/djkazic/p2pool-go/internal/metrics"
+)
+
+// bandwidthReportInterval is how often P2P traffic totals are exported to
+// Prometheus.
+const bandwidthReportInterval = 15 * time.Second
+
+// bandwidthLoop exports the node's traffic totals every
+// bandwidthReportInterval until ctx is done.
+func (n *Node) bandwidthLoop(ctx context.Context) {
+	ticker := time.NewTicker(bandwidthReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			exportBandwidth(n.bandwidth)
+		}
+	}
+}
+
+// exportBandwidth publishes total and per-protocol bytes sent and received.
+// Per-protocol totals separate gossip (the pubsub protocol) from sync
+// traffic.
+func exportBandwidth(bwc *lp2pmetrics.BandwidthCounter) {
+	total := bwc.GetBandwidthTotals()
+	metrics.P2PBytes.WithLabelValues("in").Set(float64(total.TotalIn))
+	metrics.P2PBytes.WithLabelValues("out").Set(float64(total.TotalOut))
+
+	for proto, stats := range bwc.GetBandwidthByProtocol() {
+		metrics.P2PProtocolBytes.WithLabelValues(string(proto), "in").Set(float64(stats.TotalIn))
+		metrics.P2PProtocolBytes.WithLabelValues(string(proto), "out").Set(float64(stats.TotalOut))
+	}
+}
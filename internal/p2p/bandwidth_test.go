@@ -0,0 +1,57 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	lp2pmetrics "github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gaugeValue returns the value of the registered gauge name with labels, or
+// -1 if there is none.
+func gaugeValue(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if labels[lp.GetName()] != lp.GetValue() {
+					continue metrics
+				}
+			}
+			return m.GetGauge().GetValue()
+		}
+	}
+	return -1
+}
+
+func TestExportBandwidth(t *testing.T) {
+	bwc := lp2pmetrics.NewBandwidthCounter()
+	bwc.LogRecvMessage(3000)
+	bwc.LogSentMessage(2000)
+	bwc.LogRecvMessageStream(1000, SyncProtocolID, "peer")
+
+	// The counter folds marks into its totals once a second.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		exportBandwidth(bwc)
+		in := gaugeValue(t, "p2pool_p2p_bytes", map[string]string{"direction": "in"})
+		out := gaugeValue(t, "p2pool_p2p_bytes", map[string]string{"direction": "out"})
+		sync := gaugeValue(t, "p2pool_p2p_protocol_bytes", map[string]string{"protocol": SyncProtocolID, "direction": "in"})
+		if in == 3000 && out == 2000 && sync == 1000 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("exported in=%v out=%v sync in=%v; want 3000, 2000, 1000", in, out, sync)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -1,6 +1,8 @@
 package p2p
 
 import (
+	"fmt"
+
 	"github.com/klauspost/compress/zstd"
 )
 
@@ -18,8 +20,37 @@ func CompressCoinbase(data []byte) []byte {
 // If the data does not start with the zstd magic bytes, it is returned as-is
 // for forward compatibility with uncompressed shares.
 func DecompressCoinbase(data []byte) ([]byte, error) {
-	if len(data) < 4 || data[0] != 0x28 || data[1] != 0xB5 || data[2] != 0x2F || data[3] != 0xFD {
+	if !isZstd(data) {
 		return data, nil
 	}
 	return zstdDecoder.DecodeAll(data, nil)
 }
+
+// compressMessage compresses an encoded P2P message using zstd.
+func compressMessage(data []byte) []byte {
+	return zstdEncoder.EncodeAll(data, nil)
+}
+
+// decompressMessage decompresses an encoded P2P message. Data without the
+// zstd magic bytes is returned as-is for compatibility with peers that send
+// uncompressed messages; the bool reports whether data was compressed. The
+// decompressed size is capped at maxSyncMsgSize to guard against
+// decompression bombs.
+func decompressMessage(data []byte) ([]byte, bool, error) {
+	if !isZstd(data) {
+		return data, false, nil
+	}
+	out, err := zstdDecoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, true, err
+	}
+	if len(out) > maxSyncMsgSize {
+		return nil, true, fmt.Errorf("decompressed message too large: %d bytes", len(out))
+	}
+	return out, true, nil
+}
+
+// isZstd reports whether data starts with the zstd frame magic bytes.
+func isZstd(data []byte) bool {
+	return len(data) >= 4 && data[0] == 0x28 && data[1] == 0xB5 && data[2] == 0x2F && data[3] == 0xFD
+}
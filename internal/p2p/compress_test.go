@@ -0,0 +1,36 @@
+package p2p
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecompressMessage(t *testing.T) {
+	raw := bytes.Repeat([]byte("p2pool share data "), 100)
+
+	compressed := compressMessage(raw)
+	if len(compressed) >= len(raw) {
+		t.Errorf("compressed %d bytes, want fewer than %d", len(compressed), len(raw))
+	}
+
+	out, wasCompressed, err := decompressMessage(compressed)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if !wasCompressed || !bytes.Equal(out, raw) {
+		t.Error("round trip mismatch")
+	}
+
+	// Uncompressed data passes through unchanged.
+	out, wasCompressed, err = decompressMessage(raw)
+	if err != nil || wasCompressed || !bytes.Equal(out, raw) {
+		t.Error("uncompressed data should pass through")
+	}
+}
+
+func TestDecompressMessage_Bomb(t *testing.T) {
+	bomb := compressMessage(make([]byte, 2*maxSyncMsgSize))
+	if _, _, err := decompressMessage(bomb); err == nil {
+		t.Error("expected error for oversized decompressed message")
+	}
+}
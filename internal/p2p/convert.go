@@ -0,0 +1,75 @@
+package p2p
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+	"github.com/djkazic/p2pool-go/pkg/util"
+)
+
+// ShareMsgToShare converts a P2P share message to a types.Share, expanding
+// the compact share target and decompressing the coinbase. A target easier
+// than maxTarget, the network's easiest share target, is rejected, as is a
+// message whose ShareTargetBits is not the canonical compact form of its
+// own target, so a share has exactly one wire encoding.
+func ShareMsgToShare(msg *ShareMsg, maxTarget *big.Int) (*types.Share, error) {
+	target, err := util.CompactToTargetChecked(msg.ShareTargetBits, maxTarget)
+	if err != nil {
+		return nil, fmt.Errorf("share target bits: %w", err)
+	}
+	if !util.IsCanonicalCompact(msg.ShareTargetBits) {
+		return nil, fmt.Errorf("share target bits %08x are not canonical (want %08x)",
+			msg.ShareTargetBits, util.TargetToCompact(target))
+	}
+
+	coinbaseTx, err := DecompressCoinbase(msg.CoinbaseTx)
+	if err != nil {
+		return nil, fmt.Errorf("decompress coinbase: %w", err)
+	}
+
+	return &types.Share{
+		Header: types.ShareHeader{
+			Version:       msg.Version,
+			PrevBlockHash: msg.PrevBlockHash,
+			MerkleRoot:    msg.MerkleRoot,
+			Timestamp:     msg.Timestamp,
+			Bits:          msg.Bits,
+			Nonce:         msg.Nonce,
+		},
+		ShareVersion:  msg.ShareVersion,
+		PrevShareHash: msg.PrevShareHash,
+		ShareTarget:   target,
+		MinerAddress:  msg.MinerAddress,
+		CoinbaseTx:    coinbaseTx,
+		Uncles:        msg.Uncles,
+		Height:        msg.Height,
+	}, nil
+}
+
+// ShareToShareMsg converts a types.Share to a P2P share message, compacting
+// the share target and compressing the coinbase. A missing or non-positive
+// target is sent as zero bits.
+func ShareToShareMsg(share *types.Share) *ShareMsg {
+	var shareTargetBits uint32
+	if share.ShareTarget != nil && share.ShareTarget.Sign() > 0 {
+		shareTargetBits = util.TargetToCompact(share.ShareTarget)
+	}
+
+	return &ShareMsg{
+		Type:            MsgTypeShare,
+		Version:         share.Header.Version,
+		PrevBlockHash:   share.Header.PrevBlockHash,
+		MerkleRoot:      share.Header.MerkleRoot,
+		Timestamp:       share.Header.Timestamp,
+		Bits:            share.Header.Bits,
+		Nonce:           share.Header.Nonce,
+		ShareVersion:    share.ShareVersion,
+		PrevShareHash:   share.PrevShareHash,
+		ShareTargetBits: shareTargetBits,
+		MinerAddress:    share.MinerAddress,
+		CoinbaseTx:      CompressCoinbase(share.CoinbaseTx),
+		Uncles:          share.Uncles,
+		Height:          share.Height,
+	}
+}
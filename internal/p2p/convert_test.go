@@ -0,0 +1,76 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+	"github.com/djkazic/p2pool-go/pkg/util"
+)
+
+func TestShareMsgConversion_RoundTrip(t *testing.T) {
+	share := &types.Share{
+		Header: types.ShareHeader{
+			Version:   0x20000000,
+			Timestamp: 1700000000,
+			Bits:      0x1d00ffff,
+			Nonce:     7,
+		},
+		ShareVersion:  2,
+		PrevShareHash: [32]byte{1},
+		ShareTarget:   util.CompactToTarget(0x1e0fffff),
+		MinerAddress:  "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx",
+		CoinbaseTx:    []byte{0x01, 0x00, 0x00, 0x00, 0xaa, 0xbb},
+		Uncles:        [][32]byte{{2}},
+		Height:        12,
+	}
+
+	msg := ShareToShareMsg(share)
+	if msg.ShareTargetBits != 0x1e0fffff {
+		t.Errorf("ShareTargetBits = %08x, want 1e0fffff", msg.ShareTargetBits)
+	}
+
+	back, err := ShareMsgToShare(msg, types.RegTestParams.MaxShareTarget())
+	if err != nil {
+		t.Fatalf("ShareMsgToShare: %v", err)
+	}
+	if back.Hash() != share.Hash() {
+		t.Error("hash mismatch after round-trip")
+	}
+	if back.ShareTarget.Cmp(share.ShareTarget) != 0 {
+		t.Errorf("ShareTarget = %x, want %x", back.ShareTarget, share.ShareTarget)
+	}
+	if string(back.CoinbaseTx) != string(share.CoinbaseTx) {
+		t.Error("coinbase mismatch after round-trip")
+	}
+	if back.Height != share.Height {
+		t.Errorf("Height = %d, want %d", back.Height, share.Height)
+	}
+}
+
+func TestShareMsgToShare_RejectsNonCanonicalBits(t *testing.T) {
+	for _, bits := range []uint32{
+		0x1e800000, // negative
+		0x04000000, // zero mantissa with nonzero exponent
+		0x1d000fff, // unnormalized mantissa
+		0x1d80ffff, // sign bit set
+		0xff7fffff, // exponent far past 256 bits
+		0x21008000, // above the regtest limit
+	} {
+		msg := &ShareMsg{ShareTargetBits: bits}
+		if _, err := ShareMsgToShare(msg, types.RegTestParams.MaxShareTarget()); err == nil {
+			t.Errorf("bits %08x: expected error", bits)
+		}
+	}
+
+	// Bits within regtest's limit are still too easy for mainnet.
+	msg := &ShareMsg{ShareTargetBits: 0x207fffff}
+	if _, err := ShareMsgToShare(msg, types.MainNetParams.MaxShareTarget()); err == nil {
+		t.Error("target above the network limit accepted")
+	}
+
+	// A canonical encoding is accepted.
+	msg = &ShareMsg{ShareTargetBits: util.TargetToCompact(util.CompactToTarget(0x1d00ffff))}
+	if _, err := ShareMsgToShare(msg, types.RegTestParams.MaxShareTarget()); err != nil {
+		t.Errorf("canonical bits rejected: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package p2p
+
+import (
+	"container/list"
+	"sync"
+)
+
+// seenSharesSize is how many recent share hashes the gossip dedup cache
+// remembers.
+const seenSharesSize = 4096
+
+// seenCache is a fixed-size LRU set of share hashes.
+type seenCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[[32]byte]*list.Element
+}
+
+func newSeenCache(size int) *seenCache {
+	return &seenCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[[32]byte]*list.Element, size),
+	}
+}
+
+// has reports whether hash is present, marking it recently used.
+func (c *seenCache) has(hash [32]byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[hash]; ok {
+		c.order.MoveToFront(el)
+		return true
+	}
+	return false
+}
+
+// add records hash, evicting the least recently used entry if full.
+func (c *seenCache) add(hash [32]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[hash]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[hash] = c.order.PushFront(hash)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.([32]byte))
+	}
+}
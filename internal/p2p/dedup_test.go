@@ -0,0 +1,46 @@
+package p2p
+
+import "testing"
+
+func TestSeenCache_EvictsLeastRecent(t *testing.T) {
+	c := newSeenCache(2)
+
+	a, b, d := [32]byte{0x01}, [32]byte{0x02}, [32]byte{0x03}
+
+	if c.has(a) {
+		t.Fatal("fresh hash reported as seen")
+	}
+	c.add(a)
+	c.add(b)
+	if !c.has(a) {
+		t.Fatal("a should be seen")
+	}
+
+	// a was just touched, so adding d evicts b.
+	c.add(d)
+	if !c.has(a) {
+		t.Error("a should still be cached")
+	}
+	if c.has(b) {
+		t.Error("b should have been evicted")
+	}
+}
+
+func TestShareMsg_HeaderHashIgnoresEncoding(t *testing.T) {
+	msg := testShareMsg()
+	data, err := Encode(msg)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	decoded, err := DecodeShareMsg(data, 0)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	// A relay that rewrites non-header fields still produces the same hash.
+	decoded.MinerAddress = "tb1qy"
+	decoded.CoinbaseTx = []byte{0x04}
+	if decoded.HeaderHash() != msg.HeaderHash() {
+		t.Error("header hash changed with non-header fields")
+	}
+}
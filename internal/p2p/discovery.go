@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"path/filepath"
+	"sync"
 	"time"
 
 	leveldb "github.com/ipfs/go-ds-leveldb"
@@ -12,6 +14,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
 
@@ -24,21 +27,51 @@ const (
 
 	// DHTNamespace is the Kademlia DHT namespace for peer discovery.
 	DHTNamespace = "p2pool-go"
+
+	// savedPeerDialCount is how many saved peers are dialed at startup.
+	savedPeerDialCount = 8
+
+	// savedPeerDialTimeout bounds each startup dial to a saved peer.
+	savedPeerDialTimeout = 10 * time.Second
+
+	// bootnodeCheckInterval is how often a bootnode's connection is
+	// checked, and the first delay before re-dialing one that is down.
+	bootnodeCheckInterval = 30 * time.Second
+
+	// bootnodeMaxBackoff caps the delay between dials to a bootnode that
+	// keeps failing.
+	bootnodeMaxBackoff = 10 * time.Minute
+
+	// bootnodeDialTimeout bounds each re-dial to a bootnode.
+	bootnodeDialTimeout = 15 * time.Second
+
+	// advertiseDefaultTTL is how long a DHT advertisement is assumed to
+	// last when Advertise reports no TTL; advertiseMinInterval is the
+	// shortest wait between successful advertisements.
+	advertiseDefaultTTL  = 10 * time.Minute
+	advertiseMinInterval = 1 * time.Minute
+
+	// advertiseMinBackoff and advertiseMaxBackoff bound the retry delay
+	// after failed advertisements.
+	advertiseMinBackoff = 5 * time.Second
+	advertiseMaxBackoff = 60 * time.Second
 )
 
 // Discovery manages peer discovery via mDNS and Kademlia DHT.
 type Discovery struct {
-	host   host.Host
-	logger *zap.Logger
-	dht    *dht.IpfsDHT
-	dhtDS  io.Closer // persistent DHT datastore (nil if in-memory)
+	host      host.Host
+	logger    *zap.Logger
+	namespace string // DHT rendezvous namespace
+	dht       *dht.IpfsDHT
+	dhtDS     io.Closer // persistent DHT datastore (nil if in-memory)
 }
 
 // NewDiscovery creates a new discovery service.
-func NewDiscovery(ctx context.Context, h host.Host, enableMDNS bool, bootnodes []string, savedPeers []peer.AddrInfo, dataDir string, logger *zap.Logger) (*Discovery, error) {
+func NewDiscovery(ctx context.Context, h host.Host, enableMDNS bool, bootnodes []string, savedPeers []peer.AddrInfo, dataDir, namespace string, logger *zap.Logger) (*Discovery, error) {
 	d := &Discovery{
-		host:   h,
-		logger: logger,
+		host:      h,
+		logger:    logger,
+		namespace: namespace,
 	}
 
 	// Setup mDNS for LAN discovery
@@ -52,16 +85,7 @@ func NewDiscovery(ctx context.Context, h host.Host, enableMDNS bool, bootnodes [
 	}
 
 	// Reconnect to previously known peers before DHT bootstrap
-	for _, pi := range savedPeers {
-		if pi.ID == h.ID() {
-			continue
-		}
-		if err := h.Connect(ctx, pi); err != nil {
-			logger.Debug("failed to connect to saved peer", zap.String("peer", pi.ID.String()), zap.Error(err))
-		} else {
-			logger.Info("connected to saved peer", zap.String("peer", pi.ID.String()))
-		}
-	}
+	d.dialSavedPeers(ctx, savedPeers)
 
 	// Open persistent LevelDB datastore for DHT routing table
 	dhtOpts := []dht.Option{dht.Mode(dht.ModeAutoServer)}
@@ -88,7 +112,8 @@ func NewDiscovery(ctx context.Context, h host.Host, enableMDNS bool, bootnodes [
 		return nil, fmt.Errorf("bootstrap DHT: %w", err)
 	}
 
-	// Connect to bootnodes
+	// Connect to bootnodes, and keep re-dialing any that drop or were
+	// down at startup.
 	for _, bn := range bootnodes {
 		addr, err := peer.AddrInfoFromString(bn)
 		if err != nil {
@@ -100,6 +125,7 @@ func NewDiscovery(ctx context.Context, h host.Host, enableMDNS bool, bootnodes [
 		} else {
 			logger.Info("connected to bootnode", zap.String("peer", addr.ID.String()))
 		}
+		go d.maintainBootnode(ctx, *addr, bootnodeCheckInterval, bootnodeMaxBackoff)
 	}
 
 	// Start routing discovery
@@ -110,6 +136,89 @@ func NewDiscovery(ctx context.Context, h host.Host, enableMDNS bool, bootnodes [
 	return d, nil
 }
 
+// dialSavedPeers loads saved peers into the peerstore and dials the first
+// savedPeerDialCount of them (the best ones, per SavePeers) in parallel.
+// The rest stay in the peerstore for the DHT and later dials.
+func (d *Discovery) dialSavedPeers(ctx context.Context, savedPeers []peer.AddrInfo) {
+	var wg sync.WaitGroup
+	dialed := 0
+	for _, pi := range savedPeers {
+		if pi.ID == d.host.ID() {
+			continue
+		}
+		d.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.AddressTTL)
+		if dialed >= savedPeerDialCount {
+			continue
+		}
+		dialed++
+
+		wg.Add(1)
+		go func(pi peer.AddrInfo) {
+			defer wg.Done()
+			dialCtx, cancel := context.WithTimeout(ctx, savedPeerDialTimeout)
+			defer cancel()
+			if err := d.host.Connect(dialCtx, pi); err != nil {
+				d.logger.Debug("failed to connect to saved peer", zap.String("peer", pi.ID.String()), zap.Error(err))
+			} else {
+				d.logger.Info("connected to saved peer", zap.String("peer", pi.ID.String()))
+			}
+		}(pi)
+	}
+	wg.Wait()
+}
+
+// maintainBootnode keeps a connection to a bootnode until ctx is done. While
+// connected it only checks the connection every interval; once it drops,
+// the bootnode is re-dialed with jittered exponential backoff from interval
+// up to maxBackoff, so a bootnode that was down recovers its peers without
+// every node dialing it at once.
+func (d *Discovery) maintainBootnode(ctx context.Context, pi peer.AddrInfo, interval, maxBackoff time.Duration) {
+	connected := d.host.Network().Connectedness(pi.ID) == network.Connected
+	backoff := interval
+	for {
+		wait := interval
+		if d.host.Network().Connectedness(pi.ID) == network.Connected {
+			if !connected {
+				d.logger.Info("bootnode connected", zap.String("peer", pi.ID.String()))
+				connected = true
+			}
+			backoff = interval
+		} else {
+			if connected {
+				d.logger.Warn("bootnode disconnected", zap.String("peer", pi.ID.String()))
+				connected = false
+			}
+			dialCtx, cancel := context.WithTimeout(ctx, bootnodeDialTimeout)
+			err := d.host.Connect(dialCtx, pi)
+			cancel()
+			if err == nil {
+				d.logger.Info("reconnected to bootnode", zap.String("peer", pi.ID.String()))
+				connected = true
+				backoff = interval
+			} else {
+				d.logger.Debug("bootnode redial failed", zap.String("peer", pi.ID.String()),
+					zap.Error(err), zap.Duration("retry_in", backoff))
+				wait = backoff
+				backoff = min(backoff*2, maxBackoff)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jitter(wait)):
+		}
+	}
+}
+
+// jitter returns a random duration in [d/2, 3d/2).
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d)
+}
+
 // Close shuts down the DHT and its persistent datastore.
 func (d *Discovery) Close() error {
 	if err := d.dht.Close(); err != nil {
@@ -133,48 +242,47 @@ func (d *Discovery) HandlePeerFound(pi peer.AddrInfo) {
 	}
 }
 
+// advertiseLoop advertises this node under the DHT namespace until ctx is
+// done, re-advertising as each advertisement expires.
 func (d *Discovery) advertiseLoop(ctx context.Context, rd *drouting.RoutingDiscovery) {
-	backoff := 5 * time.Second
-	const maxBackoff = 60 * time.Second
-	const defaultTTL = 10 * time.Minute
-
+	backoff := advertiseMinBackoff
 	for {
-		ttl, err := rd.Advertise(ctx, DHTNamespace)
+		ttl, err := rd.Advertise(ctx, d.namespace)
+		var wait time.Duration
+		wait, backoff = advertiseDelay(ttl, err, backoff)
 		if err != nil {
-			d.logger.Debug("DHT advertise error", zap.Error(err), zap.Duration("retry_in", backoff))
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(backoff):
-			}
-			backoff *= 2
-			if backoff > maxBackoff {
-				backoff = maxBackoff
-			}
-			continue
+			d.logger.Debug("DHT advertise error", zap.Error(err), zap.Duration("retry_in", wait))
 		}
 
-		// Re-advertise when the TTL expires. Advertise returns immediately,
-		// so we must sleep to avoid a hot loop.
-		backoff = 5 * time.Second
-		reAdvertise := defaultTTL
-		if ttl > 0 {
-			reAdvertise = ttl
-		}
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(reAdvertise):
+		case <-time.After(wait):
 		}
 	}
 }
 
+// advertiseDelay returns how long advertiseLoop waits after an
+// advertisement that returned ttl and err, and the error backoff to use
+// next. Failures back off exponentially; a success waits out the TTL, but
+// never less than advertiseMinInterval, so an unhealthy DHT returning
+// immediately can't make the loop spin.
+func advertiseDelay(ttl time.Duration, err error, backoff time.Duration) (wait, next time.Duration) {
+	if err != nil {
+		return backoff, min(backoff*2, advertiseMaxBackoff)
+	}
+	if ttl <= 0 {
+		ttl = advertiseDefaultTTL
+	}
+	return max(ttl, advertiseMinInterval), advertiseMinBackoff
+}
+
 func (d *Discovery) discoverLoop(ctx context.Context, rd *drouting.RoutingDiscovery) {
 	backoff := 30 * time.Second
 	const maxBackoff = 5 * time.Minute
 
 	for {
-		peerCh, err := rd.FindPeers(ctx, DHTNamespace)
+		peerCh, err := rd.FindPeers(ctx, d.namespace)
 		if err != nil {
 			d.logger.Warn("DHT find peers error", zap.Error(err), zap.Duration("retry_in", backoff))
 			select {
@@ -0,0 +1,82 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"go.uber.org/zap"
+)
+
+func TestMaintainBootnode_Redials(t *testing.T) {
+	a, bootnode := newTestHost(t), newTestHost(t)
+	d := &Discovery{host: a, logger: zap.NewNop()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Not connected at startup, as if the bootnode was briefly down.
+	pi := peer.AddrInfo{ID: bootnode.ID(), Addrs: bootnode.Addrs()}
+	go d.maintainBootnode(ctx, pi, 20*time.Millisecond, 100*time.Millisecond)
+
+	waitConnected := func(what string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for a.Network().Connectedness(bootnode.ID()) != network.Connected {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: bootnode not connected", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitConnected("initial dial")
+
+	if err := a.Network().ClosePeer(bootnode.ID()); err != nil {
+		t.Fatalf("ClosePeer: %v", err)
+	}
+	waitConnected("after disconnect")
+}
+
+func TestJitter(t *testing.T) {
+	for range 100 {
+		if j := jitter(time.Second); j < 500*time.Millisecond || j >= 1500*time.Millisecond {
+			t.Fatalf("jitter(1s) = %v, want [500ms, 1.5s)", j)
+		}
+	}
+	if jitter(0) != 0 {
+		t.Error("jitter(0) should be 0")
+	}
+}
+
+func TestAdvertiseDelay(t *testing.T) {
+	// Repeated failures back off up to the cap.
+	backoff := advertiseMinBackoff
+	var waits []time.Duration
+	for range 6 {
+		var wait time.Duration
+		wait, backoff = advertiseDelay(0, errors.New("dht unhealthy"), backoff)
+		waits = append(waits, wait)
+	}
+	want := []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second, 60 * time.Second, 60 * time.Second}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Fatalf("failure waits = %v, want %v", waits, want)
+		}
+	}
+
+	// A success resets the backoff and waits out the TTL, bounded below so
+	// an advertisement that returns at once can't spin the loop.
+	for _, tc := range []struct{ ttl, want time.Duration }{
+		{0, advertiseDefaultTTL},
+		{time.Nanosecond, advertiseMinInterval},
+		{3 * time.Hour, 3 * time.Hour},
+	} {
+		wait, next := advertiseDelay(tc.ttl, nil, backoff)
+		if wait != tc.want || next != advertiseMinBackoff {
+			t.Errorf("advertiseDelay(%v, nil) = %v, %v; want %v, %v", tc.ttl, wait, next, tc.want, advertiseMinBackoff)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"go.uber.org/zap"
+)
+
+// SharesHandler handles share requests (start hash → ancestors, newest first).
+type SharesHandler func(req *ShareRequest) *ShareResponse
+
+// HandleShareRequests registers the getshares protocol, serving requests
+// with handler.
+func (s *Syncer) HandleShareRequests(handler SharesHandler) {
+	s.sharesHandler = handler
+	s.host.SetStreamHandler(protocol.ID(GetSharesProtocolID), s.handleGetSharesStream)
+}
+
+// handleGetSharesStream handles incoming share requests (getshares/1.0.0).
+func (s *Syncer) handleGetSharesStream(stream network.Stream) {
+	if !s.allowRequest(stream) {
+		return
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(syncStreamTimeout))
+
+	data, compressed, err := s.readMessage(stream)
+	if err != nil {
+		s.logger.Debug("getshares read error", zap.Error(err))
+		return
+	}
+
+	req, err := DecodeShareRequest(data)
+	if err != nil {
+		s.logger.Debug("invalid share request", zap.Error(err))
+		return
+	}
+
+	resp := s.sharesHandler(req)
+	if resp == nil {
+		resp = &ShareResponse{Type: MsgTypeShareResp}
+	}
+
+	data, err = Encode(resp)
+	if err != nil {
+		s.logger.Error("encode share response", zap.Error(err))
+		return
+	}
+
+	s.writeMessage(stream, data, compressed)
+}
+
+// RequestShares asks a peer for up to count shares starting at start and
+// walking back through their parents. The response is newest first.
+func (s *Syncer) RequestShares(ctx context.Context, peerID peer.ID, start [32]byte, count int) (*ShareResponse, error) {
+	stream, err := s.host.NewStream(ctx, peerID, protocol.ID(GetSharesProtocolID))
+	if err != nil {
+		return nil, fmt.Errorf("open stream: %w", err)
+	}
+	defer stream.Close()
+
+	req := &ShareRequest{
+		Type:      MsgTypeShareReq,
+		StartHash: start,
+		Count:     count,
+	}
+
+	data, err := Encode(req)
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+
+	if err := s.writeMessage(stream, data, true); err != nil {
+		return nil, fmt.Errorf("write request: %w", err)
+	}
+
+	stream.CloseWrite()
+
+	data, _, err = s.readMessage(stream)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	resp, err := DecodeShareResponse(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if len(resp.Shares) > count {
+		return nil, fmt.Errorf("%w: %d shares, requested %d", ErrResponseTooLarge, len(resp.Shares), count)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,166 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// HandshakeProtocolID is the protocol ID for the connect-time handshake.
+	HandshakeProtocolID = "/p2pool/handshake/1.0.0"
+
+	handshakeTimeout    = 10 * time.Second
+	maxHandshakeMsgSize = 1024
+)
+
+// Handshake is exchanged right after connecting so incompatible peers are
+// dropped before any shares flow.
+type Handshake struct {
+	Type            MessageType `cbor:"1,keyasint"`
+	ProtocolVersion string      `cbor:"2,keyasint"`
+	Network         string      `cbor:"3,keyasint"`
+	ChainID         [32]byte    `cbor:"4,keyasint"` // see sharechain.ChainID
+}
+
+// DecodeHandshake decodes a CBOR-encoded Handshake.
+func DecodeHandshake(data []byte) (*Handshake, error) {
+	var msg Handshake
+	if err := decMode.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// checkCompatible returns an error describing why a remote handshake is
+// incompatible with ours. The sharechain identity is checked first; it is
+// the guard that keeps nodes on different networks apart.
+func (local *Handshake) checkCompatible(remote *Handshake) error {
+	if remote.Network != local.Network {
+		return fmt.Errorf("network mismatch: peer on %q, we are on %q", remote.Network, local.Network)
+	}
+	if remote.ChainID != local.ChainID {
+		return fmt.Errorf("sharechain mismatch: peer chain %x, ours %x", remote.ChainID[:8], local.ChainID[:8])
+	}
+	if majorMinor(remote.ProtocolVersion) != majorMinor(local.ProtocolVersion) {
+		return fmt.Errorf("protocol version mismatch: peer %s, ours %s", remote.ProtocolVersion, local.ProtocolVersion)
+	}
+	return nil
+}
+
+// majorMinor strips the patch component from a semantic version.
+func majorMinor(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// Handshaker runs the handshake protocol.
+type Handshaker struct {
+	host   host.Host
+	local  *Handshake
+	logger *zap.Logger
+}
+
+// NewHandshaker registers the handshake stream handler.
+func NewHandshaker(h host.Host, network string, chainID [32]byte, logger *zap.Logger) *Handshaker {
+	hs := &Handshaker{
+		host: h,
+		local: &Handshake{
+			Type:            MsgTypeHandshake,
+			ProtocolVersion: ProtocolVersion,
+			Network:         network,
+			ChainID:         chainID,
+		},
+		logger: logger,
+	}
+	h.SetStreamHandler(protocol.ID(HandshakeProtocolID), hs.handleStream)
+	return hs
+}
+
+// handleStream answers a peer's handshake with ours, then disconnects the
+// peer if it is incompatible.
+func (hs *Handshaker) handleStream(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	data, err := io.ReadAll(io.LimitReader(stream, maxHandshakeMsgSize))
+	if err != nil {
+		hs.logger.Debug("handshake read error", zap.Error(err))
+		return
+	}
+	remote, err := DecodeHandshake(data)
+	if err != nil {
+		hs.logger.Debug("invalid handshake", zap.Error(err))
+		return
+	}
+
+	data, err = Encode(hs.local)
+	if err != nil {
+		hs.logger.Error("encode handshake", zap.Error(err))
+		return
+	}
+	stream.Write(data)
+
+	if err := hs.local.checkCompatible(remote); err != nil {
+		hs.reject(stream.Conn().RemotePeer(), err)
+	}
+}
+
+// Verify performs the handshake with a peer and disconnects it if it is
+// incompatible. Peers that predate the handshake protocol are allowed.
+func (hs *Handshaker) Verify(ctx context.Context, pid peer.ID) error {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	stream, err := hs.host.NewStream(ctx, pid, protocol.ID(HandshakeProtocolID))
+	if err != nil {
+		hs.logger.Debug("handshake not supported by peer", zap.String("peer", pid.String()), zap.Error(err))
+		return nil
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	data, err := Encode(hs.local)
+	if err != nil {
+		return fmt.Errorf("encode handshake: %w", err)
+	}
+	if _, err := stream.Write(data); err != nil {
+		return fmt.Errorf("write handshake: %w", err)
+	}
+	stream.CloseWrite()
+
+	data, err = io.ReadAll(io.LimitReader(stream, maxHandshakeMsgSize))
+	if err != nil {
+		return fmt.Errorf("read handshake: %w", err)
+	}
+	remote, err := DecodeHandshake(data)
+	if err != nil {
+		return fmt.Errorf("decode handshake: %w", err)
+	}
+
+	if err := hs.local.checkCompatible(remote); err != nil {
+		hs.reject(pid, err)
+		return err
+	}
+	return nil
+}
+
+func (hs *Handshaker) reject(pid peer.ID, reason error) {
+	hs.logger.Warn("disconnecting incompatible peer",
+		zap.String("peer", pid.String()),
+		zap.String("reason", reason.Error()),
+	)
+	hs.host.Network().ClosePeer(pid)
+}
@@ -0,0 +1,70 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"go.uber.org/zap"
+)
+
+func TestHandshake_Compatible(t *testing.T) {
+	hostA := newTestHost(t)
+	hostB := newTestHost(t)
+	chainID := [32]byte{0x01}
+
+	NewHandshaker(hostA, "testnet3", chainID, zap.NewNop())
+	hsB := NewHandshaker(hostB, "testnet3", chainID, zap.NewNop())
+	connectHosts(t, hostA, hostB)
+
+	if err := hsB.Verify(context.Background(), hostA.ID()); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if hostB.Network().Connectedness(hostA.ID()) != network.Connected {
+		t.Error("compatible peer should stay connected")
+	}
+}
+
+func TestHandshake_Mismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		chainID [32]byte
+		version string
+	}{
+		{"network", "mainnet", [32]byte{0x01}, ProtocolVersion},
+		{"chain", "testnet3", [32]byte{0x02}, ProtocolVersion},
+		{"version", "testnet3", [32]byte{0x01}, "2.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostA := newTestHost(t)
+			hostB := newTestHost(t)
+
+			hsA := NewHandshaker(hostA, tt.network, tt.chainID, zap.NewNop())
+			hsA.local.ProtocolVersion = tt.version
+			hsB := NewHandshaker(hostB, "testnet3", [32]byte{0x01}, zap.NewNop())
+			connectHosts(t, hostA, hostB)
+
+			if err := hsB.Verify(context.Background(), hostA.ID()); err == nil {
+				t.Fatal("expected handshake mismatch")
+			}
+
+			deadline := time.Now().Add(2 * time.Second)
+			for hostB.Network().Connectedness(hostA.ID()) == network.Connected {
+				if time.Now().After(deadline) {
+					t.Fatal("incompatible peer was not disconnected")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestMajorMinor(t *testing.T) {
+	if majorMinor("1.0.3") != "1.0" || majorMinor("1.2") != "1.2" || majorMinor("x") != "x" {
+		t.Error("unexpected majorMinor result")
+	}
+}
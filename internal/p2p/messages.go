@@ -1,10 +1,14 @@
 package p2p
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 
 	"github.com/fxamacker/cbor/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/djkazic/p2pool-go/internal/types"
 )
 
 const (
@@ -12,6 +16,11 @@ const (
 	maxP2PCoinbaseTxSize = 100 * 1024 // 100KB
 	// maxP2PMinerAddressLen is the maximum miner address length accepted from P2P peers.
 	maxP2PMinerAddressLen = 128
+	// maxShareMsgSize is the maximum size of a gossiped share message: a
+	// maximum-size coinbase plus room for the other fields.
+	maxShareMsgSize = maxP2PCoinbaseTxSize + 4*1024
+	// maxP2PUncles is the maximum number of uncle references accepted in a share.
+	maxP2PUncles = 8
 	// maxShareRequestCount is the maximum number of shares a peer can request at once.
 	maxShareRequestCount = 500
 	// maxLocatorCount is the maximum number of locator hashes in an InvReq.
@@ -29,12 +38,26 @@ const (
 	// ShareTopicName is the GossipSub topic for share propagation.
 	ShareTopicName = "/p2pool/shares/" + ProtocolVersion
 
+	// TipTopicName is the GossipSub topic for chain tip announcements.
+	TipTopicName = "/p2pool/tips/" + ProtocolVersion
+
 	// SyncProtocolID is the protocol ID for initial sync.
 	// Version 3.0.0: inv-based sync (hash discovery + targeted download).
 	SyncProtocolID = "/p2pool/sync/3.0.0"
 
+	// SyncZstdProtocolID is sync/3.0.0 with zstd-compressed messages.
+	// Requesters prefer it and fall back to SyncProtocolID for older peers.
+	SyncZstdProtocolID = "/p2pool/sync/3.1.0"
+
 	// DataProtocolID is the protocol ID for hash-targeted share downloads.
 	DataProtocolID = "/p2pool/data/1.0.0"
+
+	// DataZstdProtocolID is data/1.0.0 with zstd-compressed messages.
+	DataZstdProtocolID = "/p2pool/data/1.1.0"
+
+	// GetSharesProtocolID is the protocol ID for fetching a share and its
+	// ancestors by hash, used to fill small gaps such as a missing parent.
+	GetSharesProtocolID = "/p2pool/getshares/1.0.0"
 )
 
 // MessageType identifies the type of P2P message.
@@ -49,6 +72,7 @@ const (
 	MsgTypeInvResp     MessageType = 8
 	MsgTypeDataReq     MessageType = 9
 	MsgTypeDataResp    MessageType = 10
+	MsgTypeHandshake   MessageType = 11
 )
 
 // ShareMsg is a share broadcast via GossipSub.
@@ -69,6 +93,23 @@ type ShareMsg struct {
 	ShareTargetBits uint32   `cbor:"10,keyasint"` // Compact representation of share target
 	MinerAddress    string   `cbor:"11,keyasint"`
 	CoinbaseTx      []byte   `cbor:"12,keyasint"`
+
+	Uncles [][32]byte `cbor:"13,keyasint,omitempty"`
+
+	// Network identifies the Bitcoin network the share was mined on (see
+	// NetworkMagic). Zero means unknown, as sent by older peers.
+	Network uint8 `cbor:"14,keyasint,omitempty"`
+
+	// Height is the share's committed sharechain height. Only version 2
+	// shares carry it.
+	Height int64 `cbor:"15,keyasint,omitempty"`
+
+	// From is the peer that published this share and ReceivedFrom the
+	// peer that delivered it to us. Set locally on receipt; never
+	// serialized. Only ReceivedFrom is authenticated, so it is the one
+	// to hold responsible for the share.
+	From         peer.ID `cbor:"-"`
+	ReceivedFrom peer.ID `cbor:"-"`
 }
 
 // TipAnnounce announces a node's current chain tip.
@@ -77,8 +118,17 @@ type TipAnnounce struct {
 	TipHash   [32]byte    `cbor:"2,keyasint"`
 	Height    int64       `cbor:"3,keyasint"`
 	TotalWork []byte      `cbor:"4,keyasint"` // big.Int bytes
+
+	// From is the peer that published this announcement and ReceivedFrom
+	// the peer that delivered it to us. Set locally on receipt; never
+	// serialized.
+	From         peer.ID `cbor:"-"`
+	ReceivedFrom peer.ID `cbor:"-"`
 }
 
+// maxTipWorkLen bounds the TotalWork field of a TipAnnounce.
+const maxTipWorkLen = 64
+
 // ShareRequest requests a batch of shares by hash.
 type ShareRequest struct {
 	Type      MessageType `cbor:"1,keyasint"`
@@ -92,15 +142,92 @@ type ShareResponse struct {
 	Shares []ShareMsg  `cbor:"2,keyasint"`
 }
 
+// HeaderHash returns the hash of the share's block header, which
+// identifies the share regardless of how the message was encoded.
+func (m *ShareMsg) HeaderHash() [32]byte {
+	h := types.ShareHeader{
+		Version:       m.Version,
+		PrevBlockHash: m.PrevBlockHash,
+		MerkleRoot:    m.MerkleRoot,
+		Timestamp:     m.Timestamp,
+		Bits:          m.Bits,
+		Nonce:         m.Nonce,
+	}
+	return h.Hash()
+}
+
+// maxCBORNesting bounds how deeply arrays and maps may nest in a message.
+// The deepest real message (a response's share list holding uncle hashes)
+// nests four levels.
+const maxCBORNesting = 8
+
+// decMode decodes peer messages. Its limits are checked while the input is
+// validated, before anything is allocated for it, so a hostile payload
+// can't claim a huge array or nest without bound. No message carries more
+// elements than an inv response or more keys than ShareMsg has fields.
+var decMode = func() cbor.DecMode {
+	dm, err := cbor.DecOptions{
+		MaxNestedLevels:  maxCBORNesting,
+		MaxArrayElements: maxInvCount,
+		MaxMapPairs:      32,
+	}.DecMode()
+	if err != nil {
+		panic(fmt.Sprintf("p2p: invalid CBOR decode options: %v", err))
+	}
+	return dm
+}()
+
 // Encode serializes a message to CBOR.
 func Encode(msg interface{}) ([]byte, error) {
 	return cbor.Marshal(msg)
 }
 
-// DecodeShareMsg decodes a CBOR-encoded ShareMsg.
-func DecodeShareMsg(data []byte) (*ShareMsg, error) {
+// NetworkMagic returns the ShareMsg network byte for a Bitcoin network
+// name, or 0 if the network is not known.
+func NetworkMagic(network string) uint8 {
+	params, err := types.ParseNetwork(network)
+	if err != nil {
+		return 0
+	}
+	return params.Magic
+}
+
+// ErrMissingField is returned, wrapped with the field's name, when a
+// decoded message lacks a required field. CBOR leaves absent fields at
+// their zero value, so a zero required field is treated as missing.
+var ErrMissingField = errors.New("missing required field")
+
+// checkRequired rejects a share message whose required fields are zero.
+// Fields that are legitimately zero, such as the parent hash and height of
+// a genesis share, the uncles and the network tag, are not checked.
+func (m *ShareMsg) checkRequired() error {
+	switch {
+	case m.ShareVersion == 0:
+		return fmt.Errorf("%w: share version", ErrMissingField)
+	case m.Timestamp == 0:
+		return fmt.Errorf("%w: timestamp", ErrMissingField)
+	case m.Bits == 0:
+		return fmt.Errorf("%w: header bits", ErrMissingField)
+	case m.ShareTargetBits == 0:
+		return fmt.Errorf("%w: share target bits", ErrMissingField)
+	case m.MinerAddress == "":
+		return fmt.Errorf("%w: miner address", ErrMissingField)
+	case len(m.CoinbaseTx) == 0:
+		return fmt.Errorf("%w: coinbase tx", ErrMissingField)
+	}
+	return nil
+}
+
+// DecodeShareMsg decodes a CBOR-encoded ShareMsg. Shares missing a
+// required field are rejected with ErrMissingField. Shares tagged with a
+// network other than network are rejected; a zero on either side skips
+// the check.
+func DecodeShareMsg(data []byte, network uint8) (*ShareMsg, error) {
 	var msg ShareMsg
-	if err := cbor.Unmarshal(data, &msg); err != nil {
+	if err := decMode.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	if err := msg.checkRequired(); err != nil {
 		return nil, err
 	}
 	if len(msg.CoinbaseTx) > maxP2PCoinbaseTxSize {
@@ -109,22 +236,38 @@ func DecodeShareMsg(data []byte) (*ShareMsg, error) {
 	if len(msg.MinerAddress) > maxP2PMinerAddressLen {
 		return nil, fmt.Errorf("miner address too long: %d bytes", len(msg.MinerAddress))
 	}
+	if len(msg.Uncles) > maxP2PUncles {
+		return nil, fmt.Errorf("too many uncles: %d", len(msg.Uncles))
+	}
+	if msg.Network != 0 && network != 0 && msg.Network != network {
+		return nil, fmt.Errorf("share from network %d, expected %d", msg.Network, network)
+	}
 	return &msg, nil
 }
 
-// DecodeTipAnnounce decodes a CBOR-encoded TipAnnounce.
+// DecodeTipAnnounce decodes a CBOR-encoded TipAnnounce. Announcements
+// without a tip hash or chain work are rejected with ErrMissingField.
 func DecodeTipAnnounce(data []byte) (*TipAnnounce, error) {
 	var msg TipAnnounce
-	if err := cbor.Unmarshal(data, &msg); err != nil {
+	if err := decMode.Unmarshal(data, &msg); err != nil {
 		return nil, err
 	}
+	if msg.TipHash == ([32]byte{}) {
+		return nil, fmt.Errorf("%w: tip hash", ErrMissingField)
+	}
+	if len(msg.TotalWork) == 0 {
+		return nil, fmt.Errorf("%w: total work", ErrMissingField)
+	}
+	if len(msg.TotalWork) > maxTipWorkLen {
+		return nil, fmt.Errorf("tip work too large: %d bytes", len(msg.TotalWork))
+	}
 	return &msg, nil
 }
 
 // DecodeShareRequest decodes a CBOR-encoded ShareRequest.
 func DecodeShareRequest(data []byte) (*ShareRequest, error) {
 	var msg ShareRequest
-	if err := cbor.Unmarshal(data, &msg); err != nil {
+	if err := decMode.Unmarshal(data, &msg); err != nil {
 		return nil, err
 	}
 	if msg.Count < 0 || msg.Count > maxShareRequestCount {
@@ -136,9 +279,12 @@ func DecodeShareRequest(data []byte) (*ShareRequest, error) {
 // DecodeShareResponse decodes a CBOR-encoded ShareResponse.
 func DecodeShareResponse(data []byte) (*ShareResponse, error) {
 	var msg ShareResponse
-	if err := cbor.Unmarshal(data, &msg); err != nil {
+	if err := decMode.Unmarshal(data, &msg); err != nil {
 		return nil, err
 	}
+	if len(msg.Shares) > maxShareRequestCount {
+		return nil, fmt.Errorf("share response count too large: %d", len(msg.Shares))
+	}
 	return &msg, nil
 }
 
@@ -171,7 +317,7 @@ type DataResp struct {
 // DecodeInvReq decodes a CBOR-encoded InvReq.
 func DecodeInvReq(data []byte) (*InvReq, error) {
 	var msg InvReq
-	if err := cbor.Unmarshal(data, &msg); err != nil {
+	if err := decMode.Unmarshal(data, &msg); err != nil {
 		return nil, err
 	}
 	if len(msg.Locators) > maxLocatorCount {
@@ -186,16 +332,19 @@ func DecodeInvReq(data []byte) (*InvReq, error) {
 // DecodeInvResp decodes a CBOR-encoded InvResp.
 func DecodeInvResp(data []byte) (*InvResp, error) {
 	var msg InvResp
-	if err := cbor.Unmarshal(data, &msg); err != nil {
+	if err := decMode.Unmarshal(data, &msg); err != nil {
 		return nil, err
 	}
+	if len(msg.Hashes) > maxInvCount {
+		return nil, fmt.Errorf("inv response hash count too large: %d", len(msg.Hashes))
+	}
 	return &msg, nil
 }
 
 // DecodeDataReq decodes a CBOR-encoded DataReq.
 func DecodeDataReq(data []byte) (*DataReq, error) {
 	var msg DataReq
-	if err := cbor.Unmarshal(data, &msg); err != nil {
+	if err := decMode.Unmarshal(data, &msg); err != nil {
 		return nil, err
 	}
 	if len(msg.Hashes) > maxDataReqHashes {
@@ -207,9 +356,12 @@ func DecodeDataReq(data []byte) (*DataReq, error) {
 // DecodeDataResp decodes a CBOR-encoded DataResp.
 func DecodeDataResp(data []byte) (*DataResp, error) {
 	var msg DataResp
-	if err := cbor.Unmarshal(data, &msg); err != nil {
+	if err := decMode.Unmarshal(data, &msg); err != nil {
 		return nil, err
 	}
+	if len(msg.Shares) > maxDataReqHashes {
+		return nil, fmt.Errorf("data response share count too large: %d", len(msg.Shares))
+	}
 	return &msg, nil
 }
 
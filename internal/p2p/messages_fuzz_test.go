@@ -0,0 +1,72 @@
+package p2p
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzDecodeShareMsg checks that DecodeShareMsg never panics on peer bytes
+// and that anything it accepts is within the size limits and re-encodes to
+// a message that decodes the same.
+func FuzzDecodeShareMsg(f *testing.F) {
+	seed, _ := Encode(&ShareMsg{
+		Type:            MsgTypeShare,
+		Version:         536870912,
+		Timestamp:       1700000000,
+		Bits:            0x1d00ffff,
+		ShareVersion:    1,
+		ShareTargetBits: 0x207fffff,
+		MinerAddress:    "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx",
+		CoinbaseTx:      []byte{0x01, 0x02, 0x03},
+		Uncles:          [][32]byte{{1}},
+		Network:         NetworkMagic("testnet3"),
+	})
+	f.Add(seed)
+	f.Add([]byte{})
+	f.Add([]byte{0xa1, 0x0d, 0x9a, 0xff, 0xff, 0xff, 0xff}) // uncles claiming 2^32-1 entries
+	f.Add(bytes.Repeat([]byte{0x81}, 64))                   // deeply nested arrays
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := DecodeShareMsg(data, 0)
+		if err != nil {
+			return
+		}
+		if len(msg.CoinbaseTx) > maxP2PCoinbaseTxSize || len(msg.MinerAddress) > maxP2PMinerAddressLen || len(msg.Uncles) > maxP2PUncles {
+			t.Fatalf("accepted oversized share: coinbase %d, address %d, uncles %d",
+				len(msg.CoinbaseTx), len(msg.MinerAddress), len(msg.Uncles))
+		}
+		again, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("re-encode: %v", err)
+		}
+		back, err := DecodeShareMsg(again, 0)
+		if err != nil {
+			t.Fatalf("decode of re-encoded share: %v", err)
+		}
+		if back.HeaderHash() != msg.HeaderHash() || !bytes.Equal(back.CoinbaseTx, msg.CoinbaseTx) {
+			t.Error("re-encoded share decodes differently")
+		}
+	})
+}
+
+// FuzzDecodeSyncResponses feeds peer bytes to the sync response decoders,
+// which must not panic or return more entries than the decoder allows.
+func FuzzDecodeSyncResponses(f *testing.F) {
+	inv, _ := Encode(&InvResp{Type: MsgTypeInvResp, Hashes: [][32]byte{{1}, {2}}, More: true})
+	data, _ := Encode(&DataResp{Type: MsgTypeDataResp, Shares: []ShareMsg{{Type: MsgTypeShare, ShareVersion: 1}}})
+	f.Add(inv)
+	f.Add(data)
+	f.Add([]byte{0xa1, 0x02, 0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) // array claiming 2^64-1 entries
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if msg, err := DecodeInvResp(data); err == nil && len(msg.Hashes) > maxInvCount {
+			t.Fatalf("accepted inv response with %d hashes", len(msg.Hashes))
+		}
+		if msg, err := DecodeDataResp(data); err == nil && len(msg.Shares) > maxInvCount {
+			t.Fatalf("accepted data response with %d shares", len(msg.Shares))
+		}
+		if msg, err := DecodeShareResponse(data); err == nil && len(msg.Shares) > maxInvCount {
+			t.Fatalf("accepted share response with %d shares", len(msg.Shares))
+		}
+	})
+}
@@ -1,11 +1,15 @@
 package p2p
 
 import (
+	"bytes"
+	"errors"
+	"strings"
 	"testing"
 )
 
-func TestShareMsg_RoundTrip(t *testing.T) {
-	original := &ShareMsg{
+// testShareMsg returns a share message with every required field set.
+func testShareMsg() *ShareMsg {
+	return &ShareMsg{
 		Type:            MsgTypeShare,
 		Version:         536870912,
 		Timestamp:       1700000000,
@@ -16,6 +20,10 @@ func TestShareMsg_RoundTrip(t *testing.T) {
 		CoinbaseTx:      []byte{0x01, 0x02, 0x03},
 		ShareTargetBits: 0x207fffff,
 	}
+}
+
+func TestShareMsg_RoundTrip(t *testing.T) {
+	original := testShareMsg()
 	original.PrevShareHash[0] = 0xab
 
 	data, err := Encode(original)
@@ -23,7 +31,7 @@ func TestShareMsg_RoundTrip(t *testing.T) {
 		t.Fatalf("encode: %v", err)
 	}
 
-	decoded, err := DecodeShareMsg(data)
+	decoded, err := DecodeShareMsg(data, 0)
 	if err != nil {
 		t.Fatalf("decode: %v", err)
 	}
@@ -42,6 +50,72 @@ func TestShareMsg_RoundTrip(t *testing.T) {
 	}
 }
 
+func TestDecodeShareMsg_NetworkMismatch(t *testing.T) {
+	testnet := NetworkMagic("testnet3")
+	regtest := NetworkMagic("regtest")
+
+	msg := testShareMsg()
+	untagged, _ := Encode(msg)
+	msg.Network = regtest
+	tagged, _ := Encode(msg)
+
+	if _, err := DecodeShareMsg(tagged, testnet); err == nil {
+		t.Error("expected regtest share to be rejected on testnet")
+	}
+	if _, err := DecodeShareMsg(tagged, regtest); err != nil {
+		t.Errorf("same-network share rejected: %v", err)
+	}
+	// Older peers don't tag shares, and a node without a known network
+	// accepts anything.
+	if _, err := DecodeShareMsg(untagged, testnet); err != nil {
+		t.Errorf("untagged share rejected: %v", err)
+	}
+	if _, err := DecodeShareMsg(tagged, 0); err != nil {
+		t.Errorf("tagged share rejected by unknown network: %v", err)
+	}
+}
+
+func TestDecodeShareMsg_RequiredFields(t *testing.T) {
+	for _, tc := range []struct {
+		field string
+		clear func(*ShareMsg)
+	}{
+		{"share version", func(m *ShareMsg) { m.ShareVersion = 0 }},
+		{"timestamp", func(m *ShareMsg) { m.Timestamp = 0 }},
+		{"header bits", func(m *ShareMsg) { m.Bits = 0 }},
+		{"share target bits", func(m *ShareMsg) { m.ShareTargetBits = 0 }},
+		{"miner address", func(m *ShareMsg) { m.MinerAddress = "" }},
+		{"coinbase tx", func(m *ShareMsg) { m.CoinbaseTx = nil }},
+	} {
+		msg := testShareMsg()
+		tc.clear(msg)
+		data, _ := Encode(msg)
+		_, err := DecodeShareMsg(data, 0)
+		if !errors.Is(err, ErrMissingField) || !strings.Contains(err.Error(), tc.field) {
+			t.Errorf("without %s: err = %v, want ErrMissingField naming it", tc.field, err)
+		}
+	}
+
+	// A genesis share has no parent, height or uncles, and older peers
+	// send no network tag.
+	msg := testShareMsg()
+	msg.ShareVersion = 2
+	data, _ := Encode(msg)
+	if _, err := DecodeShareMsg(data, 0); err != nil {
+		t.Errorf("genesis share rejected: %v", err)
+	}
+}
+
+func TestDecodeTipAnnounce_RequiredFields(t *testing.T) {
+	noHash, _ := Encode(&TipAnnounce{Type: MsgTypeTipAnnounce, TotalWork: []byte{1}})
+	noWork, _ := Encode(&TipAnnounce{Type: MsgTypeTipAnnounce, TipHash: [32]byte{1}})
+	for name, data := range map[string][]byte{"tip hash": noHash, "total work": noWork} {
+		if _, err := DecodeTipAnnounce(data); !errors.Is(err, ErrMissingField) {
+			t.Errorf("without %s: err = %v, want ErrMissingField", name, err)
+		}
+	}
+}
+
 func TestTipAnnounce_RoundTrip(t *testing.T) {
 	original := &TipAnnounce{
 		Type:      MsgTypeTipAnnounce,
@@ -68,6 +142,21 @@ func TestTipAnnounce_RoundTrip(t *testing.T) {
 	}
 }
 
+func TestDecodeTipAnnounce_WorkTooLarge(t *testing.T) {
+	msg := &TipAnnounce{
+		Type:      MsgTypeTipAnnounce,
+		TipHash:   [32]byte{1},
+		TotalWork: make([]byte, maxTipWorkLen+1),
+	}
+	data, err := Encode(msg)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if _, err := DecodeTipAnnounce(data); err == nil {
+		t.Error("expected error for oversized total work")
+	}
+}
+
 func TestShareRequest_RoundTrip(t *testing.T) {
 	original := &ShareRequest{
 		Type:  MsgTypeShareReq,
@@ -155,6 +244,46 @@ func TestDecodeDataReq_TooManyHashes(t *testing.T) {
 	}
 }
 
+func TestDecodeResponses_TooManyShares(t *testing.T) {
+	data, err := Encode(&DataResp{Type: MsgTypeDataResp, Shares: make([]ShareMsg, maxDataReqHashes+1)})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if _, err := DecodeDataResp(data); err == nil {
+		t.Error("expected error for oversized data response")
+	}
+
+	data, err = Encode(&ShareResponse{Type: MsgTypeShareResp, Shares: make([]ShareMsg, maxShareRequestCount+1)})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if _, err := DecodeShareResponse(data); err == nil {
+		t.Error("expected error for oversized share response")
+	}
+}
+
+func TestDecode_CBORLimits(t *testing.T) {
+	// An inv response one hash over the array limit.
+	data, err := Encode(&InvResp{Type: MsgTypeInvResp, Hashes: make([][32]byte, maxInvCount+1)})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if _, err := DecodeInvResp(data); err == nil {
+		t.Error("expected error for oversized array")
+	}
+
+	// [[[...0]]] nested to the limit decodes; one more level fails.
+	var v any
+	nested := append(bytes.Repeat([]byte{0x81}, maxCBORNesting), 0x00)
+	if err := decMode.Unmarshal(nested, &v); err != nil {
+		t.Errorf("nesting at the limit rejected: %v", err)
+	}
+	nested = append([]byte{0x81}, nested...)
+	if err := decMode.Unmarshal(nested, &v); err == nil {
+		t.Error("expected error for nesting past the limit")
+	}
+}
+
 func TestBigIntConversion(t *testing.T) {
 	// Test with nil
 	b := BigIntToBytes(nil)
@@ -0,0 +1,102 @@
+package p2p
+
+import (
+	"context"
+	"sync"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+
+	"go.uber.org/zap"
+)
+
+// natOptions returns the libp2p options for NAT traversal: AutoNAT
+// reachability detection, UPnP/NAT-PMP port mapping, circuit relay (as a
+// client when we're unreachable, as a service when we're public) and hole
+// punching. Relay candidates are drawn from connected peers; h is set once
+// the host has been constructed.
+func natOptions(h *relayHost) []libp2p.Option {
+	return []libp2p.Option{
+		libp2p.EnableNATService(),
+		libp2p.NATPortMap(),
+		libp2p.EnableRelay(),
+		libp2p.EnableRelayService(),
+		libp2p.EnableAutoRelayWithPeerSource(relayPeerSource(h)),
+		libp2p.EnableHolePunching(),
+	}
+}
+
+// relayHost holds the host for relayPeerSource. The host is only known
+// after libp2p.New returns, while AutoRelay may already be asking for
+// candidates from its own goroutine.
+type relayHost struct {
+	mu sync.RWMutex
+	h  host.Host
+}
+
+func (r *relayHost) set(h host.Host) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.h = h
+}
+
+func (r *relayHost) get() host.Host {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.h
+}
+
+// relayPeerSource offers our connected peers as relay candidates. AutoRelay
+// filters out peers that don't support the relay protocol.
+func relayPeerSource(r *relayHost) autorelay.PeerSource {
+	return func(ctx context.Context, num int) <-chan peer.AddrInfo {
+		ch := make(chan peer.AddrInfo, num)
+		defer close(ch)
+
+		h := r.get()
+		if h == nil {
+			return ch
+		}
+		for _, pid := range h.Network().Peers() {
+			if len(ch) == num {
+				break
+			}
+			ch <- peer.AddrInfo{ID: pid, Addrs: h.Peerstore().Addrs(pid)}
+		}
+		return ch
+	}
+}
+
+// watchReachability logs changes in our AutoNAT-detected reachability.
+func watchReachability(ctx context.Context, h host.Host, logger *zap.Logger) {
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		logger.Warn("failed to subscribe to reachability events", zap.Error(err))
+		return
+	}
+
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				reachability := e.(event.EvtLocalReachabilityChanged).Reachability
+				if reachability == network.ReachabilityPrivate {
+					logger.Info("NAT detected: node is not publicly reachable, using relays",
+						zap.String("reachability", reachability.String()))
+				} else {
+					logger.Info("reachability changed", zap.String("reachability", reachability.String()))
+				}
+			}
+		}
+	}()
+}
@@ -3,45 +3,107 @@ package p2p
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/host"
+	lp2pmetrics "github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
 	"github.com/libp2p/go-libp2p/p2p/security/noise"
 
 	ma "github.com/multiformats/go-multiaddr"
 	"go.uber.org/zap"
 )
 
-const peersFile = "peers.json"
+// ErrInvalidPeerAddr is returned by ConnectPeer for addresses that cannot
+// be dialed as given.
+var ErrInvalidPeerAddr = errors.New("invalid peer address")
+
+const (
+	peersFile = "peers.json"
+
+	// maxSavedPeers caps the number of peers written to peers.json.
+	maxSavedPeers = 64
+
+	// peerPingInterval is how often connected peers are pinged to keep
+	// their round-trip latency current.
+	peerPingInterval = time.Minute
+
+	// peerPingTimeout bounds a single ping.
+	peerPingTimeout = 10 * time.Second
+
+	// protectTag is the connection manager tag that keeps useful peers
+	// from being trimmed.
+	protectTag = "p2pool-useful"
+
+	// maxProtectedPeers caps how many peers are protected at once, well
+	// under the connection manager's low watermark so trims still have
+	// peers to drop.
+	maxProtectedPeers = 16
+)
 
 // Node manages the libp2p host and P2P networking.
 type Node struct {
 	Host   host.Host
 	Logger *zap.Logger
 
-	dataDir string
+	dataDir    string
+	network    string
+	poolSecret string
 
-	pubsub    *PubSub
-	discovery *Discovery
-	syncer    *Syncer
+	pubsub     *PubSub
+	discovery  *Discovery
+	syncer     *Syncer
+	scorer     *PeerScorer
+	peerFilter *PeerFilter
+	handshaker atomic.Pointer[Handshaker]
+	bandwidth  *lp2pmetrics.BandwidthCounter
 
 	incomingShares chan *ShareMsg
+	incomingTips   chan *TipAnnounce
 	peerConnected  chan peer.ID
+
+	// Peers we've successfully synced from, with the time of the last sync.
+	// Saved first in peers.json so restarts dial them before anything else.
+	goodPeers   map[peer.ID]time.Time
+	goodPeersMu sync.Mutex
+
+	// Peers protected from connection trims, with the time each was last
+	// useful; see ProtectPeer.
+	protected   map[peer.ID]time.Time
+	protectedMu sync.Mutex
 }
 
 // NewNode creates a new libp2p node with GossipSub but does NOT start
 // discovery. Call StartDiscovery after registering all stream handlers
 // (e.g. InitSyncer) to avoid races where peers connect before handlers
 // are ready.
-func NewNode(ctx context.Context, listenPort int, dataDir string, logger *zap.Logger) (*Node, error) {
+//
+// When enableNAT is set, AutoNAT, port mapping, circuit relay and hole
+// punching are enabled so nodes behind NAT can still be reached.
+//
+// network is the Bitcoin network name; it tags gossiped shares and is
+// checked in the connect-time handshake.
+//
+// A non-empty poolSecret runs a private pool: gossip topics and the DHT
+// namespace are derived from the secret, and with usePSK the secret also
+// keys a libp2p private network so outsiders can't even connect.
+//
+// A non-nil peerFilter gates connections to and from peers not allowed by
+// it, and is reloaded while the node runs.
+func NewNode(ctx context.Context, listenPort int, dataDir string, network string, enableNAT bool, poolSecret string, usePSK bool, peerFilter *PeerFilter, logger *zap.Logger) (*Node, error) {
 	listenAddr := fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", listenPort)
 
 	// Load or create persistent identity (stable peer ID across restarts)
@@ -55,30 +117,62 @@ func NewNode(ctx context.Context, listenPort int, dataDir string, logger *zap.Lo
 		return nil, fmt.Errorf("create connection manager: %w", err)
 	}
 
-	h, err := libp2p.New(
+	bandwidth := lp2pmetrics.NewBandwidthCounter()
+
+	var relay relayHost
+	opts := []libp2p.Option{
 		libp2p.Identity(privKey),
 		libp2p.ListenAddrStrings(listenAddr),
 		libp2p.Security(noise.ID, noise.New),
 		libp2p.Muxer(yamux.ID, yamux.DefaultTransport),
 		libp2p.ConnectionManager(cm),
-	)
+		libp2p.BandwidthReporter(bandwidth),
+	}
+	if enableNAT {
+		opts = append(opts, natOptions(&relay)...)
+	}
+	if poolSecret != "" && usePSK {
+		opts = append(opts, libp2p.PrivateNetwork(privateNetworkKey(poolSecret)))
+	}
+	if peerFilter != nil {
+		opts = append(opts, libp2p.ConnectionGater(peerFilter))
+	}
+
+	h, err := libp2p.New(opts...)
 	if err != nil {
 		return nil, fmt.Errorf("create libp2p host: %w", err)
 	}
+	relay.set(h)
+	if enableNAT {
+		watchReachability(ctx, h, logger)
+		logger.Info("NAT traversal enabled (AutoNAT, relay, hole punching)")
+	}
 
 	node := &Node{
 		Host:           h,
 		Logger:         logger,
 		dataDir:        dataDir,
+		network:        network,
+		poolSecret:     poolSecret,
 		incomingShares: make(chan *ShareMsg, 256),
+		incomingTips:   make(chan *TipAnnounce, 16),
 		peerConnected:  make(chan peer.ID, 16),
+		goodPeers:      make(map[peer.ID]time.Time),
+		protected:      make(map[peer.ID]time.Time),
+		bandwidth:      bandwidth,
+		peerFilter:     peerFilter,
+		scorer:         NewPeerScorer(DefaultBanThreshold, DefaultBanDuration),
 	}
 
 	// Register connection notifier to trigger sync on new peers
-	h.Network().Notify(&peerNotifiee{peerConnected: node.peerConnected})
+	h.Network().Notify(&peerNotifiee{
+		peerConnected: node.peerConnected,
+		scorer:        node.scorer,
+		handshaker:    &node.handshaker,
+	})
 
 	// Setup GossipSub
-	node.pubsub, err = NewPubSub(ctx, h, node.incomingShares, logger)
+	node.pubsub, err = NewPubSub(ctx, h, node.incomingShares, node.incomingTips, node.scorer, peerFilter, NetworkMagic(network), poolSecret, logger)
 	if err != nil {
 		h.Close()
 		return nil, fmt.Errorf("setup pubsub: %w", err)
@@ -87,12 +181,19 @@ func NewNode(ctx context.Context, listenPort int, dataDir string, logger *zap.Lo
 	logger.Info("p2p node started",
 		zap.String("peer_id", h.ID().String()),
 		zap.Int("port", listenPort),
+		zap.Bool("private_pool", poolSecret != ""),
 	)
 
 	for _, addr := range h.Addrs() {
 		logger.Info("listening on", zap.String("addr", fmt.Sprintf("%s/p2p/%s", addr, h.ID())))
 	}
 
+	go node.pingLoop(ctx)
+	go node.bandwidthLoop(ctx)
+	if peerFilter != nil {
+		go node.peerFilterLoop(ctx)
+	}
+
 	return node, nil
 }
 
@@ -106,7 +207,7 @@ func (n *Node) StartDiscovery(ctx context.Context, enableMDNS bool, bootnodes []
 		n.Logger.Info("loaded saved peers", zap.Int("count", len(savedPeers)))
 	}
 
-	n.discovery, err = NewDiscovery(ctx, n.Host, enableMDNS, bootnodes, savedPeers, n.dataDir, n.Logger)
+	n.discovery, err = NewDiscovery(ctx, n.Host, enableMDNS, bootnodes, savedPeers, n.dataDir, dhtNamespace(n.poolSecret), n.Logger)
 	if err != nil {
 		return fmt.Errorf("setup discovery: %w", err)
 	}
@@ -123,11 +224,147 @@ func (n *Node) BroadcastShare(share *ShareMsg) error {
 	return n.pubsub.PublishShare(share)
 }
 
-// PeerDetail holds information about a connected peer for the dashboard.
+// IncomingTips returns the channel of tip announcements received from peers.
+func (n *Node) IncomingTips() <-chan *TipAnnounce {
+	return n.incomingTips
+}
+
+// BroadcastTip publishes our chain tip to the network.
+func (n *Node) BroadcastTip(tip *TipAnnounce) error {
+	return n.pubsub.PublishTip(tip)
+}
+
+// MarkShareSeen records that a gossiped share was accepted, so copies of it
+// relayed by other peers are dropped before reaching the sharechain.
+func (n *Node) MarkShareSeen(share *ShareMsg) {
+	n.pubsub.MarkSeen(share.HeaderHash())
+}
+
+// PenalizePeer adds a misbehavior penalty to a peer. If the peer crosses the
+// ban threshold it is disconnected and refused until the ban expires.
+func (n *Node) PenalizePeer(id peer.ID, penalty float64) {
+	if id == "" || id == n.Host.ID() {
+		return
+	}
+	if !n.scorer.Penalize(id, penalty) {
+		return
+	}
+	n.Logger.Warn("banning misbehaving peer",
+		zap.String("peer", id.String()),
+		zap.Duration("duration", DefaultBanDuration),
+	)
+	n.unprotectPeer(id)
+	if err := n.Host.Network().ClosePeer(id); err != nil {
+		n.Logger.Debug("failed to disconnect banned peer", zap.Error(err))
+	}
+}
+
+// ProtectPeer marks a peer that proved useful, by serving a sync or
+// relaying a valid share, so the connection manager keeps it when trimming
+// connections. At most maxProtectedPeers are protected; the one useful
+// least recently is released to make room.
+func (n *Node) ProtectPeer(id peer.ID) {
+	if id == "" || id == n.Host.ID() {
+		return
+	}
+	n.protectedMu.Lock()
+	defer n.protectedMu.Unlock()
+
+	if _, ok := n.protected[id]; !ok && len(n.protected) >= maxProtectedPeers {
+		var oldest peer.ID
+		var oldestAt time.Time
+		for p, at := range n.protected {
+			if oldest == "" || at.Before(oldestAt) {
+				oldest, oldestAt = p, at
+			}
+		}
+		delete(n.protected, oldest)
+		n.Host.ConnManager().Unprotect(oldest, protectTag)
+	}
+	n.protected[id] = time.Now()
+	n.Host.ConnManager().Protect(id, protectTag)
+}
+
+// unprotectPeer releases a peer's protection, if it has any.
+func (n *Node) unprotectPeer(id peer.ID) {
+	n.protectedMu.Lock()
+	defer n.protectedMu.Unlock()
+	if _, ok := n.protected[id]; ok {
+		delete(n.protected, id)
+		n.Host.ConnManager().Unprotect(id, protectTag)
+	}
+}
+
+// ProtectedPeerCount returns the number of peers protected from
+// connection trims.
+func (n *Node) ProtectedPeerCount() int {
+	n.protectedMu.Lock()
+	defer n.protectedMu.Unlock()
+	return len(n.protected)
+}
+
+// peerFilterLoop reloads the peer filter every peerFilterReloadInterval
+// until ctx is done.
+func (n *Node) peerFilterLoop(ctx context.Context) {
+	ticker := time.NewTicker(peerFilterReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.reloadPeerFilter()
+		}
+	}
+}
+
+// reloadPeerFilter re-reads the peer filter and disconnects connected peers
+// it no longer allows.
+func (n *Node) reloadPeerFilter() {
+	changed, err := n.peerFilter.Reload()
+	if err != nil {
+		n.Logger.Warn("failed to reload peer list, keeping the current one", zap.Error(err))
+		return
+	}
+	if !changed {
+		return
+	}
+	n.Logger.Info("reloaded peer list", zap.Int("peers", n.peerFilter.Len()))
+	for _, pid := range n.Host.Network().Peers() {
+		if n.peerFilter.Allowed(pid) {
+			continue
+		}
+		n.Logger.Info("disconnecting peer no longer allowed", zap.String("peer", pid.String()))
+		n.unprotectPeer(pid)
+		if err := n.Host.Network().ClosePeer(pid); err != nil {
+			n.Logger.Debug("failed to disconnect peer", zap.Error(err))
+		}
+	}
+}
+
+// BannedPeerCount returns the number of currently banned peers.
+func (n *Node) BannedPeerCount() int {
+	return n.scorer.BannedCount()
+}
+
+// PeerDetail holds information about a connected peer for the dashboard
+// and stats API.
 type PeerDetail struct {
+	ID        peer.ID
 	ShortID   string
-	LatencyUs int64
+	LatencyUs int64 // EWMA of measured round trips; 0 until one is measured
 	Address   string
+
+	Direction   string // "inbound" or "outbound"
+	ConnectedAt time.Time
+	Agent       string    // libp2p agent version reported by identify
+	Protocols   []string  // protocols the peer supports, sorted
+	LastSync    time.Time // last successful share sync from this peer; zero if never
+
+	// BytesIn and BytesOut count stream traffic with the peer since this
+	// node started.
+	BytesIn  int64
+	BytesOut int64
 }
 
 // ShortID returns the short form of our own peer ID.
@@ -135,25 +372,107 @@ func (n *Node) ShortID() string {
 	return n.Host.ID().ShortString()
 }
 
-// PeerDetails returns details about all connected peers.
+// PeerDetails returns details about all connected peers, read from the
+// peerstore and connection metadata.
 func (n *Node) PeerDetails() []PeerDetail {
+	ps := n.Host.Peerstore()
 	peers := n.Host.Network().Peers()
+
+	n.goodPeersMu.Lock()
+	lastSync := make(map[peer.ID]time.Time, len(peers))
+	for _, pid := range peers {
+		lastSync[pid] = n.goodPeers[pid]
+	}
+	n.goodPeersMu.Unlock()
+
 	details := make([]PeerDetail, 0, len(peers))
 	for _, pid := range peers {
-		latency := n.Host.Peerstore().LatencyEWMA(pid)
-		addr := ""
-		if addrs := n.Host.Peerstore().Addrs(pid); len(addrs) > 0 {
-			addr = addrs[0].String()
-		}
-		details = append(details, PeerDetail{
+		d := PeerDetail{
+			ID:        pid,
 			ShortID:   pid.ShortString(),
-			LatencyUs: latency.Microseconds(),
-			Address:   addr,
-		})
+			LatencyUs: ps.LatencyEWMA(pid).Microseconds(),
+			LastSync:  lastSync[pid],
+		}
+		if addrs := ps.Addrs(pid); len(addrs) > 0 {
+			d.Address = addrs[0].String()
+		}
+		if conns := n.Host.Network().ConnsToPeer(pid); len(conns) > 0 {
+			stat := conns[0].Stat()
+			d.Direction = strings.ToLower(stat.Direction.String())
+			d.ConnectedAt = stat.Opened
+			d.Address = conns[0].RemoteMultiaddr().String()
+		}
+		if n.bandwidth != nil {
+			stats := n.bandwidth.GetBandwidthForPeer(pid)
+			d.BytesIn, d.BytesOut = stats.TotalIn, stats.TotalOut
+		}
+		if agent, err := ps.Get(pid, "AgentVersion"); err == nil {
+			d.Agent, _ = agent.(string)
+		}
+		if protos, err := ps.GetProtocols(pid); err == nil {
+			for _, p := range protos {
+				d.Protocols = append(d.Protocols, string(p))
+			}
+			sort.Strings(d.Protocols)
+		}
+		details = append(details, d)
 	}
 	return details
 }
 
+// pingLoop periodically pings every connected peer. Each successful ping
+// records the round trip in the peerstore, which PeerDetails reports.
+func (n *Node) pingLoop(ctx context.Context) {
+	ticker := time.NewTicker(peerPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.pingPeers(ctx)
+		}
+	}
+}
+
+// pingPeers pings all connected peers concurrently, once each.
+func (n *Node) pingPeers(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, pid := range n.Host.Network().Peers() {
+		wg.Add(1)
+		go func(pid peer.ID) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, peerPingTimeout)
+			defer cancel()
+			if res := <-ping.Ping(pctx, n.Host, pid); res.Error != nil {
+				n.Logger.Debug("peer ping failed", zap.String("peer", pid.String()), zap.Error(res.Error))
+			}
+		}(pid)
+	}
+	wg.Wait()
+}
+
+// ConnectPeer dials a peer given as a multiaddr ending in /p2p/<peer id>,
+// e.g. to bridge two partitioned pools at runtime. Banned peers are refused.
+// Once connected the peer goes through the usual handshake and sync.
+func (n *Node) ConnectPeer(ctx context.Context, addr string) (peer.ID, error) {
+	info, err := peer.AddrInfoFromString(addr)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %v", ErrInvalidPeerAddr, addr, err)
+	}
+	if info.ID == n.Host.ID() {
+		return "", fmt.Errorf("%w %q: that is this node", ErrInvalidPeerAddr, addr)
+	}
+	if n.scorer.IsBanned(info.ID) {
+		return "", fmt.Errorf("peer %s is banned", info.ID.ShortString())
+	}
+	if err := n.Host.Connect(ctx, *info); err != nil {
+		return "", fmt.Errorf("dial %s: %w", info.ID.ShortString(), err)
+	}
+	n.Logger.Info("connected to peer on request", zap.String("peer", info.ID.String()))
+	return info.ID, nil
+}
+
 // PeerCount returns the number of connected peers.
 func (n *Node) PeerCount() int {
 	return len(n.Host.Network().Peers())
@@ -165,9 +484,18 @@ func (n *Node) ConnectedPeers() []peer.ID {
 }
 
 // InitSyncer creates the Syncer and registers stream handlers for
-// inv-based sync (hash discovery) and data protocol (targeted download).
-func (n *Node) InitSyncer(invHandler InvHandler, dataHandler DataHandler) {
+// inv-based sync (hash discovery), data protocol (targeted download) and
+// getshares (ancestor backfill).
+func (n *Node) InitSyncer(invHandler InvHandler, dataHandler DataHandler, sharesHandler SharesHandler) {
 	n.syncer = NewSyncer(n.Host, invHandler, dataHandler, n.Logger)
+	n.syncer.HandleShareRequests(sharesHandler)
+}
+
+// InitHandshake registers the handshake protocol. Once registered, every
+// new connection is handshaken before it is reported on PeerConnected, and
+// incompatible peers are disconnected. Call before StartDiscovery.
+func (n *Node) InitHandshake(chainID [32]byte) {
+	n.handshaker.Store(NewHandshaker(n.Host, n.network, chainID, n.Logger))
 }
 
 // PeerConnected returns a channel that receives peer IDs when new peers connect.
@@ -191,36 +519,69 @@ func (n *Node) Close() error {
 	return n.Host.Close()
 }
 
-// SavePeers writes the multiaddrs of currently connected peers to peers.json.
+// MarkGoodPeer records that we successfully synced shares from a peer.
+func (n *Node) MarkGoodPeer(id peer.ID) {
+	n.goodPeersMu.Lock()
+	defer n.goodPeersMu.Unlock()
+	n.goodPeers[id] = time.Now()
+}
+
+// savedPeer is the peers.json representation of a peer.
+type savedPeer struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+	Good  bool     `json:"good,omitempty"`
+}
+
+// SavePeers writes known peer multiaddrs to peers.json. Peers we've synced
+// from come first (most recent first), then connected peers, then any other
+// peer in the peerstore, up to maxSavedPeers.
 func (n *Node) SavePeers() error {
-	peers := n.Host.Network().Peers()
-	var infos []peer.AddrInfo
-	for _, pid := range peers {
-		addrs := n.Host.Peerstore().Addrs(pid)
-		if len(addrs) > 0 {
-			infos = append(infos, peer.AddrInfo{ID: pid, Addrs: addrs})
+	ps := n.Host.Peerstore()
+	seen := make(map[peer.ID]bool)
+	var saved []savedPeer
+
+	add := func(pid peer.ID, good bool) {
+		if len(saved) >= maxSavedPeers || seen[pid] || pid == n.Host.ID() {
+			return
 		}
+		addrs := ps.Addrs(pid)
+		if len(addrs) == 0 {
+			return
+		}
+		seen[pid] = true
+		sp := savedPeer{ID: pid.String(), Good: good}
+		for _, a := range addrs {
+			sp.Addrs = append(sp.Addrs, a.String())
+		}
+		saved = append(saved, sp)
 	}
 
-	if len(infos) == 0 {
-		return nil
+	n.goodPeersMu.Lock()
+	good := make([]peer.ID, 0, len(n.goodPeers))
+	for pid := range n.goodPeers {
+		good = append(good, pid)
 	}
+	sort.Slice(good, func(i, j int) bool {
+		return n.goodPeers[good[i]].After(n.goodPeers[good[j]])
+	})
+	n.goodPeersMu.Unlock()
 
-	// Marshal via an intermediate type so the JSON is human-readable.
-	type jsonPeer struct {
-		ID    string   `json:"id"`
-		Addrs []string `json:"addrs"`
+	for _, pid := range good {
+		add(pid, true)
 	}
-	var jp []jsonPeer
-	for _, info := range infos {
-		var addrs []string
-		for _, a := range info.Addrs {
-			addrs = append(addrs, a.String())
-		}
-		jp = append(jp, jsonPeer{ID: info.ID.String(), Addrs: addrs})
+	for _, pid := range n.Host.Network().Peers() {
+		add(pid, false)
+	}
+	for _, pid := range ps.PeersWithAddrs() {
+		add(pid, false)
 	}
 
-	data, err := json.MarshalIndent(jp, "", "  ")
+	if len(saved) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(saved, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal peers: %w", err)
 	}
@@ -230,11 +591,16 @@ func (n *Node) SavePeers() error {
 		return fmt.Errorf("write %s: %w", path, err)
 	}
 
-	n.Logger.Info("saved peers", zap.Int("count", len(infos)), zap.String("path", path))
+	n.Logger.Info("saved peers",
+		zap.Int("count", len(saved)),
+		zap.Int("good", min(len(good), len(saved))),
+		zap.String("path", path),
+	)
 	return nil
 }
 
-// LoadPeers reads previously saved peer addresses from peers.json.
+// LoadPeers reads previously saved peer addresses from peers.json, in the
+// order they were saved (best peers first).
 func LoadPeers(dataDir string) ([]peer.AddrInfo, error) {
 	path := filepath.Join(dataDir, peersFile)
 	data, err := os.ReadFile(path)
@@ -245,11 +611,7 @@ func LoadPeers(dataDir string) ([]peer.AddrInfo, error) {
 		return nil, fmt.Errorf("read %s: %w", path, err)
 	}
 
-	type jsonPeer struct {
-		ID    string   `json:"id"`
-		Addrs []string `json:"addrs"`
-	}
-	var jp []jsonPeer
+	var jp []savedPeer
 	if err := json.Unmarshal(data, &jp); err != nil {
 		return nil, fmt.Errorf("unmarshal peers: %w", err)
 	}
@@ -279,12 +641,37 @@ func LoadPeers(dataDir string) ([]peer.AddrInfo, error) {
 // peerNotifiee implements network.Notifiee to detect new peer connections.
 type peerNotifiee struct {
 	peerConnected chan peer.ID
+	scorer        *PeerScorer
+	handshaker    *atomic.Pointer[Handshaker]
 }
 
 func (pn *peerNotifiee) Connected(_ network.Network, conn network.Conn) {
+	// Refuse banned peers. Close asynchronously; notifiees must not block.
+	if pn.scorer.IsBanned(conn.RemotePeer()) {
+		go conn.Close()
+		return
+	}
+
+	hs := pn.handshaker.Load()
+	if hs == nil {
+		pn.notifyConnected(conn.RemotePeer())
+		return
+	}
+
+	// Handshake off the notifier goroutine; only compatible peers are
+	// reported as connected.
+	go func() {
+		if err := hs.Verify(context.Background(), conn.RemotePeer()); err != nil {
+			return
+		}
+		pn.notifyConnected(conn.RemotePeer())
+	}()
+}
+
+func (pn *peerNotifiee) notifyConnected(pid peer.ID) {
 	// Non-blocking send; drop if channel is full (sync will happen on next connect)
 	select {
-	case pn.peerConnected <- conn.RemotePeer():
+	case pn.peerConnected <- pid:
 	default:
 	}
 }
@@ -0,0 +1,235 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+
+	"go.uber.org/zap"
+)
+
+func TestSavePeers_GoodPeersFirst(t *testing.T) {
+	h := newTestHost(t)
+	n := &Node{
+		Host:      h,
+		Logger:    zap.NewNop(),
+		dataDir:   t.TempDir(),
+		goodPeers: make(map[peer.ID]time.Time),
+	}
+
+	// Three known (not connected) peers; the last one is one we synced from.
+	var known []peer.ID
+	for i := 0; i < 3; i++ {
+		other := newTestHost(t)
+		h.Peerstore().AddAddrs(other.ID(), other.Addrs(), peerstore.PermanentAddrTTL)
+		known = append(known, other.ID())
+	}
+	n.MarkGoodPeer(known[2])
+
+	if err := n.SavePeers(); err != nil {
+		t.Fatalf("SavePeers: %v", err)
+	}
+
+	loaded, err := LoadPeers(n.dataDir)
+	if err != nil {
+		t.Fatalf("LoadPeers: %v", err)
+	}
+	if len(loaded) != 3 {
+		t.Fatalf("loaded %d peers, want 3", len(loaded))
+	}
+	if loaded[0].ID != known[2] {
+		t.Errorf("first saved peer = %s, want good peer %s", loaded[0].ID, known[2])
+	}
+	for _, pi := range loaded {
+		if pi.ID == h.ID() {
+			t.Error("own peer ID should not be saved")
+		}
+	}
+}
+
+func TestNewNode_NATOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n, err := NewNode(ctx, 0, t.TempDir(), "regtest", true, "", false, nil, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewNode with NAT traversal: %v", err)
+	}
+	defer n.Host.Close()
+
+	// Before the host is set, and with no connected peers, there are no
+	// relay candidates.
+	var relay relayHost
+	if _, ok := <-relayPeerSource(&relay)(ctx, 4); ok {
+		t.Error("expected no relay candidates before the host is set")
+	}
+	relay.set(n.Host)
+	src := relayPeerSource(&relay)
+	if _, ok := <-src(ctx, 4); ok {
+		t.Error("expected no relay candidates without peers")
+	}
+}
+
+func TestNewNode_PrivatePoolPSK(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	newNode := func(secret string) *Node {
+		n, err := NewNode(ctx, 0, t.TempDir(), "regtest", false, secret, true, nil, zap.NewNop())
+		if err != nil {
+			t.Fatalf("NewNode: %v", err)
+		}
+		t.Cleanup(func() { n.Host.Close() })
+		return n
+	}
+
+	a := newNode("friends")
+	b := newNode("friends")
+	c := newNode("strangers")
+
+	aInfo := peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()}
+	if err := b.Host.Connect(ctx, aInfo); err != nil {
+		t.Errorf("nodes sharing a secret failed to connect: %v", err)
+	}
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer dialCancel()
+	if err := c.Host.Connect(dialCtx, aInfo); err == nil {
+		t.Error("node with a different secret should not connect")
+	}
+}
+
+func TestPoolTopics(t *testing.T) {
+	if shareTopic("") != ShareTopicName || tipTopic("") != TipTopicName || dhtNamespace("") != DHTNamespace {
+		t.Error("empty secret should select the public pool")
+	}
+	if shareTopic("a") == ShareTopicName || shareTopic("a") == shareTopic("b") {
+		t.Error("each secret should derive its own topic")
+	}
+	if shareTopic("a") != shareTopic("a") {
+		t.Error("topic derivation should be deterministic")
+	}
+}
+
+func TestPeerDetails(t *testing.T) {
+	a, b := newTestHost(t), newTestHost(t)
+	n := &Node{
+		Host:      a,
+		Logger:    zap.NewNop(),
+		goodPeers: make(map[peer.ID]time.Time),
+	}
+	connectHosts(t, a, b) // b dials a
+	n.MarkGoodPeer(b.ID())
+	n.pingPeers(context.Background())
+
+	details := n.PeerDetails()
+	if len(details) != 1 {
+		t.Fatalf("got %d peers, want 1", len(details))
+	}
+	d := details[0]
+	if d.ID != b.ID() {
+		t.Errorf("ID = %s, want %s", d.ID, b.ID())
+	}
+	if d.Direction != "inbound" {
+		t.Errorf("Direction = %q, want inbound", d.Direction)
+	}
+	if d.LatencyUs <= 0 {
+		t.Errorf("LatencyUs = %d after ping, want > 0", d.LatencyUs)
+	}
+	if d.LastSync.IsZero() {
+		t.Error("LastSync not set for a good peer")
+	}
+	if d.ConnectedAt.IsZero() || d.Address == "" {
+		t.Errorf("connection metadata missing: %+v", d)
+	}
+}
+
+func TestConnectPeer(t *testing.T) {
+	a, b := newTestHost(t), newTestHost(t)
+	n := &Node{
+		Host:   a,
+		Logger: zap.NewNop(),
+		scorer: NewPeerScorer(DefaultBanThreshold, DefaultBanDuration),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, bad := range []string{"", "not a multiaddr", b.Addrs()[0].String()} {
+		if _, err := n.ConnectPeer(ctx, bad); !errors.Is(err, ErrInvalidPeerAddr) {
+			t.Errorf("ConnectPeer(%q) = %v, want ErrInvalidPeerAddr", bad, err)
+		}
+	}
+
+	addr := fmt.Sprintf("%s/p2p/%s", b.Addrs()[0], b.ID())
+	pid, err := n.ConnectPeer(ctx, addr)
+	if err != nil {
+		t.Fatalf("ConnectPeer: %v", err)
+	}
+	if pid != b.ID() || a.Network().Connectedness(b.ID()) != network.Connected {
+		t.Errorf("not connected to %s", b.ID())
+	}
+
+	// An unreachable peer is a dial error, not an address error.
+	gone := newTestHost(t)
+	goneAddr := fmt.Sprintf("%s/p2p/%s", gone.Addrs()[0], gone.ID())
+	gone.Close()
+	if _, err := n.ConnectPeer(ctx, goneAddr); err == nil || errors.Is(err, ErrInvalidPeerAddr) {
+		t.Errorf("ConnectPeer to closed host = %v, want a dial error", err)
+	}
+}
+
+func TestProtectPeer(t *testing.T) {
+	cm, err := connmgr.NewConnManager(50, 100)
+	if err != nil {
+		t.Fatalf("NewConnManager: %v", err)
+	}
+	h, err := libp2p.New(libp2p.NoListenAddrs, libp2p.ConnectionManager(cm))
+	if err != nil {
+		t.Fatalf("create host: %v", err)
+	}
+	defer h.Close()
+	n := &Node{
+		Host:      h,
+		Logger:    zap.NewNop(),
+		scorer:    NewPeerScorer(DefaultBanThreshold, DefaultBanDuration),
+		protected: make(map[peer.ID]time.Time),
+	}
+
+	n.ProtectPeer(h.ID())
+	if n.ProtectedPeerCount() != 0 {
+		t.Error("protected ourselves")
+	}
+
+	first := peer.ID("peer-0")
+	for i := range maxProtectedPeers {
+		n.ProtectPeer(peer.ID(fmt.Sprintf("peer-%d", i)))
+		time.Sleep(time.Millisecond)
+	}
+	if !cm.IsProtected(first, protectTag) || n.ProtectedPeerCount() != maxProtectedPeers {
+		t.Fatalf("protected %d peers, want %d", n.ProtectedPeerCount(), maxProtectedPeers)
+	}
+
+	// One more evicts the peer useful least recently.
+	extra := peer.ID("peer-extra")
+	n.ProtectPeer(extra)
+	if cm.IsProtected(first, protectTag) || !cm.IsProtected(extra, protectTag) {
+		t.Error("oldest peer not released for the new one")
+	}
+	if n.ProtectedPeerCount() != maxProtectedPeers {
+		t.Errorf("protected %d peers, want %d", n.ProtectedPeerCount(), maxProtectedPeers)
+	}
+
+	// A banned peer loses its protection.
+	n.PenalizePeer(extra, DefaultBanThreshold)
+	if cm.IsProtected(extra, protectTag) {
+		t.Error("banned peer still protected")
+	}
+}
@@ -0,0 +1,143 @@
+package p2p
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// peerFilterReloadInterval is how often a peer list file is checked for
+// changes.
+const peerFilterReloadInterval = 30 * time.Second
+
+// PeerFilter restricts which peers may connect using a file of peer IDs,
+// read either as an allowlist (only listed peers) or a denylist (every peer
+// but those listed). It is a libp2p connection gater, so refused peers are
+// dropped during the security handshake and are never dialed. The file is
+// re-read by Reload. A nil *PeerFilter allows every peer.
+type PeerFilter struct {
+	path  string
+	allow bool
+
+	mu      sync.RWMutex
+	ids     map[peer.ID]struct{}
+	modTime time.Time
+}
+
+var _ connmgr.ConnectionGater = (*PeerFilter)(nil)
+
+// NewPeerFilter loads the peer list at path, as an allowlist if allow is
+// set and a denylist otherwise.
+func NewPeerFilter(path string, allow bool) (*PeerFilter, error) {
+	f := &PeerFilter{path: path, allow: allow}
+	if _, err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload re-reads the peer list if the file changed since it was last
+// loaded, and reports whether it did. On error the current list is kept.
+func (f *PeerFilter) Reload() (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, fmt.Errorf("peer list: %w", err)
+	}
+	f.mu.RLock()
+	unchanged := f.ids != nil && info.ModTime().Equal(f.modTime)
+	f.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	ids, err := readPeerList(f.path)
+	if err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	f.ids, f.modTime = ids, info.ModTime()
+	f.mu.Unlock()
+	return true, nil
+}
+
+// readPeerList parses a peer list file: one peer ID per line, with blank
+// lines and anything after a '#' ignored.
+func readPeerList(path string) (map[peer.ID]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("peer list: %w", err)
+	}
+	defer file.Close()
+
+	ids := make(map[peer.ID]struct{})
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		id, err := peer.Decode(text)
+		if err != nil {
+			return nil, fmt.Errorf("peer list %s line %d: invalid peer ID %q: %w", path, line, text, err)
+		}
+		ids[id] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("peer list: %w", err)
+	}
+	return ids, nil
+}
+
+// Allowed reports whether id may connect.
+func (f *PeerFilter) Allowed(id peer.ID) bool {
+	if f == nil {
+		return true
+	}
+	f.mu.RLock()
+	_, listed := f.ids[id]
+	f.mu.RUnlock()
+	return listed == f.allow
+}
+
+// Len returns the number of peer IDs in the list.
+func (f *PeerFilter) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.ids)
+}
+
+// InterceptPeerDial refuses to dial peers that are not allowed.
+func (f *PeerFilter) InterceptPeerDial(p peer.ID) bool {
+	return f.Allowed(p)
+}
+
+// InterceptAddrDial refuses to dial peers that are not allowed.
+func (f *PeerFilter) InterceptAddrDial(p peer.ID, _ ma.Multiaddr) bool {
+	return f.Allowed(p)
+}
+
+// InterceptAccept accepts every inbound connection; the remote peer is
+// only known once the connection is secured.
+func (f *PeerFilter) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+// InterceptSecured drops connections from peers that are not allowed.
+func (f *PeerFilter) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	return f.Allowed(p)
+}
+
+// InterceptUpgraded accepts every connection that got this far.
+func (f *PeerFilter) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
@@ -0,0 +1,133 @@
+package p2p
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"go.uber.org/zap"
+)
+
+// writePeerList writes ids to path as a peer list with a comment line,
+// stamping the file with modTime so reloads see the change.
+func writePeerList(t *testing.T, path string, modTime time.Time, ids ...peer.ID) {
+	t.Helper()
+	data := "# pool members\n\n"
+	for _, id := range ids {
+		data += id.String() + " # member\n"
+	}
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("write peer list: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+}
+
+func TestPeerFilter(t *testing.T) {
+	a, b := newTestHost(t).ID(), newTestHost(t).ID()
+	path := filepath.Join(t.TempDir(), "peers.txt")
+	writePeerList(t, path, time.Unix(1700000000, 0), a)
+
+	allow, err := NewPeerFilter(path, true)
+	if err != nil {
+		t.Fatalf("NewPeerFilter: %v", err)
+	}
+	deny, err := NewPeerFilter(path, false)
+	if err != nil {
+		t.Fatalf("NewPeerFilter: %v", err)
+	}
+	if !allow.Allowed(a) || allow.Allowed(b) {
+		t.Error("allowlist should admit only the listed peer")
+	}
+	if deny.Allowed(a) || !deny.Allowed(b) {
+		t.Error("denylist should refuse only the listed peer")
+	}
+	var open *PeerFilter
+	if !open.Allowed(a) {
+		t.Error("a nil filter should allow every peer")
+	}
+
+	if changed, err := allow.Reload(); err != nil || changed {
+		t.Errorf("Reload of an unchanged file = %v, %v; want false, nil", changed, err)
+	}
+	writePeerList(t, path, time.Unix(1700000060, 0), b)
+	if changed, err := allow.Reload(); err != nil || !changed {
+		t.Fatalf("Reload = %v, %v; want true, nil", changed, err)
+	}
+	if allow.Allowed(a) || !allow.Allowed(b) {
+		t.Error("reloaded allowlist not applied")
+	}
+
+	// A broken file keeps the current list.
+	if err := os.WriteFile(path, []byte("not-a-peer-id\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := allow.Reload(); err == nil {
+		t.Error("expected an error for an invalid peer ID")
+	}
+	if !allow.Allowed(b) {
+		t.Error("failed reload replaced the list")
+	}
+	if _, err := NewPeerFilter(path, true); err == nil {
+		t.Error("NewPeerFilter should reject an invalid list")
+	}
+}
+
+func TestPeerFilter_GatesConnections(t *testing.T) {
+	member, outsider := newTestHost(t), newTestHost(t)
+	path := filepath.Join(t.TempDir(), "allow.txt")
+	writePeerList(t, path, time.Unix(1700000000, 0), member.ID(), outsider.ID())
+	filter, err := NewPeerFilter(path, true)
+	if err != nil {
+		t.Fatalf("NewPeerFilter: %v", err)
+	}
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.ConnectionGater(filter))
+	if err != nil {
+		t.Fatalf("create host: %v", err)
+	}
+	defer h.Close()
+	n := &Node{Host: h, Logger: zap.NewNop(), peerFilter: filter}
+
+	connect := func(from host.Host) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return from.Connect(ctx, peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+	}
+	if err := connect(member); err != nil {
+		t.Fatalf("listed peer refused: %v", err)
+	}
+	if err := connect(outsider); err != nil {
+		t.Fatalf("listed peer refused: %v", err)
+	}
+
+	// Dropping the outsider from the list disconnects it and refuses it
+	// from then on.
+	writePeerList(t, path, time.Unix(1700000060, 0), member.ID())
+	n.reloadPeerFilter()
+	if h.Network().Connectedness(outsider.ID()) == network.Connected {
+		t.Error("removed peer still connected")
+	}
+	if h.Network().Connectedness(member.ID()) != network.Connected {
+		t.Error("listed peer disconnected by reload")
+	}
+	// The dialer may finish its side of the handshake before we refuse
+	// the connection, so check from our side.
+	outsider.Network().ClosePeer(h.ID())
+	connect(outsider)
+	if h.Network().Connectedness(outsider.ID()) == network.Connected {
+		t.Error("removed peer could reconnect")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.Connect(ctx, peer.AddrInfo{ID: outsider.ID(), Addrs: outsider.Addrs()}); err == nil {
+		t.Error("dialed a peer the filter refuses")
+	}
+}
@@ -0,0 +1,51 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// A private pool is identified by a pre-shared secret. Nodes with the same
+// secret join gossipsub topics and a DHT namespace derived from it, so they
+// never see (or are seen by) the public pool or other private pools. Public
+// and private pools cannot interoperate by design.
+
+// poolSuffix derives a short, non-reversible identifier from a pool secret.
+func poolSuffix(secret string) string {
+	sum := sha256.Sum256([]byte("p2pool-topic:" + secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+// shareTopic returns the share gossip topic for a pool secret. An empty
+// secret selects the public pool.
+func shareTopic(secret string) string {
+	if secret == "" {
+		return ShareTopicName
+	}
+	return ShareTopicName + "/" + poolSuffix(secret)
+}
+
+// tipTopic returns the tip announcement topic for a pool secret.
+func tipTopic(secret string) string {
+	if secret == "" {
+		return TipTopicName
+	}
+	return TipTopicName + "/" + poolSuffix(secret)
+}
+
+// dhtNamespace returns the DHT rendezvous namespace for a pool secret.
+func dhtNamespace(secret string) string {
+	if secret == "" {
+		return DHTNamespace
+	}
+	return DHTNamespace + "/" + poolSuffix(secret)
+}
+
+// privateNetworkKey derives a libp2p private network key from a pool secret.
+// Peers without the same key cannot complete the transport handshake.
+func privateNetworkKey(secret string) pnet.PSK {
+	sum := sha256.Sum256([]byte("p2pool-psk:" + secret))
+	return pnet.PSK(sum[:])
+}
@@ -2,6 +2,7 @@ package p2p
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
@@ -10,28 +11,54 @@ import (
 
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
+
+	"github.com/djkazic/p2pool-go/internal/metrics"
+	"github.com/djkazic/p2pool-go/internal/types"
+	"github.com/djkazic/p2pool-go/pkg/util"
 )
 
 // PubSub manages GossipSub for share propagation.
 type PubSub struct {
-	ps     *pubsub.PubSub
-	topic  *pubsub.Topic
-	sub    *pubsub.Subscription
-	self   peer.ID
-	logger *zap.Logger
+	ps       *pubsub.PubSub
+	topic    *pubsub.Topic
+	sub      *pubsub.Subscription
+	tipTopic *pubsub.Topic
+	tipSub   *pubsub.Subscription
+	self     peer.ID
+	network  uint8
+	scorer   *PeerScorer
+	filter   *PeerFilter
+	seen     *seenCache
+	logger   *zap.Logger
 
 	peerLimiters   map[peer.ID]*rate.Limiter
 	peerLimitersMu sync.Mutex
 }
 
 // NewPubSub creates a new GossipSub instance.
-func NewPubSub(ctx context.Context, h host.Host, incomingShares chan *ShareMsg, logger *zap.Logger) (*PubSub, error) {
-	ps, err := pubsub.NewGossipSub(ctx, h)
+// Published shares are tagged with network and received shares from other
+// networks are dropped. A non-empty poolSecret joins the private pool's
+// topics instead of the public ones. Messages from peers filter does not
+// allow are dropped; filter may be nil.
+func NewPubSub(ctx context.Context, h host.Host, incomingShares chan *ShareMsg, incomingTips chan *TipAnnounce, scorer *PeerScorer, filter *PeerFilter, network uint8, poolSecret string, logger *zap.Logger) (*PubSub, error) {
+	ps, err := pubsub.NewGossipSub(ctx, h,
+		pubsub.WithPeerScore(gossipScoreParams(scorer, shareTopic(poolSecret)), gossipScoreThresholds),
+		pubsub.WithPeerScoreInspect(exportScores, scoreInspectInterval),
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	topic, err := ps.Join(ShareTopicName)
+	// Validators run before a message is delivered or relayed, so shares
+	// and tips that fail them are never forwarded to the rest of the mesh.
+	if err := ps.RegisterTopicValidator(shareTopic(poolSecret), shareValidator(network, scorer, logger), pubsub.WithValidatorInline(true)); err != nil {
+		return nil, err
+	}
+	if err := ps.RegisterTopicValidator(tipTopic(poolSecret), tipValidator(scorer, logger), pubsub.WithValidatorInline(true)); err != nil {
+		return nil, err
+	}
+
+	topic, err := ps.Join(shareTopic(poolSecret))
 	if err != nil {
 		return nil, err
 	}
@@ -41,16 +68,33 @@ func NewPubSub(ctx context.Context, h host.Host, incomingShares chan *ShareMsg,
 		return nil, err
 	}
 
+	tipTopic, err := ps.Join(tipTopic(poolSecret))
+	if err != nil {
+		return nil, err
+	}
+
+	tipSub, err := tipTopic.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+
 	p := &PubSub{
 		ps:           ps,
 		topic:        topic,
 		sub:          sub,
+		tipTopic:     tipTopic,
+		tipSub:       tipSub,
 		self:         h.ID(),
+		network:      network,
+		scorer:       scorer,
+		filter:       filter,
+		seen:         newSeenCache(seenSharesSize),
 		logger:       logger,
 		peerLimiters: make(map[peer.ID]*rate.Limiter),
 	}
 
 	go p.readLoop(ctx, incomingShares)
+	go p.readTipLoop(ctx, incomingTips)
 
 	return p, nil
 }
@@ -58,6 +102,7 @@ func NewPubSub(ctx context.Context, h host.Host, incomingShares chan *ShareMsg,
 // PublishShare publishes a share to the gossipsub network.
 func (p *PubSub) PublishShare(share *ShareMsg) error {
 	share.Type = MsgTypeShare
+	share.Network = p.network
 	data, err := Encode(share)
 	if err != nil {
 		return err
@@ -65,6 +110,22 @@ func (p *PubSub) PublishShare(share *ShareMsg) error {
 	return p.topic.Publish(context.Background(), data)
 }
 
+// PublishTip publishes a chain tip announcement.
+func (p *PubSub) PublishTip(tip *TipAnnounce) error {
+	tip.Type = MsgTypeTipAnnounce
+	data, err := Encode(tip)
+	if err != nil {
+		return err
+	}
+	return p.tipTopic.Publish(context.Background(), data)
+}
+
+// MarkSeen records the header hash of a share the sharechain accepted, so
+// further copies of it are dropped.
+func (p *PubSub) MarkSeen(headerHash [32]byte) {
+	p.seen.add(headerHash)
+}
+
 func (p *PubSub) readLoop(ctx context.Context, incomingShares chan *ShareMsg) {
 	for {
 		msg, err := p.sub.Next(ctx)
@@ -81,14 +142,32 @@ func (p *PubSub) readLoop(ctx context.Context, incomingShares chan *ShareMsg) {
 			continue
 		}
 
-		if !p.getPeerLimiter(msg.GetFrom()).Allow() {
-			p.logger.Warn("peer rate limited", zap.String("peer", msg.GetFrom().String()))
+		// Ban, filter and rate-limit the peer that delivered the share;
+		// the author field is whatever the publisher claimed.
+		from := msg.ReceivedFrom
+		if p.scorer.IsBanned(from) || !p.filter.Allowed(from) {
 			continue
 		}
 
-		share, err := DecodeShareMsg(msg.Data)
-		if err != nil {
-			p.logger.Debug("invalid share message", zap.Error(err))
+		if !p.getPeerLimiter(from).Allow() {
+			p.logger.Warn("peer rate limited", zap.String("peer", from.String()))
+			continue
+		}
+
+		// Decoded by shareValidator.
+		share, ok := msg.ValidatorData.(*ShareMsg)
+		if !ok {
+			continue
+		}
+		share.From = msg.GetFrom()
+		share.ReceivedFrom = from
+
+		// Relays may re-encode a share, defeating gossipsub's message-id
+		// dedup, so also drop shares whose header we've already accepted.
+		// Headers are only marked once the sharechain accepts the share
+		// (MarkSeen), so an invalid copy can't shadow the real one.
+		if p.seen.has(share.HeaderHash()) {
+			metrics.DuplicateSharesDropped.Inc()
 			continue
 		}
 
@@ -100,6 +179,108 @@ func (p *PubSub) readLoop(ctx context.Context, incomingShares chan *ShareMsg) {
 	}
 }
 
+func (p *PubSub) readTipLoop(ctx context.Context, incomingTips chan *TipAnnounce) {
+	for {
+		msg, err := p.tipSub.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			p.logger.Error("pubsub tip read error", zap.Error(err))
+			continue
+		}
+
+		if msg.GetFrom() == p.self {
+			continue
+		}
+		from := msg.ReceivedFrom
+		if p.scorer.IsBanned(from) || !p.filter.Allowed(from) {
+			continue
+		}
+
+		if !p.getPeerLimiter(from).Allow() {
+			continue
+		}
+
+		// Decoded by tipValidator.
+		tip, ok := msg.ValidatorData.(*TipAnnounce)
+		if !ok {
+			continue
+		}
+		tip.From = msg.GetFrom()
+		tip.ReceivedFrom = from
+
+		select {
+		case incomingTips <- tip:
+		default:
+			// A newer announcement will follow; dropping is harmless.
+		}
+	}
+}
+
+// shareValidator returns the gossipsub validator for the share topic. It
+// runs only cheap structural checks: message size, decoding (required
+// fields, field limits and network), a known share version and canonical
+// compact bits. PoW, parent and coinbase checks are left to the sharechain.
+// The decoded message is handed to readLoop as the message's ValidatorData.
+// The peer that sent us a rejected message is penalized: the author field
+// is unauthenticated and may name anyone.
+func shareValidator(network uint8, scorer *PeerScorer, logger *zap.Logger) pubsub.ValidatorEx {
+	return func(_ context.Context, src peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		share, err := checkShareMessage(msg.Data, network)
+		if err != nil {
+			logger.Debug("invalid share message", zap.Error(err))
+			if !msg.Local {
+				scorer.Penalize(src, PenaltyMalformedMessage)
+			}
+			return pubsub.ValidationReject
+		}
+		msg.ValidatorData = share
+		return pubsub.ValidationAccept
+	}
+}
+
+// checkShareMessage decodes a gossiped share and applies shareValidator's
+// structural checks.
+func checkShareMessage(data []byte, network uint8) (*ShareMsg, error) {
+	if len(data) > maxShareMsgSize {
+		return nil, fmt.Errorf("share message too large: %d bytes", len(data))
+	}
+	share, err := DecodeShareMsg(data, network)
+	if err != nil {
+		return nil, err
+	}
+	// Only the range is checked here; the sharechain validator decides
+	// what each version must carry.
+	if share.ShareVersion < types.ShareVersion1 || share.ShareVersion > types.ShareVersion2 {
+		return nil, fmt.Errorf("unsupported share version %d", share.ShareVersion)
+	}
+	if !util.IsCanonicalCompact(share.Bits) {
+		return nil, fmt.Errorf("header bits 0x%08x are not canonical", share.Bits)
+	}
+	if !util.IsCanonicalCompact(share.ShareTargetBits) {
+		return nil, fmt.Errorf("share target bits 0x%08x are not canonical", share.ShareTargetBits)
+	}
+	return share, nil
+}
+
+// tipValidator returns the gossipsub validator for the tip topic, which
+// decodes announcements for readTipLoop the way shareValidator does shares.
+func tipValidator(scorer *PeerScorer, logger *zap.Logger) pubsub.ValidatorEx {
+	return func(_ context.Context, src peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		tip, err := DecodeTipAnnounce(msg.Data)
+		if err != nil {
+			logger.Debug("invalid tip announcement", zap.Error(err))
+			if !msg.Local {
+				scorer.Penalize(src, PenaltyMalformedMessage)
+			}
+			return pubsub.ValidationReject
+		}
+		msg.ValidatorData = tip
+		return pubsub.ValidationAccept
+	}
+}
+
 func (p *PubSub) getPeerLimiter(peerID peer.ID) *rate.Limiter {
 	p.peerLimitersMu.Lock()
 	defer p.peerLimitersMu.Unlock()
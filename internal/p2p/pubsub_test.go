@@ -0,0 +1,64 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+func TestShareValidator(t *testing.T) {
+	const author, relay = peer.ID("author"), peer.ID("relay")
+	scorer := NewPeerScorer(DefaultBanThreshold, time.Hour)
+	validate := shareValidator(1, scorer, zap.NewNop())
+	run := func(data []byte) (*pubsub.Message, pubsub.ValidationResult) {
+		msg := &pubsub.Message{Message: &pb.Message{Data: data, From: []byte(author)}}
+		return msg, validate(context.Background(), relay, msg)
+	}
+	encode := func(edit func(*ShareMsg)) []byte {
+		msg := testShareMsg()
+		edit(msg)
+		data, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		return data
+	}
+
+	msg, res := run(encode(func(*ShareMsg) {}))
+	if res != pubsub.ValidationAccept {
+		t.Fatalf("valid share: result %v, want accept", res)
+	}
+	if share, ok := msg.ValidatorData.(*ShareMsg); !ok || share.MinerAddress != testShareMsg().MinerAddress {
+		t.Error("decoded share not passed on as ValidatorData")
+	}
+	if scorer.Score(author) != 0 {
+		t.Errorf("valid share penalized its author: score %v", scorer.Score(author))
+	}
+
+	for name, data := range map[string][]byte{
+		"undecodable":        {0xff, 0x00, 0x13},
+		"oversized":          make([]byte, maxShareMsgSize+1),
+		"wrong network":      encode(func(m *ShareMsg) { m.Network = 2 }),
+		"unknown version":    encode(func(m *ShareMsg) { m.ShareVersion = 99 }),
+		"non-canonical bits": encode(func(m *ShareMsg) { m.ShareTargetBits = 0x20ffffff }),
+	} {
+		msg, res := run(data)
+		if res != pubsub.ValidationReject {
+			t.Errorf("%s: result %v, want reject", name, res)
+		}
+		if msg.ValidatorData != nil {
+			t.Errorf("%s: rejected message carries ValidatorData", name)
+		}
+	}
+	if scorer.Score(relay) <= 0 {
+		t.Errorf("sender of rejected shares not penalized: score %v", scorer.Score(relay))
+	}
+	if scorer.Score(author) != 0 {
+		t.Errorf("claimed author penalized: score %v", scorer.Score(author))
+	}
+}
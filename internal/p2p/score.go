@@ -0,0 +1,95 @@
+package p2p
+
+import (
+	"sort"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/djkazic/p2pool-go/internal/metrics"
+)
+
+const (
+	// appScoreWeight scales our own misbehavior score (see PeerScorer) into
+	// the gossipsub score. One invalid share drops a peer out of our mesh;
+	// a few more and we stop gossiping with it; at the ban threshold it can
+	// no longer publish to us.
+	appScoreWeight = -10.0
+
+	// scoreInspectInterval is how often the score distribution is exported.
+	scoreInspectInterval = time.Minute
+)
+
+// gossipScoreThresholds are the gossipsub score thresholds. Peers below
+// zero are pruned from our mesh; below GossipThreshold we stop gossiping
+// with them; below GraylistThreshold their messages are ignored outright.
+var gossipScoreThresholds = &pubsub.PeerScoreThresholds{
+	GossipThreshold:             -500,
+	PublishThreshold:            -1000,
+	GraylistThreshold:           -2500,
+	AcceptPXThreshold:           10,
+	OpportunisticGraftThreshold: 5,
+}
+
+// gossipScoreParams returns gossipsub peer scoring parameters. Peers earn
+// score for being first to deliver shares and for staying in the mesh, and
+// lose it for invalid deliveries and for shares that fail sharechain
+// validation (via the application-specific score from scorer).
+func gossipScoreParams(scorer *PeerScorer, shareTopicName string) *pubsub.PeerScoreParams {
+	return &pubsub.PeerScoreParams{
+		Topics: map[string]*pubsub.TopicScoreParams{
+			shareTopicName: {
+				// Mesh delivery rate penalties are left disabled: shares
+				// arrive every ~30s, too rarely to judge a peer by.
+				SkipAtomicValidation: true,
+
+				TopicWeight: 1,
+
+				TimeInMeshWeight:  0.01,
+				TimeInMeshQuantum: time.Second,
+				TimeInMeshCap:     3600,
+
+				FirstMessageDeliveriesWeight: 1,
+				FirstMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(time.Hour),
+				FirstMessageDeliveriesCap:    100,
+
+				InvalidMessageDeliveriesWeight: -100,
+				InvalidMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(time.Hour),
+			},
+		},
+		TopicScoreCap: 100,
+
+		AppSpecificScore: func(p peer.ID) float64 {
+			return scorer.Score(p)
+		},
+		AppSpecificWeight: appScoreWeight,
+
+		IPColocationFactorWeight:    -10,
+		IPColocationFactorThreshold: 5,
+
+		BehaviourPenaltyWeight:    -10,
+		BehaviourPenaltyThreshold: 6,
+		BehaviourPenaltyDecay:     pubsub.ScoreParameterDecay(10 * time.Minute),
+
+		DecayInterval: pubsub.DefaultDecayInterval,
+		DecayToZero:   pubsub.DefaultDecayToZero,
+		RetainScore:   30 * time.Minute,
+	}
+}
+
+// exportScores publishes the min, median and max gossipsub peer score.
+func exportScores(scores map[peer.ID]float64) {
+	if len(scores) == 0 {
+		metrics.GossipPeerScore.Reset()
+		return
+	}
+	vals := make([]float64, 0, len(scores))
+	for _, s := range scores {
+		vals = append(vals, s)
+	}
+	sort.Float64s(vals)
+	metrics.GossipPeerScore.WithLabelValues("min").Set(vals[0])
+	metrics.GossipPeerScore.WithLabelValues("median").Set(vals[len(vals)/2])
+	metrics.GossipPeerScore.WithLabelValues("max").Set(vals[len(vals)-1])
+}
@@ -0,0 +1,164 @@
+package p2p
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// DefaultBanThreshold is the misbehavior score at which a peer is banned.
+	DefaultBanThreshold = 100.0
+
+	// DefaultBanDuration is how long a banned peer stays banned.
+	DefaultBanDuration = 30 * time.Minute
+
+	// scoreHalfLife is how long it takes a peer's score to decay by half.
+	// A peer must misbehave repeatedly within a few half-lives to be banned.
+	scoreHalfLife = 5 * time.Minute
+
+	// maxScoredPeers bounds the number of peers tracked at once.
+	maxScoredPeers = 1000
+)
+
+// Misbehavior penalties.
+const (
+	// PenaltyInvalidShare is applied when a peer's share fails validation.
+	PenaltyInvalidShare = 20.0
+
+	// PenaltyMalformedMessage is applied when a peer's message fails to decode.
+	PenaltyMalformedMessage = 50.0
+)
+
+// PeerScorer tracks misbehavior scores per peer and bans peers whose score
+// crosses a threshold. Scores decay exponentially over time so occasional
+// failures from honest peers are forgiven.
+type PeerScorer struct {
+	mu          sync.Mutex
+	scores      map[peer.ID]*peerScore
+	bans        map[peer.ID]time.Time // peer -> ban expiry
+	threshold   float64
+	banDuration time.Duration
+
+	now func() time.Time
+}
+
+type peerScore struct {
+	score   float64
+	updated time.Time
+}
+
+// NewPeerScorer creates a scorer that bans peers for banDuration once their
+// decayed score reaches threshold.
+func NewPeerScorer(threshold float64, banDuration time.Duration) *PeerScorer {
+	return &PeerScorer{
+		scores:      make(map[peer.ID]*peerScore),
+		bans:        make(map[peer.ID]time.Time),
+		threshold:   threshold,
+		banDuration: banDuration,
+		now:         time.Now,
+	}
+}
+
+// Penalize adds penalty to a peer's score. It returns true if this pushed
+// the peer over the threshold and it is now banned.
+func (s *PeerScorer) Penalize(id peer.ID, penalty float64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.isBanned(id, now) {
+		return false
+	}
+
+	ps, ok := s.scores[id]
+	if !ok {
+		if len(s.scores) >= maxScoredPeers {
+			s.evictLowest(now)
+		}
+		ps = &peerScore{updated: now}
+		s.scores[id] = ps
+	}
+	ps.score = decay(ps.score, now.Sub(ps.updated)) + penalty
+	ps.updated = now
+
+	if ps.score < s.threshold {
+		return false
+	}
+	delete(s.scores, id)
+	s.bans[id] = now.Add(s.banDuration)
+	return true
+}
+
+// Score returns a peer's current decayed score. Banned peers score the ban
+// threshold until the ban expires.
+func (s *PeerScorer) Score(id peer.ID) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.isBanned(id, now) {
+		return s.threshold
+	}
+	ps, ok := s.scores[id]
+	if !ok {
+		return 0
+	}
+	return decay(ps.score, now.Sub(ps.updated))
+}
+
+// IsBanned reports whether a peer is currently banned.
+func (s *PeerScorer) IsBanned(id peer.ID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isBanned(id, s.now())
+}
+
+// BannedCount returns the number of currently banned peers.
+func (s *PeerScorer) BannedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for id, until := range s.bans {
+		if !now.Before(until) {
+			delete(s.bans, id)
+		}
+	}
+	return len(s.bans)
+}
+
+func (s *PeerScorer) isBanned(id peer.ID, now time.Time) bool {
+	until, ok := s.bans[id]
+	if !ok {
+		return false
+	}
+	if !now.Before(until) {
+		delete(s.bans, id)
+		return false
+	}
+	return true
+}
+
+// evictLowest drops the peer with the lowest decayed score.
+func (s *PeerScorer) evictLowest(now time.Time) {
+	var lowestID peer.ID
+	lowest := math.Inf(1)
+	for id, ps := range s.scores {
+		if score := decay(ps.score, now.Sub(ps.updated)); score < lowest {
+			lowest = score
+			lowestID = id
+		}
+	}
+	delete(s.scores, lowestID)
+}
+
+// decay applies exponential decay with scoreHalfLife to a score.
+func decay(score float64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return score
+	}
+	return score * math.Pow(0.5, float64(elapsed)/float64(scoreHalfLife))
+}
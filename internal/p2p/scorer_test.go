@@ -0,0 +1,80 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestPeerScorer_BansAfterThreshold(t *testing.T) {
+	s := NewPeerScorer(100, time.Minute)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+
+	id := peer.ID("bad-peer")
+	for i := 0; i < 4; i++ {
+		if s.Penalize(id, PenaltyInvalidShare) {
+			t.Fatalf("banned after %d penalties, want 5", i+1)
+		}
+	}
+	if !s.Penalize(id, PenaltyInvalidShare) {
+		t.Fatal("expected ban at threshold")
+	}
+	if !s.IsBanned(id) || s.BannedCount() != 1 {
+		t.Error("peer should be banned")
+	}
+	if s.Score(id) != 100 {
+		t.Errorf("banned peer score = %f, want threshold", s.Score(id))
+	}
+	if s.Penalize(id, PenaltyInvalidShare) {
+		t.Error("already banned peer reported as newly banned")
+	}
+
+	now = now.Add(time.Minute)
+	if s.IsBanned(id) || s.BannedCount() != 0 {
+		t.Error("ban should expire")
+	}
+}
+
+func TestPeerScorer_Decay(t *testing.T) {
+	s := NewPeerScorer(100, time.Minute)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+
+	id := peer.ID("flaky-peer")
+	s.Penalize(id, 80)
+	now = now.Add(scoreHalfLife)
+	if got := s.Score(id); got < 39.9 || got > 40.1 {
+		t.Errorf("score after one half-life = %f, want 40", got)
+	}
+
+	// Spread-out failures never reach the threshold.
+	if s.Penalize(id, 50) {
+		t.Error("decayed score should stay below threshold")
+	}
+}
+
+func TestGossipScoreParams_AppScore(t *testing.T) {
+	s := NewPeerScorer(DefaultBanThreshold, DefaultBanDuration)
+	params := gossipScoreParams(s, ShareTopicName)
+
+	id := peer.ID("spammer")
+	s.Penalize(id, PenaltyInvalidShare)
+
+	// One invalid share gets a peer pruned from the mesh (score < 0) but
+	// doesn't cut off gossip yet.
+	got := params.AppSpecificScore(id) * params.AppSpecificWeight
+	if got >= 0 || got <= gossipScoreThresholds.GossipThreshold {
+		t.Errorf("weighted app score after one invalid share = %f", got)
+	}
+
+	// At the ban threshold the peer can no longer publish to us.
+	ban := DefaultBanThreshold * params.AppSpecificWeight
+	if ban > gossipScoreThresholds.PublishThreshold {
+		t.Errorf("score at ban threshold = %f, want <= publish threshold", ban)
+	}
+	if params.AppSpecificScore(peer.ID("honest")) != 0 {
+		t.Error("honest peer should have zero app score")
+	}
+}
@@ -2,35 +2,82 @@ package p2p
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
+	"github.com/djkazic/p2pool-go/internal/metrics"
+
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
 const (
 	maxSyncMsgSize    = 1024 * 1024 // 1MB
 	syncStreamTimeout = 30 * time.Second
+
+	// maxSyncSessionShares caps the shares a single SyncAll session will
+	// fetch, so a peer can't keep us downloading forever.
+	maxSyncSessionShares = 100000
+
+	// syncRequestRate and syncRequestBurst limit the inv, data and
+	// getshares requests a single peer may make. A full catch-up of a
+	// default chain (two 8640-share PPLNS windows) takes about 175 data
+	// requests of maxDataReqHashes shares plus a few inv requests, so the
+	// burst covers one in full and the rate refills it within minutes.
+	syncRequestRate  = rate.Limit(1)
+	syncRequestBurst = 250
+
+	// maxSyncLimiters bounds the per-peer limiter map; the least recently
+	// used limiter is evicted first.
+	maxSyncLimiters = 500
+
+	// syncLimitWarnInterval spaces out warnings about one rate-limited peer.
+	syncLimitWarnInterval = time.Minute
 )
 
+// ErrSyncStalled is returned by SyncAll when a peer keeps answering with
+// hashes it has already sent.
+var ErrSyncStalled = errors.New("sync stalled: peer repeated a batch")
+
+// ErrResponseTooLarge is returned when a peer answers a sync request with
+// more entries than were asked for.
+var ErrResponseTooLarge = errors.New("peer response larger than requested")
+
 // InvHandler handles inventory requests (locators → hash list).
 type InvHandler func(req *InvReq) *InvResp
 
 // DataHandler handles data requests (hashes → full shares).
 type DataHandler func(req *DataReq) *DataResp
 
+// ApplyFunc applies a batch of downloaded shares, oldest first.
+type ApplyFunc func(shares []ShareMsg) error
+
 // Syncer handles initial sharechain synchronization using inv-based protocol.
 type Syncer struct {
-	host        host.Host
-	logger      *zap.Logger
-	invHandler  InvHandler
-	dataHandler DataHandler
+	host          host.Host
+	logger        *zap.Logger
+	invHandler    InvHandler
+	dataHandler   DataHandler
+	sharesHandler SharesHandler
+
+	limiters   map[peer.ID]*syncLimiter
+	limiterSeq uint64 // last syncLimiter.used handed out
+	limitersMu sync.Mutex
+}
+
+// syncLimiter rate-limits one peer's inbound sync requests.
+type syncLimiter struct {
+	lim    *rate.Limiter
+	warned time.Time
+	used   uint64 // limiterSeq at the peer's last request
 }
 
 // NewSyncer creates a new sync handler with inv-based and data protocols.
@@ -40,20 +87,26 @@ func NewSyncer(h host.Host, invHandler InvHandler, dataHandler DataHandler, logg
 		logger:      logger,
 		invHandler:  invHandler,
 		dataHandler: dataHandler,
+		limiters:    make(map[peer.ID]*syncLimiter),
 	}
 
+	h.SetStreamHandler(protocol.ID(SyncZstdProtocolID), s.handleSyncStream)
 	h.SetStreamHandler(protocol.ID(SyncProtocolID), s.handleSyncStream)
+	h.SetStreamHandler(protocol.ID(DataZstdProtocolID), s.handleDataStream)
 	h.SetStreamHandler(protocol.ID(DataProtocolID), s.handleDataStream)
 
 	return s
 }
 
-// handleSyncStream handles incoming inv requests (sync/3.0.0).
+// handleSyncStream handles incoming inv requests (sync/3.0.0 and 3.1.0).
 func (s *Syncer) handleSyncStream(stream network.Stream) {
+	if !s.allowRequest(stream) {
+		return
+	}
 	defer stream.Close()
 	stream.SetDeadline(time.Now().Add(syncStreamTimeout))
 
-	data, err := io.ReadAll(io.LimitReader(stream, maxSyncMsgSize))
+	data, compressed, err := s.readMessage(stream)
 	if err != nil {
 		s.logger.Debug("sync read error", zap.Error(err))
 		return
@@ -76,15 +129,20 @@ func (s *Syncer) handleSyncStream(stream network.Stream) {
 		return
 	}
 
-	stream.Write(data)
+	// Only compress for peers that compressed their request; older peers
+	// can't read compressed responses.
+	s.writeMessage(stream, data, compressed)
 }
 
-// handleDataStream handles incoming data requests (data/1.0.0).
+// handleDataStream handles incoming data requests (data/1.0.0 and 1.1.0).
 func (s *Syncer) handleDataStream(stream network.Stream) {
+	if !s.allowRequest(stream) {
+		return
+	}
 	defer stream.Close()
 	stream.SetDeadline(time.Now().Add(syncStreamTimeout))
 
-	data, err := io.ReadAll(io.LimitReader(stream, maxSyncMsgSize))
+	data, compressed, err := s.readMessage(stream)
 	if err != nil {
 		s.logger.Debug("data read error", zap.Error(err))
 		return
@@ -107,12 +165,70 @@ func (s *Syncer) handleDataStream(stream network.Stream) {
 		return
 	}
 
-	stream.Write(data)
+	// Only compress for peers that compressed their request; older peers
+	// can't read compressed responses.
+	s.writeMessage(stream, data, compressed)
+}
+
+// allowRequest applies the per-peer sync request limit, resetting the
+// stream of a peer that exceeds it.
+func (s *Syncer) allowRequest(stream network.Stream) bool {
+	from := stream.Conn().RemotePeer()
+
+	s.limitersMu.Lock()
+	l := s.limiter(from)
+	allowed := l.lim.Allow()
+	warn := false
+	if !allowed && time.Since(l.warned) >= syncLimitWarnInterval {
+		l.warned = time.Now()
+		warn = true
+	}
+	s.limitersMu.Unlock()
+
+	if allowed {
+		return true
+	}
+	metrics.SyncRequestsLimited.Inc()
+	if warn {
+		s.logger.Warn("peer exceeded sync request rate",
+			zap.String("peer", from.String()),
+			zap.String("protocol", string(stream.Protocol())),
+		)
+	}
+	stream.Reset()
+	return false
+}
+
+// limiter returns from's request limiter, creating it if needed. A full map
+// evicts the least recently used limiter, so peers that are actively
+// syncing keep their budget. Must be called with s.limitersMu held.
+func (s *Syncer) limiter(from peer.ID) *syncLimiter {
+	s.limiterSeq++
+	if l, ok := s.limiters[from]; ok {
+		l.used = s.limiterSeq
+		return l
+	}
+	if len(s.limiters) >= maxSyncLimiters {
+		var oldest peer.ID
+		var oldestUsed uint64
+		for id, l := range s.limiters {
+			if oldest == "" || l.used < oldestUsed {
+				oldest, oldestUsed = id, l.used
+			}
+		}
+		delete(s.limiters, oldest)
+	}
+	l := &syncLimiter{
+		lim:  rate.NewLimiter(syncRequestRate, syncRequestBurst),
+		used: s.limiterSeq,
+	}
+	s.limiters[from] = l
+	return l
 }
 
 // RequestInventory sends an inv request to a peer and returns the hash list.
 func (s *Syncer) RequestInventory(ctx context.Context, peerID peer.ID, locators [][32]byte, maxCount int) (*InvResp, error) {
-	stream, err := s.host.NewStream(ctx, peerID, protocol.ID(SyncProtocolID))
+	stream, err := s.host.NewStream(ctx, peerID, protocol.ID(SyncZstdProtocolID), protocol.ID(SyncProtocolID))
 	if err != nil {
 		return nil, fmt.Errorf("open stream: %w", err)
 	}
@@ -129,13 +245,15 @@ func (s *Syncer) RequestInventory(ctx context.Context, peerID peer.ID, locators
 		return nil, fmt.Errorf("encode request: %w", err)
 	}
 
-	if _, err := stream.Write(data); err != nil {
+	// Older peers only speak the uncompressed protocol and can't read
+	// compressed requests.
+	if err := s.writeMessage(stream, data, isZstdProtocol(stream.Protocol())); err != nil {
 		return nil, fmt.Errorf("write request: %w", err)
 	}
 
 	stream.CloseWrite()
 
-	data, err = io.ReadAll(io.LimitReader(stream, maxSyncMsgSize))
+	data, _, err = s.readMessage(stream)
 	if err != nil {
 		return nil, fmt.Errorf("read response: %w", err)
 	}
@@ -144,13 +262,16 @@ func (s *Syncer) RequestInventory(ctx context.Context, peerID peer.ID, locators
 	if err != nil {
 		return nil, fmt.Errorf("decode response: %w", err)
 	}
+	if len(resp.Hashes) > maxCount {
+		return nil, fmt.Errorf("%w: %d hashes, requested at most %d", ErrResponseTooLarge, len(resp.Hashes), maxCount)
+	}
 
 	return resp, nil
 }
 
 // RequestData sends a data request to a peer and returns full share data.
 func (s *Syncer) RequestData(ctx context.Context, peerID peer.ID, hashes [][32]byte) (*DataResp, error) {
-	stream, err := s.host.NewStream(ctx, peerID, protocol.ID(DataProtocolID))
+	stream, err := s.host.NewStream(ctx, peerID, protocol.ID(DataZstdProtocolID), protocol.ID(DataProtocolID))
 	if err != nil {
 		return nil, fmt.Errorf("open stream: %w", err)
 	}
@@ -166,13 +287,15 @@ func (s *Syncer) RequestData(ctx context.Context, peerID peer.ID, hashes [][32]b
 		return nil, fmt.Errorf("encode request: %w", err)
 	}
 
-	if _, err := stream.Write(data); err != nil {
+	// Older peers only speak the uncompressed protocol and can't read
+	// compressed requests.
+	if err := s.writeMessage(stream, data, isZstdProtocol(stream.Protocol())); err != nil {
 		return nil, fmt.Errorf("write request: %w", err)
 	}
 
 	stream.CloseWrite()
 
-	data, err = io.ReadAll(io.LimitReader(stream, maxSyncMsgSize))
+	data, _, err = s.readMessage(stream)
 	if err != nil {
 		return nil, fmt.Errorf("read response: %w", err)
 	}
@@ -181,6 +304,105 @@ func (s *Syncer) RequestData(ctx context.Context, peerID peer.ID, hashes [][32]b
 	if err != nil {
 		return nil, fmt.Errorf("decode response: %w", err)
 	}
+	if len(resp.Shares) > len(hashes) {
+		return nil, fmt.Errorf("%w: %d shares, requested %d", ErrResponseTooLarge, len(resp.Shares), len(hashes))
+	}
 
 	return resp, nil
 }
+
+// SyncAll downloads a peer's chain past the given locators in batches,
+// handing each batch of shares to apply as it arrives. While the peer
+// reports More, the last hash received is prepended to the locators as
+// the new tip so the next request continues where this one stopped.
+// Returns the number of shares fetched.
+func (s *Syncer) SyncAll(ctx context.Context, peerID peer.ID, locators [][32]byte, batchSize int, apply ApplyFunc) (int, error) {
+	if len(locators) > maxLocatorCount-1 {
+		locators = locators[:maxLocatorCount-1]
+	}
+
+	seen := make(map[[32]byte]bool)
+	reqLocators := locators
+	fetched := 0
+
+	for fetched < maxSyncSessionShares {
+		inv, err := s.RequestInventory(ctx, peerID, reqLocators, batchSize)
+		if err != nil {
+			return fetched, err
+		}
+		if len(inv.Hashes) == 0 {
+			return fetched, nil
+		}
+
+		var fresh [][32]byte
+		for _, h := range inv.Hashes {
+			if !seen[h] {
+				seen[h] = true
+				fresh = append(fresh, h)
+			}
+		}
+		if len(fresh) == 0 {
+			return fetched, ErrSyncStalled
+		}
+		if remaining := maxSyncSessionShares - fetched; len(fresh) > remaining {
+			fresh = fresh[:remaining]
+		}
+
+		for start := 0; start < len(fresh); start += maxDataReqHashes {
+			end := min(start+maxDataReqHashes, len(fresh))
+			data, err := s.RequestData(ctx, peerID, fresh[start:end])
+			if err != nil {
+				return fetched, err
+			}
+			fetched += len(data.Shares)
+			if err := apply(data.Shares); err != nil {
+				return fetched, err
+			}
+		}
+
+		if !inv.More {
+			return fetched, nil
+		}
+
+		last := inv.Hashes[len(inv.Hashes)-1]
+		reqLocators = append([][32]byte{last}, locators...)
+	}
+
+	s.logger.Debug("sync session share cap reached",
+		zap.String("peer", peerID.String()),
+		zap.Int("fetched", fetched),
+	)
+	return fetched, nil
+}
+
+// isZstdProtocol reports whether messages on protocol p are compressed.
+func isZstdProtocol(p protocol.ID) bool {
+	return p == SyncZstdProtocolID || p == DataZstdProtocolID
+}
+
+// writeMessage writes an encoded message to a stream, zstd-compressing it
+// first if requested.
+func (s *Syncer) writeMessage(stream network.Stream, data []byte, compress bool) error {
+	if compress {
+		raw := len(data)
+		data = compressMessage(data)
+		s.logger.Debug("compressed sync message",
+			zap.String("protocol", string(stream.Protocol())),
+			zap.Int("raw_bytes", raw),
+			zap.Int("sent_bytes", len(data)),
+			zap.Int("saved_bytes", raw-len(data)),
+		)
+	}
+	_, err := stream.Write(data)
+	return err
+}
+
+// readMessage reads a message from a stream, decompressing it if the peer
+// sent it zstd-compressed. The bool reports whether it was compressed.
+func (s *Syncer) readMessage(stream network.Stream) ([]byte, bool, error) {
+	data, err := io.ReadAll(io.LimitReader(stream, maxSyncMsgSize))
+	if err != nil {
+		return nil, false, err
+	}
+	return decompressMessage(data)
+}
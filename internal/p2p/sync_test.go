@@ -2,12 +2,18 @@ package p2p
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 
 	"go.uber.org/zap"
 )
@@ -187,6 +193,38 @@ func TestDataProtocol_RoundTrip(t *testing.T) {
 	}
 }
 
+func TestSync_RejectsOversizedResponses(t *testing.T) {
+	logger := zap.NewNop()
+
+	hostA := newTestHost(t)
+	hostB := newTestHost(t)
+
+	// Host A answers every request with three entries whatever was asked.
+	NewSyncer(hostA, func(req *InvReq) *InvResp {
+		return &InvResp{Type: MsgTypeInvResp, Hashes: [][32]byte{{1}, {2}, {3}}}
+	}, func(req *DataReq) *DataResp {
+		return &DataResp{Type: MsgTypeDataResp, Shares: make([]ShareMsg, 3)}
+	}, logger)
+
+	noopInv := func(req *InvReq) *InvResp { return &InvResp{Type: MsgTypeInvResp} }
+	syncerB := NewSyncer(hostB, noopInv, noopDataHandler, logger)
+
+	connectHosts(t, hostA, hostB)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := syncerB.RequestInventory(ctx, hostA.ID(), nil, 2); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("RequestInventory error = %v, want ErrResponseTooLarge", err)
+	}
+	if _, err := syncerB.RequestData(ctx, hostA.ID(), [][32]byte{{1}}); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("RequestData error = %v, want ErrResponseTooLarge", err)
+	}
+	if _, err := syncerB.RequestInventory(ctx, hostA.ID(), nil, 3); err != nil {
+		t.Errorf("RequestInventory within the limit: %v", err)
+	}
+}
+
 func TestInvProtocol_LocatorForkPoint(t *testing.T) {
 	logger := zap.NewNop()
 
@@ -414,3 +452,284 @@ func TestInvProtocol_LocatorRejected(t *testing.T) {
 		t.Fatal("expected error for oversized locator count, got nil")
 	}
 }
+
+func TestInvProtocol_UncompressedPeer(t *testing.T) {
+	logger := zap.NewNop()
+
+	hostA := newTestHost(t)
+	hostB := newTestHost(t)
+
+	hashC := [32]byte{0x0c}
+	NewSyncer(hostA, func(req *InvReq) *InvResp {
+		return &InvResp{Type: MsgTypeInvResp, Hashes: [][32]byte{hashC}}
+	}, noopDataHandler, logger)
+
+	connectHosts(t, hostA, hostB)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Speak the protocol the way an older peer would: plain CBOR both ways.
+	stream, err := hostB.NewStream(ctx, hostA.ID(), protocol.ID(SyncProtocolID))
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer stream.Close()
+
+	data, err := Encode(&InvReq{Type: MsgTypeInvReq, MaxCount: 10})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	stream.Write(data)
+	stream.CloseWrite()
+
+	data, err = io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if isZstd(data) {
+		t.Fatal("response to uncompressed request should not be compressed")
+	}
+	resp, err := DecodeInvResp(data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Hashes) != 1 || resp.Hashes[0] != hashC {
+		t.Errorf("unexpected hashes %x", resp.Hashes)
+	}
+}
+
+func TestSyncAll_ResumesWithContinuationLocator(t *testing.T) {
+	logger := zap.NewNop()
+
+	hostA := newTestHost(t)
+	hostB := newTestHost(t)
+
+	chain := [][32]byte{{0x01}, {0x02}, {0x03}, {0x04}, {0x05}}
+
+	// Host A serves the chain after the first locator it recognizes.
+	NewSyncer(hostA, func(req *InvReq) *InvResp {
+		start := 0
+	locate:
+		for _, loc := range req.Locators {
+			for i, h := range chain {
+				if h == loc {
+					start = i + 1
+					break locate
+				}
+			}
+		}
+		end := min(start+req.MaxCount, len(chain))
+		return &InvResp{
+			Type:   MsgTypeInvResp,
+			Hashes: chain[start:end],
+			More:   end < len(chain),
+		}
+	}, func(req *DataReq) *DataResp {
+		var shares []ShareMsg
+		for _, h := range req.Hashes {
+			shares = append(shares, ShareMsg{Type: MsgTypeShare, Nonce: uint32(h[0])})
+		}
+		return &DataResp{Type: MsgTypeDataResp, Shares: shares}
+	}, logger)
+
+	syncerB := NewSyncer(hostB, nil, noopDataHandler, logger)
+	connectHosts(t, hostA, hostB)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var nonces []uint32
+	fetched, err := syncerB.SyncAll(ctx, hostA.ID(), nil, 2, func(shares []ShareMsg) error {
+		for _, s := range shares {
+			nonces = append(nonces, s.Nonce)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+	if fetched != len(chain) {
+		t.Fatalf("fetched %d shares, want %d", fetched, len(chain))
+	}
+	for i, n := range nonces {
+		if n != uint32(i+1) {
+			t.Errorf("share %d nonce = %d, want %d", i, n, i+1)
+		}
+	}
+}
+
+func TestSyncAll_StopsOnRepeatedBatch(t *testing.T) {
+	logger := zap.NewNop()
+
+	hostA := newTestHost(t)
+	hostB := newTestHost(t)
+
+	// Host A ignores locators and always claims there is more.
+	requests := 0
+	NewSyncer(hostA, func(req *InvReq) *InvResp {
+		requests++
+		return &InvResp{
+			Type:   MsgTypeInvResp,
+			Hashes: [][32]byte{{0x01}, {0x02}},
+			More:   true,
+		}
+	}, noopDataHandler, logger)
+
+	syncerB := NewSyncer(hostB, nil, noopDataHandler, logger)
+	connectHosts(t, hostA, hostB)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := syncerB.SyncAll(ctx, hostA.ID(), nil, 2, func([]ShareMsg) error { return nil })
+	if !errors.Is(err, ErrSyncStalled) {
+		t.Fatalf("SyncAll error = %v, want ErrSyncStalled", err)
+	}
+	if requests != 2 {
+		t.Errorf("peer received %d inv requests, want 2", requests)
+	}
+}
+
+func TestGetSharesProtocol_RoundTrip(t *testing.T) {
+	logger := zap.NewNop()
+
+	hostA := newTestHost(t)
+	hostB := newTestHost(t)
+
+	start := [32]byte{0x03}
+	syncerA := NewSyncer(hostA, nil, noopDataHandler, logger)
+	syncerA.HandleShareRequests(func(req *ShareRequest) *ShareResponse {
+		if req.StartHash != start {
+			return nil
+		}
+		var shares []ShareMsg
+		for i := 0; i < req.Count; i++ {
+			shares = append(shares, ShareMsg{Type: MsgTypeShare, Nonce: uint32(3 - i)})
+		}
+		return &ShareResponse{Type: MsgTypeShareResp, Shares: shares}
+	})
+
+	syncerB := NewSyncer(hostB, nil, noopDataHandler, logger)
+	connectHosts(t, hostA, hostB)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := syncerB.RequestShares(ctx, hostA.ID(), start, 2)
+	if err != nil {
+		t.Fatalf("RequestShares: %v", err)
+	}
+	if len(resp.Shares) != 2 {
+		t.Fatalf("expected 2 shares, got %d", len(resp.Shares))
+	}
+	if resp.Shares[0].Nonce != 3 || resp.Shares[1].Nonce != 2 {
+		t.Errorf("unexpected share order: %d, %d", resp.Shares[0].Nonce, resp.Shares[1].Nonce)
+	}
+
+	// Oversized requests are dropped by the server.
+	if _, err := syncerB.RequestShares(ctx, hostA.ID(), start, maxShareRequestCount+1); err == nil {
+		t.Error("expected error for oversized request")
+	}
+}
+
+func TestSync_RateLimitsPeer(t *testing.T) {
+	logger := zap.NewNop()
+
+	hostA := newTestHost(t)
+	hostB := newTestHost(t)
+	hostC := newTestHost(t)
+
+	var served atomic.Int32
+	NewSyncer(hostA, func(req *InvReq) *InvResp {
+		served.Add(1)
+		return &InvResp{Type: MsgTypeInvResp}
+	}, noopDataHandler, logger)
+	syncerB := NewSyncer(hostB, nil, noopDataHandler, logger)
+	syncerC := NewSyncer(hostC, nil, noopDataHandler, logger)
+
+	connectHosts(t, hostA, hostB)
+	connectHosts(t, hostA, hostC)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var refused int
+	for i := 0; i < syncRequestBurst+10; i++ {
+		if _, err := syncerB.RequestInventory(ctx, hostA.ID(), nil, 10); err != nil {
+			refused++
+		}
+	}
+	if refused == 0 {
+		t.Fatal("no requests refused past the burst")
+	}
+	if n := served.Load(); n > syncRequestBurst+1 {
+		t.Errorf("served %d requests, want at most %d", n, syncRequestBurst+1)
+	}
+
+	// Other peers have their own budget.
+	if _, err := syncerC.RequestInventory(ctx, hostA.ID(), nil, 10); err != nil {
+		t.Errorf("request from a different peer refused: %v", err)
+	}
+}
+
+func TestInvProtocol_RequestToUncompressedPeer(t *testing.T) {
+	logger := zap.NewNop()
+
+	hostA := newTestHost(t)
+	hostB := newTestHost(t)
+
+	// hostA is an older peer that only speaks sync/3.0.0 in plain CBOR.
+	hashC := [32]byte{0x0c}
+	gotCompressed := make(chan bool, 1)
+	hostA.SetStreamHandler(protocol.ID(SyncProtocolID), func(stream network.Stream) {
+		defer stream.Close()
+		data, err := io.ReadAll(stream)
+		if err != nil {
+			return
+		}
+		gotCompressed <- isZstd(data)
+		resp, _ := Encode(&InvResp{Type: MsgTypeInvResp, Hashes: [][32]byte{hashC}})
+		stream.Write(resp)
+	})
+	syncerB := NewSyncer(hostB, func(req *InvReq) *InvResp {
+		return &InvResp{Type: MsgTypeInvResp}
+	}, noopDataHandler, logger)
+
+	connectHosts(t, hostA, hostB)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := syncerB.RequestInventory(ctx, hostA.ID(), nil, 10)
+	if err != nil {
+		t.Fatalf("RequestInventory: %v", err)
+	}
+	if <-gotCompressed {
+		t.Error("request to an older peer should not be compressed")
+	}
+	if len(resp.Hashes) != 1 || resp.Hashes[0] != hashC {
+		t.Errorf("unexpected hashes %x", resp.Hashes)
+	}
+}
+
+func TestSyncer_LimiterEvictsLeastRecentlyUsed(t *testing.T) {
+	s := &Syncer{limiters: make(map[peer.ID]*syncLimiter)}
+	for i := 0; i < maxSyncLimiters; i++ {
+		s.limiter(peer.ID(fmt.Sprint("peer", i)))
+	}
+	// peer0 keeps syncing; peer1 is now the least recently used.
+	busy := s.limiter("peer0")
+	busy.lim.Allow()
+
+	s.limiter("newcomer")
+	if len(s.limiters) != maxSyncLimiters {
+		t.Fatalf("limiters = %d, want %d", len(s.limiters), maxSyncLimiters)
+	}
+	if s.limiters["peer0"] != busy {
+		t.Error("recently used limiter evicted")
+	}
+	if _, ok := s.limiters["peer1"]; ok {
+		t.Error("least recently used limiter kept")
+	}
+}
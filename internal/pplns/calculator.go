@@ -11,6 +11,7 @@ import (
 type Calculator struct {
 	finderFeePercent  float64
 	dustThresholdSats int64
+	ledger            *Ledger
 }
 
 // NewCalculator creates a new PPLNS calculator.
@@ -21,6 +22,13 @@ func NewCalculator(finderFeePercent float64, dustThresholdSats int64) *Calculato
 	}
 }
 
+// SetLedger makes the calculator carry below-dust payouts forward in ledger
+// rather than consolidating them, whenever a finder is given. It must be
+// called before payouts are calculated.
+func (c *Calculator) SetLedger(ledger *Ledger) {
+	c.ledger = ledger
+}
+
 // CalculatePayouts computes payout amounts for each miner in the PPLNS window.
 // totalReward is the total coinbase value (block subsidy + fees) in satoshis.
 // finderAddress is the miner who found the block (receives the finder fee).
@@ -42,33 +50,7 @@ func (c *Calculator) CalculatePayouts(window *Window, totalReward int64, finderA
 	}
 
 	// Calculate per-miner payouts proportional to weight
-	payouts := make(map[string]int64)
-	var distributed int64
-
-	// Sort addresses for deterministic output
-	addresses := make([]string, 0, len(minerWeights))
-	for addr := range minerWeights {
-		addresses = append(addresses, addr)
-	}
-	sort.Strings(addresses)
-
-	for _, addr := range addresses {
-		weight := minerWeights[addr]
-		// payout = distributableReward * weight / totalWeight
-		payout := new(big.Int).Mul(big.NewInt(distributableReward), weight)
-		payout.Div(payout, totalWeight)
-
-		if !payout.IsInt64() {
-			// Shouldn't happen (payout <= distributableReward), but clamp for safety.
-			payout.SetInt64(distributableReward)
-		}
-		amount := payout.Int64()
-
-		if amount > 0 {
-			payouts[addr] = amount
-			distributed += amount
-		}
-	}
+	payouts, addresses, distributed := splitByWeight(minerWeights, totalWeight, distributableReward)
 
 	// Add finder fee to finder's payout
 	if finderAddress != "" && finderFee > 0 {
@@ -86,6 +68,11 @@ func (c *Calculator) CalculatePayouts(window *Window, totalReward int64, finderA
 		}
 	}
 
+	if c.ledger != nil && finderAddress != "" {
+		c.ledger.settle(payouts, finderAddress, c.dustThresholdSats)
+		return sortedPayouts(payouts)
+	}
+
 	// Consolidate dust outputs: payouts below dust threshold get redistributed
 	var dustTotal int64
 	var dustAddresses []string
@@ -117,16 +104,22 @@ func (c *Calculator) CalculatePayouts(window *Window, totalReward int64, finderA
 		}
 	}
 
-	// Build sorted result
+	return sortedPayouts(payouts)
+}
+
+// sortedPayouts returns the non-zero payouts sorted by amount descending,
+// then address for determinism.
+func sortedPayouts(payouts map[string]int64) []types.PayoutEntry {
 	result := make([]types.PayoutEntry, 0, len(payouts))
 	for addr, amount := range payouts {
-		result = append(result, types.PayoutEntry{
-			Address: addr,
-			Amount:  amount,
-		})
+		if amount > 0 {
+			result = append(result, types.PayoutEntry{
+				Address: addr,
+				Amount:  amount,
+			})
+		}
 	}
 
-	// Sort by amount descending, then address for determinism
 	sort.Slice(result, func(i, j int) bool {
 		if result[i].Amount != result[j].Amount {
 			return result[i].Amount > result[j].Amount
@@ -136,3 +129,56 @@ func (c *Calculator) CalculatePayouts(window *Window, totalReward int64, finderA
 
 	return result
 }
+
+// EstimatePayouts returns what each miner in the window would receive if a
+// block paying subsidy were found now. It uses the same proportional split as
+// CalculatePayouts but designates no finder, so no finder fee or dust
+// consolidation is applied. Returns nil for an empty window.
+func (c *Calculator) EstimatePayouts(window *Window, subsidy int64) map[string]int64 {
+	if window == nil || window.ShareCount() == 0 || subsidy <= 0 {
+		return nil
+	}
+
+	totalWeight := window.TotalWeight()
+	if totalWeight.Sign() == 0 {
+		return nil
+	}
+
+	payouts, _, _ := splitByWeight(window.MinerWeights(), totalWeight, subsidy)
+	return payouts
+}
+
+// splitByWeight divides reward among miners proportionally to their weight.
+// It returns the per-address amounts, the sorted list of all addresses, and
+// the total amount distributed (which may be less than reward due to rounding).
+func splitByWeight(minerWeights map[string]*big.Int, totalWeight *big.Int, reward int64) (map[string]int64, []string, int64) {
+	payouts := make(map[string]int64)
+	var distributed int64
+
+	// Sort addresses for deterministic output
+	addresses := make([]string, 0, len(minerWeights))
+	for addr := range minerWeights {
+		addresses = append(addresses, addr)
+	}
+	sort.Strings(addresses)
+
+	for _, addr := range addresses {
+		weight := minerWeights[addr]
+		// payout = reward * weight / totalWeight
+		payout := new(big.Int).Mul(big.NewInt(reward), weight)
+		payout.Div(payout, totalWeight)
+
+		if !payout.IsInt64() {
+			// Shouldn't happen (payout <= reward), but clamp for safety.
+			payout.SetInt64(reward)
+		}
+		amount := payout.Int64()
+
+		if amount > 0 {
+			payouts[addr] = amount
+			distributed += amount
+		}
+	}
+
+	return payouts, addresses, distributed
+}
@@ -178,3 +178,144 @@ func TestWindow_MinerWeights(t *testing.T) {
 		t.Errorf("miner1 weight=%s, miner2 weight=%s, expected equal", w1, w2)
 	}
 }
+
+func TestWindowByWork(t *testing.T) {
+	maxTarget := easyTarget()
+	halfTarget := new(big.Int).Div(maxTarget, big.NewInt(2))
+
+	shares := []*types.Share{
+		makeShare("miner1", halfTarget), // weight 2
+		makeShare("miner2", maxTarget),  // weight 1
+		makeShare("miner1", halfTarget), // weight 2
+		makeShare("miner2", maxTarget),  // weight 1
+	}
+
+	window, work := WindowByWork(shares, maxTarget, big.NewInt(3))
+	if window.ShareCount() != 2 {
+		t.Errorf("share count = %d, want 2", window.ShareCount())
+	}
+	if work.Cmp(big.NewInt(3)) != 0 {
+		t.Errorf("work = %s, want 3", work)
+	}
+
+	// Chain shorter than the target: everything is included.
+	window, work = WindowByWork(shares, maxTarget, big.NewInt(100))
+	if window.ShareCount() != len(shares) {
+		t.Errorf("share count = %d, want %d", window.ShareCount(), len(shares))
+	}
+	if work.Cmp(big.NewInt(6)) != 0 {
+		t.Errorf("work = %s, want 6", work)
+	}
+}
+
+func TestEstimatePayouts(t *testing.T) {
+	maxTarget := easyTarget()
+	halfTarget := new(big.Int).Div(maxTarget, big.NewInt(2))
+
+	shares := []*types.Share{
+		makeShare("miner1", maxTarget),  // weight 1
+		makeShare("miner2", halfTarget), // weight 2
+		makeShare("miner2", maxTarget),  // weight 1
+	}
+
+	calc := NewCalculator(0.5, 546)
+	est := calc.EstimatePayouts(NewWindow(shares, maxTarget), 4000000)
+
+	if est["miner1"] != 1000000 {
+		t.Errorf("miner1 estimate = %d, want 1000000", est["miner1"])
+	}
+	if est["miner2"] != 3000000 {
+		t.Errorf("miner2 estimate = %d, want 3000000", est["miner2"])
+	}
+
+	if got := calc.EstimatePayouts(NewWindow(nil, maxTarget), 4000000); got != nil {
+		t.Errorf("empty window estimate = %v, want nil", got)
+	}
+}
+
+func TestWindow_UncleWeight(t *testing.T) {
+	maxTarget := easyTarget()
+	quarterTarget := new(big.Int).Div(maxTarget, big.NewInt(4))
+
+	shares := []*types.Share{
+		makeShare("miner1", quarterTarget), // weight 4
+		makeShare("miner1", quarterTarget), // weight 4
+	}
+	uncles := []*types.Share{
+		makeShare("miner2", quarterTarget), // weight 4, credited at 50%
+	}
+
+	window := NewWindowWithUncles(shares, uncles, maxTarget)
+	weights := window.MinerWeights()
+	if weights["miner2"].Int64() != 2 {
+		t.Errorf("uncle weight = %s, want 2", weights["miner2"])
+	}
+	if window.TotalWeight().Int64() != 10 {
+		t.Errorf("total weight = %s, want 10", window.TotalWeight())
+	}
+
+	calc := NewCalculator(0, 0)
+	est := calc.EstimatePayouts(window, 1000000)
+	if est["miner1"] != 800000 || est["miner2"] != 200000 {
+		t.Errorf("estimates = %v, want miner1=800000 miner2=200000", est)
+	}
+}
+
+func TestWindow_Entries(t *testing.T) {
+	maxTarget := easyTarget()
+	quarterTarget := new(big.Int).Div(maxTarget, big.NewInt(4))
+
+	shares := []*types.Share{makeShare("miner1", quarterTarget), makeShare("miner1", maxTarget)}
+	shares[0].Header.Timestamp = 1700000030
+	shares[1].Header.Timestamp = 1700000000
+	uncles := []*types.Share{makeShare("miner2", quarterTarget)}
+
+	window := NewWindowWithUncles(shares, uncles, maxTarget)
+	entries := window.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("len(entries) = %d, want 3", len(entries))
+	}
+	want := []struct {
+		hash   [32]byte
+		miner  string
+		ts     uint32
+		weight int64
+		uncle  bool
+	}{
+		{shares[0].Hash(), "miner1", 1700000030, 4, false},
+		{shares[1].Hash(), "miner1", 1700000000, 1, false},
+		{uncles[0].Hash(), "miner2", 0, 2, true},
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.Hash != w.hash || e.Miner != w.miner || e.Timestamp != w.ts || e.Weight.Int64() != w.weight || e.Uncle != w.uncle {
+			t.Errorf("entry %d = %+v, want %+v", i, e, w)
+		}
+	}
+
+	// Entries are a snapshot: modifying them leaves the window alone.
+	entries[0].Weight.SetInt64(1000)
+	if window.TotalWeight().Int64() != 7 {
+		t.Errorf("total weight = %s after modifying entries, want 7", window.TotalWeight())
+	}
+}
+
+// BenchmarkWindow_Weights measures a payout-estimation pass over a full
+// window: MinerWeights plus TotalWeight, as CalculatePayouts does.
+func BenchmarkWindow_Weights(b *testing.B) {
+	maxTarget := DefaultMaxTarget()
+	miners := []string{"miner1", "miner2", "miner3", "miner4", "miner5"}
+	shares := make([]*types.Share, 8640)
+	for i := range shares {
+		target := new(big.Int).Div(maxTarget, big.NewInt(int64(1000+i)))
+		shares[i] = makeShare(miners[i%len(miners)], target)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := NewWindow(shares, maxTarget)
+		w.MinerWeights()
+		w.TotalWeight()
+	}
+}
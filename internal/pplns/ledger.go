@@ -0,0 +1,247 @@
+package pplns
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+// maxPendingSettlements bounds how many uncommitted settlements a Ledger
+// remembers. Jobs are rebuilt far less often than this between blocks.
+const maxPendingSettlements = 256
+
+// BalanceStore persists the balances a Ledger carries between blocks.
+type BalanceStore interface {
+	LoadBalances() (map[string]int64, error)
+	// SaveBalances replaces the stored balances.
+	SaveBalances(balances map[string]int64) error
+}
+
+// Ledger carries below-dust payouts forward across blocks instead of
+// consolidating them into the finder's output. A miner whose payout is
+// below the dust threshold is owed it: the finder is paid the amount now,
+// and once the miner's accumulated balance reaches the threshold it is paid
+// out of the finder's output of a later block. Every coinbase still pays out
+// exactly its reward, and what the finder holds back always equals what
+// the ledger owes.
+//
+// Settlements only take effect through Commit, once a block paying them is
+// accepted, so templates may be built and discarded freely. A committed
+// block is undone with Revert if it is orphaned, and forgotten with Confirm
+// once it is buried.
+type Ledger struct {
+	mu       sync.Mutex
+	store    BalanceStore
+	balances map[string]int64
+
+	// pending maps settlementKey of each settled payout set to the change
+	// committing it makes; order is their insertion order.
+	pending map[string]*settlement
+	order   []string
+
+	// committed holds the change each committed, unconfirmed block made,
+	// by block hash.
+	committed map[string]map[string]int64
+}
+
+// settlement is a payout set settled against a set of base balances.
+type settlement struct {
+	payouts string
+	delta   map[string]int64
+}
+
+// NewLedger creates a ledger, loading carried balances from store. A nil
+// store keeps balances in memory only.
+func NewLedger(store BalanceStore) (*Ledger, error) {
+	balances := make(map[string]int64)
+	if store != nil {
+		loaded, err := store.LoadBalances()
+		if err != nil {
+			return nil, fmt.Errorf("load balances: %w", err)
+		}
+		maps.Copy(balances, loaded)
+	}
+	return &Ledger{
+		store:     store,
+		balances:  balances,
+		pending:   make(map[string]*settlement),
+		committed: make(map[string]map[string]int64),
+	}, nil
+}
+
+// Balances returns a copy of the balances currently carried.
+func (l *Ledger) Balances() map[string]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return maps.Clone(l.balances)
+}
+
+// settle adjusts payouts in place against the carried balances and
+// remembers the change to them for Commit. The total paid is unchanged:
+// amounts only move between miners and finder.
+func (l *Ledger) settle(payouts map[string]int64, finder string, dustThresholdSats int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	next := maps.Clone(l.balances)
+	addresses := make([]string, 0, len(payouts))
+	for addr := range payouts {
+		if addr != finder {
+			addresses = append(addresses, addr)
+		}
+	}
+	slices.Sort(addresses)
+
+	// Hold back dust first, so the finder can afford the carried balances
+	// paid below.
+	var owed []string
+	for _, addr := range addresses {
+		amount := payouts[addr]
+		if next[addr]+amount < dustThresholdSats {
+			next[addr] += amount
+			payouts[finder] += amount
+			delete(payouts, addr)
+		} else if next[addr] > 0 {
+			owed = append(owed, addr)
+		}
+	}
+
+	// Pay carried balances that now reach the threshold, as long as the
+	// finder's own output stays above dust. Otherwise they wait for a
+	// later block.
+	for _, addr := range owed {
+		carried := next[addr]
+		if payouts[finder]-carried >= dustThresholdSats {
+			payouts[addr] += carried
+			payouts[finder] -= carried
+			delete(next, addr)
+			continue
+		}
+		if payouts[addr] < dustThresholdSats {
+			next[addr] += payouts[addr]
+			payouts[finder] += payouts[addr]
+			delete(payouts, addr)
+		}
+	}
+
+	delta := make(map[string]int64)
+	for addr := range next {
+		if d := next[addr] - l.balances[addr]; d != 0 {
+			delta[addr] = d
+		}
+	}
+	for addr, amount := range l.balances {
+		if _, ok := next[addr]; !ok {
+			delta[addr] = -amount
+		}
+	}
+
+	payoutsID := payoutsKey(payouts)
+	key := payoutsKey(l.balances) + "|" + payoutsID
+	if _, ok := l.pending[key]; !ok {
+		if len(l.order) >= maxPendingSettlements {
+			delete(l.pending, l.order[0])
+			l.order = l.order[1:]
+		}
+		l.order = append(l.order, key)
+	}
+	l.pending[key] = &settlement{payouts: payoutsID, delta: delta}
+}
+
+// Commit records that block, paying payouts as returned by a Calculator
+// using this ledger, was accepted, and persists the balances it leaves. The
+// settlement made against the current balances is preferred; if other
+// blocks were committed since the block's template was built, the change
+// it was settled with is applied to the current balances instead.
+func (l *Ledger) Commit(block string, payouts []types.PayoutEntry) error {
+	amounts := make(map[string]int64, len(payouts))
+	for _, p := range payouts {
+		amounts[p.Address] += p.Amount
+	}
+	payoutsID := payoutsKey(amounts)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.committed[block]; ok {
+		return fmt.Errorf("block %s already committed", block)
+	}
+	s, ok := l.pending[payoutsKey(l.balances)+"|"+payoutsID]
+	if !ok {
+		for i := len(l.order) - 1; i >= 0; i-- {
+			if p := l.pending[l.order[i]]; p.payouts == payoutsID {
+				s, ok = p, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return fmt.Errorf("no settlement matches the block's payouts")
+	}
+	if err := l.apply(s.delta, 1); err != nil {
+		return err
+	}
+	l.committed[block] = s.delta
+	return nil
+}
+
+// Revert undoes a committed block that was orphaned: dust it held back is
+// no longer owed, and balances it paid out are owed again.
+func (l *Ledger) Revert(block string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delta, ok := l.committed[block]
+	if !ok {
+		return fmt.Errorf("block %s not committed", block)
+	}
+	if err := l.apply(delta, -1); err != nil {
+		return err
+	}
+	delete(l.committed, block)
+	return nil
+}
+
+// Confirm forgets a committed block once it can no longer be orphaned.
+func (l *Ledger) Confirm(block string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.committed, block)
+}
+
+// apply adds sign times delta to the balances and persists them. A balance
+// never goes below zero: if two blocks both paid a carried balance, the
+// second payment came out of its finder's output. Must be called with l.mu
+// held.
+func (l *Ledger) apply(delta map[string]int64, sign int64) error {
+	next := maps.Clone(l.balances)
+	for addr, d := range delta {
+		next[addr] += sign * d
+		if next[addr] <= 0 {
+			delete(next, addr)
+		}
+	}
+	if l.store != nil {
+		if err := l.store.SaveBalances(next); err != nil {
+			return fmt.Errorf("save balances: %w", err)
+		}
+	}
+	l.balances = next
+	return nil
+}
+
+// payoutsKey identifies a set of payouts independent of their order.
+func payoutsKey(payouts map[string]int64) string {
+	addresses := slices.Sorted(maps.Keys(payouts))
+	var sb strings.Builder
+	for _, addr := range addresses {
+		if payouts[addr] > 0 {
+			fmt.Fprintf(&sb, "%s:%d;", addr, payouts[addr])
+		}
+	}
+	return sb.String()
+}
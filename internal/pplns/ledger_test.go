@@ -0,0 +1,168 @@
+package pplns
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+type memBalances struct {
+	saved map[string]int64
+}
+
+func (m *memBalances) LoadBalances() (map[string]int64, error) {
+	return maps.Clone(m.saved), nil
+}
+
+func (m *memBalances) SaveBalances(balances map[string]int64) error {
+	m.saved = maps.Clone(balances)
+	return nil
+}
+
+func TestLedger_CarriesDustUntilThreshold(t *testing.T) {
+	maxTarget := easyTarget()
+	// tiny earns 100 sats a block, below the 546 sat threshold.
+	shares := []*types.Share{makeShare("tiny", maxTarget)}
+	for i := 0; i < 499; i++ {
+		shares = append(shares, makeShare("big", maxTarget))
+	}
+	for i := 0; i < 500; i++ {
+		shares = append(shares, makeShare("node", maxTarget))
+	}
+	window := NewWindow(shares, maxTarget)
+	const reward = 100000
+
+	store := &memBalances{}
+	ledger, err := NewLedger(store)
+	if err != nil {
+		t.Fatalf("NewLedger: %v", err)
+	}
+	calc := NewCalculator(0, 546)
+	calc.SetLedger(ledger)
+
+	var paidTiny int64
+	for block := 1; block <= 6; block++ {
+		payouts := calc.CalculatePayouts(window, reward, "node")
+		var total int64
+		for _, p := range payouts {
+			total += p.Amount
+			if p.Address == "tiny" {
+				paidTiny += p.Amount
+			}
+		}
+		if total != reward {
+			t.Fatalf("block %d: payouts total %d, want %d", block, total, reward)
+		}
+		if err := ledger.Commit(fmt.Sprint("block", block), payouts); err != nil {
+			t.Fatalf("block %d: Commit: %v", block, err)
+		}
+
+		// Nothing is created or lost: tiny's earnings are either paid or
+		// still owed.
+		owed := ledger.Balances()["tiny"]
+		if paidTiny+owed != int64(block)*100 {
+			t.Fatalf("block %d: paid %d + owed %d, want %d", block, paidTiny, owed, block*100)
+		}
+		if block < 6 && paidTiny != 0 {
+			t.Fatalf("block %d: tiny paid %d before reaching the threshold", block, paidTiny)
+		}
+	}
+	if paidTiny != 600 {
+		t.Errorf("tiny paid %d after six blocks, want 600", paidTiny)
+	}
+	if len(store.saved) != 0 {
+		t.Errorf("stored balances = %v, want none", store.saved)
+	}
+
+	// The ledger reloads carried balances.
+	store.saved = map[string]int64{"tiny": 300}
+	reloaded, err := NewLedger(store)
+	if err != nil {
+		t.Fatalf("NewLedger: %v", err)
+	}
+	if got := reloaded.Balances()["tiny"]; got != 300 {
+		t.Errorf("reloaded balance = %d, want 300", got)
+	}
+}
+
+func TestLedger_CommitRequiresSettlement(t *testing.T) {
+	maxTarget := easyTarget()
+	window := NewWindow([]*types.Share{makeShare("tiny", maxTarget), makeShare("big", maxTarget)}, maxTarget)
+	ledger, _ := NewLedger(nil)
+	calc := NewCalculator(0, 546)
+	calc.SetLedger(ledger)
+
+	// Settling alone, e.g. for a discarded template, changes nothing.
+	payouts := calc.CalculatePayouts(window, 1000, "node")
+	if len(ledger.Balances()) != 0 {
+		t.Errorf("balances changed before commit: %v", ledger.Balances())
+	}
+	if err := ledger.Commit("other", []types.PayoutEntry{{Address: "big", Amount: 1000}}); err == nil {
+		t.Error("expected error committing payouts that were never settled")
+	}
+	if err := ledger.Commit("block", payouts); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if got := ledger.Balances(); got["tiny"] != 500 || got["big"] != 500 {
+		t.Errorf("balances = %v, want tiny and big owed 500", got)
+	}
+}
+
+func TestLedger_CommitStaleSettlement(t *testing.T) {
+	maxTarget := easyTarget()
+	window := NewWindow([]*types.Share{makeShare("tiny", maxTarget), makeShare("big", maxTarget)}, maxTarget)
+	ledger, _ := NewLedger(nil)
+	calc := NewCalculator(0, 546)
+	calc.SetLedger(ledger)
+
+	// Two templates settled against the same balances both find blocks.
+	first := calc.CalculatePayouts(window, 1000, "node")
+	second := calc.CalculatePayouts(window, 800, "node")
+	if err := ledger.Commit("first", first); err != nil {
+		t.Fatalf("Commit first: %v", err)
+	}
+	if err := ledger.Commit("second", second); err != nil {
+		t.Fatalf("Commit stale settlement: %v", err)
+	}
+	if got := ledger.Balances(); got["tiny"] != 900 || got["big"] != 900 {
+		t.Errorf("balances = %v, want tiny and big owed 900", got)
+	}
+	if err := ledger.Commit("first", first); err == nil {
+		t.Error("expected error committing a block twice")
+	}
+}
+
+func TestLedger_RevertOrphanedBlock(t *testing.T) {
+	maxTarget := easyTarget()
+	window := NewWindow([]*types.Share{
+		makeShare("tiny", maxTarget), makeShare("big", maxTarget), makeShare("node", maxTarget),
+	}, maxTarget)
+	store := &memBalances{}
+	ledger, _ := NewLedger(store)
+	calc := NewCalculator(0, 546)
+	calc.SetLedger(ledger)
+
+	if err := ledger.Commit("kept", calc.CalculatePayouts(window, 1500, "node")); err != nil {
+		t.Fatalf("Commit kept: %v", err)
+	}
+	ledger.Confirm("kept")
+
+	// The next block pays the carried balances, then is orphaned.
+	if err := ledger.Commit("orphan", calc.CalculatePayouts(window, 6000, "node")); err != nil {
+		t.Fatalf("Commit orphan: %v", err)
+	}
+	if got := ledger.Balances(); len(got) != 0 {
+		t.Fatalf("balances = %v, want all paid", got)
+	}
+	if err := ledger.Revert("orphan"); err != nil {
+		t.Fatalf("Revert: %v", err)
+	}
+	if got := store.saved; got["tiny"] != 500 || got["big"] != 500 {
+		t.Errorf("stored balances = %v, want tiny and big owed 500 again", got)
+	}
+	if err := ledger.Revert("kept"); err == nil {
+		t.Error("expected error reverting a confirmed block")
+	}
+}
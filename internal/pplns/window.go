@@ -7,9 +7,15 @@ import (
 	"github.com/djkazic/p2pool-go/pkg/util"
 )
 
+// UncleWeightPercent is the fraction of its normal weight an uncle share
+// earns. Uncles did real work but lost a sharechain race, so they are paid,
+// though less than shares that landed on the main chain.
+const UncleWeightPercent = 50
+
 // Window represents the PPLNS sliding window of shares.
 type Window struct {
 	shares    []*types.Share
+	uncles    []*types.Share
 	maxTarget *big.Int
 }
 
@@ -21,13 +27,29 @@ func NewWindow(shares []*types.Share, maxTarget *big.Int) *Window {
 	}
 }
 
+// NewWindowWithUncles creates a PPLNS window from main-chain shares (newest
+// first) plus the uncles they reference. Uncles are credited at
+// UncleWeightPercent of their normal weight.
+func NewWindowWithUncles(shares, uncles []*types.Share, maxTarget *big.Int) *Window {
+	return &Window{
+		shares:    shares,
+		uncles:    uncles,
+		maxTarget: maxTarget,
+	}
+}
+
+// UncleWeight returns the discounted weight of an uncle share.
+func (w *Window) UncleWeight(share *types.Share) *big.Int {
+	weight := w.ShareWeight(share)
+	weight.Mul(weight, big.NewInt(UncleWeightPercent))
+	return weight.Div(weight, big.NewInt(100))
+}
+
 // ShareWeight returns the weight (difficulty) of a single share.
-// Weight = maxTarget / shareTarget (i.e., the share's difficulty).
+// Weight = maxTarget / shareTarget (i.e., the share's difficulty). The
+// division is cached on the share; the returned value is a fresh copy.
 func (w *Window) ShareWeight(share *types.Share) *big.Int {
-	if share.ShareTarget == nil || share.ShareTarget.Sign() == 0 {
-		return big.NewInt(1)
-	}
-	return new(big.Int).Div(w.maxTarget, share.ShareTarget)
+	return new(big.Int).Set(share.Weight(w.maxTarget))
 }
 
 // MinerWeights returns a map of miner address -> total weight in the window.
@@ -35,7 +57,7 @@ func (w *Window) MinerWeights() map[string]*big.Int {
 	weights := make(map[string]*big.Int)
 
 	for _, share := range w.shares {
-		weight := w.ShareWeight(share)
+		weight := share.Weight(w.maxTarget)
 		addr := share.MinerAddress
 		if existing, ok := weights[addr]; ok {
 			existing.Add(existing, weight)
@@ -44,6 +66,16 @@ func (w *Window) MinerWeights() map[string]*big.Int {
 		}
 	}
 
+	for _, uncle := range w.uncles {
+		weight := w.UncleWeight(uncle)
+		addr := uncle.MinerAddress
+		if existing, ok := weights[addr]; ok {
+			existing.Add(existing, weight)
+		} else {
+			weights[addr] = weight
+		}
+	}
+
 	return weights
 }
 
@@ -51,11 +83,49 @@ func (w *Window) MinerWeights() map[string]*big.Int {
 func (w *Window) TotalWeight() *big.Int {
 	total := new(big.Int)
 	for _, share := range w.shares {
-		total.Add(total, w.ShareWeight(share))
+		total.Add(total, share.Weight(w.maxTarget))
+	}
+	for _, uncle := range w.uncles {
+		total.Add(total, w.UncleWeight(uncle))
 	}
 	return total
 }
 
+// WindowEntry describes one share's credit in a window.
+type WindowEntry struct {
+	Hash      [32]byte
+	Miner     string
+	Timestamp uint32
+	// Weight is the share's difficulty, discounted for uncles.
+	Weight *big.Int
+	Uncle  bool
+}
+
+// Entries returns the window's shares, newest first, followed by the uncles
+// they reference. The entries are copies, so callers may keep and modify
+// them while the chain moves on.
+func (w *Window) Entries() []WindowEntry {
+	entries := make([]WindowEntry, 0, len(w.shares)+len(w.uncles))
+	for _, share := range w.shares {
+		entries = append(entries, WindowEntry{
+			Hash:      share.Hash(),
+			Miner:     share.MinerAddress,
+			Timestamp: share.Header.Timestamp,
+			Weight:    w.ShareWeight(share),
+		})
+	}
+	for _, uncle := range w.uncles {
+		entries = append(entries, WindowEntry{
+			Hash:      uncle.Hash(),
+			Miner:     uncle.MinerAddress,
+			Timestamp: uncle.Header.Timestamp,
+			Weight:    w.UncleWeight(uncle),
+			Uncle:     true,
+		})
+	}
+	return entries
+}
+
 // ShareCount returns the number of shares in the window.
 func (w *Window) ShareCount() int {
 	return len(w.shares)
@@ -70,3 +140,24 @@ func (w *Window) MaxTarget() *big.Int {
 func DefaultMaxTarget() *big.Int {
 	return util.CompactToTarget(0x207fffff)
 }
+
+// WindowByWork builds a window from shares (newest first) that covers
+// targetWork worth of share difficulty. Shares are accumulated until the
+// running total reaches targetWork; anything older is dropped. The actual
+// accumulated work is returned so callers can tell when the chain was too
+// short to fill the window.
+func WindowByWork(shares []*types.Share, maxTarget, targetWork *big.Int) (*Window, *big.Int) {
+	w := &Window{maxTarget: maxTarget}
+	work := new(big.Int)
+
+	for i, share := range shares {
+		if work.Cmp(targetWork) >= 0 {
+			w.shares = shares[:i]
+			return w, work
+		}
+		work.Add(work, share.Weight(maxTarget))
+	}
+
+	w.shares = shares
+	return w, work
+}
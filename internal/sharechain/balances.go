@@ -0,0 +1,62 @@
+package sharechain
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+var bucketBalances = []byte("balances")
+
+// LoadBalances returns the payout balances carried between blocks, keyed
+// by address.
+func (s *BoltStore) LoadBalances() (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	balances := make(map[string]int64)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketBalances)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if len(v) != 8 {
+				return fmt.Errorf("balance for %q is %d bytes", k, len(v))
+			}
+			balances[string(k)] = int64(binary.BigEndian.Uint64(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// SaveBalances replaces the carried payout balances in one transaction.
+func (s *BoltStore) SaveBalances(balances map[string]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(bucketBalances) != nil {
+			if err := tx.DeleteBucket(bucketBalances); err != nil {
+				return err
+			}
+		}
+		b, err := tx.CreateBucket(bucketBalances)
+		if err != nil {
+			return err
+		}
+		for addr, amount := range balances {
+			var v [8]byte
+			binary.BigEndian.PutUint64(v[:], uint64(amount))
+			if err := b.Put([]byte(addr), v[:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,37 @@
+package sharechain
+
+import (
+	"maps"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltStore_Balances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewBoltStore(path, testLogger())
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+
+	if balances, err := store.LoadBalances(); err != nil || len(balances) != 0 {
+		t.Fatalf("empty balances = %v, %v", balances, err)
+	}
+	if err := store.SaveBalances(map[string]int64{testMiner1: 100, testMiner2: 200}); err != nil {
+		t.Fatalf("SaveBalances: %v", err)
+	}
+	// Saving replaces, so paid-out miners disappear.
+	want := map[string]int64{testMiner2: 450}
+	if err := store.SaveBalances(want); err != nil {
+		t.Fatalf("SaveBalances: %v", err)
+	}
+
+	store.Close()
+	store, err = NewBoltStore(path, testLogger())
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	defer store.Close()
+	if got, err := store.LoadBalances(); err != nil || !maps.Equal(got, want) {
+		t.Errorf("LoadBalances = %v, %v; want %v", got, err, want)
+	}
+}
@@ -0,0 +1,220 @@
+package sharechain
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+
+	"go.uber.org/zap"
+)
+
+// AddBatch validates shares and adds them to the chain in one store write,
+// without emitting events, for applying a sync batch. Shares are applied
+// parents first whatever their order in the slice, and ones already known
+// are skipped. It returns the number of shares added. If any share is
+// invalid nothing is added and the error names the offending share.
+func (sc *ShareChain) AddBatch(shares []*types.Share) (added int, err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	pending := newPendingStore(sc.store)
+	base, baseValidator, baseForkChoice := sc.store, sc.validator.store, sc.forkChoice.store
+	// Validation, fork choice and retargeting read through sc.store; point
+	// them at the pending view so each share sees its batch ancestors.
+	sc.store, sc.validator.store, sc.forkChoice.store = pending, pending, pending
+	defer func() {
+		sc.store, sc.validator.store, sc.forkChoice.store = base, baseValidator, baseForkChoice
+	}()
+
+	for _, share := range orderBatch(shares) {
+		hash := share.Hash()
+		if pending.Has(hash) {
+			continue
+		}
+		if err := sc.validator.ValidateShare(share); err != nil {
+			return 0, fmt.Errorf("invalid share %x: %w", hash[:8], err)
+		}
+		if err := sc.checkCheckpoint(share); err != nil {
+			return 0, fmt.Errorf("invalid share %x: %w", hash[:8], err)
+		}
+		if err := pending.Add(share); err != nil {
+			return 0, err
+		}
+
+		oldTip, hadTip := pending.Tip()
+		var oldTipHash [32]byte
+		if hadTip {
+			oldTipHash = oldTip.Hash()
+		}
+		newTipHash := sc.forkChoice.SelectTip(oldTipHash, hash, sc.windowSize)
+		if hadTip && newTipHash != oldTipHash && sc.undoesCheckpoint(oldTipHash, newTipHash) {
+			newTipHash = oldTipHash
+		}
+		if err := pending.SetTip(newTipHash); err != nil {
+			return 0, fmt.Errorf("set tip: %w", err)
+		}
+	}
+
+	if len(pending.added) == 0 {
+		return 0, nil
+	}
+	if err := base.AddBatch(pending.added); err != nil {
+		return 0, fmt.Errorf("store shares: %w", err)
+	}
+	if pending.hasTip {
+		if err := base.SetTip(pending.tipHash); err != nil {
+			return len(pending.added), fmt.Errorf("set tip: %w", err)
+		}
+	}
+
+	sc.logger.Debug("share batch added (sync)",
+		zap.Int("shares", len(pending.added)),
+		zap.Int("chain_length", base.Count()),
+	)
+	return len(pending.added), nil
+}
+
+// orderBatch returns shares with every share after its parent when both
+// are in the batch, dropping duplicates.
+func orderBatch(shares []*types.Share) []*types.Share {
+	byHash := make(map[[32]byte]*types.Share, len(shares))
+	children := make(map[[32]byte][]*types.Share)
+	for _, share := range shares {
+		hash := share.Hash()
+		if _, dup := byHash[hash]; dup {
+			continue
+		}
+		byHash[hash] = share
+		children[share.PrevShareHash] = append(children[share.PrevShareHash], share)
+	}
+
+	ordered := make([]*types.Share, 0, len(byHash))
+	var queue []*types.Share
+	for _, share := range byHash {
+		if _, inBatch := byHash[share.PrevShareHash]; !inBatch {
+			queue = append(queue, share)
+		}
+	}
+	for len(queue) > 0 {
+		share := queue[0]
+		queue = queue[1:]
+		ordered = append(ordered, share)
+		queue = append(queue, children[share.Hash()]...)
+	}
+	return ordered
+}
+
+// pendingStore layers shares not yet written on top of a ShareStore. Reads
+// see both; writes other than Add and SetTip go to the base store.
+type pendingStore struct {
+	ShareStore
+	shares  map[[32]byte]*types.Share
+	heights map[[32]byte]int64
+	totals  map[[32]byte]*big.Int
+	added   []*types.Share
+	tipHash [32]byte
+	hasTip  bool
+}
+
+func newPendingStore(base ShareStore) *pendingStore {
+	p := &pendingStore{
+		ShareStore: base,
+		shares:     make(map[[32]byte]*types.Share),
+		heights:    make(map[[32]byte]int64),
+		totals:     make(map[[32]byte]*big.Int),
+	}
+	if tip, ok := base.Tip(); ok {
+		p.tipHash, p.hasTip = tip.Hash(), true
+	}
+	return p
+}
+
+func (p *pendingStore) Add(share *types.Share) error {
+	hash := share.Hash()
+	if p.Has(hash) {
+		return fmt.Errorf("share %x already exists", hash[:8])
+	}
+	p.shares[hash] = share
+	p.added = append(p.added, share)
+
+	var zeroHash [32]byte
+	if share.PrevShareHash == zeroHash {
+		p.heights[hash] = 0
+	} else if parent, ok := p.Height(share.PrevShareHash); ok {
+		p.heights[hash] = parent + 1
+	}
+
+	if share.PrevShareHash == zeroHash {
+		p.totals[hash] = shareWork(share)
+	} else if parent, ok := p.TotalWork(share.PrevShareHash); ok {
+		p.totals[hash] = addWork(parent, share)
+	}
+	return nil
+}
+
+func (p *pendingStore) Get(hash [32]byte) (*types.Share, bool) {
+	if share, ok := p.shares[hash]; ok {
+		return share, true
+	}
+	return p.ShareStore.Get(hash)
+}
+
+func (p *pendingStore) Has(hash [32]byte) bool {
+	_, ok := p.shares[hash]
+	return ok || p.ShareStore.Has(hash)
+}
+
+func (p *pendingStore) Tip() (*types.Share, bool) {
+	if !p.hasTip {
+		return nil, false
+	}
+	return p.Get(p.tipHash)
+}
+
+func (p *pendingStore) SetTip(hash [32]byte) error {
+	if !p.Has(hash) {
+		return fmt.Errorf("cannot set tip to unknown share %x", hash[:8])
+	}
+	p.tipHash, p.hasTip = hash, true
+	return nil
+}
+
+func (p *pendingStore) Count() int {
+	return p.ShareStore.Count() + len(p.shares)
+}
+
+func (p *pendingStore) Height(hash [32]byte) (int64, bool) {
+	if height, ok := p.heights[hash]; ok {
+		return height, true
+	}
+	return p.ShareStore.Height(hash)
+}
+
+func (p *pendingStore) TotalWork(hash [32]byte) (*big.Int, bool) {
+	if total, ok := p.totals[hash]; ok {
+		return new(big.Int).Set(total), true
+	}
+	return p.ShareStore.TotalWork(hash)
+}
+
+func (p *pendingStore) GetAncestors(hash [32]byte, count int) []*types.Share {
+	count = min(count, MaxAncestors, p.Count())
+	var ancestors []*types.Share
+	visited := make(map[[32]byte]struct{})
+	current := hash
+	for len(ancestors) < count {
+		share, ok := p.shares[current]
+		if !ok {
+			// The rest of the chain is in the base store.
+			return append(ancestors, p.ShareStore.GetAncestors(current, count-len(ancestors))...)
+		}
+		if _, seen := visited[current]; seen {
+			break
+		}
+		visited[current] = struct{}{}
+		ancestors = append(ancestors, share)
+		current = share.PrevShareHash
+	}
+	return ancestors
+}
@@ -0,0 +1,78 @@
+package sharechain
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+// makeTestChain builds count linked shares on top of prev without storing
+// them.
+func makeTestChain(prev [32]byte, count int, ts uint32) []*types.Share {
+	shares := make([]*types.Share, count)
+	for i := range shares {
+		shares[i] = makeTestShare(prev, testMiner1, ts+uint32(i)*30)
+		prev = shares[i].Hash()
+	}
+	return shares
+}
+
+func TestShareChain_AddBatch(t *testing.T) {
+	chain := NewShareChain(NewMemoryStore(), NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil), 8640, testNetwork, testLogger())
+	base := uint32(time.Now().Add(-time.Hour).Unix())
+	shares := makeTestChain([32]byte{}, 50, base)
+
+	if err := chain.AddShare(shares[0]); err != nil {
+		t.Fatalf("AddShare genesis: %v", err)
+	}
+
+	// Out of order, with a share already known and a duplicate.
+	batch := append([]*types.Share{shares[10]}, shares...)
+	rand.New(rand.NewSource(1)).Shuffle(len(batch), func(i, j int) { batch[i], batch[j] = batch[j], batch[i] })
+
+	added, err := chain.AddBatch(batch)
+	if err != nil {
+		t.Fatalf("AddBatch: %v", err)
+	}
+	if added != 49 {
+		t.Errorf("added = %d, want 49", added)
+	}
+	if chain.Count() != 50 {
+		t.Errorf("count = %d, want 50", chain.Count())
+	}
+	tip, _ := chain.Tip()
+	if tip.Hash() != shares[49].Hash() {
+		t.Error("tip should be the last share of the batch")
+	}
+}
+
+func TestShareChain_AddBatchRollsBack(t *testing.T) {
+	chain := NewShareChain(NewMemoryStore(), NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil), 8640, testNetwork, testLogger())
+	base := uint32(time.Now().Add(-time.Hour).Unix())
+	shares := makeTestChain([32]byte{}, 10, base)
+	if err := chain.AddShare(shares[0]); err != nil {
+		t.Fatalf("AddShare genesis: %v", err)
+	}
+
+	// ShareVersion is not part of the hash, so the chain stays linked.
+	shares[5].ShareVersion = 2
+	_, err := chain.AddBatch(shares[1:])
+	if err == nil {
+		t.Fatal("expected error for batch with an invalid share")
+	}
+	bad := shares[5].Hash()
+	if !strings.Contains(err.Error(), fmt.Sprintf("%x", bad[:8])) {
+		t.Errorf("error %q should name the invalid share", err)
+	}
+	if chain.Count() != 1 {
+		t.Errorf("count = %d, want 1 after rollback", chain.Count())
+	}
+	tip, _ := chain.Tip()
+	if tip.Hash() != shares[0].Hash() {
+		t.Error("tip should not move after rollback")
+	}
+}
@@ -0,0 +1,88 @@
+package sharechain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+
+	"go.etcd.io/bbolt"
+)
+
+var bucketBlocks = []byte("blocks")
+
+// BlockRecord is the durable record of a Bitcoin block found by the pool,
+// kept so payouts can be audited after the PPLNS window has moved on.
+type BlockRecord struct {
+	Hash    string // display-order hex
+	Height  int64
+	Reward  int64 // coinbase value in satoshis
+	Finder  string
+	FoundAt time.Time
+	Payouts []types.PayoutEntry
+}
+
+// BlockRecorder is implemented by stores that persist found blocks.
+type BlockRecorder interface {
+	SaveBlock(rec *BlockRecord) error
+	// GetBlockHistory returns up to n of the most recently saved blocks,
+	// newest first.
+	GetBlockHistory(n int) ([]*BlockRecord, error)
+}
+
+// SaveBlock appends a found block to the blocks bucket.
+func (s *BoltStore) SaveBlock(rec *BlockRecord) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(rec); err != nil {
+		return fmt.Errorf("encode block record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucketBlocks)
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		// Big-endian sequence keys keep records in insertion order.
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], seq)
+		return b.Put(key[:], buf.Bytes())
+	})
+}
+
+// GetBlockHistory returns up to n of the most recently found blocks,
+// newest first.
+func (s *BoltStore) GetBlockHistory(n int) ([]*BlockRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var records []*BlockRecord
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketBlocks)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(records) < n; k, v = c.Prev() {
+			var rec BlockRecord
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&rec); err != nil {
+				return fmt.Errorf("decode block record %x: %w", k, err)
+			}
+			records = append(records, &rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
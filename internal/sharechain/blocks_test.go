@@ -0,0 +1,57 @@
+package sharechain
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+func TestBoltStore_BlockHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewBoltStore(path, testLogger())
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+
+	if history, err := store.GetBlockHistory(10); err != nil || len(history) != 0 {
+		t.Fatalf("empty history = %v, %v", history, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		rec := &BlockRecord{
+			Hash:    string(rune('a' + i)),
+			Height:  int64(800000 + i),
+			Reward:  312500000,
+			Finder:  testMiner1,
+			FoundAt: time.Unix(int64(1700000000+i), 0),
+			Payouts: []types.PayoutEntry{{Address: testMiner1, Amount: 312500000}},
+		}
+		if err := store.SaveBlock(rec); err != nil {
+			t.Fatalf("save block %d: %v", i, err)
+		}
+	}
+
+	// Records survive a reopen and come back newest first.
+	store.Close()
+	store, err = NewBoltStore(path, testLogger())
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	defer store.Close()
+
+	history, err := store.GetBlockHistory(2)
+	if err != nil {
+		t.Fatalf("GetBlockHistory: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("got %d records, want 2", len(history))
+	}
+	if history[0].Height != 800002 || history[1].Height != 800001 {
+		t.Errorf("heights = %d, %d; want 800002, 800001", history[0].Height, history[1].Height)
+	}
+	if len(history[0].Payouts) != 1 || history[0].Payouts[0].Amount != 312500000 {
+		t.Errorf("payouts not preserved: %+v", history[0].Payouts)
+	}
+}
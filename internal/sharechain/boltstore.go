@@ -2,10 +2,13 @@ package sharechain
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
 	"math/big"
+	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/djkazic/p2pool-go/internal/types"
 
@@ -14,9 +17,18 @@ import (
 )
 
 var (
-	bucketShares = []byte("shares")
-	bucketMeta   = []byte("meta")
-	keyTip       = []byte("tip")
+	bucketShares  = []byte("shares")
+	bucketMeta    = []byte("meta")
+	bucketHeights = []byte("heights")
+	bucketWork    = []byte("work")
+	keyTip        = []byte("tip")
+)
+
+const (
+	// compactMinFree and compactFreeRatio gate compaction: rewriting the
+	// file only pays off once free pages make up a good part of it.
+	compactMinFree   = 16 << 20
+	compactFreeRatio = 0.5
 )
 
 // BoltStore is a write-through persistent ShareStore backed by bbolt.
@@ -24,10 +36,18 @@ var (
 type BoltStore struct {
 	mu      sync.RWMutex
 	db      *bbolt.DB
+	path    string
 	shares  map[[32]byte]*types.Share
+	index   *heightIndex
+	work    *workIndex
 	tipHash [32]byte
 	hasTip  bool
 	logger  *zap.Logger
+
+	// closed is set by Close; compacting guards against overlapping
+	// compactions.
+	closed     bool
+	compacting atomic.Bool
 }
 
 // NewBoltStore opens (or creates) a bbolt database at path, loads all
@@ -43,6 +63,12 @@ func NewBoltStore(path string, logger *zap.Logger) (*BoltStore, error) {
 		if _, err := tx.CreateBucketIfNotExists(bucketShares); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists(bucketHeights); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(bucketWork); err != nil {
+			return err
+		}
 		_, err := tx.CreateBucketIfNotExists(bucketMeta)
 		return err
 	})
@@ -53,7 +79,10 @@ func NewBoltStore(path string, logger *zap.Logger) (*BoltStore, error) {
 
 	s := &BoltStore{
 		db:     db,
+		path:   path,
 		shares: make(map[[32]byte]*types.Share),
+		index:  newHeightIndex(),
+		work:   newWorkIndex(),
 		logger: logger,
 	}
 
@@ -91,6 +120,15 @@ func NewBoltStore(path string, logger *zap.Logger) (*BoltStore, error) {
 		return nil, fmt.Errorf("load tip: %w", err)
 	}
 
+	if err := s.loadHeights(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("load heights: %w", err)
+	}
+	if err := s.loadWork(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("load work: %w", err)
+	}
+
 	logger.Info("sharechain loaded from disk",
 		zap.Int("shares_loaded", len(s.shares)),
 		zap.Bool("has_tip", s.hasTip),
@@ -112,15 +150,112 @@ func (s *BoltStore) Add(share *types.Share) error {
 	if err != nil {
 		return fmt.Errorf("encode share: %w", err)
 	}
+	height, hasHeight := s.index.heightOf(share)
+	total, hasTotal := s.work.workOf(share)
 
 	err = s.db.Update(func(tx *bbolt.Tx) error {
-		return tx.Bucket(bucketShares).Put(hash[:], data)
+		if err := tx.Bucket(bucketShares).Put(hash[:], data); err != nil {
+			return err
+		}
+		if hasTotal {
+			if err := tx.Bucket(bucketWork).Put(hash[:], total.Bytes()); err != nil {
+				return err
+			}
+		}
+		if !hasHeight {
+			return nil
+		}
+		return tx.Bucket(bucketHeights).Put(hash[:], encodeHeight(height))
 	})
 	if err != nil {
 		return fmt.Errorf("persist share: %w", err)
 	}
 
 	s.shares[hash] = share
+	if hasHeight {
+		s.index.set(hash, height)
+	}
+	if hasTotal {
+		s.work.set(hash, total)
+	}
+	return nil
+}
+
+// AddBatch persists shares in a single bolt transaction, so a sync batch
+// costs one fsync instead of one per share.
+func (s *BoltStore) AddBatch(shares []*types.Share) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hashes := make([][32]byte, len(shares))
+	encoded := make([][]byte, len(shares))
+	heights := make(map[[32]byte]int64, len(shares))
+	totals := make(map[[32]byte]*big.Int, len(shares))
+	for i, share := range shares {
+		hash := share.Hash()
+		_, exists := s.shares[hash]
+		_, dup := heights[hash]
+		if exists || dup {
+			return fmt.Errorf("share %x already exists", hash[:8])
+		}
+		data, err := encodeShare(share)
+		if err != nil {
+			return fmt.Errorf("encode share %x: %w", hash[:8], err)
+		}
+		hashes[i], encoded[i] = hash, data
+
+		height, ok := s.index.heightOf(share)
+		if !ok {
+			if parent, inBatch := heights[share.PrevShareHash]; inBatch && parent >= 0 {
+				height, ok = parent+1, true
+			}
+		}
+		if !ok {
+			height = -1
+		}
+		heights[hash] = height
+
+		if parent, inBatch := totals[share.PrevShareHash]; inBatch {
+			totals[hash] = addWork(parent, share)
+		} else if total, ok := s.work.workOf(share); ok {
+			totals[hash] = total
+		}
+	}
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketShares)
+		hb := tx.Bucket(bucketHeights)
+		wb := tx.Bucket(bucketWork)
+		for i, hash := range hashes {
+			if err := b.Put(hash[:], encoded[i]); err != nil {
+				return err
+			}
+			if total, ok := totals[hash]; ok {
+				if err := wb.Put(hash[:], total.Bytes()); err != nil {
+					return err
+				}
+			}
+			if height := heights[hash]; height >= 0 {
+				if err := hb.Put(hash[:], encodeHeight(height)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("persist shares: %w", err)
+	}
+
+	for i, hash := range hashes {
+		s.shares[hash] = shares[i]
+		if height := heights[hash]; height >= 0 {
+			s.index.set(hash, height)
+		}
+		if total, ok := totals[hash]; ok {
+			s.work.set(hash, total)
+		}
+	}
 	return nil
 }
 
@@ -155,7 +290,14 @@ func (s *BoltStore) SetTip(hash [32]byte) error {
 	if _, ok := s.shares[hash]; !ok {
 		return fmt.Errorf("cannot set tip to unknown share %x", hash[:8])
 	}
+	if err := checkTipRegression(s.shares, s.index, s.tipHash, hash); err != nil {
+		return err
+	}
+	return s.setTip(hash)
+}
 
+// setTip persists hash as the tip. Must be called with s.mu held.
+func (s *BoltStore) setTip(hash [32]byte) error {
 	err := s.db.Update(func(tx *bbolt.Tx) error {
 		return tx.Bucket(bucketMeta).Put(keyTip, hash[:])
 	})
@@ -165,6 +307,7 @@ func (s *BoltStore) SetTip(hash [32]byte) error {
 
 	s.tipHash = hash
 	s.hasTip = true
+	s.index.setTip(s.shares, hash)
 	return nil
 }
 
@@ -177,20 +320,7 @@ func (s *BoltStore) Count() int {
 func (s *BoltStore) GetAncestors(hash [32]byte, count int) []*types.Share {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-
-	var ancestors []*types.Share
-	current := hash
-
-	for i := 0; i < count; i++ {
-		share, ok := s.shares[current]
-		if !ok {
-			break
-		}
-		ancestors = append(ancestors, share)
-		current = share.PrevShareHash
-	}
-
-	return ancestors
+	return walkAncestors(s.shares, hash, count)
 }
 
 func (s *BoltStore) Delete(hash [32]byte) error {
@@ -202,13 +332,21 @@ func (s *BoltStore) Delete(hash [32]byte) error {
 	}
 
 	err := s.db.Update(func(tx *bbolt.Tx) error {
-		return tx.Bucket(bucketShares).Delete(hash[:])
+		if err := tx.Bucket(bucketShares).Delete(hash[:]); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketWork).Delete(hash[:]); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketHeights).Delete(hash[:])
 	})
 	if err != nil {
 		return fmt.Errorf("delete share from disk: %w", err)
 	}
 
 	delete(s.shares, hash)
+	s.index.remove(hash)
+	s.work.remove(hash)
 	return nil
 }
 
@@ -220,6 +358,8 @@ func (s *BoltStore) DeleteBatch(hashes [][32]byte) (int, error) {
 	deleted := 0
 	err := s.db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(bucketShares)
+		hb := tx.Bucket(bucketHeights)
+		wb := tx.Bucket(bucketWork)
 		for _, h := range hashes {
 			if _, ok := s.shares[h]; !ok {
 				continue
@@ -227,7 +367,15 @@ func (s *BoltStore) DeleteBatch(hashes [][32]byte) (int, error) {
 			if err := b.Delete(h[:]); err != nil {
 				return err
 			}
+			if err := hb.Delete(h[:]); err != nil {
+				return err
+			}
+			if err := wb.Delete(h[:]); err != nil {
+				return err
+			}
 			delete(s.shares, h)
+			s.index.remove(h)
+			s.work.remove(h)
 			deleted++
 		}
 		return nil
@@ -235,6 +383,142 @@ func (s *BoltStore) DeleteBatch(hashes [][32]byte) (int, error) {
 	return deleted, err
 }
 
+// Prune deletes shares more than keepDepth behind the tip in a single
+// transaction. The freed pages stay in the file until MaybeCompact.
+func (s *BoltStore) Prune(keepDepth int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.hasTip {
+		return 0, nil
+	}
+	toDelete := pruneCandidates(s.shares, s.tipHash, keepDepth)
+	if len(toDelete) == 0 {
+		return 0, nil
+	}
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketShares)
+		hb := tx.Bucket(bucketHeights)
+		wb := tx.Bucket(bucketWork)
+		for _, h := range toDelete {
+			if err := b.Delete(h[:]); err != nil {
+				return err
+			}
+			if err := hb.Delete(h[:]); err != nil {
+				return err
+			}
+			if err := wb.Delete(h[:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("delete pruned shares: %w", err)
+	}
+	for _, h := range toDelete {
+		delete(s.shares, h)
+		s.index.remove(h)
+		s.work.remove(h)
+	}
+
+	return len(toDelete), nil
+}
+
+// MaybeCompact rewrites the database into a fresh file to reclaim free
+// pages, once they take up at least compactMinFree bytes and
+// compactFreeRatio of the file. It reports whether it compacted.
+func (s *BoltStore) MaybeCompact() (bool, error) {
+	return s.compactIfFree(compactMinFree, compactFreeRatio)
+}
+
+// compactIfFree compacts if free pages take up at least minFree bytes and
+// ratio of the file. The copy runs without holding s.mu, so it blocks
+// neither readers nor writers; if a write commits meanwhile the copy is
+// stale and is dropped until a later call.
+func (s *BoltStore) compactIfFree(minFree int64, ratio float64) (bool, error) {
+	if !s.compacting.CompareAndSwap(false, true) {
+		return false, nil
+	}
+	defer s.compacting.Store(false)
+
+	s.mu.RLock()
+	db, closed := s.db, s.closed
+	s.mu.RUnlock()
+	if closed {
+		return false, nil
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false, fmt.Errorf("stat db: %w", err)
+	}
+	free := int64(db.Stats().FreeAlloc)
+	if free < minFree || float64(free) < ratio*float64(info.Size()) {
+		return false, nil
+	}
+
+	before, err := lastTxID(db)
+	if err != nil {
+		return false, err
+	}
+	tmpPath := s.path + ".compact"
+	os.Remove(tmpPath)
+	dst, err := bbolt.Open(tmpPath, 0600, nil)
+	if err != nil {
+		return false, fmt.Errorf("open compaction target: %w", err)
+	}
+	if err := bbolt.Compact(dst, db, 1<<20); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return false, fmt.Errorf("compact: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return false, fmt.Errorf("close compaction target: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		os.Remove(tmpPath)
+		return false, nil
+	}
+	if after, err := lastTxID(s.db); err != nil || after != before {
+		os.Remove(tmpPath)
+		return false, err
+	}
+
+	if err := s.db.Close(); err != nil {
+		os.Remove(tmpPath)
+		return false, fmt.Errorf("close db: %w", err)
+	}
+	renameErr := os.Rename(tmpPath, s.path)
+
+	// Reopen whichever file is now at s.path so the store stays usable
+	// even if the rename failed.
+	db, err = bbolt.Open(s.path, 0600, nil)
+	if err != nil {
+		return false, fmt.Errorf("reopen db after compaction: %w", err)
+	}
+	s.db = db
+	if renameErr != nil {
+		os.Remove(tmpPath)
+		return false, fmt.Errorf("replace db file: %w", renameErr)
+	}
+	return true, nil
+}
+
+// lastTxID returns the ID of db's last committed write transaction.
+func lastTxID(db *bbolt.DB) (int, error) {
+	var id int
+	err := db.View(func(tx *bbolt.Tx) error {
+		id = tx.ID()
+		return nil
+	})
+	return id, err
+}
+
 func (s *BoltStore) AllHashes() [][32]byte {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -246,7 +530,109 @@ func (s *BoltStore) AllHashes() [][32]byte {
 	return hashes
 }
 
+func (s *BoltStore) Height(hash [32]byte) (int64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	height, ok := s.index.heights[hash]
+	return height, ok
+}
+
+func (s *BoltStore) GetByHeight(height int64) ([]*types.Share, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.lookup(s.shares, height)
+}
+
+// loadHeights rebuilds the height index from disk. Heights survive pruning
+// there, so shares whose ancestry is gone keep theirs; shares written
+// before the index existed are derived from their parents and persisted.
+func (s *BoltStore) loadHeights() error {
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketHeights).ForEach(func(k, v []byte) error {
+			var hash [32]byte
+			copy(hash[:], k)
+			if _, ok := s.shares[hash]; ok && len(v) == 8 {
+				s.index.set(hash, decodeHeight(v))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if derived := s.index.derive(s.shares); len(derived) > 0 {
+		err = s.db.Update(func(tx *bbolt.Tx) error {
+			b := tx.Bucket(bucketHeights)
+			for _, h := range derived {
+				if err := b.Put(h[:], encodeHeight(s.index.heights[h])); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.hasTip {
+		s.index.setTip(s.shares, s.tipHash)
+	}
+	return nil
+}
+
+func (s *BoltStore) TotalWork(hash [32]byte) (*big.Int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.work.get(hash)
+}
+
+// loadWork rebuilds the cumulative work index from disk, where totals
+// survive pruning. Shares written before the index existed are derived from
+// their parents and persisted.
+func (s *BoltStore) loadWork() error {
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketWork).ForEach(func(k, v []byte) error {
+			var hash [32]byte
+			copy(hash[:], k)
+			if _, ok := s.shares[hash]; ok {
+				s.work.set(hash, new(big.Int).SetBytes(v))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	derived := s.work.derive(s.shares)
+	if len(derived) == 0 {
+		return nil
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketWork)
+		for _, h := range derived {
+			if err := b.Put(h[:], s.work.totals[h].Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func encodeHeight(height int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(height))
+}
+
+func decodeHeight(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
+
 func (s *BoltStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
 	return s.db.Close()
 }
 
@@ -260,6 +646,8 @@ type gobShare struct {
 	MinerAddress    string
 	CoinbaseTx      []byte
 	ShareChainNonce uint64
+	Uncles          [][32]byte
+	Height          int64
 }
 
 func encodeShare(s *types.Share) ([]byte, error) {
@@ -270,6 +658,8 @@ func encodeShare(s *types.Share) ([]byte, error) {
 		MinerAddress:    s.MinerAddress,
 		CoinbaseTx:      s.CoinbaseTx,
 		ShareChainNonce: s.ShareChainNonce,
+		Uncles:          s.Uncles,
+		Height:          s.Height,
 	}
 	if s.ShareTarget != nil {
 		gs.ShareTargetBytes = s.ShareTarget.Bytes()
@@ -295,6 +685,8 @@ func decodeShare(data []byte) (*types.Share, error) {
 		MinerAddress:    gs.MinerAddress,
 		CoinbaseTx:      gs.CoinbaseTx,
 		ShareChainNonce: gs.ShareChainNonce,
+		Uncles:          gs.Uncles,
+		Height:          gs.Height,
 	}
 	if len(gs.ShareTargetBytes) > 0 {
 		s.ShareTarget = new(big.Int).SetBytes(gs.ShareTargetBytes)
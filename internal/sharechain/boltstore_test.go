@@ -1,9 +1,15 @@
 package sharechain
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+
+	"go.etcd.io/bbolt"
 )
 
 func TestBoltStore_AddAndGet(t *testing.T) {
@@ -171,3 +177,327 @@ func TestBoltStore_PersistenceAcrossRestart(t *testing.T) {
 		t.Error("database file does not exist")
 	}
 }
+
+func TestBoltStore_PruneAndCompact(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	store, err := NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+
+	var prevHash [32]byte
+	for i := 0; i < 20; i++ {
+		share := makeTestShare(prevHash, testMiner1, uint32(1700000000+i*30))
+		if err := store.Add(share); err != nil {
+			t.Fatalf("Add %d: %v", i, err)
+		}
+		prevHash = share.Hash()
+	}
+	if err := store.SetTip(prevHash); err != nil {
+		t.Fatalf("SetTip: %v", err)
+	}
+
+	pruned, err := store.Prune(5)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if pruned != 15 {
+		t.Errorf("pruned = %d, want 15", pruned)
+	}
+
+	// A few freed pages are below the threshold.
+	if compacted, err := store.MaybeCompact(); err != nil || compacted {
+		t.Errorf("MaybeCompact = %v, %v; want no compaction", compacted, err)
+	}
+	if compacted, err := store.compactIfFree(0, 0); err != nil || !compacted {
+		t.Fatalf("compactIfFree = %v, %v; want compaction", compacted, err)
+	}
+
+	// Store remains usable after compaction swapped the file
+	extra := makeTestShare(prevHash, testMiner1, 1700000600)
+	if err := store.Add(extra); err != nil {
+		t.Fatalf("Add after prune: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Stat(dbPath + ".compact"); !os.IsNotExist(err) {
+		t.Error("compaction temp file should be removed")
+	}
+
+	reopened, err := NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.Count() != 6 {
+		t.Errorf("count after reopen = %d, want 6", reopened.Count())
+	}
+	tip, ok := reopened.Tip()
+	if !ok || tip.Hash() != prevHash {
+		t.Error("tip should survive prune and compaction")
+	}
+}
+
+func TestBoltStore_HeightsAcrossRestart(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+	shares := addChain(t, store, [32]byte{}, testMiner1, 20, 1700000000)
+	tipHash := shares[19].Hash()
+	if err := store.SetTip(tipHash); err != nil {
+		t.Fatalf("SetTip: %v", err)
+	}
+	if _, err := store.Prune(5); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Genesis is pruned, so heights must come from disk.
+	store, err = NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if h, ok := store.Height(tipHash); !ok || h != 19 {
+		t.Errorf("tip height after reopen = %d, %v; want 19", h, ok)
+	}
+	got, ok := store.GetByHeight(16)
+	if !ok || got[0].Hash() != shares[16].Hash() {
+		t.Error("GetByHeight(16) should return the main-chain share")
+	}
+	if _, ok := store.GetByHeight(10); ok {
+		t.Error("pruned height should be empty")
+	}
+	next := makeTestShare(tipHash, testMiner1, 1700001000)
+	if err := store.Add(next); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if h, ok := store.Height(next.Hash()); !ok || h != 20 {
+		t.Errorf("new share height = %d, %v; want 20", h, ok)
+	}
+
+	// Without the stored index a pruned chain cannot recover heights.
+	if err := store.db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(bucketHeights)
+	}); err != nil {
+		t.Fatalf("drop heights: %v", err)
+	}
+	store.Close()
+	store, err = NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if _, ok := store.Height(tipHash); ok {
+		t.Error("height without a path to genesis should be unknown")
+	}
+}
+
+func TestBoltStore_DerivesMissingHeights(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+	shares := addChain(t, store, [32]byte{}, testMiner1, 5, 1700000000)
+	// Simulate a database written before the height index existed.
+	if err := store.db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(bucketHeights)
+	}); err != nil {
+		t.Fatalf("drop heights: %v", err)
+	}
+	store.Close()
+
+	store, err = NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	for i, s := range shares {
+		if h, ok := store.Height(s.Hash()); !ok || h != int64(i) {
+			t.Errorf("share %d height = %d, %v", i, h, ok)
+		}
+	}
+}
+
+func TestBoltStore_WorkAcrossRestart(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+	shares := addChain(t, store, [32]byte{}, testMiner1, 10, 1700000000)
+	tipHash := shares[9].Hash()
+	want, _ := store.TotalWork(tipHash)
+	if err := store.SetTip(tipHash); err != nil {
+		t.Fatalf("SetTip: %v", err)
+	}
+	if _, err := store.Prune(3); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	store.Close()
+
+	// Genesis is pruned, so the total must come from disk.
+	store, err = NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got, ok := store.TotalWork(tipHash); !ok || got.Cmp(want) != 0 {
+		t.Errorf("tip work after reopen = %v, %v; want %v", got, ok, want)
+	}
+
+	// A database written before the index existed derives totals from the
+	// stored shares, but only where they reach back to genesis.
+	if err := store.db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(bucketWork)
+	}); err != nil {
+		t.Fatalf("drop work: %v", err)
+	}
+	store.Close()
+	store, err = NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if got, ok := store.TotalWork(tipHash); ok {
+		t.Errorf("tip work derived as %v with genesis pruned", got)
+	}
+}
+
+func TestBoltStore_DerivesWork(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+	shares := addChain(t, store, [32]byte{}, testMiner1, 5, 1700000000)
+	tipHash := shares[4].Hash()
+	if err := store.db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(bucketWork)
+	}); err != nil {
+		t.Fatalf("drop work: %v", err)
+	}
+	store.Close()
+
+	store, err = NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if got, ok := store.TotalWork(tipHash); !ok || got.Cmp(referenceWork(store, tipHash)) != 0 {
+		t.Errorf("derived tip work = %v, %v; want %v", got, ok, referenceWork(store, tipHash))
+	}
+}
+
+func TestBoltStore_AddBatch(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+	shares := makeTestChain([32]byte{}, 5, 1700000000)
+	if err := store.AddBatch(shares); err != nil {
+		t.Fatalf("AddBatch: %v", err)
+	}
+	if err := store.AddBatch(shares[4:]); err == nil {
+		t.Error("expected error re-adding a stored share")
+	}
+	store.Close()
+
+	store, err = NewBoltStore(dbPath, testLogger())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if store.Count() != 5 {
+		t.Errorf("count after reopen = %d, want 5", store.Count())
+	}
+	if h, ok := store.Height(shares[4].Hash()); !ok || h != 4 {
+		t.Errorf("height = %d, %v; want 4", h, ok)
+	}
+}
+
+// BenchmarkSync100 measures adding a 100-share chain, as a sync would, to
+// each store. BoltStore serves every read from its in-memory map, so the
+// gap between the two is the cost of persisting, not of repeated reads.
+func BenchmarkSync100(b *testing.B) {
+	base := uint32(time.Now().Add(-time.Hour).Unix())
+	shares := make([]*types.Share, 100)
+	var prev [32]byte
+	for i := range shares {
+		shares[i] = makeTestShare(prev, testMiner1, base+uint32(i)*30)
+		prev = shares[i].Hash()
+	}
+
+	run := func(b *testing.B, newStore func() ShareStore) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			store := newStore()
+			chain := NewShareChain(store, NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil), 8640, testNetwork, testLogger())
+			b.StartTimer()
+			for _, s := range shares {
+				if err := chain.AddShare(s); err != nil {
+					b.Fatalf("AddShare: %v", err)
+				}
+			}
+			b.StopTimer()
+			store.Close()
+		}
+	}
+
+	b.Run("memory", func(b *testing.B) {
+		run(b, func() ShareStore { return NewMemoryStore() })
+	})
+	b.Run("bolt", func(b *testing.B) {
+		dir := b.TempDir()
+		n := 0
+		run(b, func() ShareStore {
+			n++
+			store, err := NewBoltStore(filepath.Join(dir, fmt.Sprintf("bench%d.db", n)), testLogger())
+			if err != nil {
+				b.Fatalf("NewBoltStore: %v", err)
+			}
+			return store
+		})
+	})
+}
+
+// BenchmarkBoltStore_AddBatch compares 1000 individual Adds, each its own
+// transaction, with a single AddBatch.
+func BenchmarkBoltStore_AddBatch(b *testing.B) {
+	shares := makeTestChain([32]byte{}, 1000, 1700000000)
+
+	b.Run("individual", func(b *testing.B) {
+		dir := b.TempDir()
+		for i := 0; i < b.N; i++ {
+			store, err := NewBoltStore(filepath.Join(dir, fmt.Sprintf("add%d.db", i)), testLogger())
+			if err != nil {
+				b.Fatalf("NewBoltStore: %v", err)
+			}
+			for _, s := range shares {
+				if err := store.Add(s); err != nil {
+					b.Fatalf("Add: %v", err)
+				}
+			}
+			store.Close()
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		dir := b.TempDir()
+		for i := 0; i < b.N; i++ {
+			store, err := NewBoltStore(filepath.Join(dir, fmt.Sprintf("batch%d.db", i)), testLogger())
+			if err != nil {
+				b.Fatalf("NewBoltStore: %v", err)
+			}
+			if err := store.AddBatch(shares); err != nil {
+				b.Fatalf("AddBatch: %v", err)
+			}
+			store.Close()
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package sharechain
+
+import (
+	"math/big"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+// ChainBuilder tracks the best tip of a set of shares from their
+// PrevShareHash links alone, whatever order the shares are added in. A share
+// whose parent has not been added yet is held until the parent arrives, at
+// which point it and any descendants waiting on it join the chain and
+// compete for the tip. The tip is the connected share preferred by
+// CompareTips over the fork-choice window, the same rule ShareChain applies
+// as shares arrive in order.
+//
+// A ChainBuilder is not safe for concurrent use.
+type ChainBuilder struct {
+	connected  *MemoryStore
+	forkChoice *ForkChoice
+	windowSize int
+
+	// anchors are shares outside the builder, e.g. pruned, that shares
+	// may build on. waiting holds shares by their missing parent.
+	anchors map[[32]byte]struct{}
+	waiting map[[32]byte][]*types.Share
+
+	tip     [32]byte
+	tipWork *big.Int
+	hasTip  bool
+}
+
+// NewChainBuilder creates an empty chain builder comparing chain work over
+// windowSize shares.
+func NewChainBuilder(windowSize int) *ChainBuilder {
+	connected := NewMemoryStore()
+	return &ChainBuilder{
+		connected:  connected,
+		forkChoice: NewForkChoice(connected),
+		windowSize: windowSize,
+		anchors:    make(map[[32]byte]struct{}),
+		waiting:    make(map[[32]byte][]*types.Share),
+	}
+}
+
+// AddAnchor marks hash as a share that exists but is not added to the
+// builder, such as the pruned parent of the oldest stored share, so shares
+// building on it are connected. It must be called before those shares are
+// added.
+func (b *ChainBuilder) AddAnchor(hash [32]byte) {
+	b.anchors[hash] = struct{}{}
+}
+
+// Add adds a share, connecting it and any shares waiting on it if its parent
+// is known. It reports whether the tip changed.
+func (b *ChainBuilder) Add(share *types.Share) bool {
+	hash := share.Hash()
+	if b.connected.Has(hash) {
+		return false
+	}
+
+	var zeroHash [32]byte
+	parent := share.PrevShareHash
+	_, anchored := b.anchors[parent]
+	if parent != zeroHash && !anchored && !b.connected.Has(parent) {
+		for _, w := range b.waiting[parent] {
+			if w.Hash() == hash {
+				return false
+			}
+		}
+		b.waiting[parent] = append(b.waiting[parent], share)
+		return false
+	}
+
+	oldTip, hadTip := b.tip, b.hasTip
+	queue := []*types.Share{share}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		h := s.Hash()
+		if b.connected.Add(s) != nil {
+			continue
+		}
+		b.consider(h)
+		queue = append(queue, b.waiting[h]...)
+		delete(b.waiting, h)
+	}
+	return !hadTip || b.tip != oldTip
+}
+
+// consider makes a newly connected share the tip if fork choice prefers it.
+func (b *ChainBuilder) consider(hash [32]byte) {
+	work := b.forkChoice.ChainWork(hash, b.windowSize)
+	if !b.hasTip || CompareTips(work, hash, b.tipWork, b.tip) > 0 {
+		b.tip, b.tipWork, b.hasTip = hash, work, true
+	}
+}
+
+// Tip returns the best tip among the connected shares.
+func (b *ChainBuilder) Tip() ([32]byte, bool) {
+	return b.tip, b.hasTip
+}
+
+// Connected returns the number of shares linked to the chain.
+func (b *ChainBuilder) Connected() int {
+	return b.connected.Count()
+}
+
+// Waiting returns the number of shares held for a missing parent.
+func (b *ChainBuilder) Waiting() int {
+	n := 0
+	for _, shares := range b.waiting {
+		n += len(shares)
+	}
+	return n
+}
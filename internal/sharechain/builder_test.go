@@ -0,0 +1,105 @@
+package sharechain
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+func TestChainBuilder_ReverseOrder(t *testing.T) {
+	shares := makeTestChain([32]byte{}, 5, 1700000000)
+	want := shares[4].Hash()
+
+	b := NewChainBuilder(100)
+	for i := len(shares) - 1; i > 0; i-- {
+		if b.Add(shares[i]) {
+			t.Fatalf("share %d changed the tip before genesis arrived", i)
+		}
+	}
+	if _, ok := b.Tip(); ok {
+		t.Fatal("tip set with no connected shares")
+	}
+	if b.Waiting() != 4 {
+		t.Errorf("waiting = %d, want 4", b.Waiting())
+	}
+
+	// Genesis arriving last connects the whole chain.
+	if !b.Add(shares[0]) {
+		t.Error("genesis should change the tip")
+	}
+	if tip, ok := b.Tip(); !ok || tip != want {
+		t.Errorf("tip = %x, want %x", tip[:8], want[:8])
+	}
+	if b.Connected() != 5 || b.Waiting() != 0 {
+		t.Errorf("connected = %d, waiting = %d; want 5, 0", b.Connected(), b.Waiting())
+	}
+}
+
+func TestChainBuilder_OrderIndependent(t *testing.T) {
+	// A 3-share fork and a 5-share fork off a common genesis.
+	genesis := makeTestShare([32]byte{}, testMiner1, 1700000000)
+	short := makeTestChain(genesis.Hash(), 3, 1700000030)
+	long := make([]*types.Share, 5)
+	prev := genesis.Hash()
+	for i := range long {
+		long[i] = makeTestShare(prev, testMiner2, 1700000030+uint32(i)*30)
+		prev = long[i].Hash()
+	}
+	all := append(append([]*types.Share{genesis}, short...), long...)
+	want := long[4].Hash()
+
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 20; round++ {
+		rng.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+		b := NewChainBuilder(100)
+		for _, s := range all {
+			b.Add(s)
+		}
+		if tip, _ := b.Tip(); tip != want {
+			t.Fatalf("round %d: tip = %x, want %x", round, tip[:8], want[:8])
+		}
+	}
+}
+
+func TestChainBuilder_Anchor(t *testing.T) {
+	shares := makeTestChain([32]byte{}, 4, 1700000000)
+
+	b := NewChainBuilder(100)
+	b.AddAnchor(shares[0].Hash()) // pruned
+	for i := len(shares) - 1; i > 0; i-- {
+		b.Add(shares[i])
+	}
+	want := shares[3].Hash()
+	if tip, _ := b.Tip(); tip != want {
+		t.Errorf("tip = %x, want %x", tip[:8], want[:8])
+	}
+}
+
+func TestShareChain_RebuildTip(t *testing.T) {
+	store := NewMemoryStore()
+	chain := NewShareChain(store, NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil), 8640, testNetwork, testLogger())
+	shares := makeTestChain([32]byte{}, 5, uint32(time.Now().Add(-time.Hour).Unix()))
+	for _, s := range shares {
+		if err := store.Add(s); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	// The tip update for the last shares was lost.
+	if err := store.SetTip(shares[2].Hash()); err != nil {
+		t.Fatalf("SetTip: %v", err)
+	}
+
+	moved, err := chain.RebuildTip()
+	if err != nil || !moved {
+		t.Fatalf("RebuildTip = %v, %v; want true, nil", moved, err)
+	}
+	if tip, _ := chain.Tip(); tip.Hash() != shares[4].Hash() {
+		t.Errorf("tip = %s, want %s", tip.HashHex(), shares[4].HashHex())
+	}
+
+	if moved, err := chain.RebuildTip(); err != nil || moved {
+		t.Errorf("second RebuildTip = %v, %v; want false, nil", moved, err)
+	}
+}
@@ -27,6 +27,11 @@ type Event struct {
 	Share      *types.Share
 	OldTipHash [32]byte // populated on EventReorg
 	ReorgDepth int      // shares rolled back on old fork (populated on EventReorg)
+
+	// Disconnected and Connected list the shares leaving and joining the
+	// main chain, tip-first (populated on EventReorg).
+	Disconnected [][32]byte
+	Connected    [][32]byte
 }
 
 // ShareChain manages the share chain state.
@@ -40,24 +45,88 @@ type ShareChain struct {
 
 	windowSize int
 
+	// v2Height is the height from which new shares use version 2, or
+	// negative while version 2 is not activated.
+	v2Height int64
+
+	// checkpoints pins share hashes by height (see Checkpoint);
+	// checkpointHashes is the reverse index.
+	checkpoints      map[int64][32]byte
+	checkpointHashes map[[32]byte]int64
+
 	// Event subscribers
 	subscribers []chan Event
 	subMu       sync.RWMutex
 }
 
 // NewShareChain creates a new share chain.
-func NewShareChain(store ShareStore, diffCalc *DifficultyCalculator, windowSize int, network string, logger *zap.Logger) *ShareChain {
+func NewShareChain(store ShareStore, diffCalc *DifficultyCalculator, windowSize int, network types.NetworkParams, logger *zap.Logger) *ShareChain {
 	sc := &ShareChain{
 		store:      store,
 		forkChoice: NewForkChoice(store),
-		diffCalc:   diffCalc,
+		diffCalc:   diffCalc.withMaxTarget(network.MaxShareTarget()),
 		logger:     logger,
 		windowSize: windowSize,
+		v2Height:   -1,
 	}
 	sc.validator = NewValidator(store, sc.getExpectedTargetForParent, network)
 	return sc
 }
 
+// SetMinTimeFunc sets the callback used to look up Bitcoin's MinTime for
+// blocks built on a given previous block. Shares whose timestamp is earlier
+// are rejected. It must be called before shares are added.
+func (sc *ShareChain) SetMinTimeFunc(fn func(prevBlockHash [32]byte) (uint32, bool)) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.validator.minTimeFunc = fn
+}
+
+// SetCoinbaseValueFunc sets the callback used to look up the coinbase value
+// of our template for blocks built on a given previous block. Shares whose
+// coinbase pays out noticeably more are logged, not rejected: fees depend
+// on the mempool, which peers don't share. It must be called before shares
+// are added.
+func (sc *ShareChain) SetCoinbaseValueFunc(fn func(prevBlockHash [32]byte) (int64, bool)) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.validator.coinbaseValueFunc = fn
+}
+
+// SetShareV2Height sets the sharechain height from which ShareVersionFor
+// picks version 2. Both versions stay valid either way; the height only
+// decides what this node produces, so pools can switch over together. A
+// negative height, the default, keeps producing version 1. It must be
+// called before shares are added.
+func (sc *ShareChain) SetShareV2Height(height int64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.v2Height = height
+}
+
+// ShareVersionFor returns the version and height of a new share built on
+// parentHash. Version 2 shares commit to their height, so if the parent's
+// height is unknown (it was added after its ancestry was pruned) version 1
+// is used.
+func (sc *ShareChain) ShareVersionFor(parentHash [32]byte) (uint32, int64) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	var height int64
+	var zeroHash [32]byte
+	if parentHash != zeroHash {
+		parent, ok := sc.store.Height(parentHash)
+		if !ok {
+			return types.ShareVersion1, 0
+		}
+		height = parent + 1
+	}
+	if sc.v2Height >= 0 && height >= sc.v2Height {
+		return types.ShareVersion2, height
+	}
+	return types.ShareVersion1, 0
+}
+
 // Subscribe returns a channel that receives sharechain events.
 // When the context is cancelled, the subscription is automatically removed.
 func (sc *ShareChain) Subscribe(ctx context.Context) chan Event {
@@ -113,6 +182,10 @@ func (sc *ShareChain) AddShare(share *types.Share) error {
 	if err := sc.validator.ValidateShare(share); err != nil {
 		return fmt.Errorf("invalid share: %w", err)
 	}
+	if err := sc.checkCheckpoint(share); err != nil {
+		return fmt.Errorf("invalid share: %w", err)
+	}
+	sc.warnCoinbaseValue(share)
 
 	// Store
 	if err := sc.store.Add(share); err != nil {
@@ -127,6 +200,9 @@ func (sc *ShareChain) AddShare(share *types.Share) error {
 	}
 
 	newTipHash := sc.forkChoice.SelectTip(oldTipHash, hash, sc.windowSize)
+	if hadTip && newTipHash != oldTipHash && sc.undoesCheckpoint(oldTipHash, newTipHash) {
+		newTipHash = oldTipHash
+	}
 	if err := sc.store.SetTip(newTipHash); err != nil {
 		return fmt.Errorf("set tip: %w", err)
 	}
@@ -134,17 +210,21 @@ func (sc *ShareChain) AddShare(share *types.Share) error {
 	// Emit events
 	if newTipHash != oldTipHash {
 		if hadTip && share.PrevShareHash != oldTipHash {
-			_, reorgDepth, _ := sc.forkChoice.FindCommonAncestor(oldTipHash, newTipHash, sc.windowSize)
+			disconnected, connected := sc.forkChoice.ReorgPath(oldTipHash, newTipHash, sc.windowSize)
+			reorgDepth := len(disconnected)
 			sc.logger.Info("sharechain reorg",
 				zap.String("old_tip", oldTip.HashHex()),
 				zap.String("new_tip", share.HashHex()),
 				zap.Int("reorg_depth", reorgDepth),
+				zap.Int("connected", len(connected)),
 			)
 			sc.emit(Event{
-				Type:       EventReorg,
-				Share:      share,
-				OldTipHash: oldTipHash,
-				ReorgDepth: reorgDepth,
+				Type:         EventReorg,
+				Share:        share,
+				OldTipHash:   oldTipHash,
+				ReorgDepth:   reorgDepth,
+				Disconnected: disconnected,
+				Connected:    connected,
 			})
 		}
 		sc.emit(Event{Type: EventNewTip, Share: share})
@@ -183,6 +263,10 @@ func (sc *ShareChain) AddShareQuiet(share *types.Share) error {
 	if err := sc.validator.ValidateShare(share); err != nil {
 		return fmt.Errorf("invalid share: %w", err)
 	}
+	if err := sc.checkCheckpoint(share); err != nil {
+		return fmt.Errorf("invalid share: %w", err)
+	}
+	sc.warnCoinbaseValue(share)
 
 	if err := sc.store.Add(share); err != nil {
 		return fmt.Errorf("store share: %w", err)
@@ -195,6 +279,9 @@ func (sc *ShareChain) AddShareQuiet(share *types.Share) error {
 	}
 
 	newTipHash := sc.forkChoice.SelectTip(oldTipHash, hash, sc.windowSize)
+	if hadTip && newTipHash != oldTipHash && sc.undoesCheckpoint(oldTipHash, newTipHash) {
+		newTipHash = oldTipHash
+	}
 	if err := sc.store.SetTip(newTipHash); err != nil {
 		return fmt.Errorf("set tip: %w", err)
 	}
@@ -208,6 +295,19 @@ func (sc *ShareChain) AddShareQuiet(share *types.Share) error {
 	return nil
 }
 
+// warnCoinbaseValue logs a share paying noticeably more than our template
+// for the same Bitcoin block could; see Validator.coinbaseOverpay.
+func (sc *ShareChain) warnCoinbaseValue(share *types.Share) {
+	if total, expected, over := sc.validator.coinbaseOverpay(share); over {
+		sc.logger.Warn("share coinbase pays more than our template",
+			zap.String("hash", share.HashHex()),
+			zap.String("miner", share.MinerAddress),
+			zap.Int64("coinbase_total", total),
+			zap.Int64("template_value", expected),
+		)
+	}
+}
+
 // Tip returns the current chain tip.
 func (sc *ShareChain) Tip() (*types.Share, bool) {
 	sc.mu.RLock()
@@ -215,6 +315,19 @@ func (sc *ShareChain) Tip() (*types.Share, bool) {
 	return sc.store.Tip()
 }
 
+// TipWork returns the current tip along with its chain work over the
+// fork-choice window.
+func (sc *ShareChain) TipWork() (*types.Share, *big.Int, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	tip, ok := sc.store.Tip()
+	if !ok {
+		return nil, nil, false
+	}
+	return tip, sc.forkChoice.ChainWork(tip.Hash(), sc.windowSize), true
+}
+
 // GetShare returns a share by hash.
 func (sc *ShareChain) GetShare(hash [32]byte) (*types.Share, bool) {
 	sc.mu.RLock()
@@ -236,6 +349,26 @@ func (sc *ShareChain) Count() int {
 	return sc.store.Count()
 }
 
+// MaxShareTarget returns the network's easiest share target.
+func (sc *ShareChain) MaxShareTarget() *big.Int {
+	return sc.validator.network.MaxShareTarget()
+}
+
+// MeetsMinimumWork reports whether a share's PoW meets the network's
+// easiest share target. Unlike full validation it needs no parent, so it
+// screens shares that can't be placed yet.
+func (sc *ShareChain) MeetsMinimumWork(share *types.Share) bool {
+	return share.MeetsTarget(sc.MaxShareTarget())
+}
+
+// Height returns a stored share's height above genesis, or false if it is
+// unknown.
+func (sc *ShareChain) Height(hash [32]byte) (int64, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.store.Height(hash)
+}
+
 // GetExpectedTarget returns the expected share target for the next share.
 func (sc *ShareChain) GetExpectedTarget() *big.Int {
 	sc.mu.RLock()
@@ -258,11 +391,11 @@ func (sc *ShareChain) GetExpectedTargetForParent(parentHash [32]byte) *big.Int {
 func (sc *ShareChain) getExpectedTarget() *big.Int {
 	tip, ok := sc.store.Tip()
 	if !ok {
-		return new(big.Int).Set(MaxShareTarget)
+		return sc.diffCalc.MaxTarget()
 	}
 
 	tipHash := tip.Hash()
-	ancestors := sc.store.GetAncestors(tipHash, DifficultyAdjustmentWindow)
+	ancestors := sc.store.GetAncestors(tipHash, sc.diffCalc.Window())
 	return sc.diffCalc.NextTarget(ancestors)
 }
 
@@ -271,10 +404,10 @@ func (sc *ShareChain) getExpectedTarget() *big.Int {
 func (sc *ShareChain) getExpectedTargetForParent(parentHash [32]byte) *big.Int {
 	var zeroHash [32]byte
 	if parentHash == zeroHash {
-		return new(big.Int).Set(MaxShareTarget)
+		return sc.diffCalc.MaxTarget()
 	}
 
-	ancestors := sc.store.GetAncestors(parentHash, DifficultyAdjustmentWindow)
+	ancestors := sc.store.GetAncestors(parentHash, sc.diffCalc.Window())
 	newTarget := sc.diffCalc.NextTarget(ancestors)
 
 	// Log difficulty adjustments
@@ -389,12 +522,93 @@ func (sc *ShareChain) PruneOldShares(maxKeep int) int {
 	return pruned
 }
 
+// Prune deletes shares more than keepDepth behind the tip, keeping any
+// recent forks. keepDepth is raised to at least twice the difficulty
+// adjustment window so retargeting always has full history. Stores that
+// can compact do so afterwards, without the chain lock held.
+// Returns the number of shares pruned.
+func (sc *ShareChain) Prune(keepDepth int) (int, error) {
+	sc.mu.Lock()
+	if keepDepth < 2*sc.diffCalc.Window() {
+		keepDepth = 2 * sc.diffCalc.Window()
+	}
+
+	store := sc.store
+	pruned, err := store.Prune(keepDepth)
+	if pruned > 0 {
+		sc.logger.Info("pruned sharechain",
+			zap.Int("pruned", pruned),
+			zap.Int("remaining", store.Count()),
+			zap.Int("keep_depth", keepDepth),
+		)
+	}
+	sc.mu.Unlock()
+
+	type compacter interface {
+		MaybeCompact() (bool, error)
+	}
+	if c, ok := store.(compacter); ok && pruned > 0 {
+		if compacted, err := c.MaybeCompact(); err != nil {
+			// The deletes are committed; a failed compaction only means the
+			// file keeps its free pages until the next attempt.
+			sc.logger.Warn("sharechain compaction failed", zap.Error(err))
+		} else if compacted {
+			sc.logger.Info("compacted sharechain database")
+		}
+	}
+	return pruned, err
+}
+
+// RebuildTip recomputes the tip from every stored share with a ChainBuilder
+// and moves the store's tip to it if they differ, e.g. when shares were
+// written but the tip update was lost. Shares whose parent was pruned start
+// the chain. It reports whether the tip moved.
+func (sc *ShareChain) RebuildTip() (bool, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	builder := NewChainBuilder(sc.windowSize)
+	hashes := sc.store.AllHashes()
+	var zeroHash [32]byte
+	for _, h := range hashes {
+		if share, ok := sc.store.Get(h); ok && share.PrevShareHash != zeroHash && !sc.store.Has(share.PrevShareHash) {
+			builder.AddAnchor(share.PrevShareHash)
+		}
+	}
+	for _, h := range hashes {
+		if share, ok := sc.store.Get(h); ok {
+			builder.Add(share)
+		}
+	}
+
+	newTip, ok := builder.Tip()
+	if !ok {
+		return false, nil
+	}
+	var oldTipHash [32]byte
+	oldTip, hadTip := sc.store.Tip()
+	if hadTip {
+		oldTipHash = oldTip.Hash()
+		if oldTipHash == newTip || sc.undoesCheckpoint(oldTipHash, newTip) {
+			return false, nil
+		}
+	}
+	if err := sc.store.SetTip(newTip); err != nil {
+		return false, fmt.Errorf("set tip: %w", err)
+	}
+	sc.logger.Warn("stored sharechain tip was not the best tip, moved it",
+		zap.String("old_tip", util.HashToHex(oldTipHash)),
+		zap.String("new_tip", util.HashToHex(newTip)),
+	)
+	return true, nil
+}
+
 // ValidateLoaded validates all shares loaded from disk.
 // Walks the main chain from genesis to tip, validating each share in order.
 // Returns an error on the first invalid share found.
 func (sc *ShareChain) ValidateLoaded() error {
-	sc.mu.RLock()
-	defer sc.mu.RUnlock()
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
 
 	tip, ok := sc.store.Tip()
 	if !ok {
@@ -420,6 +634,9 @@ func (sc *ShareChain) ValidateLoaded() error {
 		if err := sc.validator.ValidateShare(share); err != nil {
 			return fmt.Errorf("invalid share %x: %w", share.Hash(), err)
 		}
+		if err := sc.checkCheckpoint(share); err != nil {
+			return fmt.Errorf("invalid share %x: %w", share.Hash(), err)
+		}
 	}
 
 	sc.logger.Info("loaded shares validated", zap.Int("count", len(ancestors)))
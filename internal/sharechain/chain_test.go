@@ -1,21 +1,25 @@
 package sharechain
 
 import (
+	"bytes"
 	"context"
 	"math/big"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/djkazic/p2pool-go/internal/types"
 	"github.com/djkazic/p2pool-go/pkg/util"
+	"github.com/djkazic/p2pool-go/testutil"
 
 	"go.uber.org/zap"
 )
 
+var testNetwork = types.TestNet3Params
+
 const (
-	testNetwork = "testnet3"
-	testMiner1  = "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"
-	testMiner2  = "tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy"
+	testMiner1 = "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"
+	testMiner2 = "tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy"
 )
 
 func testLogger() *zap.Logger {
@@ -148,9 +152,75 @@ func TestMemoryStore_GetAncestors(t *testing.T) {
 	}
 }
 
+func TestMemoryStore_GetByHeight(t *testing.T) {
+	store := NewMemoryStore()
+	main := addChain(t, store, [32]byte{}, testMiner1, 5, 1700000000)
+	fork := addChain(t, store, main[2].Hash(), testMiner2, 3, 1700001000)
+
+	if h, ok := store.Height(main[4].Hash()); !ok || h != 4 {
+		t.Errorf("main tip height = %d, %v; want 4", h, ok)
+	}
+	if h, ok := store.Height(fork[2].Hash()); !ok || h != 5 {
+		t.Errorf("fork tip height = %d, %v; want 5", h, ok)
+	}
+	if _, ok := store.Height([32]byte{0xff}); ok {
+		t.Error("unknown share should have no height")
+	}
+
+	if err := store.SetTip(main[4].Hash()); err != nil {
+		t.Fatalf("SetTip: %v", err)
+	}
+	shares, ok := store.GetByHeight(3)
+	if !ok || len(shares) != 2 {
+		t.Fatalf("GetByHeight(3) = %d shares, want 2", len(shares))
+	}
+	if shares[0].Hash() != main[3].Hash() {
+		t.Error("main-chain share should come first")
+	}
+
+	// Reorging to the fork moves its shares to the front.
+	if err := store.SetTip(fork[2].Hash()); err != nil {
+		t.Fatalf("SetTip: %v", err)
+	}
+	shares, _ = store.GetByHeight(3)
+	if shares[0].Hash() != fork[0].Hash() {
+		t.Error("fork share should come first after reorg")
+	}
+	if _, ok := store.GetByHeight(6); ok {
+		t.Error("no share should exist above the tip")
+	}
+
+	if err := store.Delete(fork[2].Hash()); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := store.GetByHeight(5); ok {
+		t.Error("deleted share still indexed")
+	}
+}
+
+func TestMemoryStore_GetAncestorsCycle(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Real share hashes commit to their parent, so a cycle can only come
+	// from corrupt data; plant one directly under chosen keys.
+	self := [32]byte{1}
+	store.shares[self] = makeTestShare(self, testMiner1, 1700000000)
+	if got := store.GetAncestors(self, 1000); len(got) != 1 {
+		t.Errorf("self-referential share: got %d ancestors, want 1", len(got))
+	}
+
+	a, b, c := [32]byte{2}, [32]byte{3}, [32]byte{4}
+	store.shares[a] = makeTestShare(b, testMiner1, 1700000030)
+	store.shares[b] = makeTestShare(c, testMiner1, 1700000060)
+	store.shares[c] = makeTestShare(a, testMiner1, 1700000090)
+	if got := store.GetAncestors(a, MaxAncestors*2); len(got) != 3 {
+		t.Errorf("cycle: got %d ancestors, want 3", len(got))
+	}
+}
+
 func TestShareChain_AddShare(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	events := chain.Subscribe(context.Background())
@@ -188,7 +258,7 @@ func TestShareChain_AddShare(t *testing.T) {
 
 func TestShareChain_LinearChain(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	var prevHash [32]byte
@@ -209,7 +279,7 @@ func TestShareChain_LinearChain(t *testing.T) {
 
 func TestShareChain_DuplicateIgnored(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	share := makeTestShare([32]byte{}, testMiner1, uint32(time.Now().Unix()))
@@ -225,7 +295,7 @@ func TestShareChain_DuplicateIgnored(t *testing.T) {
 
 func TestShareChain_RejectsInvalid(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	// Share with missing miner address should be rejected
@@ -273,6 +343,61 @@ func TestForkChoice_SelectTip(t *testing.T) {
 	}
 }
 
+func TestForkChoice_EqualWorkTieBreak(t *testing.T) {
+	now := uint32(time.Now().Unix())
+	parent := makeTestShare([32]byte{}, testMiner1, now-30)
+	a := makeTestShare(parent.Hash(), testMiner1, now)
+	b := makeTestShare(parent.Hash(), testMiner2, now)
+
+	// The lower hash, read as a little-endian number, wins.
+	aHash, bHash := a.Hash(), b.Hash()
+	aInt := new(big.Int).SetBytes(util.ReverseBytes(aHash[:]))
+	bInt := new(big.Int).SetBytes(util.ReverseBytes(bHash[:]))
+	want := bHash
+	if aInt.Cmp(bInt) < 0 {
+		want = aHash
+	}
+
+	for _, order := range [][]*types.Share{{a, b}, {b, a}} {
+		diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+		chain := NewShareChain(NewMemoryStore(), diffCalc, 8640, testNetwork, testLogger())
+		for _, s := range append([]*types.Share{parent}, order...) {
+			if err := chain.AddShare(s); err != nil {
+				t.Fatalf("AddShare: %v", err)
+			}
+		}
+		tip, _ := chain.Tip()
+		if tip.Hash() != want {
+			t.Errorf("arrival order %s, %s: tip = %s, want %x", order[0].HashHex(), order[1].HashHex(), tip.HashHex(), want)
+		}
+	}
+}
+
+func TestCompareTips(t *testing.T) {
+	low := [32]byte{31: 0x01}
+	high := [32]byte{0: 0x01, 31: 0x02}
+	one, two := big.NewInt(1), big.NewInt(2)
+
+	tests := []struct {
+		workA *big.Int
+		hashA [32]byte
+		workB *big.Int
+		hashB [32]byte
+		want  int
+	}{
+		{two, high, one, low, 1}, // more work beats a lower hash
+		{one, low, two, high, -1},
+		{one, low, one, high, 1}, // equal work: lower hash wins
+		{one, high, one, low, -1},
+		{one, low, one, low, 0},
+	}
+	for i, tt := range tests {
+		if got := CompareTips(tt.workA, tt.hashA, tt.workB, tt.hashB); got != tt.want {
+			t.Errorf("case %d: CompareTips = %d, want %d", i, got, tt.want)
+		}
+	}
+}
+
 func TestForkChoice_FindCommonAncestor(t *testing.T) {
 	store := NewMemoryStore()
 	fc := NewForkChoice(store)
@@ -311,7 +436,7 @@ func TestForkChoice_FindCommonAncestor(t *testing.T) {
 
 func TestShareChain_ReorgEventFields(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	events := chain.Subscribe(context.Background())
@@ -379,11 +504,25 @@ func TestShareChain_ReorgEventFields(t *testing.T) {
 	if newTipHash == oldTip {
 		t.Error("new tip should differ from old tip")
 	}
+	if len(reorgEvent.Disconnected) != reorgEvent.ReorgDepth {
+		t.Errorf("disconnected = %d shares, want %d", len(reorgEvent.Disconnected), reorgEvent.ReorgDepth)
+	}
+	if len(reorgEvent.Disconnected) == 0 || reorgEvent.Disconnected[0] != oldTip {
+		t.Error("disconnected list should start at the old tip")
+	}
+	if len(reorgEvent.Connected) == 0 || reorgEvent.Connected[0] != newTipHash {
+		t.Error("connected list should start at the new tip")
+	}
+	for _, h := range append(reorgEvent.Disconnected, reorgEvent.Connected...) {
+		if h == genesisHash {
+			t.Error("common ancestor should not appear in reorg path")
+		}
+	}
 }
 
 func TestShareChain_PruneOrphans(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	baseTime := time.Now().Add(-5 * time.Minute)
@@ -478,6 +617,48 @@ func TestMemoryStore_DeleteAndAllHashes(t *testing.T) {
 	}
 }
 
+func TestMemoryStore_Prune(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Main chain: 10 shares
+	var main []*types.Share
+	prev := [32]byte{}
+	for i := 0; i < 10; i++ {
+		s := makeTestShare(prev, testMiner1, uint32(1700000000+i*30))
+		_ = store.Add(s)
+		main = append(main, s)
+		prev = s.Hash()
+	}
+	_ = store.SetTip(prev)
+
+	// Recent fork off main[7] and an old fork off main[1]
+	recentFork := makeTestShare(main[7].Hash(), testMiner2, 1700000300)
+	oldFork := makeTestShare(main[1].Hash(), testMiner2, 1700000300)
+	_ = store.Add(recentFork)
+	_ = store.Add(oldFork)
+
+	pruned, err := store.Prune(4)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+
+	// Keep main[6..9] and the recent fork; drop main[0..5] and the old fork.
+	if pruned != 7 {
+		t.Errorf("pruned = %d, want 7", pruned)
+	}
+	for i, s := range main {
+		if got, want := store.Has(s.Hash()), i >= 6; got != want {
+			t.Errorf("main[%d] present = %v, want %v", i, got, want)
+		}
+	}
+	if !store.Has(recentFork.Hash()) {
+		t.Error("recent fork should be kept")
+	}
+	if store.Has(oldFork.Hash()) {
+		t.Error("old fork should be pruned")
+	}
+}
+
 // drainEvents reads all pending events from the channel without blocking.
 func drainEvents(ch chan Event) {
 	for {
@@ -490,7 +671,7 @@ func drainEvents(ch chan Event) {
 }
 
 func TestDifficultyCalculator_TooFast(t *testing.T) {
-	dc := NewDifficultyCalculator(30 * time.Second)
+	dc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 
 	// Simulate shares coming in at 15s intervals (too fast, target is 30s)
 	// shares[0] is newest, shares[len-1] is oldest
@@ -512,7 +693,7 @@ func TestDifficultyCalculator_TooFast(t *testing.T) {
 }
 
 func TestDifficultyCalculator_TooSlow(t *testing.T) {
-	dc := NewDifficultyCalculator(30 * time.Second)
+	dc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 
 	// Simulate shares at 60s intervals (too slow)
 	// Use a target that's harder than MaxShareTarget
@@ -534,11 +715,216 @@ func TestDifficultyCalculator_TooSlow(t *testing.T) {
 	}
 }
 
+func TestDifficultyCalculator_JitteredTimestamps(t *testing.T) {
+	dc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+	target := util.CompactToTarget(util.TargetToCompact(new(big.Int).Div(MaxShareTarget, big.NewInt(1000))))
+
+	// 30 version 2 shares exactly on target, newest first.
+	makeWindow := func() []*types.Share {
+		shares := make([]*types.Share, 30)
+		for i := range shares {
+			shares[i] = &types.Share{
+				Header:       types.ShareHeader{Timestamp: uint32(1700000000 + (29-i)*30)},
+				ShareVersion: types.ShareVersion2,
+				ShareTarget:  target,
+			}
+		}
+		return shares
+	}
+	steady := dc.NextTarget(makeWindow())
+	if steady.Cmp(target) != 0 {
+		t.Fatalf("on-target window retargeted to %x, want %x", steady, target)
+	}
+
+	// An out-of-order share may drop at most its own interval from the
+	// span, so difficulty can rise by a few percent, not the 4x clamp.
+	spiked := func(got *big.Int) bool {
+		floor := new(big.Int).Mul(steady, big.NewInt(9))
+		floor.Div(floor, big.NewInt(10))
+		return got.Cmp(floor) < 0
+	}
+
+	// Back-date the newest share to just after the oldest one, as a peer
+	// could within MaxTimePast. Plain newest-minus-oldest would see almost
+	// no elapsed time and jump difficulty by the full 4x clamp.
+	backdated := makeWindow()
+	backdated[0].Header.Timestamp = backdated[len(backdated)-1].Header.Timestamp + 1
+	if got := dc.NextTarget(backdated); spiked(got) {
+		t.Errorf("back-dated newest share spiked difficulty: %x vs %x", got, steady)
+	}
+
+	// Forward-date the oldest share past the rest of the window.
+	forward := makeWindow()
+	forward[len(forward)-1].Header.Timestamp = forward[0].Header.Timestamp + 60
+	if got := dc.NextTarget(forward); spiked(got) {
+		t.Errorf("forward-dated oldest share spiked difficulty: %x vs %x", got, steady)
+	}
+
+	// Jitter every timestamp by up to ±2 minutes.
+	jittered := makeWindow()
+	for i, s := range jittered {
+		s.Header.Timestamp = uint32(int64(s.Header.Timestamp) + int64((i*53)%241) - 120)
+	}
+	if got := dc.NextTarget(jittered); spiked(got) {
+		t.Errorf("jittered timestamps spiked difficulty: %x vs %x", got, steady)
+	}
+
+	// One share in the middle dated two hours ahead can't stretch the span.
+	outlier := makeWindow()
+	outlier[15].Header.Timestamp += 7200
+	ceiling := new(big.Int).Mul(steady, big.NewInt(11))
+	ceiling.Div(ceiling, big.NewInt(10))
+	if got := dc.NextTarget(outlier); got.Cmp(ceiling) > 0 {
+		t.Errorf("forward-dated share dropped difficulty: %x vs %x", got, steady)
+	}
+
+	// Version 1 windows keep the newest-minus-oldest span, so they retarget
+	// as before version 2.
+	legacy := makeWindow()
+	for _, s := range legacy {
+		s.ShareVersion = types.ShareVersion1
+	}
+	legacy[0].Header.Timestamp = legacy[len(legacy)-1].Header.Timestamp + 1
+	want := new(big.Int).Div(target, big.NewInt(4)) // the full 4x clamp
+	if got := dc.NextTarget(legacy); got.Cmp(util.CompactToTarget(util.TargetToCompact(want))) != 0 {
+		t.Errorf("version 1 window retargeted to %x, want %x", got, want)
+	}
+}
+
+func TestDifficultyCalculator_Deterministic(t *testing.T) {
+	// Irregular spacing and slowly drifting targets, newest first.
+	base := new(big.Int).Div(MaxShareTarget, big.NewInt(1000))
+	shares := make([]*types.Share, 100)
+	ts := uint32(1700000000)
+	for i := len(shares) - 1; i >= 0; i-- {
+		ts += uint32(3 + (i*7)%23)
+		target := new(big.Int).Add(base, big.NewInt(int64(i)*1_000_003))
+		shares[i] = &types.Share{
+			Header:      types.ShareHeader{Timestamp: ts},
+			ShareTarget: util.CompactToTarget(util.TargetToCompact(target)),
+		}
+	}
+
+	a := NewDifficultyCalculator(10*time.Second, 24, nil)
+	b := NewDifficultyCalculator(10*time.Second, 24, nil)
+	for n := 2; n <= len(shares); n++ {
+		ta, tb := a.NextTarget(shares[:n]), b.NextTarget(shares[:n])
+		if !bytes.Equal(ta.Bytes(), tb.Bytes()) {
+			t.Fatalf("window of %d shares: targets differ: %x vs %x", n, ta, tb)
+		}
+	}
+
+	// The window is part of consensus: a different one changes the result.
+	wide := NewDifficultyCalculator(10*time.Second, 96, nil)
+	if a.NextTarget(shares).Cmp(wide.NextTarget(shares)) == 0 {
+		t.Error("different windows should produce different targets")
+	}
+}
+
+// lwmaWindow builds n shares newest first, spaced by spacing(i) seconds
+// (i counting from the oldest), all at target.
+func lwmaWindow(n int, target *big.Int, spacing func(i int) uint32) []*types.Share {
+	shares := make([]*types.Share, n)
+	ts := uint32(1700000000)
+	for i := 0; i < n; i++ {
+		ts += spacing(i)
+		shares[n-1-i] = &types.Share{
+			Header:      types.ShareHeader{Timestamp: ts},
+			ShareTarget: target,
+		}
+	}
+	return shares
+}
+
+func TestLWMA_AdjustsToSolveTimes(t *testing.T) {
+	dc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, LWMAAlgo{})
+	target := util.CompactToTarget(util.TargetToCompact(new(big.Int).Div(MaxShareTarget, big.NewInt(1000))))
+
+	fast := dc.NextTarget(lwmaWindow(50, target, func(int) uint32 { return 10 }))
+	if fast.Cmp(target) >= 0 {
+		t.Error("target should decrease when shares come too fast")
+	}
+	slow := dc.NextTarget(lwmaWindow(50, target, func(int) uint32 { return 90 }))
+	if slow.Cmp(target) <= 0 {
+		t.Error("target should increase when shares come too slow")
+	}
+	steady := dc.NextTarget(lwmaWindow(50, target, func(int) uint32 { return 30 }))
+	if util.TargetToCompact(steady) != util.TargetToCompact(target) {
+		t.Errorf("on-target shares changed bits: %08x -> %08x",
+			util.TargetToCompact(target), util.TargetToCompact(steady))
+	}
+
+	// Recent solve times weigh more: a burst at the end of an otherwise
+	// on-target window moves the target more than the same burst at the start.
+	late := dc.NextTarget(lwmaWindow(50, target, func(i int) uint32 {
+		if i >= 40 {
+			return 5
+		}
+		return 30
+	}))
+	early := dc.NextTarget(lwmaWindow(50, target, func(i int) uint32 {
+		if i < 10 {
+			return 5
+		}
+		return 30
+	}))
+	if late.Cmp(early) >= 0 {
+		t.Error("a recent burst should raise difficulty more than an old one")
+	}
+}
+
+func TestLWMA_Deterministic(t *testing.T) {
+	// Irregular spacing, out-of-order timestamps and drifting targets.
+	shares := lwmaWindow(100, nil, func(i int) uint32 { return uint32((i*37)%97 + 1) })
+	base := new(big.Int).Div(MaxShareTarget, big.NewInt(5000))
+	for i, s := range shares {
+		target := new(big.Int).Add(base, big.NewInt(int64(i)*7_777_777))
+		s.ShareTarget = util.CompactToTarget(util.TargetToCompact(target))
+		if i%11 == 0 {
+			s.Header.Timestamp -= 200
+		}
+	}
+
+	// A second node sees the same shares after a P2P round trip, where
+	// targets travel as compact bits.
+	remote := make([]*types.Share, len(shares))
+	for i, s := range shares {
+		remote[i] = &types.Share{
+			Header:      s.Header,
+			ShareTarget: util.CompactToTarget(util.TargetToCompact(s.ShareTarget)),
+		}
+	}
+
+	a := NewDifficultyCalculator(30*time.Second, 60, LWMAAlgo{})
+	b := NewDifficultyCalculator(30*time.Second, 60, LWMAAlgo{})
+	for n := 2; n <= len(shares); n++ {
+		ta, tb := a.NextTarget(shares[:n]), b.NextTarget(remote[:n])
+		if !bytes.Equal(ta.Bytes(), tb.Bytes()) {
+			t.Fatalf("window of %d shares: targets differ: %x vs %x", n, ta, tb)
+		}
+	}
+}
+
+func TestDifficultyAlgoByName(t *testing.T) {
+	for _, name := range []string{"ratio", "lwma"} {
+		algo, err := DifficultyAlgoByName(name)
+		if err != nil {
+			t.Fatalf("DifficultyAlgoByName(%q): %v", name, err)
+		}
+		if algo.Name() != name {
+			t.Errorf("Name() = %q, want %q", algo.Name(), name)
+		}
+	}
+	if _, err := DifficultyAlgoByName("sha3"); err == nil {
+		t.Error("expected error for unknown algorithm")
+	}
+}
+
 // --- New validation tests ---
 
 func TestValidation_RejectsShareTargetMismatch(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	// Create a share with correct PoW but inflated ShareTarget
@@ -552,9 +938,37 @@ func TestValidation_RejectsShareTargetMismatch(t *testing.T) {
 	}
 }
 
+func TestValidation_RejectsNonCanonicalTargets(t *testing.T) {
+	store := NewMemoryStore()
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
+
+	// A target one above consensus compacts to the same bits, but is not
+	// what those bits expand to.
+	share := makeTestShare([32]byte{}, testMiner1, uint32(time.Now().Unix()))
+	share.ShareTarget = new(big.Int).Add(maxTarget(), big.NewInt(1))
+	if err := chain.AddShare(share); err == nil {
+		t.Error("expected rejection for a target with extra precision")
+	}
+
+	// 0x21007fff expands to a valid target, but its canonical form is
+	// 0x207fff00.
+	share = makeTestShare([32]byte{}, testMiner1, uint32(time.Now().Unix()))
+	share.Header.Bits = 0x21007fff
+	for nonce := uint32(0); ; nonce++ {
+		share.Header.Nonce = nonce
+		if util.HashMeetsTarget(share.Header.Hash(), share.ShareTarget) {
+			break
+		}
+	}
+	if err := chain.AddShare(share); err == nil {
+		t.Error("expected rejection for non-canonical header bits")
+	}
+}
+
 func TestValidation_RejectsWrongCoinbaseCommitment(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	share := makeTestShare([32]byte{}, testMiner1, uint32(time.Now().Unix()))
@@ -578,9 +992,131 @@ func TestValidation_RejectsWrongCoinbaseCommitment(t *testing.T) {
 	}
 }
 
+// makeTestShareV2 returns a version 2 share at height by minerAddr whose coinbase
+// pays both test miners.
+func makeTestShareV2(prevShareHash [32]byte, minerAddr string, height int64, timestamp uint32) *types.Share {
+	s := makeTestShare(prevShareHash, minerAddr, timestamp)
+	s.ShareVersion = types.ShareVersion2
+	s.Height = height
+	commitment := types.BuildShareCommitment(s.CommitmentHash())
+	payouts := []types.PayoutEntry{
+		{Address: testMiner1, Amount: 2500000000},
+		{Address: testMiner2, Amount: 2500000000},
+	}
+	coinbaseTx, _, err := types.NewCoinbaseBuilder(testNetwork).BuildCoinbase(800000, commitment, payouts, "", 8)
+	if err != nil {
+		panic("makeTestShareV2: BuildCoinbase failed: " + err.Error())
+	}
+	s.CoinbaseTx = coinbaseTx
+	return s
+}
+
+func TestValidation_V2BindsMinerAddress(t *testing.T) {
+	newChain := func() *ShareChain {
+		diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+		return NewShareChain(NewMemoryStore(), diffCalc, 8640, testNetwork, testLogger())
+	}
+	now := uint32(time.Now().Unix())
+
+	if err := newChain().AddShare(makeTestShareV2([32]byte{}, testMiner1, 0, now)); err != nil {
+		t.Fatalf("valid v2 share rejected: %v", err)
+	}
+
+	// A relayer claims the share for the other miner the coinbase pays,
+	// keeping the header and so the PoW.
+	swapped := makeTestShareV2([32]byte{}, testMiner1, 0, now)
+	swapped.MinerAddress = testMiner2
+	if err := newChain().AddShare(swapped); err == nil {
+		t.Error("expected rejection for a v2 share with a swapped miner address")
+	}
+
+	// Version 1 shares are still accepted, address swaps included; that
+	// gap is what version 2 closes.
+	v1 := makeTestShareV2([32]byte{}, testMiner1, 0, now)
+	v1.ShareVersion = types.ShareVersion1
+	v1.CoinbaseTx = makeTestShare([32]byte{}, testMiner2, now).CoinbaseTx
+	v1.MinerAddress = testMiner2
+	if err := newChain().AddShare(v1); err != nil {
+		t.Errorf("v1 share rejected: %v", err)
+	}
+}
+
+func TestShareChain_MixedVersions(t *testing.T) {
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+	chain := NewShareChain(NewMemoryStore(), diffCalc, 8640, testNetwork, testLogger())
+	// Without an activation height only version 1 is produced.
+	if got, _ := chain.ShareVersionFor([32]byte{}); got != types.ShareVersion1 {
+		t.Errorf("ShareVersionFor before activation = %d, want 1", got)
+	}
+	chain.SetShareV2Height(3)
+
+	var prev [32]byte
+	base := time.Now().Add(-5 * time.Minute)
+	for i := 0; i < 6; i++ {
+		// Nodes switch at the activation height, but a straggler may
+		// still produce a version 1 share after it.
+		want, wantHeight := types.ShareVersion1, int64(0)
+		if i >= 3 {
+			want, wantHeight = types.ShareVersion2, int64(i)
+		}
+		if got, height := chain.ShareVersionFor(prev); got != want || height != wantHeight {
+			t.Errorf("height %d: ShareVersionFor = %d, %d; want %d, %d", i, got, height, want, wantHeight)
+		}
+
+		ts := uint32(base.Unix()) + uint32(i)*30
+		share := makeTestShare(prev, testMiner1, ts)
+		if i%2 == 1 {
+			share = makeTestShareV2(prev, testMiner1, int64(i), ts)
+		}
+		if err := chain.AddShare(share); err != nil {
+			t.Fatalf("AddShare(height %d, v%d): %v", i, share.ShareVersion, err)
+		}
+		prev = share.Hash()
+	}
+	if tip, _ := chain.Tip(); tip.Hash() != prev {
+		t.Error("tip is not the last share of the mixed-version chain")
+	}
+
+	// A version 2 share can't commit to a height it doesn't know.
+	if got, _ := chain.ShareVersionFor([32]byte{0xff}); got != types.ShareVersion1 {
+		t.Errorf("ShareVersionFor(unknown parent) = %d, want 1", got)
+	}
+}
+
+func TestValidation_V2Height(t *testing.T) {
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+	chain := NewShareChain(NewMemoryStore(), diffCalc, 8640, testNetwork, testLogger())
+	now := uint32(time.Now().Unix())
+
+	genesis := makeTestShareV2([32]byte{}, testMiner1, 0, now-60)
+	if err := chain.AddShare(genesis); err != nil {
+		t.Fatalf("genesis rejected: %v", err)
+	}
+	if err := chain.AddShare(makeTestShareV2(genesis.Hash(), testMiner1, 2, now-30)); err == nil {
+		t.Error("expected rejection for a share skipping a height")
+	}
+
+	// Changing the height after the coinbase was built breaks the commitment.
+	forged := makeTestShareV2(genesis.Hash(), testMiner1, 2, now-30)
+	forged.Height = 1
+	if err := chain.AddShare(forged); err == nil {
+		t.Error("expected rejection for a height the coinbase doesn't commit to")
+	}
+
+	v1 := makeTestShare(genesis.Hash(), testMiner1, now-30)
+	v1.Height = 1
+	if err := chain.AddShare(v1); err == nil {
+		t.Error("expected rejection for a version 1 share carrying a height")
+	}
+
+	if err := chain.AddShare(makeTestShareV2(genesis.Hash(), testMiner1, 1, now-30)); err != nil {
+		t.Errorf("share at parent height + 1 rejected: %v", err)
+	}
+}
+
 func TestValidation_RejectsMinerNotInOutputs(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	share := makeTestShare([32]byte{}, testMiner1, uint32(time.Now().Unix()))
@@ -605,7 +1141,7 @@ func TestValidation_RejectsMinerNotInOutputs(t *testing.T) {
 
 func TestValidation_ExpectedTargetFromParent(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	baseTime := time.Now().Add(-5 * time.Minute)
@@ -644,7 +1180,7 @@ func TestValidation_ExpectedTargetFromParent(t *testing.T) {
 
 func TestValidation_RejectsWrongShareVersion(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	share := makeTestShare([32]byte{}, testMiner1, uint32(time.Now().Unix()))
@@ -658,7 +1194,7 @@ func TestValidation_RejectsWrongShareVersion(t *testing.T) {
 
 func TestValidation_RejectsInvalidMinerAddress(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	share := makeTestShare([32]byte{}, testMiner1, uint32(time.Now().Unix()))
@@ -672,7 +1208,7 @@ func TestValidation_RejectsInvalidMinerAddress(t *testing.T) {
 
 func TestValidation_RejectsMissingCoinbase(t *testing.T) {
 	store := NewMemoryStore()
-	diffCalc := NewDifficultyCalculator(30 * time.Second)
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
 	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
 
 	share := makeTestShare([32]byte{}, testMiner1, uint32(time.Now().Unix()))
@@ -683,3 +1219,258 @@ func TestValidation_RejectsMissingCoinbase(t *testing.T) {
 		t.Error("expected rejection for missing coinbase")
 	}
 }
+
+func TestValidation_RejectsTimestampBeforeMinTime(t *testing.T) {
+	store := NewMemoryStore()
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
+
+	now := uint32(time.Now().Unix())
+	var knownBlock [32]byte // makeTestShare uses PrevShareHash as PrevBlockHash
+	chain.SetMinTimeFunc(func(prevBlockHash [32]byte) (uint32, bool) {
+		return now, prevBlockHash == knownBlock
+	})
+
+	early := makeTestShare([32]byte{}, testMiner1, now-1)
+	if err := chain.AddShare(early); err == nil {
+		t.Error("expected rejection for timestamp before min time")
+	}
+
+	genesis := makeTestShare([32]byte{}, testMiner1, now)
+	if err := chain.AddShare(genesis); err != nil {
+		t.Fatalf("share at min time rejected: %v", err)
+	}
+
+	// Shares on a Bitcoin block we have no template for are not checked.
+	child := makeTestShare(genesis.Hash(), testMiner1, now-1)
+	if err := chain.AddShare(child); err != nil {
+		t.Errorf("share on unknown block rejected: %v", err)
+	}
+}
+
+func TestValidation_CoinbaseOverpay(t *testing.T) {
+	store := NewMemoryStore()
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+	chain := NewShareChain(store, diffCalc, 8640, testNetwork, testLogger())
+
+	// makeTestShare pays 50 BTC and uses PrevShareHash as PrevBlockHash.
+	var knownBlock [32]byte
+	coinbaseValue := int64(4000000000)
+	chain.SetCoinbaseValueFunc(func(prevBlockHash [32]byte) (int64, bool) {
+		return coinbaseValue, prevBlockHash == knownBlock
+	})
+
+	// Paying more than our template is only flagged: another node's
+	// mempool may well allow it.
+	now := uint32(time.Now().Unix())
+	genesis := makeTestShare([32]byte{}, testMiner1, now)
+	if total, expected, over := chain.validator.coinbaseOverpay(genesis); !over || total != 5000000000 || expected != coinbaseValue {
+		t.Errorf("coinbaseOverpay = %d, %d, %v; want 5000000000, %d, true", total, expected, over, coinbaseValue)
+	}
+	if err := chain.AddShare(genesis); err != nil {
+		t.Fatalf("share paying more than the template rejected: %v", err)
+	}
+
+	coinbaseValue = 5000000000
+	if _, _, over := chain.validator.coinbaseOverpay(genesis); over {
+		t.Error("share paying the template value flagged")
+	}
+
+	// Shares on a Bitcoin block we have no template for aren't compared.
+	coinbaseValue = 1
+	child := makeTestShare(genesis.Hash(), testMiner1, now)
+	if _, _, over := chain.validator.coinbaseOverpay(child); over {
+		t.Error("share on an unknown block flagged")
+	}
+	if err := chain.AddShare(child); err != nil {
+		t.Errorf("share on unknown block rejected: %v", err)
+	}
+
+	// MAX_MONEY holds everywhere, so it is grounds for rejection.
+	payouts := []types.PayoutEntry{
+		{Address: testMiner1, Amount: maxMoney},
+		{Address: testMiner2, Amount: 1},
+	}
+	coinbase, _, err := types.NewCoinbaseBuilder(testNetwork).BuildCoinbase(800000, types.BuildShareCommitment(child.Hash()), payouts, "", 8)
+	if err != nil {
+		t.Fatalf("BuildCoinbase: %v", err)
+	}
+	outputs, err := types.ParseCoinbaseOutputs(coinbase)
+	if err != nil {
+		t.Fatalf("ParseCoinbaseOutputs: %v", err)
+	}
+	if err := chain.validator.validateCoinbaseValue(outputs); err == nil || !strings.Contains(err.Error(), "max money") {
+		t.Errorf("validateCoinbaseValue = %v, want rejection for exceeding max money", err)
+	}
+}
+
+func TestChainID(t *testing.T) {
+	base := ChainID(types.MainNetParams, NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil))
+	if base != ChainID(types.MainNetParams, NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)) {
+		t.Error("ChainID should be deterministic")
+	}
+	if base == ChainID(types.TestNet3Params, NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)) {
+		t.Error("networks should have different chain IDs")
+	}
+	if base == ChainID(types.MainNetParams, NewDifficultyCalculator(10*time.Second, DifficultyAdjustmentWindow, nil)) {
+		t.Error("share target times should have different chain IDs")
+	}
+	if base == ChainID(types.MainNetParams, NewDifficultyCalculator(30*time.Second, 144, nil)) {
+		t.Error("difficulty windows should have different chain IDs")
+	}
+	if base == ChainID(types.MainNetParams, NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, LWMAAlgo{})) {
+		t.Error("difficulty algorithms should have different chain IDs")
+	}
+}
+
+func TestShareChain_NetworkMaxShareTarget(t *testing.T) {
+	for _, network := range []types.NetworkParams{types.MainNetParams, types.TestNet3Params, types.RegTestParams} {
+		diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+		chain := NewShareChain(NewMemoryStore(), diffCalc, 8640, network, testLogger())
+		want := network.MaxShareTarget()
+		if got := chain.GetExpectedTarget(); got.Cmp(want) != 0 {
+			t.Errorf("%s: first share target = %x, want %x", network.Name, got, want)
+		}
+		// Retargets never go easier than the network allows.
+		if got := chain.diffCalc.MaxTarget(); got.Cmp(want) != 0 {
+			t.Errorf("%s: difficulty floor = %x, want %x", network.Name, got, want)
+		}
+	}
+
+	if types.MainNetParams.MaxShareTarget().Cmp(types.MainNetParams.PowLimit) != 0 {
+		t.Error("mainnet shares should be at least Bitcoin difficulty 1")
+	}
+
+	// Networks sharing a name but not share limits must not interoperate.
+	easy := types.MainNetParams
+	easy.MaxShareBits = maxShareTargetBits
+	dc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+	if ChainID(types.MainNetParams, dc) == ChainID(easy, dc) {
+		t.Error("max share targets should have different chain IDs")
+	}
+}
+
+func TestShareChain_MeetsMinimumWork(t *testing.T) {
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+	chain := NewShareChain(NewMemoryStore(), diffCalc, 8640, testNetwork, testLogger())
+
+	// The parent is unknown: only the PoW can be judged.
+	base := makeTestShare([32]byte{0x01}, testMiner1, uint32(time.Now().Unix()))
+	max := testNetwork.MaxShareTarget()
+	var sawValid, sawInvalid bool
+	for nonce := uint32(0); !sawValid || !sawInvalid; nonce++ {
+		share := &types.Share{Header: base.Header, PrevShareHash: base.PrevShareHash}
+		share.Header.Nonce = nonce
+		meets := util.HashMeetsTarget(share.Header.Hash(), max)
+		if got := chain.MeetsMinimumWork(share); got != meets {
+			t.Fatalf("nonce %d: MeetsMinimumWork = %v, want %v", nonce, got, meets)
+		}
+		sawValid = sawValid || meets
+		sawInvalid = sawInvalid || !meets
+	}
+}
+
+func TestWithMinShareDifficulty(t *testing.T) {
+	if got, err := WithMinShareDifficulty(testNetwork, 0); err != nil || got.MaxShareBits != testNetwork.MaxShareBits {
+		t.Errorf("zero difficulty = 0x%08x, %v; want the network default", got.MaxShareBits, err)
+	}
+	if _, err := WithMinShareDifficulty(testNetwork, -1); err == nil {
+		t.Error("expected error for negative difficulty")
+	}
+	if _, err := WithMinShareDifficulty(testNetwork, 1e-12); err == nil {
+		t.Error("expected error for a floor easier than the easiest share target")
+	}
+
+	raised, err := WithMinShareDifficulty(testNetwork, 1)
+	if err != nil {
+		t.Fatalf("WithMinShareDifficulty: %v", err)
+	}
+	if raised.MaxShareBits != 0x1d00ffff {
+		t.Errorf("difficulty 1 floor = 0x%08x, want 0x1d00ffff", raised.MaxShareBits)
+	}
+	dc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+	if ChainID(testNetwork, dc) == ChainID(raised, dc) {
+		t.Error("a raised floor should change the chain ID")
+	}
+}
+
+func TestValidation_RejectsShareBelowMinDifficulty(t *testing.T) {
+	raised, err := WithMinShareDifficulty(testNetwork, 1)
+	if err != nil {
+		t.Fatalf("WithMinShareDifficulty: %v", err)
+	}
+	// The target function agrees with the share, so only the floor check
+	// can reject it.
+	validator := NewValidator(NewMemoryStore(), func([32]byte) *big.Int { return maxTarget() }, raised)
+	share := makeTestShare([32]byte{}, testMiner1, uint32(time.Now().Unix()))
+	if err := validator.ValidateShare(share); err == nil {
+		t.Error("expected rejection for a share below the minimum difficulty")
+	}
+
+	validator = NewValidator(NewMemoryStore(), func([32]byte) *big.Int { return maxTarget() }, testNetwork)
+	if err := validator.ValidateShare(share); err != nil {
+		t.Errorf("share at the default floor rejected: %v", err)
+	}
+}
+
+func TestDifficultyCalculator_ClampsToNetworkFloor(t *testing.T) {
+	raised, err := WithMinShareDifficulty(testNetwork, 2)
+	if err != nil {
+		t.Fatalf("WithMinShareDifficulty: %v", err)
+	}
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+	chain := NewShareChain(NewMemoryStore(), diffCalc, 8640, raised, testLogger())
+
+	// Shares arriving far slower than the target time push the retarget
+	// easier, but never past the floor.
+	floor := raised.MaxShareTarget()
+	now := uint32(time.Now().Unix())
+	shares := []*types.Share{
+		{Header: types.ShareHeader{Timestamp: now}, ShareTarget: floor},
+		{Header: types.ShareHeader{Timestamp: now - 3600}, ShareTarget: floor},
+	}
+	if got := chain.diffCalc.NextTarget(shares); got.Cmp(floor) > 0 {
+		t.Errorf("NextTarget = %x, easier than the floor %x", got, floor)
+	}
+}
+
+func TestShareChain_MinedChain(t *testing.T) {
+	store := NewMemoryStore()
+	diffCalc := NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil)
+	chain := NewShareChain(store, diffCalc, 8640, testutil.RegtestNetwork, testLogger())
+
+	shares := testutil.MineShareChain(5)
+	for i, s := range shares {
+		if err := chain.AddShare(s); err != nil {
+			t.Fatalf("AddShare(%d) failed: %v", i, err)
+		}
+	}
+
+	tip, ok := chain.Tip()
+	if !ok {
+		t.Fatal("chain should have tip")
+	}
+	if tip.Hash() != shares[len(shares)-1].Hash() {
+		t.Error("tip should be the last mined share")
+	}
+}
+
+func TestShareChain_HeightAfterPruning(t *testing.T) {
+	chain := NewShareChain(NewMemoryStore(), NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil), 8640, testNetwork, testLogger())
+	base := uint32(time.Now().Add(-10 * time.Minute).Unix())
+
+	prev := [32]byte{}
+	for i := 0; i < 10; i++ {
+		s := makeTestShare(prev, testMiner1, base+uint32(i)*30)
+		if err := chain.AddShare(s); err != nil {
+			t.Fatalf("AddShare %d: %v", i, err)
+		}
+		prev = s.Hash()
+	}
+	chain.PruneOldShares(4)
+
+	// The tip's height is unaffected by how many shares are stored.
+	if h, ok := chain.Height(prev); !ok || h != 9 {
+		t.Errorf("Height(tip) = %d, %v; want 9 with %d shares stored", h, ok, chain.Count())
+	}
+}
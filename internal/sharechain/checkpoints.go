@@ -0,0 +1,165 @@
+package sharechain
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+	"github.com/djkazic/p2pool-go/pkg/util"
+
+	"go.uber.org/zap"
+)
+
+// Checkpoint pins the share at a sharechain height. Shares that would put a
+// different share at that height are rejected, and the tip never reorgs
+// away from a checkpointed share, bounding the damage a long alternate
+// chain from a peer can do.
+type Checkpoint struct {
+	Height int64
+	Hash   [32]byte
+}
+
+// defaultCheckpoints are built-in checkpoints per network, starting with
+// the genesis share. No network's genesis share is pinned yet; operators
+// can pin their own with the checkpoints option.
+var defaultCheckpoints = map[string][]Checkpoint{}
+
+// DefaultCheckpoints returns the built-in checkpoints for a network.
+func DefaultCheckpoints(network string) []Checkpoint {
+	return append([]Checkpoint(nil), defaultCheckpoints[network]...)
+}
+
+// ParseCheckpoint parses a checkpoint in "height:hash" form, with the hash
+// in display (reversed) hex.
+func ParseCheckpoint(s string) (Checkpoint, error) {
+	heightStr, hashStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return Checkpoint{}, fmt.Errorf("checkpoint %q: want height:hash", s)
+	}
+	height, err := strconv.ParseInt(heightStr, 10, 64)
+	if err != nil || height < 0 {
+		return Checkpoint{}, fmt.Errorf("checkpoint %q: invalid height", s)
+	}
+	hash, err := util.HexToHash(hashStr)
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("checkpoint %q: invalid hash: %w", s, err)
+	}
+	return Checkpoint{Height: height, Hash: hash}, nil
+}
+
+// SetCheckpoints sets the checkpoints the chain enforces. Two checkpoints
+// at the same height must agree. It must be called before shares are added
+// or loaded shares are validated.
+func (sc *ShareChain) SetCheckpoints(checkpoints []Checkpoint) error {
+	byHeight := make(map[int64][32]byte, len(checkpoints))
+	byHash := make(map[[32]byte]int64, len(checkpoints))
+	for _, cp := range checkpoints {
+		if prev, ok := byHeight[cp.Height]; ok && prev != cp.Hash {
+			return fmt.Errorf("conflicting checkpoints at height %d", cp.Height)
+		}
+		byHeight[cp.Height] = cp.Hash
+		byHash[cp.Hash] = cp.Height
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.checkpoints = byHeight
+	sc.checkpointHashes = byHash
+	return nil
+}
+
+// Checkpoints returns the enforced checkpoints, lowest height first.
+func (sc *ShareChain) Checkpoints() []Checkpoint {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	cps := make([]Checkpoint, 0, len(sc.checkpoints))
+	for height, hash := range sc.checkpoints {
+		cps = append(cps, Checkpoint{Height: height, Hash: hash})
+	}
+	sort.Slice(cps, func(i, j int) bool { return cps[i].Height < cps[j].Height })
+	return cps
+}
+
+// checkCheckpoint rejects a share that would sit at a checkpointed height
+// with a different hash. Must be called with sc.mu held for writing.
+func (sc *ShareChain) checkCheckpoint(share *types.Share) error {
+	if len(sc.checkpoints) == 0 {
+		return nil
+	}
+
+	var height int64
+	var zeroHash [32]byte
+	if share.PrevShareHash != zeroHash {
+		parentHeight, ok := sc.store.Height(share.PrevShareHash)
+		if !ok {
+			// Without a height the share cannot be checked, so it must
+			// not slip past the checkpoints.
+			return &ValidationError{Reason: "share's parent has no known height to check against checkpoints"}
+		}
+		height = parentHeight + 1
+	}
+
+	if want, ok := sc.checkpoints[height]; ok && share.Hash() != want {
+		return &ValidationError{Reason: fmt.Sprintf(
+			"share at height %d conflicts with checkpoint %s", height, util.HashToHex(want))}
+	}
+	return nil
+}
+
+// undoesCheckpoint reports whether moving the tip from oldTip to newTip
+// would disconnect a checkpointed share. Must be called with sc.mu held.
+func (sc *ShareChain) undoesCheckpoint(oldTip, newTip [32]byte) bool {
+	if len(sc.checkpointHashes) == 0 {
+		return false
+	}
+	disconnected, _ := sc.forkChoice.ReorgPath(oldTip, newTip, sc.windowSize)
+	for _, hash := range disconnected {
+		if height, ok := sc.checkpointHashes[hash]; ok {
+			sc.logger.Warn("refusing reorg past checkpoint",
+				zap.Int64("checkpoint_height", height),
+				zap.String("checkpoint", util.HashToHex(hash)),
+				zap.String("old_tip", util.HashToHex(oldTip)),
+				zap.String("new_tip", util.HashToHex(newTip)),
+			)
+			return true
+		}
+	}
+	return false
+}
+
+// PinBase pins the oldest stored share on the main chain at its height,
+// unless a checkpoint already covers that height. That share is the
+// genesis share until the chain is pruned, so a node that has synced once
+// refuses alternate chains that fork below what it holds. It returns the
+// pinned checkpoint, or false if nothing was pinned.
+func (sc *ShareChain) PinBase() (Checkpoint, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	tip, ok := sc.store.Tip()
+	if !ok {
+		return Checkpoint{}, false
+	}
+	ancestors := sc.store.GetAncestors(tip.Hash(), MaxAncestors)
+	base := ancestors[len(ancestors)-1].Hash()
+	height, ok := sc.store.Height(base)
+	if !ok {
+		return Checkpoint{}, false
+	}
+	if _, ok := sc.checkpoints[height]; ok {
+		return Checkpoint{}, false
+	}
+	if _, ok := sc.checkpointHashes[base]; ok {
+		return Checkpoint{}, false
+	}
+
+	if sc.checkpoints == nil {
+		sc.checkpoints = make(map[int64][32]byte)
+		sc.checkpointHashes = make(map[[32]byte]int64)
+	}
+	sc.checkpoints[height] = base
+	sc.checkpointHashes[base] = height
+	return Checkpoint{Height: height, Hash: base}, true
+}
@@ -0,0 +1,162 @@
+package sharechain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/djkazic/p2pool-go/pkg/util"
+)
+
+func TestParseCheckpoint(t *testing.T) {
+	hash := [32]byte{1, 2, 3}
+	cp, err := ParseCheckpoint("42:" + util.HashToHex(hash))
+	if err != nil {
+		t.Fatalf("ParseCheckpoint: %v", err)
+	}
+	if cp.Height != 42 || cp.Hash != hash {
+		t.Errorf("got %+v", cp)
+	}
+
+	for _, bad := range []string{
+		"",
+		"42",
+		"-1:" + util.HashToHex(hash),
+		"x:" + util.HashToHex(hash),
+		"42:zz",
+	} {
+		if _, err := ParseCheckpoint(bad); err == nil {
+			t.Errorf("ParseCheckpoint(%q): expected error", bad)
+		}
+	}
+}
+
+func TestSetCheckpoints_Conflict(t *testing.T) {
+	chain := NewShareChain(NewMemoryStore(), NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil), 8640, testNetwork, testLogger())
+	err := chain.SetCheckpoints([]Checkpoint{{Height: 1, Hash: [32]byte{1}}, {Height: 1, Hash: [32]byte{2}}})
+	if err == nil {
+		t.Error("expected error for conflicting checkpoints")
+	}
+}
+
+func TestCheckpoint_RejectsConflictingShare(t *testing.T) {
+	chain := NewShareChain(NewMemoryStore(), NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil), 8640, testNetwork, testLogger())
+	base := uint32(time.Now().Add(-5 * time.Minute).Unix())
+
+	genesis := makeTestShare([32]byte{}, testMiner1, base)
+	pinned := makeTestShare(genesis.Hash(), testMiner1, base+30)
+	other := makeTestShare(genesis.Hash(), testMiner2, base+30)
+	if err := chain.SetCheckpoints([]Checkpoint{{Height: 1, Hash: pinned.Hash()}}); err != nil {
+		t.Fatalf("SetCheckpoints: %v", err)
+	}
+
+	if err := chain.AddShare(genesis); err != nil {
+		t.Fatalf("AddShare genesis: %v", err)
+	}
+	if err := chain.AddShare(other); err == nil {
+		t.Error("share conflicting with checkpoint should be rejected")
+	}
+	if err := chain.AddShare(pinned); err != nil {
+		t.Fatalf("checkpointed share rejected: %v", err)
+	}
+	if err := chain.AddShare(makeTestShare(pinned.Hash(), testMiner1, base+60)); err != nil {
+		t.Errorf("share above checkpoint rejected: %v", err)
+	}
+}
+
+func TestCheckpoint_RefusesReorg(t *testing.T) {
+	chain := NewShareChain(NewMemoryStore(), NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil), 8640, testNetwork, testLogger())
+	base := uint32(time.Now().Add(-5 * time.Minute).Unix())
+
+	genesis := makeTestShare([32]byte{}, testMiner1, base)
+	if err := chain.AddShare(genesis); err != nil {
+		t.Fatalf("AddShare genesis: %v", err)
+	}
+	prevA := genesis.Hash()
+	var chainA [][32]byte
+	for i := 0; i < 2; i++ {
+		s := makeTestShare(prevA, testMiner1, base+uint32(i+1)*30)
+		if err := chain.AddShare(s); err != nil {
+			t.Fatalf("AddShare A[%d]: %v", i, err)
+		}
+		prevA = s.Hash()
+		chainA = append(chainA, prevA)
+	}
+
+	// Fork B branches off below the checkpoint before it is pinned.
+	forkB := makeTestShare(genesis.Hash(), testMiner2, base+30)
+	if err := chain.AddShare(forkB); err != nil {
+		t.Fatalf("AddShare B[0]: %v", err)
+	}
+
+	if err := chain.SetCheckpoints([]Checkpoint{{Height: 1, Hash: chainA[0]}}); err != nil {
+		t.Fatalf("SetCheckpoints: %v", err)
+	}
+
+	// Extending fork B past chain A's work would normally reorg.
+	prevB := forkB.Hash()
+	for i := 1; i < 4; i++ {
+		s := makeTestShare(prevB, testMiner2, base+uint32(i+1)*30)
+		if err := chain.AddShare(s); err != nil {
+			t.Fatalf("AddShare B[%d]: %v", i, err)
+		}
+		prevB = s.Hash()
+	}
+
+	tip, _ := chain.Tip()
+	if tip.Hash() != chainA[len(chainA)-1] {
+		t.Error("tip should stay on the checkpointed chain")
+	}
+}
+
+func TestCheckpoint_EnforcedAfterPruning(t *testing.T) {
+	chain := NewShareChain(NewMemoryStore(), NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil), 8640, testNetwork, testLogger())
+	base := uint32(time.Now().Add(-10 * time.Minute).Unix())
+
+	prev := [32]byte{}
+	var hashes [][32]byte
+	for i := 0; i < 10; i++ {
+		s := makeTestShare(prev, testMiner1, base+uint32(i)*30)
+		if err := chain.AddShare(s); err != nil {
+			t.Fatalf("AddShare %d: %v", i, err)
+		}
+		prev = s.Hash()
+		hashes = append(hashes, prev)
+	}
+	if err := chain.SetCheckpoints([]Checkpoint{{Height: 8, Hash: hashes[8]}}); err != nil {
+		t.Fatalf("SetCheckpoints: %v", err)
+	}
+
+	// Genesis is pruned, so the parent's height must come from the index.
+	chain.PruneOldShares(4)
+	if err := chain.AddShare(makeTestShare(hashes[7], testMiner2, base+8*30)); err == nil {
+		t.Error("share conflicting with checkpoint should be rejected after pruning")
+	}
+}
+
+func TestPinBase(t *testing.T) {
+	chain := NewShareChain(NewMemoryStore(), NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil), 8640, testNetwork, testLogger())
+	base := uint32(time.Now().Add(-5 * time.Minute).Unix())
+
+	if _, ok := chain.PinBase(); ok {
+		t.Error("empty chain should pin nothing")
+	}
+
+	genesis := makeTestShare([32]byte{}, testMiner1, base)
+	if err := chain.AddShare(genesis); err != nil {
+		t.Fatalf("AddShare genesis: %v", err)
+	}
+	if err := chain.AddShare(makeTestShare(genesis.Hash(), testMiner1, base+30)); err != nil {
+		t.Fatalf("AddShare: %v", err)
+	}
+
+	cp, ok := chain.PinBase()
+	if !ok || cp.Height != 0 || cp.Hash != genesis.Hash() {
+		t.Fatalf("PinBase = %+v, %v; want genesis at height 0", cp, ok)
+	}
+	if _, ok := chain.PinBase(); ok {
+		t.Error("PinBase should not repin a covered height")
+	}
+	if err := chain.AddShare(makeTestShare([32]byte{}, testMiner2, base+30)); err == nil {
+		t.Error("alternate genesis share should be rejected")
+	}
+}
@@ -1,7 +1,9 @@
 package sharechain
 
 import (
+	"fmt"
 	"math/big"
+	"slices"
 	"time"
 
 	"github.com/djkazic/p2pool-go/internal/types"
@@ -9,14 +11,20 @@ import (
 )
 
 const (
-	// DifficultyAdjustmentWindow is the number of shares to look back for difficulty adjustment.
+	// DifficultyAdjustmentWindow is the default number of shares to look back
+	// for difficulty adjustment.
 	DifficultyAdjustmentWindow = 72 // ~36 minutes at 30s target
 
+	// minDifficultyWindow is the smallest usable adjustment window: timing
+	// needs at least two shares.
+	minDifficultyWindow = 2
+
 	// MinShareTarget prevents the difficulty from going too high (target too low).
 	minShareTargetBits = 0x1d00ffff // Bitcoin difficulty 1
 
-	// MaxShareTarget is the easiest possible share target (highest allowed value).
-	// Uses regtest-style max target so CPU miners can produce shares.
+	// MaxShareTarget is the easiest share target on any network (highest
+	// allowed value), regtest-style so CPU miners can produce shares. A
+	// network may set a harder limit; see types.NetworkParams.MaxShareBits.
 	maxShareTargetBits = 0x207fffff
 )
 
@@ -25,20 +33,149 @@ var (
 	MaxShareTarget = util.CompactToTarget(maxShareTargetBits)
 )
 
+// WithMinShareDifficulty returns network with its minimum share difficulty,
+// relative to Bitcoin difficulty 1, raised to difficulty. Zero keeps the
+// network's default. The floor is a consensus parameter and changes the
+// chain ID.
+func WithMinShareDifficulty(network types.NetworkParams, difficulty float64) (types.NetworkParams, error) {
+	if difficulty == 0 {
+		return network, nil
+	}
+	if difficulty < 0 {
+		return network, fmt.Errorf("min share difficulty must not be negative")
+	}
+	bits := util.TargetToCompact(util.DifficultyToTarget(difficulty, MinShareTarget))
+	if util.CompactToTarget(bits).Cmp(MaxShareTarget) > 0 {
+		return network, fmt.Errorf("min share difficulty %g is easier than the easiest share target", difficulty)
+	}
+	network.MaxShareBits = bits
+	return network, nil
+}
+
+// DifficultyAlgo computes the next share target from a window of recent
+// shares. Implementations are consensus code: given the same window and
+// target time they must return the same value on every node, so they may
+// only use integer arithmetic on the shares' own fields.
+type DifficultyAlgo interface {
+	// Name identifies the algorithm in config and in the chain ID.
+	Name() string
+
+	// NextTarget returns the target for the share following window[0].
+	// window is newest first and holds at least two shares. The result is
+	// clamped to the difficulty floor and compact-normalized by the caller.
+	NextTarget(window []*types.Share, targetTime time.Duration) *big.Int
+}
+
+// DefaultDifficultyAlgo is the algorithm the public sharechain uses.
+const DefaultDifficultyAlgo = "ratio"
+
+// DifficultyAlgoByName returns the difficulty algorithm with the given
+// config name.
+func DifficultyAlgoByName(name string) (DifficultyAlgo, error) {
+	switch name {
+	case RatioAlgo{}.Name():
+		return RatioAlgo{}, nil
+	case LWMAAlgo{}.Name():
+		return LWMAAlgo{}, nil
+	default:
+		return nil, fmt.Errorf("unknown difficulty algorithm %q", name)
+	}
+}
+
 // DifficultyCalculator adjusts sharechain difficulty.
 type DifficultyCalculator struct {
 	targetTime time.Duration
+	window     int
+	algo       DifficultyAlgo
+
+	// maxTarget is the difficulty floor: the easiest target NextTarget
+	// returns. Nil means MaxShareTarget.
+	maxTarget *big.Int
 }
 
-// NewDifficultyCalculator creates a new difficulty calculator.
-func NewDifficultyCalculator(targetTime time.Duration) *DifficultyCalculator {
+// NewDifficultyCalculator creates a new difficulty calculator that targets
+// one share per targetTime, retargeting over the last window shares with
+// algo. All three are consensus parameters: every node on a sharechain must
+// use the same values. A window below 2 is raised to 2, and a nil algo
+// selects RatioAlgo.
+func NewDifficultyCalculator(targetTime time.Duration, window int, algo DifficultyAlgo) *DifficultyCalculator {
+	if window < minDifficultyWindow {
+		window = minDifficultyWindow
+	}
+	if algo == nil {
+		algo = RatioAlgo{}
+	}
 	return &DifficultyCalculator{
 		targetTime: targetTime,
+		window:     window,
+		algo:       algo,
 	}
 }
 
-// NextTarget calculates the next share target based on a window of recent shares.
-// Uses: newTarget = currentTarget * (actualTime / expectedTime), clamped to 4x.
+// Window returns the number of shares the calculator retargets over.
+func (dc *DifficultyCalculator) Window() int {
+	return dc.window
+}
+
+// Algo returns the calculator's difficulty algorithm.
+func (dc *DifficultyCalculator) Algo() DifficultyAlgo {
+	return dc.algo
+}
+
+// withMaxTarget returns a copy of dc whose targets are no easier than
+// maxTarget.
+func (dc *DifficultyCalculator) withMaxTarget(maxTarget *big.Int) *DifficultyCalculator {
+	c := *dc
+	c.maxTarget = maxTarget
+	return &c
+}
+
+// MaxTarget returns the easiest target NextTarget returns.
+func (dc *DifficultyCalculator) MaxTarget() *big.Int {
+	if dc.maxTarget == nil {
+		return new(big.Int).Set(MaxShareTarget)
+	}
+	return new(big.Int).Set(dc.maxTarget)
+}
+
+// NextTarget calculates the next share target based on a window of recent
+// shares, newest first.
+func (dc *DifficultyCalculator) NextTarget(shares []*types.Share) *big.Int {
+	maxTarget := dc.MaxTarget()
+	if len(shares) < 2 {
+		return maxTarget
+	}
+
+	window := shares
+	if len(window) > dc.window {
+		window = window[:dc.window]
+	}
+
+	newTarget := dc.algo.NextTarget(window, dc.targetTime)
+
+	// Clamp to the difficulty floor
+	if newTarget.Cmp(maxTarget) > 0 {
+		newTarget.Set(maxTarget)
+	}
+	if newTarget.Sign() <= 0 {
+		newTarget.SetInt64(1)
+	}
+	// Normalize through compact round-trip so all nodes produce identical
+	// big.Int values regardless of whether a share was mined locally or
+	// received via P2P (where targets are transmitted as compact uint32).
+	return util.CompactToTarget(util.TargetToCompact(newTarget))
+}
+
+// RatioAlgo scales the newest target by how far the window's actual
+// duration missed the expected one. It is the original algorithm and the
+// default.
+type RatioAlgo struct{}
+
+// Name implements DifficultyAlgo.
+func (RatioAlgo) Name() string { return "ratio" }
+
+// NextTarget computes newTarget = currentTarget * (actualTime / expectedTime),
+// clamped to 4x.
 //
 // The window is trimmed to only include shares whose target is within 4x of the
 // newest share's target. During difficulty transitions (cold start, hashrate
@@ -48,16 +185,7 @@ func NewDifficultyCalculator(targetTime time.Duration) *DifficultyCalculator {
 // algorithm has found the right difficulty, causing compounding overshoot or
 // glacially slow convergence. Trimming ensures the algorithm uses only timing
 // data from shares at a comparable difficulty level.
-func (dc *DifficultyCalculator) NextTarget(shares []*types.Share) *big.Int {
-	if len(shares) < 2 {
-		return new(big.Int).Set(MaxShareTarget)
-	}
-
-	window := shares
-	if len(window) > DifficultyAdjustmentWindow {
-		window = window[:DifficultyAdjustmentWindow]
-	}
-
+func (RatioAlgo) NextTarget(window []*types.Share, targetTime time.Duration) *big.Int {
 	// window[0] is the most recent share, window[len-1] is the oldest
 	newest := window[0]
 
@@ -85,14 +213,12 @@ func (dc *DifficultyCalculator) NextTarget(shares []*types.Share) *big.Int {
 		return util.CompactToTarget(util.TargetToCompact(currentTarget))
 	}
 
-	oldest := window[len(window)-1]
-
-	actualTime := int64(newest.Header.Timestamp) - int64(oldest.Header.Timestamp)
+	actualTime, intervals := windowTimeSpan(window)
 	if actualTime <= 0 {
 		actualTime = 1
 	}
 
-	expectedTime := int64(dc.targetTime.Seconds()) * int64(len(window)-1)
+	expectedTime := int64(targetTime.Seconds()) * intervals
 	if expectedTime <= 0 {
 		expectedTime = 1
 	}
@@ -111,13 +237,99 @@ func (dc *DifficultyCalculator) NextTarget(shares []*types.Share) *big.Int {
 	if newTarget.Cmp(minAdjust) < 0 {
 		newTarget.Set(minAdjust)
 	}
+	return newTarget
+}
 
-	// Clamp to global limits
-	if newTarget.Cmp(MaxShareTarget) > 0 {
-		newTarget.Set(MaxShareTarget)
+// lwmaMaxSolveTimeFactor caps a single LWMA solve time at this many target
+// times, so one late timestamp can't drop the difficulty sharply.
+const lwmaMaxSolveTimeFactor = 6
+
+// medianSpanShares is how many timestamps at each end of a window are
+// reduced to their median when measuring its span.
+const medianSpanShares = 11
+
+// windowTimeSpan returns the time covered by a newest-first window of at
+// least two shares, and the number of share intervals that time spans.
+//
+// Share timestamps may be off by up to MaxTimeFuture/MaxTimePast, so the
+// plain newest-minus-oldest span lets a single misdated share at either end
+// collapse or stretch the span for the whole window. Windows holding a
+// version 2 share therefore measure between the median timestamps of the
+// newest and oldest medianSpanShares shares (fewer in short windows),
+// which one misdated share can move by at most a neighbour's interval.
+// Version 1 windows keep newest-minus-oldest, so every node retargets them
+// as before and the rule changes only once version 2 shares are produced.
+func windowTimeSpan(window []*types.Share) (span, intervals int64) {
+	n := len(window)
+	if !slices.ContainsFunc(window, func(s *types.Share) bool { return s.ShareVersion >= types.ShareVersion2 }) {
+		return int64(window[0].Header.Timestamp) - int64(window[n-1].Header.Timestamp), int64(n - 1)
 	}
-	// Normalize through compact round-trip so all nodes produce identical
-	// big.Int values regardless of whether a share was mined locally or
-	// received via P2P (where targets are transmitted as compact uint32).
-	return util.CompactToTarget(util.TargetToCompact(newTarget))
+
+	// An odd count keeps the median a real timestamp. The medians sit
+	// (k-1)/2 shares in from each end.
+	k := min(medianSpanShares, n/2)
+	if k%2 == 0 {
+		k--
+	}
+	return medianTimestamp(window[:k]) - medianTimestamp(window[n-k:]), int64(n - k)
+}
+
+// medianTimestamp returns the median timestamp of an odd number of shares.
+func medianTimestamp(shares []*types.Share) int64 {
+	ts := make([]int64, len(shares))
+	for i, s := range shares {
+		ts[i] = int64(s.Header.Timestamp)
+	}
+	slices.Sort(ts)
+	return ts[len(ts)/2]
+}
+
+// LWMAAlgo is a linearly-weighted moving average: each solve time in the
+// window is weighted by its position, so the newest shares count most. It
+// reacts faster than RatioAlgo to hashrate changes while oscillating less
+// under bursty hashrate.
+type LWMAAlgo struct{}
+
+// Name implements DifficultyAlgo.
+func (LWMAAlgo) Name() string { return "lwma" }
+
+// NextTarget computes avgTarget * Σ(i·solveTime_i) / (T·N(N+1)/2), where
+// solve times run oldest (i=1) to newest (i=N) and are clamped to
+// [1, 6T]. Missing targets count as MaxShareTarget.
+func (LWMAAlgo) NextTarget(window []*types.Share, targetTime time.Duration) *big.Int {
+	t := int64(targetTime.Seconds())
+	if t <= 0 {
+		t = 1
+	}
+	maxSolve := lwmaMaxSolveTimeFactor * t
+
+	n := int64(len(window) - 1)
+	var weighted int64
+	sumTargets := new(big.Int)
+	// Walk from the oldest solve time to the newest.
+	for i := int64(1); i <= n; i++ {
+		cur := window[n-i]
+		prev := window[n-i+1]
+		solve := int64(cur.Header.Timestamp) - int64(prev.Header.Timestamp)
+		if solve < 1 {
+			solve = 1
+		}
+		if solve > maxSolve {
+			solve = maxSolve
+		}
+		weighted += i * solve
+
+		target := cur.ShareTarget
+		if target == nil || target.Sign() <= 0 {
+			target = MaxShareTarget
+		}
+		sumTargets.Add(sumTargets, target)
+	}
+
+	// next = (sumTargets / n) * weighted / (t * n(n+1)/2), with one final
+	// division to keep precision.
+	newTarget := sumTargets.Mul(sumTargets, big.NewInt(weighted))
+	newTarget.Mul(newTarget, big.NewInt(2))
+	newTarget.Div(newTarget, big.NewInt(n*n*(n+1)*t))
+	return newTarget
 }
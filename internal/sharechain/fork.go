@@ -3,7 +3,7 @@ package sharechain
 import (
 	"math/big"
 
-	"github.com/djkazic/p2pool-go/pkg/util"
+	"github.com/djkazic/p2pool-go/internal/types"
 )
 
 // ForkChoice implements heaviest-chain tip selection for the sharechain.
@@ -18,7 +18,15 @@ func NewForkChoice(store ShareStore) *ForkChoice {
 
 // ChainWork calculates the cumulative work of a chain ending at the given share.
 // Work is defined as the sum of difficulties of all shares in the chain.
+// A chain no deeper than maxDepth is summed in full by the store's total,
+// so only chains longer than the window are walked.
 func (fc *ForkChoice) ChainWork(tipHash [32]byte, maxDepth int) *big.Int {
+	if height, ok := fc.store.Height(tipHash); ok && height < int64(maxDepth) {
+		if total, ok := fc.store.TotalWork(tipHash); ok {
+			return total
+		}
+	}
+
 	totalWork := new(big.Int)
 	current := tipHash
 	var zeroHash [32]byte
@@ -29,14 +37,7 @@ func (fc *ForkChoice) ChainWork(tipHash [32]byte, maxDepth int) *big.Int {
 			break
 		}
 
-		// Work for this share = target_max / share_target (i.e., difficulty)
-		if share.ShareTarget != nil && share.ShareTarget.Sign() > 0 {
-			work := new(big.Int).Div(MaxShareTarget, share.ShareTarget)
-			totalWork.Add(totalWork, work)
-		} else {
-			// If no share target, count as difficulty 1
-			totalWork.Add(totalWork, big.NewInt(1))
-		}
+		totalWork.Add(totalWork, shareWork(share))
 
 		current = share.PrevShareHash
 		if current == zeroHash {
@@ -47,8 +48,18 @@ func (fc *ForkChoice) ChainWork(tipHash [32]byte, maxDepth int) *big.Int {
 	return totalWork
 }
 
+// shareWork returns the work a share adds to its chain: target_max /
+// share_target, i.e. its difficulty. A share without a target counts as
+// difficulty 1.
+func shareWork(share *types.Share) *big.Int {
+	if share.ShareTarget != nil && share.ShareTarget.Sign() > 0 {
+		return new(big.Int).Div(MaxShareTarget, share.ShareTarget)
+	}
+	return big.NewInt(1)
+}
+
 // SelectTip chooses between the current tip and a new candidate share.
-// Returns the hash that should be the new tip.
+// Returns the hash that should be the new tip; see CompareTips for the rule.
 func (fc *ForkChoice) SelectTip(currentTip, candidate [32]byte, windowSize int) [32]byte {
 	var zeroHash [32]byte
 
@@ -62,32 +73,35 @@ func (fc *ForkChoice) SelectTip(currentTip, candidate [32]byte, windowSize int)
 		return currentTip
 	}
 
-	// Compare cumulative work
 	currentWork := fc.ChainWork(currentTip, windowSize)
 	candidateWork := fc.ChainWork(candidate, windowSize)
-
-	cmp := candidateWork.Cmp(currentWork)
-	if cmp > 0 {
+	if CompareTips(candidateWork, candidate, currentWork, currentTip) > 0 {
 		return candidate
 	}
-	if cmp < 0 {
-		return currentTip
-	}
+	return currentTip
+}
 
-	// Tie-breaking: lower hash wins (deterministic)
-	currentShare, _ := fc.store.Get(currentTip)
-	candidateShare, _ := fc.store.Get(candidate)
-	if currentShare != nil && candidateShare != nil {
-		currentHash := currentShare.Hash()
-		candidateHash := candidateShare.Hash()
-		currentInt := new(big.Int).SetBytes(util.ReverseBytes(currentHash[:]))
-		candidateInt := new(big.Int).SetBytes(util.ReverseBytes(candidateHash[:]))
-		if candidateInt.Cmp(currentInt) < 0 {
-			return candidate
+// CompareTips orders two chain tips by fork choice, returning +1 if tip a is
+// preferred, -1 if tip b is and 0 if they are the same tip. More cumulative
+// work wins; on equal work the lower share hash, read as a little-endian
+// number like a block hash, wins.
+//
+// This is consensus-critical: every node must resolve equal-work forks the
+// same way regardless of the order it saw the shares in, or the pool splits.
+// Anything that picks between tips must use it.
+func CompareTips(workA *big.Int, hashA [32]byte, workB *big.Int, hashB [32]byte) int {
+	if cmp := workA.Cmp(workB); cmp != 0 {
+		return cmp
+	}
+	for i := len(hashA) - 1; i >= 0; i-- {
+		if hashA[i] != hashB[i] {
+			if hashA[i] < hashB[i] {
+				return 1
+			}
+			return -1
 		}
 	}
-
-	return currentTip
+	return 0
 }
 
 // FindCommonAncestor finds the common ancestor between two chain tips.
@@ -132,3 +146,30 @@ func (fc *ForkChoice) FindCommonAncestor(tipA, tipB [32]byte, maxDepth int) ([32
 
 	return zeroHash, -1, -1
 }
+
+// ReorgPath returns the shares that leave and join the main chain when
+// switching from oldTip to newTip. Both lists are ordered tip-first and stop
+// short of the common ancestor. If no common ancestor is found within
+// maxDepth, both lists are nil.
+func (fc *ForkChoice) ReorgPath(oldTip, newTip [32]byte, maxDepth int) (disconnected, connected [][32]byte) {
+	_, depthOld, depthNew := fc.FindCommonAncestor(oldTip, newTip, maxDepth)
+	if depthOld < 0 {
+		return nil, nil
+	}
+	return fc.walkBack(oldTip, depthOld), fc.walkBack(newTip, depthNew)
+}
+
+// walkBack returns up to n hashes starting at tip and following parents.
+func (fc *ForkChoice) walkBack(tip [32]byte, n int) [][32]byte {
+	hashes := make([][32]byte, 0, n)
+	current := tip
+	for i := 0; i < n; i++ {
+		share, ok := fc.store.Get(current)
+		if !ok {
+			break
+		}
+		hashes = append(hashes, current)
+		current = share.PrevShareHash
+	}
+	return hashes
+}
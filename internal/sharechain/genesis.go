@@ -0,0 +1,31 @@
+package sharechain
+
+import (
+	"encoding/binary"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+	"github.com/djkazic/p2pool-go/pkg/util"
+)
+
+// ChainID identifies a sharechain by its consensus parameters: the Bitcoin
+// network, its share target bounds and the difficulty calculator's target
+// share interval, adjustment window and algorithm. Nodes with different
+// chain IDs would reject each other's shares, so peers compare it when
+// connecting.
+//
+// The window and algorithm are only hashed when they differ from the
+// defaults, so default chains keep the ID they had before these were
+// tunable.
+func ChainID(network types.NetworkParams, dc *DifficultyCalculator) [32]byte {
+	data := []byte("p2pool-go/" + network.Name)
+	data = binary.BigEndian.AppendUint32(data, minShareTargetBits)
+	data = binary.BigEndian.AppendUint32(data, network.MaxShareBits)
+	data = binary.BigEndian.AppendUint64(data, uint64(dc.targetTime))
+	if dc.window != DifficultyAdjustmentWindow {
+		data = binary.BigEndian.AppendUint32(data, uint32(dc.window))
+	}
+	if name := dc.algo.Name(); name != DefaultDifficultyAlgo {
+		data = append(data, "/"+name...)
+	}
+	return util.DoubleSHA256(data)
+}
@@ -0,0 +1,71 @@
+package sharechain
+
+import (
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+	"github.com/djkazic/p2pool-go/pkg/util"
+)
+
+// HashrateWindow is the time window used for pool hashrate estimation.
+// Shorter than the PPLNS window so hashrate responds to changes in minutes,
+// not days.
+const HashrateWindow = 30 * time.Minute
+
+// EstimateHashrate estimates the hashrate (H/s) that produced shares
+// (newest first), using only shares within window of the newest share.
+// Each share counts as diff1/shareTarget difficulty-1 units of 2^32
+// hashes. Returns 0 with fewer than two shares or no elapsed time.
+func EstimateHashrate(shares []*types.Share, window time.Duration) float64 {
+	if len(shares) < 2 {
+		return 0
+	}
+
+	newest := shares[0].Header.Timestamp
+	cutoff := newest - uint32(window.Seconds())
+
+	// Trim to shares within the hashrate window.
+	end := len(shares)
+	for end > 1 && shares[end-1].Header.Timestamp < cutoff {
+		end--
+	}
+	shares = shares[:end]
+	if len(shares) < 2 {
+		return 0
+	}
+
+	diff1 := util.CompactToTarget(0x1d00ffff)
+	diff1Float := new(big.Float).SetInt(diff1)
+	// Exclude the oldest share's work — it was done before the measurement
+	// window begins (oldest→newest). Only count work within that interval.
+	var totalWork float64
+	for _, s := range shares[:len(shares)-1] {
+		if s.ShareTarget != nil && s.ShareTarget.Sign() > 0 {
+			shareDiff, _ := new(big.Float).Quo(
+				diff1Float,
+				new(big.Float).SetInt(s.ShareTarget),
+			).Float64()
+			totalWork += shareDiff
+		}
+	}
+	oldest := shares[len(shares)-1].Header.Timestamp
+	if newest <= oldest {
+		return 0
+	}
+	return totalWork * math.Pow(2, 32) / float64(newest-oldest)
+}
+
+// PoolHashrate estimates the pool hashrate from up to count shares behind
+// the chain tip. Returns 0 on an empty or single-share chain.
+func (sc *ShareChain) PoolHashrate(count int) float64 {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	tip, ok := sc.store.Tip()
+	if !ok {
+		return 0
+	}
+	return EstimateHashrate(sc.store.GetAncestors(tip.Hash(), count), HashrateWindow)
+}
@@ -0,0 +1,61 @@
+package sharechain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+	"github.com/djkazic/p2pool-go/pkg/util"
+)
+
+func TestEstimateHashrate(t *testing.T) {
+	// Empty or single share → 0
+	if EstimateHashrate(nil, HashrateWindow) != 0 {
+		t.Error("nil shares should return 0")
+	}
+	if EstimateHashrate([]*types.Share{{}}, HashrateWindow) != 0 {
+		t.Error("single share should return 0")
+	}
+
+	// Two shares 30 seconds apart with known target
+	target := util.CompactToTarget(0x207fffff)
+	shares := []*types.Share{
+		{Header: types.ShareHeader{Timestamp: 1700000030}, ShareTarget: target},
+		{Header: types.ShareHeader{Timestamp: 1700000000}, ShareTarget: target},
+	}
+	hr := EstimateHashrate(shares, HashrateWindow)
+	if hr <= 0 {
+		t.Errorf("expected positive hashrate, got %f", hr)
+	}
+
+	// Same timestamp → 0 (division by zero guard)
+	sameTime := []*types.Share{
+		{Header: types.ShareHeader{Timestamp: 1700000000}, ShareTarget: target},
+		{Header: types.ShareHeader{Timestamp: 1700000000}, ShareTarget: target},
+	}
+	if EstimateHashrate(sameTime, HashrateWindow) != 0 {
+		t.Error("same timestamp should return 0")
+	}
+}
+
+func TestEstimateHashrate_IgnoresSharesOutsideWindow(t *testing.T) {
+	target := util.CompactToTarget(0x207fffff)
+	recent := []*types.Share{
+		{Header: types.ShareHeader{Timestamp: 1700003630}, ShareTarget: target},
+		{Header: types.ShareHeader{Timestamp: 1700003600}, ShareTarget: target},
+	}
+	// An old share an hour earlier would stretch the interval.
+	withOld := append(append([]*types.Share{}, recent...),
+		&types.Share{Header: types.ShareHeader{Timestamp: 1700000000}, ShareTarget: target})
+
+	if got, want := EstimateHashrate(withOld, 10*time.Minute), EstimateHashrate(recent, 10*time.Minute); got != want {
+		t.Errorf("hashrate with old share = %f, want %f", got, want)
+	}
+}
+
+func TestShareChain_PoolHashrateEmpty(t *testing.T) {
+	sc := NewShareChain(NewMemoryStore(), NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil), 100, testNetwork, testLogger())
+	if hr := sc.PoolHashrate(100); hr != 0 {
+		t.Errorf("empty chain hashrate = %f, want 0", hr)
+	}
+}
@@ -0,0 +1,186 @@
+package sharechain
+
+import (
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+// heightIndex maps shares to their height above genesis and back. Every
+// fork is indexed, so a height can hold several shares; main records which
+// of them lies on the chain ending at the store's tip. Shares whose parent
+// has no known height (added after their ancestors were pruned) are left
+// unindexed: a committed height that can't be checked against a parent is
+// not trusted.
+//
+// heightIndex is not safe for concurrent use; the owning store's lock
+// guards it.
+type heightIndex struct {
+	heights  map[[32]byte]int64
+	byHeight map[int64][][32]byte
+	main     map[int64][32]byte
+}
+
+func newHeightIndex() *heightIndex {
+	return &heightIndex{
+		heights:  make(map[[32]byte]int64),
+		byHeight: make(map[int64][][32]byte),
+		main:     make(map[int64][32]byte),
+	}
+}
+
+// heightOf returns the height share would be indexed at: its parent's
+// height plus one, or 0 for a genesis share. It returns false if the
+// parent has no known height.
+func (ix *heightIndex) heightOf(share *types.Share) (int64, bool) {
+	var zeroHash [32]byte
+	if share.PrevShareHash == zeroHash {
+		return 0, true
+	}
+	parent, ok := ix.heights[share.PrevShareHash]
+	if !ok {
+		return 0, false
+	}
+	return parent + 1, true
+}
+
+// add indexes share at heightOf(share), if known.
+func (ix *heightIndex) add(share *types.Share) {
+	if height, ok := ix.heightOf(share); ok {
+		ix.set(share.Hash(), height)
+	}
+}
+
+// set records hash at height.
+func (ix *heightIndex) set(hash [32]byte, height int64) {
+	if _, ok := ix.heights[hash]; ok {
+		return
+	}
+	ix.heights[hash] = height
+	ix.byHeight[height] = append(ix.byHeight[height], hash)
+}
+
+// derive indexes every share in shares that is not indexed yet but whose
+// ancestry reaches an indexed share or genesis, and returns the newly
+// indexed hashes.
+func (ix *heightIndex) derive(shares map[[32]byte]*types.Share) [][32]byte {
+	var added [][32]byte
+	var zeroHash [32]byte
+	for hash := range shares {
+		var path [][32]byte
+		base := int64(-1)
+		current := hash
+		for len(path) <= len(shares) {
+			if h, ok := ix.heights[current]; ok {
+				base = h
+				break
+			}
+			share, ok := shares[current]
+			if !ok {
+				path = nil
+				break
+			}
+			path = append(path, current)
+			if share.PrevShareHash == zeroHash {
+				break
+			}
+			current = share.PrevShareHash
+		}
+		if len(path) > len(shares) {
+			continue
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			base++
+			ix.set(path[i], base)
+			added = append(added, path[i])
+		}
+	}
+	return added
+}
+
+// remove drops hash from the index.
+func (ix *heightIndex) remove(hash [32]byte) {
+	height, ok := ix.heights[hash]
+	if !ok {
+		return
+	}
+	delete(ix.heights, hash)
+	if ix.main[height] == hash {
+		delete(ix.main, height)
+	}
+	hashes := ix.byHeight[height]
+	for i, h := range hashes {
+		if h == hash {
+			hashes = append(hashes[:i], hashes[i+1:]...)
+			break
+		}
+	}
+	if len(hashes) == 0 {
+		delete(ix.byHeight, height)
+	} else {
+		ix.byHeight[height] = hashes
+	}
+}
+
+// behindTip reports whether hash is an ancestor of tip on the main chain,
+// which main must currently end at.
+func (ix *heightIndex) behindTip(hash, tip [32]byte) bool {
+	height, ok := ix.heights[hash]
+	return ok && hash != tip && ix.main[height] == hash
+}
+
+// setTip points main at the chain ending at tip, rewriting entries back to
+// the point where the old and new chains meet.
+func (ix *heightIndex) setTip(shares map[[32]byte]*types.Share, tip [32]byte) {
+	height, ok := ix.heights[tip]
+	if !ok {
+		clear(ix.main)
+		return
+	}
+	for h := range ix.main {
+		if h > height {
+			delete(ix.main, h)
+		}
+	}
+	current := tip
+	for height >= 0 {
+		share, ok := shares[current]
+		if !ok {
+			break
+		}
+		if ix.main[height] == current {
+			return
+		}
+		ix.main[height] = current
+		current = share.PrevShareHash
+		height--
+	}
+	// Below here the old main chain is not reachable from tip.
+	for h := range ix.main {
+		if h <= height {
+			delete(ix.main, h)
+		}
+	}
+}
+
+// lookup returns the stored shares at height, the main-chain share first.
+func (ix *heightIndex) lookup(shares map[[32]byte]*types.Share, height int64) ([]*types.Share, bool) {
+	hashes := ix.byHeight[height]
+	if len(hashes) == 0 {
+		return nil, false
+	}
+	result := make([]*types.Share, 0, len(hashes))
+	mainHash, hasMain := ix.main[height]
+	if hasMain {
+		if share, ok := shares[mainHash]; ok {
+			result = append(result, share)
+		}
+	}
+	for _, h := range hashes {
+		if hasMain && h == mainHash {
+			continue
+		}
+		if share, ok := shares[h]; ok {
+			result = append(result, share)
+		}
+	}
+	return result, len(result) > 0
+}
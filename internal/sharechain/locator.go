@@ -0,0 +1,105 @@
+package sharechain
+
+// MaxLocatorCount is the most hashes BuildLocator returns. Peers reject
+// inventory requests carrying more locators than this.
+const MaxLocatorCount = 64
+
+// locatorDenseCount is how many of the most recent shares are listed
+// one by one before the gaps start doubling.
+const locatorDenseCount = 10
+
+// BuildLocator returns the hashes tip, tip-1, ..., tip-9, then with
+// exponentially increasing gaps back to genesis, which is always the last
+// entry. A peer finds our fork point as the first locator it knows.
+// Returns nil if tip is not in the store.
+func BuildLocator(store ShareStore, tip [32]byte) [][32]byte {
+	ancestors := store.GetAncestors(tip, store.Count())
+	if len(ancestors) == 0 {
+		return nil
+	}
+
+	// ancestors[0] = tip, ancestors[len-1] = genesis
+	var locators [][32]byte
+	step := 1
+	for idx := 0; idx < len(ancestors) && len(locators) < MaxLocatorCount-1; idx += step {
+		locators = append(locators, ancestors[idx].Hash())
+		if len(locators) >= locatorDenseCount {
+			step *= 2
+		}
+	}
+
+	genesisHash := ancestors[len(ancestors)-1].Hash()
+	if locators[len(locators)-1] != genesisHash {
+		locators = append(locators, genesisHash)
+	}
+
+	return locators
+}
+
+// Locator builds a locator from the current chain tip.
+func (sc *ShareChain) Locator() [][32]byte {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	tip, ok := sc.store.Tip()
+	if !ok {
+		return nil
+	}
+	if locators, ok := buildTipLocator(sc.store, tip.Hash()); ok {
+		return locators
+	}
+	return BuildLocator(sc.store, tip.Hash())
+}
+
+// buildTipLocator builds the same locator as BuildLocator for the store's
+// own tip by looking shares up by height instead of walking the chain.
+// Pruning keeps the main chain contiguous from the tip down and drops any
+// fork that does not rejoin it, so every stored height at or below the tip
+// holds a main-chain share, listed first. Returns false if the tip has no
+// height.
+func buildTipLocator(store ShareStore, tip [32]byte) ([][32]byte, bool) {
+	tipHeight, ok := store.Height(tip)
+	if !ok {
+		return nil, false
+	}
+	at := func(height int64) ([32]byte, bool) {
+		shares, ok := store.GetByHeight(height)
+		if !ok {
+			return [32]byte{}, false
+		}
+		return shares[0].Hash(), true
+	}
+
+	// Binary search for the oldest stored main-chain height.
+	lo, hi := int64(0), tipHeight
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, ok := at(mid); ok {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	var locators [][32]byte
+	step := int64(1)
+	for height := tipHeight; height >= lo && len(locators) < MaxLocatorCount-1; height -= step {
+		hash, ok := at(height)
+		if !ok {
+			return nil, false
+		}
+		locators = append(locators, hash)
+		if len(locators) >= locatorDenseCount {
+			step *= 2
+		}
+	}
+
+	genesisHash, ok := at(lo)
+	if !ok {
+		return nil, false
+	}
+	if locators[len(locators)-1] != genesisHash {
+		locators = append(locators, genesisHash)
+	}
+	return locators, true
+}
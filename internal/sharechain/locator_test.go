@@ -0,0 +1,125 @@
+package sharechain
+
+import (
+	"testing"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+// addChain appends count shares mined by miner on top of prev and returns them.
+func addChain(t *testing.T, store ShareStore, prev [32]byte, miner string, count int, ts uint32) []*types.Share {
+	t.Helper()
+	var shares []*types.Share
+	for i := 0; i < count; i++ {
+		s := makeTestShare(prev, miner, ts+uint32(i*30))
+		if err := store.Add(s); err != nil {
+			t.Fatalf("add share %d: %v", i, err)
+		}
+		shares = append(shares, s)
+		prev = s.Hash()
+	}
+	return shares
+}
+
+func TestBuildLocator_UnknownTip(t *testing.T) {
+	if locators := BuildLocator(NewMemoryStore(), [32]byte{0x01}); locators != nil {
+		t.Errorf("expected nil locators, got %d", len(locators))
+	}
+}
+
+func TestBuildLocator_CappedAndEndsAtGenesis(t *testing.T) {
+	store := NewMemoryStore()
+	shares := addChain(t, store, [32]byte{}, testMiner1, 2000, 1700000000)
+
+	locators := BuildLocator(store, shares[len(shares)-1].Hash())
+	if len(locators) > MaxLocatorCount {
+		t.Fatalf("got %d locators, max %d", len(locators), MaxLocatorCount)
+	}
+	if locators[0] != shares[len(shares)-1].Hash() {
+		t.Error("first locator should be the tip")
+	}
+	if locators[len(locators)-1] != shares[0].Hash() {
+		t.Error("last locator should be genesis")
+	}
+}
+
+func TestBuildLocator_FindsForkPoint(t *testing.T) {
+	// Both sides share the first 300 shares, then diverge.
+	ours := NewMemoryStore()
+	theirs := NewMemoryStore()
+
+	common := addChain(t, ours, [32]byte{}, testMiner1, 300, 1700000000)
+	for _, s := range common {
+		if err := theirs.Add(s); err != nil {
+			t.Fatalf("add common share: %v", err)
+		}
+	}
+	forkHash := common[len(common)-1].Hash()
+	ourBranch := addChain(t, ours, forkHash, testMiner1, 40, 1700010000)
+	addChain(t, theirs, forkHash, testMiner2, 25, 1700010000)
+
+	locators := BuildLocator(ours, ourBranch[len(ourBranch)-1].Hash())
+
+	// The peer answers from the first locator it knows; that must be an
+	// ancestor of the fork and no further back than the locator spacing.
+	var found [32]byte
+	foundIdx := -1
+	for i, h := range locators {
+		if _, ok := theirs.Get(h); ok {
+			found = h
+			foundIdx = i
+			break
+		}
+	}
+	if foundIdx < 0 {
+		t.Fatal("peer recognized no locator")
+	}
+
+	height := -1
+	for i, s := range common {
+		if s.Hash() == found {
+			height = i
+		}
+	}
+	if height < 0 {
+		t.Fatal("matched locator is not on the common chain")
+	}
+	// 40 branch shares put the fork 40 back from our tip; the spacing at
+	// that depth is at most 64, so the peer resends well under 64 shares.
+	if gap := len(common) - 1 - height; gap > 64 {
+		t.Errorf("fork point %d shares before the real fork, want <= 64", gap)
+	}
+}
+
+func TestBuildTipLocator_MatchesWalk(t *testing.T) {
+	store := NewMemoryStore()
+	main := addChain(t, store, [32]byte{}, testMiner1, 1500, 1700000000)
+	addChain(t, store, main[1200].Hash(), testMiner2, 20, 1700100000)
+	tip := main[len(main)-1].Hash()
+	if err := store.SetTip(tip); err != nil {
+		t.Fatalf("SetTip: %v", err)
+	}
+
+	check := func() {
+		t.Helper()
+		got, ok := buildTipLocator(store, tip)
+		if !ok {
+			t.Fatal("tip has no height")
+		}
+		want := BuildLocator(store, tip)
+		if len(got) != len(want) {
+			t.Fatalf("got %d locators, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("locator %d differs", i)
+			}
+		}
+	}
+	check()
+
+	if _, err := store.Prune(500); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	check()
+}
@@ -2,25 +2,49 @@ package sharechain
 
 import (
 	"fmt"
+	"math/big"
 	"sync"
 
 	"github.com/djkazic/p2pool-go/internal/types"
 )
 
+// MaxAncestors caps the number of shares a single GetAncestors walk
+// returns, regardless of the count requested.
+const MaxAncestors = 1 << 20
+
 // ShareStore defines the interface for storing and retrieving shares.
 type ShareStore interface {
 	Add(share *types.Share) error
+	// AddBatch adds shares, parents before children, all or none.
+	AddBatch(shares []*types.Share) error
 	Get(hash [32]byte) (*types.Share, bool)
 	Has(hash [32]byte) bool
 	Tip() (*types.Share, bool)
+	// SetTip moves the tip to hash, which must be stored and must not be
+	// an ancestor of the current tip.
 	SetTip(hash [32]byte) error
 	Count() int
-	// GetAncestors returns shares walking backwards from the given hash, up to count.
+	// GetAncestors returns shares walking backwards from the given hash, up to
+	// count (and at most MaxAncestors). A cycle ends the walk early.
 	GetAncestors(hash [32]byte, count int) []*types.Share
+	// Height returns a share's height above genesis. Shares added after
+	// their ancestry was pruned have no height unless they commit to one.
+	Height(hash [32]byte) (int64, bool)
+	// GetByHeight returns the shares at a height across all forks, with the
+	// share on the chain ending at the tip first when it is stored.
+	GetByHeight(height int64) ([]*types.Share, bool)
+	// TotalWork returns a share's cumulative work back to genesis,
+	// including ancestors pruned since it was added. Shares whose ancestry
+	// did not reach genesis when they were added have none.
+	TotalWork(hash [32]byte) (*big.Int, bool)
 	// Delete removes a share from the store by hash.
 	Delete(hash [32]byte) error
 	// AllHashes returns the hashes of all shares in the store.
 	AllHashes() [][32]byte
+	// Prune deletes shares more than keepDepth behind the current tip.
+	// Shares on any branch that rejoins the main chain within keepDepth
+	// are kept. Returns the number of shares deleted.
+	Prune(keepDepth int) (int, error)
 	Close() error
 }
 
@@ -28,6 +52,8 @@ type ShareStore interface {
 type MemoryStore struct {
 	mu      sync.RWMutex
 	shares  map[[32]byte]*types.Share
+	index   *heightIndex
+	work    *workIndex
 	tipHash [32]byte
 	hasTip  bool
 }
@@ -36,6 +62,8 @@ type MemoryStore struct {
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
 		shares: make(map[[32]byte]*types.Share),
+		index:  newHeightIndex(),
+		work:   newWorkIndex(),
 	}
 }
 
@@ -49,6 +77,31 @@ func (s *MemoryStore) Add(share *types.Share) error {
 	}
 
 	s.shares[hash] = share
+	s.index.add(share)
+	s.work.add(share)
+	return nil
+}
+
+func (s *MemoryStore) AddBatch(shares []*types.Share) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[[32]byte]struct{}, len(shares))
+	for _, share := range shares {
+		hash := share.Hash()
+		_, exists := s.shares[hash]
+		_, dup := seen[hash]
+		if exists || dup {
+			return fmt.Errorf("share %x already exists", hash[:8])
+		}
+		seen[hash] = struct{}{}
+	}
+
+	for _, share := range shares {
+		s.shares[share.Hash()] = share
+		s.index.add(share)
+		s.work.add(share)
+	}
 	return nil
 }
 
@@ -82,8 +135,12 @@ func (s *MemoryStore) SetTip(hash [32]byte) error {
 	if _, ok := s.shares[hash]; !ok {
 		return fmt.Errorf("cannot set tip to unknown share %x", hash[:8])
 	}
+	if err := checkTipRegression(s.shares, s.index, s.tipHash, hash); err != nil {
+		return err
+	}
 	s.tipHash = hash
 	s.hasTip = true
+	s.index.setTip(s.shares, hash)
 	return nil
 }
 
@@ -102,6 +159,8 @@ func (s *MemoryStore) Delete(hash [32]byte) error {
 	}
 
 	delete(s.shares, hash)
+	s.index.remove(hash)
+	s.work.remove(hash)
 	return nil
 }
 
@@ -116,23 +175,139 @@ func (s *MemoryStore) AllHashes() [][32]byte {
 	return hashes
 }
 
+func (s *MemoryStore) Prune(keepDepth int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.hasTip {
+		return 0, nil
+	}
+	toDelete := pruneCandidates(s.shares, s.tipHash, keepDepth)
+	for _, h := range toDelete {
+		delete(s.shares, h)
+		s.index.remove(h)
+		s.work.remove(h)
+	}
+	return len(toDelete), nil
+}
+
+func (s *MemoryStore) Height(hash [32]byte) (int64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	height, ok := s.index.heights[hash]
+	return height, ok
+}
+
+func (s *MemoryStore) GetByHeight(height int64) ([]*types.Share, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.lookup(s.shares, height)
+}
+
+func (s *MemoryStore) TotalWork(hash [32]byte) (*big.Int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.work.get(hash)
+}
+
 func (s *MemoryStore) Close() error { return nil }
 
 func (s *MemoryStore) GetAncestors(hash [32]byte, count int) []*types.Share {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return walkAncestors(s.shares, hash, count)
+}
 
-	var ancestors []*types.Share
-	current := hash
+// walkAncestors follows PrevShareHash links from hash through shares,
+// returning up to count shares. The walk stops early at a missing parent,
+// at a share already visited (a cycle from corrupt or malicious data), or
+// after MaxAncestors hops, so the result is a partial chain rather than an
+// endless loop.
+func walkAncestors(shares map[[32]byte]*types.Share, hash [32]byte, count int) []*types.Share {
+	count = min(count, MaxAncestors, len(shares))
+	if count <= 0 {
+		return nil
+	}
 
-	for i := 0; i < count; i++ {
-		share, ok := s.shares[current]
+	ancestors := make([]*types.Share, 0, count)
+	visited := make(map[[32]byte]struct{}, count)
+	current := hash
+	for len(ancestors) < count {
+		share, ok := shares[current]
 		if !ok {
 			break
 		}
+		if _, seen := visited[current]; seen {
+			break
+		}
+		visited[current] = struct{}{}
 		ancestors = append(ancestors, share)
 		current = share.PrevShareHash
 	}
 
 	return ancestors
 }
+
+// checkTipRegression refuses moving the tip from tip back to one of its own
+// ancestors: fork choice only ever moves it to a share not behind it, so
+// such a move is a late or replayed share clobbering a better tip. Moving
+// to another branch, or away from a tip that is no longer stored, is
+// allowed.
+func checkTipRegression(shares map[[32]byte]*types.Share, index *heightIndex, tip, hash [32]byte) error {
+	if _, ok := shares[tip]; !ok || !index.behindTip(hash, tip) {
+		return nil
+	}
+	return fmt.Errorf("cannot move tip back to its ancestor %x", hash[:8])
+}
+
+// pruneCandidates returns the hashes in shares that lie more than keepDepth
+// behind tipHash. A share is kept if it is one of the keepDepth most recent
+// main-chain shares, or if following its parents reaches one of those (a
+// recent fork that could still win a reorg).
+func pruneCandidates(shares map[[32]byte]*types.Share, tipHash [32]byte, keepDepth int) [][32]byte {
+	if keepDepth <= 0 {
+		return nil
+	}
+
+	// keep caches the decision for every share visited so far.
+	keep := make(map[[32]byte]bool, len(shares))
+	current := tipHash
+	for i := 0; i < keepDepth; i++ {
+		share, ok := shares[current]
+		if !ok {
+			break
+		}
+		keep[current] = true
+		current = share.PrevShareHash
+	}
+
+	var toDelete [][32]byte
+	for hash := range shares {
+		// Walk back until a decided share or the end of the stored chain.
+		// Every share on the path shares the outcome of the walk.
+		var path [][32]byte
+		result := false
+		current := hash
+		for len(path) <= len(shares) {
+			if decided, ok := keep[current]; ok {
+				result = decided
+				break
+			}
+			share, ok := shares[current]
+			if !ok {
+				break
+			}
+			path = append(path, current)
+			current = share.PrevShareHash
+		}
+
+		for _, h := range path {
+			keep[h] = result
+			if !result {
+				toDelete = append(toDelete, h)
+			}
+		}
+	}
+
+	return toDelete
+}
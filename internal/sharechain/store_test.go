@@ -0,0 +1,301 @@
+package sharechain
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+// storeImpls opens an empty store of each ShareStore implementation. The
+// tests below run against all of them so they keep the same semantics.
+var storeImpls = map[string]func(t *testing.T) ShareStore{
+	"memory": func(t *testing.T) ShareStore {
+		return NewMemoryStore()
+	},
+	"bolt": func(t *testing.T) ShareStore {
+		store, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"), testLogger())
+		if err != nil {
+			t.Fatalf("NewBoltStore: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	},
+}
+
+func forEachStore(t *testing.T, fn func(t *testing.T, store ShareStore)) {
+	for name, open := range storeImpls {
+		t.Run(name, func(t *testing.T) {
+			fn(t, open(t))
+		})
+	}
+}
+
+func TestStore_AddGetHas(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store ShareStore) {
+		share := makeTestShare([32]byte{}, testMiner1, 1700000000)
+		hash := share.Hash()
+		if store.Has(hash) {
+			t.Fatal("empty store has share")
+		}
+		if err := store.Add(share); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if err := store.Add(share); err == nil {
+			t.Error("duplicate Add should fail")
+		}
+		got, ok := store.Get(hash)
+		if !ok || got.Hash() != hash {
+			t.Error("Get after Add failed")
+		}
+		if !store.Has(hash) || store.Count() != 1 {
+			t.Errorf("Has = %v, Count = %d", store.Has(hash), store.Count())
+		}
+		if _, ok := store.Get([32]byte{0xff}); ok {
+			t.Error("Get of unknown share succeeded")
+		}
+	})
+}
+
+func TestStore_Tip(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store ShareStore) {
+		if _, ok := store.Tip(); ok {
+			t.Error("empty store has a tip")
+		}
+		if err := store.SetTip([32]byte{0xff}); err == nil {
+			t.Error("SetTip to unknown share should fail")
+		}
+		shares := addChain(t, store, [32]byte{}, testMiner1, 3, 1700000000)
+		if _, ok := store.Tip(); ok {
+			t.Error("Add should not set the tip")
+		}
+		if err := store.SetTip(shares[1].Hash()); err != nil {
+			t.Fatalf("SetTip: %v", err)
+		}
+		tip, ok := store.Tip()
+		if !ok || tip.Hash() != shares[1].Hash() {
+			t.Error("tip mismatch")
+		}
+	})
+}
+
+func TestStore_SetTipRefusesRegression(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store ShareStore) {
+		chain := addChain(t, store, [32]byte{}, testMiner1, 3, 1700000000)
+		fork := addChain(t, store, chain[0].Hash(), testMiner2, 1, 1700001000)
+		if err := store.SetTip(chain[2].Hash()); err != nil {
+			t.Fatalf("SetTip: %v", err)
+		}
+
+		for _, s := range chain[:2] {
+			if err := store.SetTip(s.Hash()); err == nil {
+				t.Errorf("tip moved back to ancestor %s", s.HashHex())
+			}
+		}
+		if tip, _ := store.Tip(); tip.Hash() != chain[2].Hash() {
+			t.Fatalf("tip = %s, want %s", tip.HashHex(), chain[2].HashHex())
+		}
+		if err := store.SetTip(chain[2].Hash()); err != nil {
+			t.Errorf("setting the same tip again: %v", err)
+		}
+
+		// Another branch is fork choice's call.
+		if err := store.SetTip(fork[0].Hash()); err != nil {
+			t.Errorf("SetTip to a fork: %v", err)
+		}
+		if err := store.SetTip(chain[2].Hash()); err != nil {
+			t.Errorf("SetTip back to the main chain: %v", err)
+		}
+	})
+}
+
+func TestStore_GetAncestorsAndHeights(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store ShareStore) {
+		shares := addChain(t, store, [32]byte{}, testMiner1, 6, 1700000000)
+		tip := shares[5].Hash()
+
+		ancestors := store.GetAncestors(tip, 4)
+		if len(ancestors) != 4 || ancestors[0].Hash() != tip || ancestors[3].Hash() != shares[2].Hash() {
+			t.Errorf("GetAncestors(4) returned %d shares in the wrong order", len(ancestors))
+		}
+		if got := store.GetAncestors(tip, 100); len(got) != 6 {
+			t.Errorf("GetAncestors(100) = %d, want 6", len(got))
+		}
+		if got := store.GetAncestors([32]byte{0xff}, 10); len(got) != 0 {
+			t.Errorf("GetAncestors of unknown share = %d, want 0", len(got))
+		}
+
+		if h, ok := store.Height(tip); !ok || h != 5 {
+			t.Errorf("Height = %d, %v; want 5", h, ok)
+		}
+		if got, ok := store.GetByHeight(2); !ok || got[0].Hash() != shares[2].Hash() {
+			t.Error("GetByHeight(2) mismatch")
+		}
+	})
+}
+
+func TestStore_CommittedHeight(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store ShareStore) {
+		// The parent was pruned before this store saw the shares.
+		share := makeTestShareV2([32]byte{0xaa}, testMiner1, 100, 1700000000)
+		child := makeTestShare(share.Hash(), testMiner1, 1700000030)
+		for _, s := range []*types.Share{share, child} {
+			if err := store.Add(s); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+		}
+		// A committed height with no parent to check it against is not
+		// trusted, so neither share is indexed.
+		if h, ok := store.Height(share.Hash()); ok {
+			t.Errorf("share Height = %d, want unindexed", h)
+		}
+		if h, ok := store.Height(child.Hash()); ok {
+			t.Errorf("child Height = %d, want unindexed", h)
+		}
+		if got, ok := store.Get(share.Hash()); !ok || got.Height != 100 {
+			t.Error("committed height not stored")
+		}
+	})
+}
+
+func TestStore_DeleteAndPrune(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store ShareStore) {
+		shares := addChain(t, store, [32]byte{}, testMiner1, 10, 1700000000)
+		if err := store.SetTip(shares[9].Hash()); err != nil {
+			t.Fatalf("SetTip: %v", err)
+		}
+
+		if err := store.Delete([32]byte{0xff}); err == nil {
+			t.Error("Delete of unknown share should fail")
+		}
+		if err := store.Delete(shares[0].Hash()); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if store.Has(shares[0].Hash()) || len(store.AllHashes()) != 9 {
+			t.Error("deleted share still present")
+		}
+
+		pruned, err := store.Prune(4)
+		if err != nil {
+			t.Fatalf("Prune: %v", err)
+		}
+		if pruned != 5 || store.Count() != 4 {
+			t.Errorf("pruned %d, count %d; want 5, 4", pruned, store.Count())
+		}
+		if _, ok := store.GetByHeight(3); ok {
+			t.Error("pruned height still indexed")
+		}
+	})
+}
+
+func TestStore_AddBatch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store ShareStore) {
+		shares := makeTestChain([32]byte{}, 5, 1700000000)
+		if err := store.AddBatch(shares); err != nil {
+			t.Fatalf("AddBatch: %v", err)
+		}
+		if err := store.AddBatch(makeTestChain(shares[4].Hash(), 2, 1700001000)); err != nil {
+			t.Fatalf("AddBatch on top: %v", err)
+		}
+		if store.Count() != 7 {
+			t.Errorf("count = %d, want 7", store.Count())
+		}
+
+		// A batch with a stored share is rejected whole.
+		next := makeTestChain(shares[0].Hash(), 2, 1700002000)
+		if err := store.AddBatch(append(next, shares[3])); err == nil {
+			t.Error("batch with a stored share should fail")
+		}
+		if store.Has(next[0].Hash()) || store.Count() != 7 {
+			t.Error("failed batch left shares behind")
+		}
+	})
+}
+
+// referenceWork sums the difficulty of the stored chain ending at hash.
+func referenceWork(store ShareStore, hash [32]byte) *big.Int {
+	total := new(big.Int)
+	for _, s := range store.GetAncestors(hash, MaxAncestors) {
+		total.Add(total, new(big.Int).Div(MaxShareTarget, s.ShareTarget))
+	}
+	return total
+}
+
+func TestStore_TotalWork(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store ShareStore) {
+		shares := makeTestChain([32]byte{}, 6, 1700000000)
+		for i, s := range shares {
+			s.ShareTarget = new(big.Int).Rsh(maxTarget(), uint(i%3))
+		}
+		if err := store.AddBatch(shares[:3]); err != nil {
+			t.Fatalf("AddBatch: %v", err)
+		}
+		for _, s := range shares[3:] {
+			if err := store.Add(s); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+		}
+		fork := addChain(t, store, shares[1].Hash(), testMiner2, 3, 1700001000)
+
+		for _, s := range append(shares, fork...) {
+			got, ok := store.TotalWork(s.Hash())
+			if want := referenceWork(store, s.Hash()); !ok || got.Cmp(want) != 0 {
+				t.Errorf("TotalWork(%s) = %v, %v; want %v", s.HashHex(), got, ok, want)
+			}
+		}
+		if _, ok := store.TotalWork([32]byte{0xff}); ok {
+			t.Error("unknown share has total work")
+		}
+
+		// Totals keep the work of pruned ancestors.
+		tipHash := shares[5].Hash()
+		want := referenceWork(store, tipHash)
+		if err := store.SetTip(tipHash); err != nil {
+			t.Fatalf("SetTip: %v", err)
+		}
+		if _, err := store.Prune(2); err != nil {
+			t.Fatalf("Prune: %v", err)
+		}
+		if got, _ := store.TotalWork(tipHash); got.Cmp(want) != 0 {
+			t.Errorf("tip work after prune = %v, want %v", got, want)
+		}
+
+		// A share stored before its parent gets no total rather than one
+		// missing its ancestors' work.
+		orphan := makeTestShare([32]byte{0x01}, testMiner1, 1700002000)
+		if err := store.Add(orphan); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if total, ok := store.TotalWork(orphan.Hash()); ok {
+			t.Errorf("share without a parent has total work %v", total)
+		}
+	})
+}
+
+func TestForkChoice_ChainWorkUsesTotals(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store ShareStore) {
+		shares := makeTestChain([32]byte{}, 6, 1700000000)
+		for i, s := range shares {
+			s.ShareTarget = new(big.Int).Rsh(maxTarget(), uint(i%3))
+		}
+		if err := store.AddBatch(shares); err != nil {
+			t.Fatalf("AddBatch: %v", err)
+		}
+		fc := NewForkChoice(store)
+		tipHash := shares[5].Hash()
+
+		// The whole chain fits the window: the total is the answer.
+		if got, want := fc.ChainWork(tipHash, 10), referenceWork(store, tipHash); got.Cmp(want) != 0 {
+			t.Errorf("ChainWork over the whole chain = %v, want %v", got, want)
+		}
+		// A shorter window still sums only its shares.
+		want := new(big.Int)
+		for _, s := range shares[3:] {
+			want.Add(want, shareWork(s))
+		}
+		if got := fc.ChainWork(tipHash, 3); got.Cmp(want) != 0 {
+			t.Errorf("ChainWork over 3 shares = %v, want %v", got, want)
+		}
+	})
+}
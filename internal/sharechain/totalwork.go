@@ -0,0 +1,111 @@
+package sharechain
+
+import (
+	"math/big"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+// workIndex caches each share's cumulative work: its own work plus its
+// parent's total, so a total reaches back to genesis without walking the
+// chain. Like heights, a total is only known for a share whose parent has
+// one, or for genesis: a share added after its ancestors were pruned, or
+// before its parent arrived, is left out rather than indexed with a total
+// that would undercount its chain. Totals survive the pruning of the
+// ancestors they include.
+//
+// workIndex is not safe for concurrent use; the owning store's lock guards
+// it.
+type workIndex struct {
+	totals map[[32]byte]*big.Int
+}
+
+func newWorkIndex() *workIndex {
+	return &workIndex{totals: make(map[[32]byte]*big.Int)}
+}
+
+// addWork returns parentTotal plus share's own work.
+func addWork(parentTotal *big.Int, share *types.Share) *big.Int {
+	total := shareWork(share)
+	return total.Add(total, parentTotal)
+}
+
+// workOf returns the total share would be indexed with: its parent's plus
+// its own, or its own for a genesis share. It returns false if the parent
+// has no known total.
+func (wi *workIndex) workOf(share *types.Share) (*big.Int, bool) {
+	var zeroHash [32]byte
+	if share.PrevShareHash == zeroHash {
+		return shareWork(share), true
+	}
+	parent, ok := wi.totals[share.PrevShareHash]
+	if !ok {
+		return nil, false
+	}
+	return addWork(parent, share), true
+}
+
+// add indexes share at workOf(share), if known.
+func (wi *workIndex) add(share *types.Share) {
+	if total, ok := wi.workOf(share); ok {
+		wi.set(share.Hash(), total)
+	}
+}
+
+// set records hash's total unless it already has one.
+func (wi *workIndex) set(hash [32]byte, total *big.Int) {
+	if _, ok := wi.totals[hash]; !ok {
+		wi.totals[hash] = total
+	}
+}
+
+// get returns a copy of hash's total.
+func (wi *workIndex) get(hash [32]byte) (*big.Int, bool) {
+	total, ok := wi.totals[hash]
+	if !ok {
+		return nil, false
+	}
+	return new(big.Int).Set(total), true
+}
+
+// derive indexes every share in shares that has no total yet but whose
+// ancestry reaches a share with one or genesis, and returns the newly
+// indexed hashes.
+func (wi *workIndex) derive(shares map[[32]byte]*types.Share) [][32]byte {
+	var added [][32]byte
+	var zeroHash [32]byte
+	for hash := range shares {
+		var path []*types.Share
+		current := hash
+		for len(path) <= len(shares) {
+			if _, ok := wi.totals[current]; ok {
+				break
+			}
+			share, ok := shares[current]
+			if !ok {
+				path = nil
+				break
+			}
+			path = append(path, share)
+			if share.PrevShareHash == zeroHash {
+				break
+			}
+			current = share.PrevShareHash
+		}
+		if len(path) > len(shares) {
+			continue
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			h := path[i].Hash()
+			total, _ := wi.workOf(path[i])
+			wi.set(h, total)
+			added = append(added, h)
+		}
+	}
+	return added
+}
+
+// remove drops hash from the index.
+func (wi *workIndex) remove(hash [32]byte) {
+	delete(wi.totals, hash)
+}
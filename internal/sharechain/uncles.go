@@ -0,0 +1,167 @@
+package sharechain
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+const (
+	// MaxUncleDepth is how far back an uncle's parent may be from the
+	// including share's parent. An uncle whose parent is the including
+	// share's parent (depth 1) is a direct competitor of that share.
+	MaxUncleDepth = 3
+
+	// MaxUnclesPerShare caps how many uncles a single share may reference.
+	MaxUnclesPerShare = 2
+)
+
+// validateUncles checks the uncle references of a share. Only version 2
+// shares may reference uncles, as older nodes commit to the parent alone.
+// Every uncle must be a known share that forks off one of the share's
+// MaxUncleDepth most recent ancestors, must not itself be an ancestor, and
+// must not already have been credited by an ancestor. An unknown uncle, like
+// a missing parent, may just not have arrived yet.
+func (v *Validator) validateUncles(share *types.Share) error {
+	if len(share.Uncles) == 0 {
+		return nil
+	}
+	if share.ShareVersion < types.ShareVersion2 {
+		return &ValidationError{Reason: fmt.Sprintf("version %d share references uncles", share.ShareVersion)}
+	}
+	if len(share.Uncles) > MaxUnclesPerShare {
+		return &ValidationError{Reason: fmt.Sprintf("too many uncles: %d (max %d)", len(share.Uncles), MaxUnclesPerShare)}
+	}
+
+	var zeroHash [32]byte
+	if share.PrevShareHash == zeroHash {
+		return &ValidationError{Reason: "genesis share cannot reference uncles"}
+	}
+
+	recent, onChain, credited := uncleContext(v.store, share.PrevShareHash)
+
+	seen := make(map[[32]byte]struct{}, len(share.Uncles))
+	for _, u := range share.Uncles {
+		if _, dup := seen[u]; dup {
+			return &ValidationError{Reason: fmt.Sprintf("duplicate uncle %x", u[:8])}
+		}
+		seen[u] = struct{}{}
+
+		uncle, ok := v.store.Get(u)
+		if !ok {
+			return &ValidationError{Reason: fmt.Sprintf("uncle %x not found", u[:8])}
+		}
+		if _, ok := onChain[u]; ok {
+			return &ValidationError{Reason: fmt.Sprintf("uncle %x is an ancestor", u[:8])}
+		}
+		if _, ok := recent[uncle.PrevShareHash]; !ok {
+			return &ValidationError{Reason: fmt.Sprintf("uncle %x is not a recent fork", u[:8])}
+		}
+		if _, ok := credited[u]; ok {
+			return &ValidationError{Reason: fmt.Sprintf("uncle %x already credited", u[:8])}
+		}
+	}
+
+	return nil
+}
+
+// uncleContext gathers what is needed to judge uncles for a share built on
+// parentHash: the ancestors an uncle may fork from, every share on the chain
+// that could be mistaken for an uncle, and the uncles already credited by
+// those ancestors.
+func uncleContext(store ShareStore, parentHash [32]byte) (recent, onChain, credited map[[32]byte]struct{}) {
+	ancestors := store.GetAncestors(parentHash, MaxUncleDepth+1)
+
+	recent = make(map[[32]byte]struct{}, MaxUncleDepth)
+	onChain = make(map[[32]byte]struct{}, len(ancestors))
+	credited = make(map[[32]byte]struct{})
+	for i, a := range ancestors {
+		h := a.Hash()
+		onChain[h] = struct{}{}
+		if i < MaxUncleDepth {
+			recent[h] = struct{}{}
+			for _, u := range a.Uncles {
+				credited[u] = struct{}{}
+			}
+		}
+	}
+	return recent, onChain, credited
+}
+
+// SelectUncles returns up to MaxUnclesPerShare uncles that a new share built
+// on parentHash may reference. Candidates closest to the parent are preferred;
+// ties are broken by hash for determinism. Candidates are looked up by
+// height, so if the parent has no known height none are returned.
+func (sc *ShareChain) SelectUncles(parentHash [32]byte) [][32]byte {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	var zeroHash [32]byte
+	if parentHash == zeroHash {
+		return nil
+	}
+	parentHeight, ok := sc.store.Height(parentHash)
+	if !ok {
+		return nil
+	}
+
+	_, onChain, credited := uncleContext(sc.store, parentHash)
+
+	type candidate struct {
+		hash  [32]byte
+		depth int
+	}
+	var candidates []candidate
+	// An uncle forking off the ancestor at depth d sits one above it.
+	for depth, a := range sc.store.GetAncestors(parentHash, MaxUncleDepth) {
+		siblings, _ := sc.store.GetByHeight(parentHeight - int64(depth) + 1)
+		for _, s := range siblings {
+			h := s.Hash()
+			if s.PrevShareHash != a.Hash() {
+				continue
+			}
+			if _, ok := onChain[h]; ok {
+				continue
+			}
+			if _, ok := credited[h]; ok {
+				continue
+			}
+			candidates = append(candidates, candidate{hash: h, depth: depth})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].depth != candidates[j].depth {
+			return candidates[i].depth < candidates[j].depth
+		}
+		return bytes.Compare(candidates[i].hash[:], candidates[j].hash[:]) < 0
+	})
+
+	var uncles [][32]byte
+	for _, c := range candidates {
+		if len(uncles) == MaxUnclesPerShare {
+			break
+		}
+		uncles = append(uncles, c.hash)
+	}
+	return uncles
+}
+
+// GetUncles resolves the uncles referenced by the given shares. Uncles that
+// are no longer in the store are skipped.
+func (sc *ShareChain) GetUncles(shares []*types.Share) []*types.Share {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	var uncles []*types.Share
+	for _, s := range shares {
+		for _, u := range s.Uncles {
+			if uncle, ok := sc.store.Get(u); ok {
+				uncles = append(uncles, uncle)
+			}
+		}
+	}
+	return uncles
+}
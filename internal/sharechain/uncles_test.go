@@ -0,0 +1,151 @@
+package sharechain
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+// makeTestShareWithUncles creates a valid version 2 share at height that
+// references the given uncles, rebuilding the coinbase so it commits to
+// them.
+func makeTestShareWithUncles(prevShareHash [32]byte, minerAddr string, height int64, timestamp uint32, uncles [][32]byte) *types.Share {
+	s := makeTestShare(prevShareHash, minerAddr, timestamp)
+	s.ShareVersion = types.ShareVersion2
+	s.Height = height
+	s.Uncles = uncles
+
+	builder := types.NewCoinbaseBuilder(testNetwork)
+	commitment := types.BuildShareCommitment(s.CommitmentHash())
+	payouts := []types.PayoutEntry{
+		{Address: minerAddr, Amount: 5000000000},
+	}
+	coinbaseTx, _, err := builder.BuildCoinbase(800000, commitment, payouts, "", 8)
+	if err != nil {
+		panic("makeTestShareWithUncles: BuildCoinbase failed: " + err.Error())
+	}
+	s.CoinbaseTx = coinbaseTx
+	return s
+}
+
+// forkedChain builds genesis plus two competing children and returns the
+// chain along with the child that became the tip and the one that lost.
+func forkedChain(t *testing.T) (*ShareChain, *types.Share, *types.Share) {
+	t.Helper()
+	chain := NewShareChain(NewMemoryStore(), NewDifficultyCalculator(30*time.Second, DifficultyAdjustmentWindow, nil), 8640, testNetwork, testLogger())
+
+	base := uint32(time.Now().Add(-5 * time.Minute).Unix())
+	genesis := makeTestShare([32]byte{}, testMiner1, base)
+	a := makeTestShare(genesis.Hash(), testMiner1, base+30)
+	b := makeTestShare(genesis.Hash(), testMiner2, base+31)
+	for _, s := range []*types.Share{genesis, a, b} {
+		if err := chain.AddShare(s); err != nil {
+			t.Fatalf("AddShare: %v", err)
+		}
+	}
+
+	tip, _ := chain.Tip()
+	if tip.Hash() == a.Hash() {
+		return chain, a, b
+	}
+	return chain, b, a
+}
+
+func TestUncles_SelectAndAccept(t *testing.T) {
+	chain, main, uncle := forkedChain(t)
+
+	selected := chain.SelectUncles(main.Hash())
+	if len(selected) != 1 || selected[0] != uncle.Hash() {
+		t.Fatalf("SelectUncles = %x, want [%x]", selected, uncle.Hash())
+	}
+
+	ts := main.Header.Timestamp + 30
+	child := makeTestShareWithUncles(main.Hash(), testMiner1, 2, ts, selected)
+	if err := chain.AddShare(child); err != nil {
+		t.Fatalf("share with valid uncle rejected: %v", err)
+	}
+
+	if got := chain.SelectUncles(child.Hash()); len(got) != 0 {
+		t.Errorf("already credited uncle selected again: %x", got)
+	}
+	uncles := chain.GetUncles([]*types.Share{child, main})
+	if len(uncles) != 1 || uncles[0].Hash() != uncle.Hash() {
+		t.Errorf("GetUncles returned %d shares, want the uncle", len(uncles))
+	}
+
+	// A later share may not credit the same uncle again.
+	again := makeTestShareWithUncles(child.Hash(), testMiner1, 3, ts+30, [][32]byte{uncle.Hash()})
+	if err := chain.AddShare(again); err == nil {
+		t.Error("expected rejection for uncle already credited by an ancestor")
+	}
+}
+
+func TestUncles_Rejections(t *testing.T) {
+	chain, main, uncle := forkedChain(t)
+	ts := main.Header.Timestamp + 30
+
+	tests := []struct {
+		name   string
+		uncles [][32]byte
+	}{
+		{"unknown", [][32]byte{{0xde, 0xad}}},
+		{"ancestor", [][32]byte{main.Hash()}},
+		{"duplicate", [][32]byte{uncle.Hash(), uncle.Hash()}},
+		{"too many", [][32]byte{uncle.Hash(), {0x01}, {0x02}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := makeTestShareWithUncles(main.Hash(), testMiner1, 2, ts, tt.uncles)
+			if err := chain.AddShare(s); err == nil {
+				t.Error("expected rejection")
+			}
+		})
+	}
+
+	// Uncles without a matching coinbase commitment are rejected too.
+	s := makeTestShareV2(main.Hash(), testMiner1, 2, ts)
+	s.Uncles = [][32]byte{uncle.Hash()}
+	if err := chain.AddShare(s); err == nil {
+		t.Error("expected rejection for uncles missing from the commitment")
+	}
+
+	// Version 1 shares can't reference uncles at all, even when their
+	// commitment covers them.
+	v1 := makeTestShare(main.Hash(), testMiner1, ts)
+	v1.Uncles = [][32]byte{uncle.Hash()}
+	builder := types.NewCoinbaseBuilder(testNetwork)
+	commitment := types.BuildShareCommitment(types.ShareCommitmentHash(main.Hash(), v1.Uncles))
+	coinbaseTx, _, err := builder.BuildCoinbase(800000, commitment, []types.PayoutEntry{{Address: testMiner1, Amount: 5000000000}}, "", 8)
+	if err != nil {
+		t.Fatalf("BuildCoinbase: %v", err)
+	}
+	v1.CoinbaseTx = coinbaseTx
+	if err := chain.AddShare(v1); err == nil || !strings.Contains(err.Error(), "references uncles") {
+		t.Errorf("version 1 share with uncles: err = %v, want rejection", err)
+	}
+}
+
+func TestUncles_SelectByHeight(t *testing.T) {
+	chain, main, uncle := forkedChain(t)
+
+	// Two more shares on main: the uncle is now at depth 2, then out of
+	// reach.
+	ts := main.Header.Timestamp + 30
+	prev := main
+	for i, want := range []int{1, 0} {
+		next := makeTestShare(prev.Hash(), testMiner1, ts+uint32(i)*30)
+		if err := chain.AddShare(next); err != nil {
+			t.Fatalf("AddShare: %v", err)
+		}
+		prev = next
+		got := chain.SelectUncles(next.Hash())
+		if len(got) != want {
+			t.Fatalf("SelectUncles at depth %d = %x, want %d uncles", i+2, got, want)
+		}
+		if want == 1 && got[0] != uncle.Hash() {
+			t.Errorf("SelectUncles = %x, want %x", got[0], uncle.Hash())
+		}
+	}
+}
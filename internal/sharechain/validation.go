@@ -24,6 +24,15 @@ const (
 	// maxMinerAddressLen is the maximum allowed miner address length.
 	// Bech32m addresses are at most ~90 characters.
 	maxMinerAddressLen = 128
+
+	// maxMoney is Bitcoin's MAX_MONEY: no output, and no coinbase total,
+	// may exceed 21 million BTC.
+	maxMoney = 21_000_000 * 100_000_000
+
+	// coinbaseValueTolerance is how far, as a fraction, a share's coinbase
+	// total may exceed our own template's coinbase value before we warn.
+	// Peers build on their own mempools, so fees differ between templates.
+	coinbaseValueTolerance = 0.05
 )
 
 // ValidationError represents a share validation failure.
@@ -39,12 +48,21 @@ func (e *ValidationError) Error() string {
 type Validator struct {
 	store          ShareStore
 	targetFunc     func(parentHash [32]byte) *big.Int
-	network        string
+	network        types.NetworkParams
 	skipTimeChecks bool // set during ValidateLoaded replay
+
+	// minTimeFunc returns the Bitcoin MinTime for blocks built on
+	// prevBlockHash, or false if it is not known.
+	minTimeFunc func(prevBlockHash [32]byte) (uint32, bool)
+
+	// coinbaseValueFunc returns the coinbase value (subsidy plus fees) of
+	// our template for blocks built on prevBlockHash, or false if it is not
+	// known. It only feeds coinbaseOverpay's warning.
+	coinbaseValueFunc func(prevBlockHash [32]byte) (int64, bool)
 }
 
 // NewValidator creates a new share validator.
-func NewValidator(store ShareStore, targetFunc func(parentHash [32]byte) *big.Int, network string) *Validator {
+func NewValidator(store ShareStore, targetFunc func(parentHash [32]byte) *big.Int, network types.NetworkParams) *Validator {
 	return &Validator{
 		store:      store,
 		targetFunc: targetFunc,
@@ -54,9 +72,9 @@ func NewValidator(store ShareStore, targetFunc func(parentHash [32]byte) *big.In
 
 // ValidateShare performs all validation checks on a share.
 func (v *Validator) ValidateShare(share *types.Share) error {
-	// 1. ShareVersion must equal 1
-	if share.ShareVersion != 1 {
-		return &ValidationError{Reason: fmt.Sprintf("unsupported share version %d, expected 1", share.ShareVersion)}
+	// 1. ShareVersion must be one we know, with its version-specific fields
+	if err := v.validateVersion(share); err != nil {
+		return err
 	}
 
 	// 2. Size limits — reject before any expensive processing
@@ -67,11 +85,11 @@ func (v *Validator) ValidateShare(share *types.Share) error {
 		return &ValidationError{Reason: fmt.Sprintf("coinbase tx too large: %d bytes", len(share.CoinbaseTx))}
 	}
 
-	// 3. MinerAddress must be valid bech32 for network
+	// 3. MinerAddress must be a valid address for network
 	if share.MinerAddress == "" {
 		return &ValidationError{Reason: "missing miner address"}
 	}
-	if err := types.ValidateAddress(share.MinerAddress, v.network); err != nil {
+	if err := types.ValidateShareAddress(share.MinerAddress, share.ShareVersion, v.network); err != nil {
 		return &ValidationError{Reason: fmt.Sprintf("invalid miner address: %v", err)}
 	}
 
@@ -103,6 +121,15 @@ func (v *Validator) ValidateShare(share *types.Share) error {
 				}
 			}
 		}
+
+		// Not before the block's MinTime — the share is also a candidate
+		// block, and bitcoind rejects those as time-too-old.
+		if v.minTimeFunc != nil {
+			if minTime, ok := v.minTimeFunc(share.Header.PrevBlockHash); ok && share.Header.Timestamp < minTime {
+				return &ValidationError{Reason: fmt.Sprintf(
+					"share timestamp %d is before block min time %d", share.Header.Timestamp, minTime)}
+			}
+		}
 	}
 
 	// 5. Expected target — compute via targetFunc from parent
@@ -113,27 +140,46 @@ func (v *Validator) ValidateShare(share *types.Share) error {
 		return &ValidationError{Reason: "share does not meet required target"}
 	}
 
-	// 7. ShareTarget consistency — declared target must match consensus
+	// 7. ShareTarget consistency — declared target must match consensus and
+	// be exactly the expansion of its canonical compact bits, so every node
+	// agrees on one encoding per target
+	if share.ShareTarget == nil || share.ShareTarget.Sign() <= 0 {
+		return &ValidationError{Reason: "missing share target"}
+	}
 	declaredBits := util.TargetToCompact(share.ShareTarget)
+	if !util.IsCanonicalCompact(declaredBits) || util.CompactToTarget(declaredBits).Cmp(share.ShareTarget) != 0 {
+		return &ValidationError{Reason: fmt.Sprintf(
+			"share target %x has no canonical compact encoding", share.ShareTarget)}
+	}
+	if share.ShareTarget.Cmp(v.network.MaxShareTarget()) > 0 {
+		return &ValidationError{Reason: fmt.Sprintf(
+			"share target bits 0x%08x below the network's minimum difficulty (0x%08x)", declaredBits, v.network.MaxShareBits)}
+	}
 	expectedBits := util.TargetToCompact(expectedTarget)
 	if declaredBits != expectedBits {
 		return &ValidationError{Reason: fmt.Sprintf(
 			"share target mismatch: declared bits 0x%08x, expected 0x%08x", declaredBits, expectedBits)}
 	}
 
-	// 8. Coinbase commitment — must contain correct PrevShareHash
+	// 8. Uncles — each must be a recent, not-yet-credited fork share
+	if err := v.validateUncles(share); err != nil {
+		return err
+	}
+
+	// 9. Coinbase commitment — must contain correct PrevShareHash (and
+	// uncles, and for version 2 MinerAddress)
 	if len(share.CoinbaseTx) > 0 {
 		committedHash, err := types.ExtractShareCommitment(share.CoinbaseTx)
 		if err != nil {
 			return &ValidationError{Reason: fmt.Sprintf("coinbase commitment extraction failed: %v", err)}
 		}
-		if committedHash != share.PrevShareHash {
+		if committedHash != share.CommitmentHash() {
 			return &ValidationError{Reason: fmt.Sprintf(
-				"coinbase commitment %x does not match PrevShareHash %x",
-				committedHash[:8], share.PrevShareHash[:8])}
+				"coinbase commitment %x does not match v%d share fields (PrevShareHash %x)",
+				committedHash[:8], share.ShareVersion, share.PrevShareHash[:8])}
 		}
 
-		// 9. Miner in outputs — coinbase must pay MinerAddress
+		// 10. Miner in outputs — coinbase must pay MinerAddress
 		outputs, err := types.ParseCoinbaseOutputs(share.CoinbaseTx)
 		if err != nil {
 			return &ValidationError{Reason: fmt.Sprintf("coinbase output parsing failed: %v", err)}
@@ -141,17 +187,119 @@ func (v *Validator) ValidateShare(share *types.Share) error {
 		if err := types.ValidateMinerInOutputs(outputs, share.MinerAddress, v.network); err != nil {
 			return &ValidationError{Reason: fmt.Sprintf("miner not in coinbase outputs: %v", err)}
 		}
+
+		// 11. Coinbase value — outputs must stay within MAX_MONEY, or the
+		// share could never be a valid block
+		if err := v.validateCoinbaseValue(outputs); err != nil {
+			return err
+		}
 	} else {
 		return &ValidationError{Reason: "missing coinbase transaction"}
 	}
 
-	// Note: nBits (Bitcoin target) is not validated because we cannot know which
-	// Bitcoin block template the miner used. The sharechain only requires the
-	// share hash to meet the sharechain target.
+	// 12. Header nBits must be canonical; bitcoind would reject the block
+	// otherwise.
+	if !util.IsCanonicalCompact(share.Header.Bits) {
+		return &ValidationError{Reason: fmt.Sprintf("header bits 0x%08x are not canonical", share.Header.Bits)}
+	}
+
+	// Note: nBits (Bitcoin target) is not validated against a template because we
+	// cannot know which Bitcoin block template the miner used. The sharechain only
+	// requires the share hash to meet the sharechain target.
+
+	return nil
+}
+
+// validateVersion dispatches on the share's version to check the fields
+// that version adds. Versions not listed are rejected. A new version needs a
+// case here and its commitment in types.VersionedCommitmentHash; the
+// coinbase commitment itself is checked for every version in step 9.
+func (v *Validator) validateVersion(share *types.Share) error {
+	switch share.ShareVersion {
+	case types.ShareVersion1:
+		// Still accepted while the pool migrates to version 2. Its height
+		// is derived from ancestry, so it must not claim one.
+		if share.Height != 0 {
+			return &ValidationError{Reason: fmt.Sprintf("version 1 share carries height %d", share.Height)}
+		}
+		return nil
+	case types.ShareVersion2:
+		// Adds the miner address and height to the coinbase commitment.
+		return v.validateHeight(share)
+	default:
+		return &ValidationError{Reason: fmt.Sprintf(
+			"unsupported share version %d, expected %d or %d",
+			share.ShareVersion, types.ShareVersion1, types.ShareVersion2)}
+	}
+}
+
+// validateHeight checks that a share's committed height is its parent's
+// height plus one, or 0 for a genesis share. A parent whose height is
+// unknown, or which is missing (rejected later), can't be checked against;
+// the height is then only bound by the commitment.
+func (v *Validator) validateHeight(share *types.Share) error {
+	var want int64
+	var zeroHash [32]byte
+	if share.PrevShareHash != zeroHash {
+		parent, ok := v.store.Height(share.PrevShareHash)
+		if !ok {
+			if share.Height < 1 {
+				return &ValidationError{Reason: fmt.Sprintf("invalid share height %d", share.Height)}
+			}
+			return nil
+		}
+		want = parent + 1
+	}
+	if share.Height != want {
+		return &ValidationError{Reason: fmt.Sprintf("share height %d, expected %d", share.Height, want)}
+	}
+	return nil
+}
 
+// validateCoinbaseValue checks that the coinbase outputs sum to a value
+// Bitcoin could accept: every output and the total within MAX_MONEY. The
+// fees a block may claim depend on its transactions, which the share does
+// not carry, so no tighter bound holds on every node; checking against our
+// own template would make acceptance depend on our mempool and split the
+// sharechain between peers. coinbaseOverpay compares against the template
+// for a warning instead.
+func (v *Validator) validateCoinbaseValue(outputs []types.CoinbaseOutput) error {
+	var total int64
+	for i, out := range outputs {
+		if out.Value < 0 || out.Value > maxMoney {
+			return &ValidationError{Reason: fmt.Sprintf("coinbase output %d has invalid value %d", i, out.Value)}
+		}
+		total += out.Value
+		if total > maxMoney {
+			return &ValidationError{Reason: fmt.Sprintf("coinbase outputs total %d exceeds max money", total)}
+		}
+	}
 	return nil
 }
 
+// coinbaseOverpay returns a valid share's coinbase total and our template's
+// coinbase value for the same Bitcoin block, reporting whether the total is
+// more than coinbaseValueTolerance above it. Such a share would likely be
+// an invalid block, but only our mempool says so.
+func (v *Validator) coinbaseOverpay(share *types.Share) (total, expected int64, over bool) {
+	if v.coinbaseValueFunc == nil {
+		return 0, 0, false
+	}
+	expected, ok := v.coinbaseValueFunc(share.Header.PrevBlockHash)
+	if !ok {
+		return 0, 0, false
+	}
+	outputs, err := types.ParseCoinbaseOutputs(share.CoinbaseTx)
+	if err != nil {
+		return 0, 0, false
+	}
+	for _, out := range outputs {
+		total += out.Value
+	}
+	limit := expected + int64(float64(expected)*coinbaseValueTolerance)
+	return total, expected, total > limit
+}
+
 // IsBlock checks if a validated share also meets Bitcoin's full difficulty.
 func (v *Validator) IsBlock(share *types.Share) bool {
 	return share.MeetsBitcoinTarget()
@@ -19,6 +19,14 @@ const (
 
 	// VardiffVariancePercent is the acceptable variance before adjustment.
 	VardiffVariancePercent = 25.0
+
+	// VardiffMaxStep is the largest factor difficulty may change by in a
+	// single retarget, in either direction.
+	VardiffMaxStep = 2.0
+
+	// vardiffBufferSize is the number of recent share timestamps kept for
+	// rate estimation.
+	vardiffBufferSize = 32
 )
 
 // Vardiff manages per-miner variable difficulty.
@@ -26,35 +34,51 @@ type Vardiff struct {
 	difficulty     float64
 	prevDifficulty float64 // previous difficulty, accepted during grace period after a change
 	targetTime     time.Duration
+	minDifficulty  float64
+	maxDifficulty  float64
 
-	// Tracking
+	// Tracking: ring buffer of share timestamps since the last retarget.
 	lastRetarget time.Time
-	shareCount   int
+	shareTimes   [vardiffBufferSize]time.Time
+	head         int // next write position in shareTimes
+	shareCount   int // shares recorded since the last retarget
 }
 
 // NewVardiff creates a new variable difficulty manager.
 func NewVardiff(initialDifficulty float64) *Vardiff {
 	return &Vardiff{
-		difficulty:   initialDifficulty,
-		targetTime:   VardiffTargetTime,
-		lastRetarget: time.Now(),
+		difficulty:    initialDifficulty,
+		targetTime:    VardiffTargetTime,
+		minDifficulty: VardiffMinDifficulty,
+		maxDifficulty: VardiffMaxDifficulty,
+		lastRetarget:  time.Now(),
 	}
 }
 
-// SetDifficulty sets the difficulty to the given value, clamped to
-// [VardiffMinDifficulty, VardiffMaxDifficulty]. It resets the retarget
-// timer and share count so vardiff doesn't immediately override.
-func (v *Vardiff) SetDifficulty(diff float64) {
-	if diff < VardiffMinDifficulty {
-		diff = VardiffMinDifficulty
+// SetBounds sets the difficulty range vardiff may adjust within. Values
+// outside [VardiffMinDifficulty, VardiffMaxDifficulty] are ignored.
+func (v *Vardiff) SetBounds(minDiff, maxDiff float64) {
+	if minDiff >= VardiffMinDifficulty && minDiff <= maxDiff {
+		v.minDifficulty = minDiff
 	}
-	if diff > VardiffMaxDifficulty {
-		diff = VardiffMaxDifficulty
+	if maxDiff <= VardiffMaxDifficulty && maxDiff >= v.minDifficulty {
+		v.maxDifficulty = maxDiff
 	}
+	v.difficulty = v.clamp(v.difficulty)
+}
+
+// SetDifficulty sets the difficulty to the given value, clamped to the
+// configured bounds. It resets the retarget timer and share history so
+// vardiff doesn't immediately override.
+func (v *Vardiff) SetDifficulty(diff float64) {
 	v.prevDifficulty = v.difficulty
-	v.difficulty = diff
-	v.lastRetarget = time.Now()
-	v.shareCount = 0
+	v.difficulty = v.clamp(diff)
+	v.reset(time.Now())
+}
+
+// InBounds reports whether diff lies within the configured difficulty range.
+func (v *Vardiff) InBounds(diff float64) bool {
+	return diff >= v.minDifficulty && diff <= v.maxDifficulty
 }
 
 // Difficulty returns the current difficulty.
@@ -68,26 +92,38 @@ func (v *Vardiff) PrevDifficulty() float64 {
 	return v.prevDifficulty
 }
 
-// RecordShare records a share submission and returns true if difficulty should change.
-func (v *Vardiff) RecordShare() bool {
+// RecordShare records a share submitted at t and returns true if the
+// difficulty changed. Retargeting happens at most once per
+// VardiffRetargetTime.
+func (v *Vardiff) RecordShare(t time.Time) bool {
+	v.shareTimes[v.head] = t
+	v.head = (v.head + 1) % vardiffBufferSize
 	v.shareCount++
 
-	elapsed := time.Since(v.lastRetarget)
-	if elapsed < VardiffRetargetTime {
+	if t.Sub(v.lastRetarget) < VardiffRetargetTime {
 		return false
 	}
 
-	return v.retarget(elapsed)
+	return v.retarget(t)
 }
 
 // retarget adjusts the difficulty and returns true if it changed.
-func (v *Vardiff) retarget(elapsed time.Duration) bool {
+func (v *Vardiff) retarget(now time.Time) bool {
 	if v.shareCount == 0 {
 		return false
 	}
 
-	// Actual time per share
-	actualTime := elapsed.Seconds() / float64(v.shareCount)
+	// Actual time per share. Once the buffer has wrapped, measure across
+	// the buffered timestamps so a burst of shares is rated correctly;
+	// otherwise measure from the last retarget.
+	var actualTime float64
+	if v.shareCount >= vardiffBufferSize {
+		oldest := v.shareTimes[v.head]
+		newest := v.shareTimes[(v.head+vardiffBufferSize-1)%vardiffBufferSize]
+		actualTime = newest.Sub(oldest).Seconds() / float64(vardiffBufferSize-1)
+	} else {
+		actualTime = now.Sub(v.lastRetarget).Seconds() / float64(v.shareCount)
+	}
 	targetTime := v.targetTime.Seconds()
 
 	// Check if within acceptable variance
@@ -95,27 +131,48 @@ func (v *Vardiff) retarget(elapsed time.Duration) bool {
 	high := targetTime * (1.0 + VardiffVariancePercent/100.0)
 
 	if actualTime >= low && actualTime <= high {
-		v.lastRetarget = time.Now()
-		v.shareCount = 0
+		v.reset(now)
 		return false
 	}
 
-	// Adjust: newDiff = oldDiff * (targetTime / actualTime)
-	ratio := targetTime / actualTime
-	newDiff := v.difficulty * ratio
-
-	// Clamp
-	if newDiff < VardiffMinDifficulty {
-		newDiff = VardiffMinDifficulty
+	// Adjust: newDiff = oldDiff * (targetTime / actualTime), limited to
+	// VardiffMaxStep per retarget.
+	ratio := VardiffMaxStep
+	if actualTime > 0 {
+		ratio = targetTime / actualTime
+	}
+	if ratio > VardiffMaxStep {
+		ratio = VardiffMaxStep
 	}
-	if newDiff > VardiffMaxDifficulty {
-		newDiff = VardiffMaxDifficulty
+	if ratio < 1/VardiffMaxStep {
+		ratio = 1 / VardiffMaxStep
+	}
+	newDiff := v.clamp(v.difficulty * ratio)
+
+	v.reset(now)
+	if newDiff == v.difficulty {
+		return false
 	}
 
 	v.prevDifficulty = v.difficulty
 	v.difficulty = newDiff
-	v.lastRetarget = time.Now()
-	v.shareCount = 0
-
 	return true
 }
+
+// clamp limits diff to the configured bounds.
+func (v *Vardiff) clamp(diff float64) float64 {
+	if diff < v.minDifficulty {
+		return v.minDifficulty
+	}
+	if diff > v.maxDifficulty {
+		return v.maxDifficulty
+	}
+	return diff
+}
+
+// reset clears the share history and restarts the retarget timer.
+func (v *Vardiff) reset(now time.Time) {
+	v.lastRetarget = now
+	v.shareCount = 0
+	v.head = 0
+}
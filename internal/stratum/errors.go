@@ -0,0 +1,96 @@
+package stratum
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Stratum v1 error codes, as understood by common miner firmware.
+const (
+	ErrCodeOther          = 20
+	ErrCodeJobNotFound    = 21
+	ErrCodeDuplicateShare = 22
+	ErrCodeLowDifficulty  = 23
+	ErrCodeUnauthorized   = 24
+	ErrCodeNotSubscribed  = 25
+)
+
+// Error is a stratum error, sent on the wire as the [code, message,
+// traceback] tuple. The traceback is always null.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// MarshalJSON encodes the error as a stratum error tuple.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Code, e.Message, nil})
+}
+
+// Standard share rejections. Share validators should return these so
+// miners log a meaningful reason.
+var (
+	ErrJobNotFound    = &Error{Code: ErrCodeJobNotFound, Message: "Job not found"}
+	ErrDuplicateShare = &Error{Code: ErrCodeDuplicateShare, Message: "Duplicate share"}
+	ErrLowDifficulty  = &Error{Code: ErrCodeLowDifficulty, Message: "Low difficulty share"}
+	ErrUnauthorized   = &Error{Code: ErrCodeUnauthorized, Message: "Unauthorized worker"}
+	ErrNotSubscribed  = &Error{Code: ErrCodeNotSubscribed, Message: "Not subscribed"}
+)
+
+// Reasons a share was rejected, as reported in ShareRejection. They are
+// stable enough to use as metric labels.
+const (
+	RejectStale         = "stale"
+	RejectDuplicate     = "duplicate"
+	RejectLowDifficulty = "low_difficulty"
+	RejectUnauthorized  = "unauthorized"
+	RejectRateLimited   = "rate_limited"
+	RejectMalformed     = "malformed"
+	RejectBadExtranonce = "bad_extranonce"
+	RejectBadVersion    = "bad_version"
+	RejectInvalid       = "invalid"
+)
+
+// ShareRejection describes a mining.submit the server rejected.
+type ShareRejection struct {
+	SessionID string
+	Worker    string
+	JobID     string // empty if the submission could not be parsed
+	Reason    string // one of the Reject* constants
+	Err       *Error // the error sent to the miner
+}
+
+// rejectReason classifies a share validator's error by its stratum code.
+func rejectReason(err *Error) string {
+	switch err.Code {
+	case ErrCodeJobNotFound:
+		return RejectStale
+	case ErrCodeDuplicateShare:
+		return RejectDuplicate
+	case ErrCodeLowDifficulty:
+		return RejectLowDifficulty
+	case ErrCodeUnauthorized, ErrCodeNotSubscribed:
+		return RejectUnauthorized
+	default:
+		return RejectInvalid
+	}
+}
+
+// NewError returns a stratum error with code 20 (other).
+func NewError(msg string) *Error {
+	return &Error{Code: ErrCodeOther, Message: msg}
+}
+
+// toError converts any error into a stratum error, reporting errors that
+// are not already stratum errors as code 20.
+func toError(err error) *Error {
+	var serr *Error
+	if errors.As(err, &serr) {
+		return serr
+	}
+	return NewError(err.Error())
+}
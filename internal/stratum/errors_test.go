@@ -0,0 +1,49 @@
+package stratum
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestError_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(&Response{ID: 1, Error: ErrLowDifficulty})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":1,"result":null,"error":[23,"Low difficulty share",null]}`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
+
+func TestToError(t *testing.T) {
+	if got := toError(ErrJobNotFound); got != ErrJobNotFound {
+		t.Errorf("stratum error not passed through: %v", got)
+	}
+	if got := toError(fmt.Errorf("check: %w", ErrDuplicateShare)); got.Code != ErrCodeDuplicateShare {
+		t.Errorf("wrapped error code = %d, want %d", got.Code, ErrCodeDuplicateShare)
+	}
+	if got := toError(fmt.Errorf("boom")); got.Code != ErrCodeOther || got.Message != "boom" {
+		t.Errorf("plain error = %+v", got)
+	}
+}
+
+func TestRejectReason(t *testing.T) {
+	tests := []struct {
+		err  *Error
+		want string
+	}{
+		{ErrJobNotFound, RejectStale},
+		{ErrDuplicateShare, RejectDuplicate},
+		{ErrLowDifficulty, RejectLowDifficulty},
+		{ErrUnauthorized, RejectUnauthorized},
+		{ErrNotSubscribed, RejectUnauthorized},
+		{NewError("Invalid share"), RejectInvalid},
+	}
+	for _, tt := range tests {
+		if got := rejectReason(tt.err); got != tt.want {
+			t.Errorf("rejectReason(%q) = %s, want %s", tt.err.Message, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package stratum
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// extranonce1Size is the length of the per-session extranonce1 in bytes.
+const extranonce1Size = 4
+
+// ErrExtranonceExhausted is returned when every extranonce1 value is held
+// by a live session or still quarantined.
+var ErrExtranonceExhausted = errors.New("extranonce1 space exhausted")
+
+// extranonceQuarantine is how long a released extranonce1 value is held
+// back before it may be handed out again, so submissions on the old
+// session's jobs are stale long before another miner works on the value.
+const extranonceQuarantine = time.Hour
+
+// extranonceAllocator hands out extranonce1 values that are unique among
+// concurrently held ones. Never-allocated values are used first; released
+// values are only reused once the fresh space runs out, oldest first and
+// after extranonceQuarantine, so two miners never work on the same coinbase
+// space while the old one's jobs may still be submitted.
+type extranonceAllocator struct {
+	mu   sync.Mutex
+	next uint64          // next never-allocated value
+	max  uint64          // largest allocatable value
+	free []releasedValue // released values, oldest first
+	now  func() time.Time
+}
+
+// releasedValue is an extranonce1 value and when it was released.
+type releasedValue struct {
+	value    uint32
+	released time.Time
+}
+
+func newExtranonceAllocator(size int) *extranonceAllocator {
+	return &extranonceAllocator{
+		next: 1,
+		max:  1<<(8*size) - 1,
+		now:  time.Now,
+	}
+}
+
+// get allocates an extranonce1 value.
+func (a *extranonceAllocator) get() (uint32, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.next <= a.max {
+		v := uint32(a.next)
+		a.next++
+		return v, nil
+	}
+	if len(a.free) > 0 && a.now().Sub(a.free[0].released) >= extranonceQuarantine {
+		v := a.free[0].value
+		a.free = a.free[1:]
+		return v, nil
+	}
+	return 0, ErrExtranonceExhausted
+}
+
+// put releases a value previously returned by get.
+func (a *extranonceAllocator) put(v uint32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.free = append(a.free, releasedValue{value: v, released: a.now()})
+}
+
+// allocated returns how many distinct values have ever been handed out.
+func (a *extranonceAllocator) allocated() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int(a.next - 1)
+}
+
+// inUse returns how many values are currently held.
+func (a *extranonceAllocator) inUse() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int(a.next-1) - len(a.free)
+}
+
+// formatExtranonce1 renders a value as the hex extranonce1 sent to miners.
+func formatExtranonce1(v uint32) string {
+	return fmt.Sprintf("%0*x", 2*extranonce1Size, v)
+}
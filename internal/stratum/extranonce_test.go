@@ -0,0 +1,97 @@
+package stratum
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExtranonceAllocator_UniqueWhileHeld(t *testing.T) {
+	a := newExtranonceAllocator(extranonce1Size)
+
+	var mu sync.Mutex
+	held := make(map[uint32]bool)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				v, err := a.get()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if held[v] {
+					t.Errorf("value %d handed out twice", v)
+				}
+				held[v] = true
+				mu.Unlock()
+
+				mu.Lock()
+				delete(held, v)
+				mu.Unlock()
+				a.put(v)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := a.inUse(); n != 0 {
+		t.Errorf("inUse = %d after releasing everything", n)
+	}
+}
+
+func TestExtranonceAllocator_PrefersFreshValues(t *testing.T) {
+	a := newExtranonceAllocator(extranonce1Size)
+	v1, _ := a.get()
+	a.put(v1)
+
+	if got, _ := a.get(); got == v1 {
+		t.Errorf("released value %d handed straight back out", v1)
+	}
+}
+
+func TestExtranonceAllocator_Exhausted(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := newExtranonceAllocator(1)
+	a.now = func() time.Time { return now }
+	var first, second uint32
+	for i := 0; i < 255; i++ {
+		v, err := a.get()
+		if err != nil {
+			t.Fatalf("get %d: %v", i, err)
+		}
+		if i == 0 {
+			first = v
+		}
+		if i == 1 {
+			second = v
+		}
+	}
+	if _, err := a.get(); err != ErrExtranonceExhausted {
+		t.Fatalf("err = %v, want ErrExtranonceExhausted", err)
+	}
+
+	// Released values stay quarantined, then come back oldest first.
+	a.put(second)
+	now = now.Add(time.Minute)
+	a.put(first)
+	if _, err := a.get(); err != ErrExtranonceExhausted {
+		t.Fatalf("quarantined value reused: err = %v", err)
+	}
+	now = now.Add(extranonceQuarantine)
+	if v, err := a.get(); err != nil || v != second {
+		t.Errorf("get after quarantine = %d, %v; want %d", v, err, second)
+	}
+}
+
+func TestFormatExtranonce1(t *testing.T) {
+	if got := formatExtranonce1(1); got != "00000001" {
+		t.Errorf("got %q", got)
+	}
+	if got := formatExtranonce1(0xffffffff); got != "ffffffff" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,171 @@
+package stratum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PROXY protocol (https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt)
+// lets a TCP load balancer pass the original client address ahead of the
+// proxied stream. Only enable it on ports that are reachable solely through
+// such a proxy: anyone who can connect directly could claim any address.
+
+const (
+	// proxyV1MaxLen is the longest valid v1 header, including CRLF.
+	proxyV1MaxLen = 107
+
+	// proxyV2HeaderLen is the fixed part of a v2 header.
+	proxyV2HeaderLen = 16
+)
+
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyConn is a connection whose PROXY protocol header has been consumed.
+// Reads continue from the buffered reader; RemoteAddr reports the client
+// address the proxy announced.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *proxyConn) RemoteAddr() net.Addr { return c.remote }
+
+// readProxyHeader consumes a PROXY protocol v1 or v2 header from conn, if
+// one is present, and returns a connection reporting the announced source
+// address. Connections without a header are returned unchanged apart from
+// buffering, so health checks from the proxy itself still work. The caller
+// is expected to have set a read deadline.
+func readProxyHeader(conn net.Conn) (net.Conn, error) {
+	r := bufio.NewReaderSize(conn, 256)
+	pc := &proxyConn{Conn: conn, r: r, remote: conn.RemoteAddr()}
+
+	// Only wait for a full signature when the first byte could start one,
+	// so a short first write from a client behind no proxy is not held up.
+	first, err := r.Peek(1)
+	if err != nil || (first[0] != proxyV1Prefix[0] && first[0] != proxyV2Sig[0]) {
+		return pc, nil
+	}
+	n := len(proxyV1Prefix)
+	if first[0] == proxyV2Sig[0] {
+		n = len(proxyV2Sig)
+	}
+	head, _ := r.Peek(n)
+	switch {
+	case bytes.HasPrefix(head, proxyV1Prefix):
+		addr, err := parseProxyV1(r)
+		if err != nil {
+			return nil, err
+		}
+		if addr != nil {
+			pc.remote = addr
+		}
+	case bytes.Equal(head, proxyV2Sig):
+		addr, err := parseProxyV2(r)
+		if err != nil {
+			return nil, err
+		}
+		if addr != nil {
+			pc.remote = addr
+		}
+	}
+	return pc, nil
+}
+
+// parseProxyV1 parses a text header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 3333\r\n". It returns a nil
+// address for "PROXY UNKNOWN".
+func parseProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("proxy v1 header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLen {
+			return nil, fmt.Errorf("proxy v1 header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("proxy v1 header not terminated by CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed proxy v1 header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("proxy v1 header: invalid source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxy v1 header: invalid source port %q", fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// parseProxyV2 parses a binary header. It returns a nil address for LOCAL
+// connections (the proxy's own health checks) and for address families
+// other than IPv4 and IPv6.
+func parseProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("proxy v2 header: %w", err)
+	}
+	verCmd, fam := hdr[12], hdr[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("proxy v2 header: unsupported version %d", verCmd>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("proxy v2 header: %w", err)
+	}
+
+	switch verCmd & 0x0f {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("proxy v2 header: unsupported command %d", verCmd&0x0f)
+	}
+
+	switch fam >> 4 {
+	case 0x1: // AF_INET: src addr, dst addr, src port, dst port
+		if len(body) < 12 {
+			return nil, fmt.Errorf("proxy v2 header: short IPv4 address block")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(append([]byte(nil), body[0:4]...)),
+			Port: int(binary.BigEndian.Uint16(body[8:10])),
+		}, nil
+	case 0x2: // AF_INET6
+		if len(body) < 36 {
+			return nil, fmt.Errorf("proxy v2 header: short IPv6 address block")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(append([]byte(nil), body[0:16]...)),
+			Port: int(binary.BigEndian.Uint16(body[32:34])),
+		}, nil
+	default:
+		return nil, nil
+	}
+}
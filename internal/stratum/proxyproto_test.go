@@ -0,0 +1,138 @@
+package stratum
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeWith returns the server side of a pipe whose client writes data and
+// then closes.
+func pipeWith(data []byte) net.Conn {
+	server, client := net.Pipe()
+	go func() {
+		client.Write(data)
+		client.Close()
+	}()
+	return server
+}
+
+func proxyV2Header(cmd byte, fam byte, addrs []byte) []byte {
+	hdr := append([]byte(nil), proxyV2Sig...)
+	hdr = append(hdr, 0x20|cmd, fam)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(addrs)))
+	return append(hdr, addrs...)
+}
+
+func TestReadProxyHeader_V1(t *testing.T) {
+	conn, err := readProxyHeader(pipeWith([]byte("PROXY TCP4 192.0.2.7 198.51.100.1 56324 3333\r\n{\"id\":1}\n")))
+	if err != nil {
+		t.Fatalf("readProxyHeader: %v", err)
+	}
+	if got := conn.RemoteAddr().String(); got != "192.0.2.7:56324" {
+		t.Errorf("RemoteAddr = %s", got)
+	}
+	rest, _ := io.ReadAll(conn)
+	if string(rest) != "{\"id\":1}\n" {
+		t.Errorf("payload after header = %q", rest)
+	}
+
+	conn, err = readProxyHeader(pipeWith([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 4000 3333\r\n")))
+	if err != nil {
+		t.Fatalf("readProxyHeader v6: %v", err)
+	}
+	if got := conn.RemoteAddr().String(); got != "[2001:db8::1]:4000" {
+		t.Errorf("RemoteAddr = %s", got)
+	}
+}
+
+func TestReadProxyHeader_V2(t *testing.T) {
+	addrs := []byte{203, 0, 113, 9, 10, 0, 0, 1}
+	addrs = binary.BigEndian.AppendUint16(addrs, 40000)
+	addrs = binary.BigEndian.AppendUint16(addrs, 3333)
+	data := append(proxyV2Header(0x1, 0x11, addrs), "GET / HTTP/1.1\r\n"...)
+
+	conn, err := readProxyHeader(pipeWith(data))
+	if err != nil {
+		t.Fatalf("readProxyHeader: %v", err)
+	}
+	if got := conn.RemoteAddr().String(); got != "203.0.113.9:40000" {
+		t.Errorf("RemoteAddr = %s", got)
+	}
+	rest, _ := io.ReadAll(conn)
+	if string(rest) != "GET / HTTP/1.1\r\n" {
+		t.Errorf("payload after header = %q", rest)
+	}
+}
+
+func TestReadProxyHeader_KeepsProxyAddress(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"no header":  []byte("{\"id\":1}\n"),
+		"v1 unknown": []byte("PROXY UNKNOWN\r\n{}"),
+		"v2 local":   proxyV2Header(0x0, 0x00, nil),
+	} {
+		raw := pipeWith(data)
+		conn, err := readProxyHeader(raw)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if conn.RemoteAddr() != raw.RemoteAddr() {
+			t.Errorf("%s: RemoteAddr = %v, want the proxy's", name, conn.RemoteAddr())
+		}
+	}
+}
+
+func TestReadProxyHeader_Malformed(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"v1 bad ip":      []byte("PROXY TCP4 not-an-ip 198.51.100.1 1 2\r\n"),
+		"v1 family":      []byte("PROXY TCP4 2001:db8::1 2001:db8::2 1 2\r\n"),
+		"v1 no crlf":     []byte("PROXY TCP4 192.0.2.7 198.51.100.1 1 2\n"),
+		"v1 too long":    []byte("PROXY " + strings.Repeat("x", 200)),
+		"v2 bad version": append(append([]byte(nil), proxyV2Sig...), 0x11, 0x11, 0, 0),
+		"v2 short ipv4":  proxyV2Header(0x1, 0x11, []byte{1, 2, 3}),
+		"v2 truncated":   append(append([]byte(nil), proxyV2Sig...), 0x21, 0x11, 0, 12, 1),
+	} {
+		raw := pipeWith(data)
+		if _, err := readProxyHeader(raw); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		raw.Close()
+	}
+}
+
+func TestServer_ProxyProtocolRemoteAddr(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	srv.SetHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	}))
+	if err := srv.StartPorts([]PortConfig{{Addr: "127.0.0.1:0", ProxyProtocol: true}}); err != nil {
+		t.Fatalf("StartPorts: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("PROXY TCP4 192.0.2.7 127.0.0.1 56324 3333\r\n"))
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: pool\r\nConnection: close\r\n\r\n"))
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "192.0.2.7:56324" {
+		t.Errorf("handler saw RemoteAddr %q, want the proxied client", body)
+	}
+}
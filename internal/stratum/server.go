@@ -2,6 +2,7 @@ package stratum
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -14,27 +15,60 @@ import (
 )
 
 const (
-	// connReadTimeout is how long to wait for data from a miner before
-	// considering the connection dead. Reset after each successful read.
-	connReadTimeout = 5 * time.Minute
+	// DefaultIdleTimeout is how long to wait for data from a miner before
+	// considering the connection dead. Reset before each read.
+	DefaultIdleTimeout = 10 * time.Minute
 
 	// tcpKeepAliveInterval is the TCP keepalive probe interval.
 	tcpKeepAliveInterval = 30 * time.Second
+
+	// drainPollInterval is how often Drain checks for remaining sessions.
+	drainPollInterval = 100 * time.Millisecond
+
+	// ShutdownDrainDelay is how long Shutdown waits after client.reconnect
+	// before closing connections.
+	ShutdownDrainDelay = 2 * time.Second
 )
 
+// PortConfig describes one stratum listen port and the difficulty profile
+// applied to sessions that connect through it. Zero difficulty values fall
+// back to the server's start difficulty and the vardiff defaults.
+type PortConfig struct {
+	Addr            string
+	StartDifficulty float64
+	MinDifficulty   float64
+	MaxDifficulty   float64
+
+	// ProxyProtocol accepts a PROXY protocol v1/v2 header at the start of
+	// each connection and uses the client address it announces. Enable it
+	// only on ports reachable exclusively through a trusted load balancer.
+	ProxyProtocol bool
+
+	// TLS, if set, terminates TLS (stratum+ssl) on this port. Stratum and
+	// HTTP are then demultiplexed on the decrypted stream. Serve TLS and
+	// plaintext on distinct ports: the first-byte demux cannot tell a
+	// ClientHello from a plaintext client.
+	TLS *tls.Config
+}
+
 // Server is a Stratum v1 mining server.
 type Server struct {
-	listener net.Listener
-	logger   *zap.Logger
+	listener  net.Listener   // first listener, kept for single-port callers
+	listeners []net.Listener // all bound listeners
+	logger    *zap.Logger
 
 	sessions   map[string]*Session
 	sessionsMu sync.RWMutex
 
 	submitCh chan *ShareSubmission
 
-	// Extranonce allocation
-	extranonceCounter atomic.Uint64
-	extranonce2Size   int
+	// Extranonce allocation. rotated holds the extranonce1 value a session
+	// was moved to by RotateExtranonce, keyed by session ID and guarded by
+	// sessionsMu; the session's original value doubles as its ID and stays
+	// held until it disconnects.
+	extranonces     *extranonceAllocator
+	rotated         map[string]uint32
+	extranonce2Size int
 
 	// Initial difficulty for new miners (vardiff adjusts from here)
 	startDifficulty float64
@@ -45,8 +79,32 @@ type Server struct {
 
 	maxSessions int
 
+	// Per-worker share statistics, keyed by authorized worker name
+	workerStats   map[string]*workerTracker
+	workerStatsMu sync.Mutex
+
 	httpHandler http.Handler
 
+	// jobValidator reports whether a job ID can still accept shares.
+	jobValidator func(jobID string) bool
+
+	// shareValidator checks a submission's proof of work before the
+	// miner is told it was accepted.
+	shareValidator func(sub *ShareSubmission) error
+
+	// rejectHandler is told about every rejected submission.
+	rejectHandler func(rej ShareRejection)
+
+	// idleTimeout disconnects sessions that send nothing for this long.
+	// Zero disables the deadline.
+	idleTimeout time.Duration
+
+	// keepaliveInterval is how often idle sessions are probed with a
+	// harmless write. Zero disables the probe.
+	keepaliveInterval time.Duration
+
+	draining atomic.Bool
+
 	cancel context.CancelFunc
 }
 
@@ -56,26 +114,62 @@ func NewServer(startDifficulty float64, logger *zap.Logger) *Server {
 		logger:          logger,
 		sessions:        make(map[string]*Session),
 		submitCh:        make(chan *ShareSubmission, 256),
+		extranonces:     newExtranonceAllocator(extranonce1Size),
+		rotated:         make(map[string]uint32),
 		extranonce2Size: 4,
 		startDifficulty: startDifficulty,
 		maxSessions:     1000,
+		workerStats:     make(map[string]*workerTracker),
+		idleTimeout:     DefaultIdleTimeout,
 	}
 }
 
-// Start begins listening on the given address.
+// Start begins listening on the given address using the server's start
+// difficulty and default vardiff bounds.
 func (s *Server) Start(addr string) error {
-	var err error
-	s.listener, err = net.Listen("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("listen: %w", err)
+	return s.StartPorts([]PortConfig{{Addr: addr, StartDifficulty: s.startDifficulty}})
+}
+
+// StartPorts begins listening on every configured port. Sessions inherit the
+// difficulty profile of the port they connected on. If any port fails to
+// bind, the ones already bound are closed and the error is returned.
+func (s *Server) StartPorts(ports []PortConfig) error {
+	if len(ports) == 0 {
+		return fmt.Errorf("no stratum ports configured")
+	}
+
+	listeners := make([]net.Listener, 0, len(ports))
+	for _, port := range ports {
+		ln, err := net.Listen("tcp", port.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("listen %s: %w", port.Addr, err)
+		}
+		listeners = append(listeners, ln)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	s.cancel = cancel
+	s.listener = listeners[0]
+	s.listeners = listeners
 
-	s.logger.Info("stratum server listening", zap.String("addr", addr))
-
-	go s.acceptLoop(ctx)
+	for i, ln := range listeners {
+		port := ports[i]
+		if port.StartDifficulty <= 0 {
+			port.StartDifficulty = s.startDifficulty
+		}
+		s.logger.Info("stratum server listening",
+			zap.String("addr", port.Addr),
+			zap.Float64("start_difficulty", port.StartDifficulty),
+			zap.Bool("tls", port.TLS != nil),
+		)
+		go s.acceptLoop(ctx, ln, port)
+	}
+	if s.keepaliveInterval > 0 {
+		go s.keepaliveLoop(ctx)
+	}
 	return nil
 }
 
@@ -84,8 +178,8 @@ func (s *Server) Stop() error {
 	if s.cancel != nil {
 		s.cancel()
 	}
-	if s.listener != nil {
-		s.listener.Close()
+	for _, ln := range s.listeners {
+		ln.Close()
 	}
 
 	s.sessionsMu.Lock()
@@ -98,6 +192,81 @@ func (s *Server) Stop() error {
 	return nil
 }
 
+// Drain stops accepting new connections, closing the listeners (and with
+// them the dashboard on the stratum port), while existing sessions keep
+// receiving jobs. It returns once every session has disconnected or timeout
+// has elapsed, then stops the server. Use it for rolling restarts so miners
+// move over gradually instead of all at once.
+func (s *Server) Drain(timeout time.Duration) error {
+	s.draining.Store(true)
+	for _, ln := range s.listeners {
+		ln.Close()
+	}
+	s.logger.Info("draining stratum server",
+		zap.Int("sessions", s.SessionCount()),
+		zap.Duration("timeout", timeout),
+	)
+
+	deadline := time.Now().Add(timeout)
+	for s.SessionCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+	if n := s.SessionCount(); n > 0 {
+		s.logger.Info("drain timeout elapsed, closing remaining sessions", zap.Int("sessions", n))
+	}
+	return s.Stop()
+}
+
+// IsDraining reports whether Drain has been called.
+func (s *Server) IsDraining() bool {
+	return s.draining.Load()
+}
+
+// ReconnectAll sends client.reconnect to every connected session, optionally
+// pointing miners at a backup host:port. Notifications are sent concurrently
+// and each write is bounded by writeTimeout, so a stuck miner cannot block
+// the caller for longer than that.
+func (s *Server) ReconnectAll(host string, port int, waitSeconds int) {
+	s.sessionsMu.RLock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.sessionsMu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func(session *Session) {
+			defer wg.Done()
+			if err := session.SendReconnect(host, port, waitSeconds); err != nil {
+				s.logger.Debug("failed to send reconnect", zap.String("session", session.ID), zap.Error(err))
+			}
+		}(session)
+	}
+	wg.Wait()
+
+	s.logger.Info("sent client.reconnect to miners",
+		zap.Int("sessions", len(sessions)),
+		zap.String("host", host),
+		zap.Int("port", port),
+	)
+}
+
+// Shutdown stops accepting connections, asks all miners to reconnect to
+// host:port and gives them drainDelay to act on it, then stops the server.
+// With no host there is nowhere to send miners, so it stops right away.
+func (s *Server) Shutdown(host string, port int, drainDelay time.Duration) error {
+	for _, ln := range s.listeners {
+		ln.Close()
+	}
+	if host != "" && s.SessionCount() > 0 {
+		s.ReconnectAll(host, port, int(drainDelay.Seconds()))
+		time.Sleep(drainDelay)
+	}
+	return s.Stop()
+}
+
 // SubmitChannel returns the channel of share submissions.
 func (s *Server) SubmitChannel() <-chan *ShareSubmission {
 	return s.submitCh
@@ -109,16 +278,111 @@ func (s *Server) BroadcastJob(job *Job) {
 	s.currentJob = job
 	s.currentJobMu.Unlock()
 
+	var dead []*Session
 	s.sessionsMu.RLock()
-	defer s.sessionsMu.RUnlock()
-
 	for _, session := range s.sessions {
 		if session.State == StateAuthorized {
 			if err := session.NotifyJob(job); err != nil {
 				s.logger.Warn("failed to notify miner", zap.String("session", session.ID), zap.Error(err))
+				dead = append(dead, session)
 			}
 		}
 	}
+	s.sessionsMu.RUnlock()
+
+	for _, session := range dead {
+		s.dropSession(session)
+	}
+}
+
+// sendKeepalives probes every session with a harmless write and drops the
+// ones whose write fails.
+func (s *Server) sendKeepalives() {
+	s.sessionsMu.RLock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.sessionsMu.RUnlock()
+
+	for _, session := range sessions {
+		if err := session.SendKeepalive(); err != nil {
+			s.logger.Debug("keepalive failed", zap.String("session", session.ID), zap.Error(err))
+			s.dropSession(session)
+		}
+	}
+}
+
+func (s *Server) keepaliveLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sendKeepalives()
+		}
+	}
+}
+
+// dropSession removes a session whose connection is known to be dead and
+// closes it, so it stops counting towards SessionCount right away rather
+// than when its read loop notices.
+func (s *Server) dropSession(session *Session) {
+	s.sessionsMu.Lock()
+	if s.sessions[session.ID] == session {
+		delete(s.sessions, session.ID)
+	}
+	s.sessionsMu.Unlock()
+	session.Close()
+}
+
+// RotateExtranonce assigns a fresh extranonce1 to the given session and
+// pushes it to the miner with mining.set_extranonce. The session must have
+// sent mining.extranonce.subscribe.
+func (s *Server) RotateExtranonce(sessionID string) error {
+	s.sessionsMu.RLock()
+	session, ok := s.sessions[sessionID]
+	s.sessionsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown session %s", sessionID)
+	}
+
+	v, err := s.extranonces.get()
+	if err != nil {
+		return err
+	}
+	extranonce1 := formatExtranonce1(v)
+	err = session.SetExtranonce(extranonce1, s.extranonce2Size)
+
+	// Keep the new value only if the session switched to it and is still
+	// connected; otherwise nothing else will release it.
+	s.sessionsMu.Lock()
+	if s.sessions[sessionID] == session && session.currentExtranonce1() == extranonce1 {
+		if prev, ok := s.rotated[sessionID]; ok {
+			s.extranonces.put(prev)
+		}
+		s.rotated[sessionID] = v
+	} else {
+		s.extranonces.put(v)
+	}
+	s.sessionsMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Resend the current job so the miner restarts work on the new extranonce.
+	s.currentJobMu.RLock()
+	job := s.currentJob
+	s.currentJobMu.RUnlock()
+	if job != nil {
+		clean := *job
+		clean.CleanJobs = true
+		return session.NotifyJob(&clean)
+	}
+	return nil
 }
 
 // SessionCount returns the number of active sessions.
@@ -133,6 +397,7 @@ type SessionInfo struct {
 	WorkerName  string
 	Difficulty  float64
 	ConnectedAt time.Time
+	Port        string // listen address the session connected through
 }
 
 // MinerStats returns a snapshot of all authorized sessions.
@@ -146,22 +411,75 @@ func (s *Server) MinerStats() []SessionInfo {
 				WorkerName:  sess.WorkerName,
 				Difficulty:  sess.Vardiff.Difficulty(),
 				ConnectedAt: sess.ConnectedAt,
+				Port:        sess.Port,
 			})
 		}
 	}
 	return out
 }
 
+// SetJobValidator sets a function used by sessions to reject submissions
+// for unknown or stale jobs with stratum error 21. It must be called before
+// Start.
+func (s *Server) SetJobValidator(fn func(jobID string) bool) {
+	s.jobValidator = fn
+}
+
+// SetIdleTimeout sets how long a session may go without sending anything
+// before it is disconnected. Miners only talk to the pool when they submit
+// or resubscribe, so this must comfortably exceed the slowest expected share
+// interval at the minimum difficulty. Zero disables the deadline. It must be
+// called before Start.
+func (s *Server) SetIdleTimeout(d time.Duration) {
+	s.idleTimeout = d
+}
+
+// SetKeepaliveInterval enables a server-initiated keepalive: every d, each
+// subscribed session is sent a harmless notification and dropped if the
+// write fails. This notices miners lost to NAT timeouts between jobs. Zero
+// disables it. It must be called before Start.
+func (s *Server) SetKeepaliveInterval(d time.Duration) {
+	s.keepaliveInterval = d
+}
+
+// KeepaliveInterval returns the configured keepalive interval, zero if
+// disabled.
+func (s *Server) KeepaliveInterval() time.Duration {
+	return s.keepaliveInterval
+}
+
+// SetShareValidator sets a function that checks each submission before it
+// is answered. A non-nil error rejects the share and is reported to the
+// miner; return one of the stratum errors (such as ErrLowDifficulty) for a
+// specific code, anything else is sent as code 20. Only accepted shares
+// reach SubmitChannel; the validator may set ShareSubmission.Checked to
+// pass its results along with them. It is called concurrently from session goroutines
+// and must be called before Start.
+func (s *Server) SetShareValidator(fn func(sub *ShareSubmission) error) {
+	s.shareValidator = fn
+}
+
+// SetRejectHandler sets a function called with every rejected
+// mining.submit, whether the session or the share validator rejected it.
+// It is called concurrently from session goroutines and must be called
+// before Start.
+func (s *Server) SetRejectHandler(fn func(rej ShareRejection)) {
+	s.rejectHandler = fn
+}
+
 // SetHTTPHandler sets an HTTP handler for non-stratum connections.
 // HTTP requests are detected by peeking the first byte of each connection.
 func (s *Server) SetHTTPHandler(h http.Handler) {
 	s.httpHandler = h
 }
 
-func (s *Server) acceptLoop(ctx context.Context) {
+func (s *Server) acceptLoop(ctx context.Context, ln net.Listener, port PortConfig) {
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
+			if s.draining.Load() {
+				return
+			}
 			select {
 			case <-ctx.Done():
 				return
@@ -171,16 +489,44 @@ func (s *Server) acceptLoop(ctx context.Context) {
 			}
 		}
 
-		go s.handleConnection(ctx, conn)
+		go s.handleConnection(ctx, conn, port)
 	}
 }
 
-func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
+func (s *Server) handleConnection(ctx context.Context, conn net.Conn, port PortConfig) {
+	// Close the connection as soon as the server stops, unblocking any
+	// pending read, including connections that have not registered a
+	// session yet and so are missed by Stop.
+	raw := conn
+	stopClose := context.AfterFunc(ctx, func() { raw.Close() })
+	defer stopClose()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	// Behind a load balancer, take the miner's address from the PROXY
+	// header. The wrapped conn reports it as RemoteAddr to everything below.
+	if port.ProxyProtocol {
+		pc, err := readProxyHeader(conn)
+		if err != nil {
+			s.logger.Debug("bad proxy protocol header",
+				zap.String("remote", conn.RemoteAddr().String()),
+				zap.Error(err),
+			)
+			conn.Close()
+			return
+		}
+		conn = pc
+	}
+
+	// The handshake runs on the first read below, under the same deadline.
+	if port.TLS != nil {
+		conn = tls.Server(conn, port.TLS)
+	}
+
 	// Peek the first byte to determine the protocol.
 	// Stratum (JSON-RPC) always starts with '{'.
 	// HTTP requests start with a letter (G for GET, P for POST, etc.).
 	buf := make([]byte, 1)
-	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
 	if _, err := io.ReadFull(conn, buf); err != nil {
 		conn.Close()
 		return
@@ -209,18 +555,40 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 	}
 
 	// Enable TCP keepalive to detect dead connections
-	if tc, ok := conn.(*net.TCPConn); ok {
+	if tc, ok := raw.(*net.TCPConn); ok {
 		tc.SetKeepAlive(true)
 		tc.SetKeepAlivePeriod(tcpKeepAliveInterval)
 	}
 
-	// Allocate unique extranonce1
-	id := s.extranonceCounter.Add(1)
-	extranonce1 := fmt.Sprintf("%08x", id)
+	// Allocate an extranonce1 unique among live sessions
+	id, err := s.extranonces.get()
+	if err != nil {
+		s.logger.Warn("rejecting stratum connection",
+			zap.String("remote", conn.RemoteAddr().String()),
+			zap.Error(err),
+		)
+		conn.Close()
+		return
+	}
+	extranonce1 := formatExtranonce1(id)
 	sessionID := extranonce1
 
 	codec := NewCodec(prefixed)
-	session := NewSession(sessionID, codec, extranonce1, s.extranonce2Size, s.startDifficulty, s.submitCh, s.logger)
+	session := NewSession(sessionID, codec, extranonce1, s.extranonce2Size, port.StartDifficulty, s.submitCh, s.logger)
+	session.Port = port.Addr
+	session.jobValid = s.jobValidator
+	session.shareValid = s.shareValidator
+	session.shareRejected = s.rejectHandler
+	if port.MinDifficulty > 0 || port.MaxDifficulty > 0 {
+		minDiff, maxDiff := port.MinDifficulty, port.MaxDifficulty
+		if minDiff <= 0 {
+			minDiff = VardiffMinDifficulty
+		}
+		if maxDiff <= 0 {
+			maxDiff = VardiffMaxDifficulty
+		}
+		session.Vardiff.SetBounds(minDiff, maxDiff)
+	}
 
 	s.sessionsMu.Lock()
 	s.sessions[sessionID] = session
@@ -230,9 +598,18 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 
 	defer func() {
 		s.sessionsMu.Lock()
-		delete(s.sessions, sessionID)
+		if s.sessions[sessionID] == session {
+			delete(s.sessions, sessionID)
+		}
+		if v, ok := s.rotated[sessionID]; ok {
+			delete(s.rotated, sessionID)
+			s.extranonces.put(v)
+		}
 		s.sessionsMu.Unlock()
 		session.Close()
+		// Released last: the value is the session ID, so it must not be
+		// reused until the entries above are gone.
+		s.extranonces.put(id)
 		s.logger.Info("miner disconnected", zap.String("session", sessionID))
 	}()
 
@@ -247,7 +624,11 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 
 		// Set read deadline so we detect dead connections instead of blocking forever.
 		// The miner should be submitting shares or keepalive messages regularly.
-		conn.SetReadDeadline(time.Now().Add(connReadTimeout))
+		// Only reads are bounded: job notifications carry their own write
+		// deadline and do not count as activity from the miner.
+		if s.idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+		}
 
 		req, err := codec.ReadRequest()
 		if err != nil {
@@ -2,9 +2,17 @@ package stratum
 
 import (
 	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math/big"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -145,6 +153,142 @@ func TestServer_ExtranonceUniqueness(t *testing.T) {
 	}
 }
 
+func TestServer_ExtranonceReleased(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	addr := srv.listener.Addr().String()
+	for i := 0; i < 20; i++ {
+		conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+		if err != nil {
+			t.Fatalf("connect %d failed: %v", i, err)
+		}
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+		if _, err := reader.ReadBytes('\n'); err != nil {
+			t.Fatalf("subscribe %d: %v", i, err)
+		}
+		conn.Close()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for srv.extranonces.inUse() != 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if n := srv.extranonces.inUse(); n != 0 {
+			t.Fatalf("%d extranonce1 values still held after disconnect %d", n, i)
+		}
+	}
+
+	// Released values are quarantined, so each session got a fresh one.
+	if n := srv.extranonces.allocated(); n != 20 {
+		t.Errorf("sequential sessions allocated %d extranonce1 values, want 20", n)
+	}
+}
+
+func TestServer_StartPorts(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	err := srv.StartPorts([]PortConfig{
+		{Addr: "127.0.0.1:0", StartDifficulty: 0.01},
+		{Addr: "127.0.0.1:0", StartDifficulty: 4096, MinDifficulty: 1024, MaxDifficulty: 65536},
+	})
+	if err != nil {
+		t.Fatalf("StartPorts failed: %v", err)
+	}
+	defer srv.Stop()
+
+	if len(srv.listeners) != 2 {
+		t.Fatalf("listeners = %d, want 2", len(srv.listeners))
+	}
+
+	for i, want := range []float64{0.01, 4096} {
+		conn, err := net.DialTimeout("tcp", srv.listeners[i].Addr().String(), 2*time.Second)
+		if err != nil {
+			t.Fatalf("connect port %d: %v", i, err)
+		}
+		reader := bufio.NewReader(conn)
+
+		conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+		reader.ReadBytes('\n') // subscribe response
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("read set_difficulty on port %d: %v", i, err)
+		}
+		var notif Notification
+		json.Unmarshal(line, &notif)
+		if len(notif.Params) != 1 || notif.Params[0] != want {
+			t.Errorf("port %d start difficulty = %v, want %v", i, notif.Params, want)
+		}
+		conn.Close()
+	}
+}
+
+// selfSignedTLS returns a server config with a throwaway certificate for
+// 127.0.0.1.
+func selfSignedTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestServer_TLSPort(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	err := srv.StartPorts([]PortConfig{
+		{Addr: "127.0.0.1:0"},
+		{Addr: "127.0.0.1:0", TLS: selfSignedTLS(t)},
+	})
+	if err != nil {
+		t.Fatalf("StartPorts: %v", err)
+	}
+	defer srv.Stop()
+
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", srv.listeners[1].Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("TLS dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read subscribe response: %v", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil || resp.Error != nil {
+		t.Fatalf("subscribe over TLS failed: %s (%v)", line, err)
+	}
+
+	// The plaintext port still serves plain stratum.
+	plain, err := net.DialTimeout("tcp", srv.listeners[0].Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("plaintext dial: %v", err)
+	}
+	defer plain.Close()
+	plain.SetDeadline(time.Now().Add(5 * time.Second))
+	plain.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	if _, err := bufio.NewReader(plain).ReadBytes('\n'); err != nil {
+		t.Fatalf("plaintext subscribe: %v", err)
+	}
+}
+
 func TestVardiff(t *testing.T) {
 	v := NewVardiff(1.0)
 	if v.Difficulty() != 1.0 {
@@ -152,6 +296,863 @@ func TestVardiff(t *testing.T) {
 	}
 }
 
+func TestVardiff_RaisesForFastMiner(t *testing.T) {
+	v := NewVardiff(1.0)
+	start := time.Now()
+	v.lastRetarget = start
+
+	// One share per second, far faster than the 10s target.
+	changed := false
+	for i := 1; i <= 60; i++ {
+		if v.RecordShare(start.Add(time.Duration(i) * time.Second)) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		t.Fatal("expected difficulty change for fast miner")
+	}
+	// Clamped to a 2x step per retarget.
+	if v.Difficulty() != 2.0 {
+		t.Errorf("difficulty = %f, want 2.0", v.Difficulty())
+	}
+	if v.PrevDifficulty() != 1.0 {
+		t.Errorf("prev difficulty = %f, want 1.0", v.PrevDifficulty())
+	}
+}
+
+func TestVardiff_LowersForSlowMiner(t *testing.T) {
+	v := NewVardiff(8.0)
+	start := time.Now()
+	v.lastRetarget = start
+
+	// A single share after two minutes, far slower than the 10s target.
+	if !v.RecordShare(start.Add(2 * time.Minute)) {
+		t.Fatal("expected difficulty change for slow miner")
+	}
+	if v.Difficulty() != 4.0 {
+		t.Errorf("difficulty = %f, want 4.0", v.Difficulty())
+	}
+}
+
+func TestVardiff_Bounds(t *testing.T) {
+	v := NewVardiff(1.0)
+	v.SetBounds(0.5, 1.5)
+	start := time.Now()
+	v.lastRetarget = start
+
+	for i := 1; i <= 60; i++ {
+		v.RecordShare(start.Add(time.Duration(i) * time.Second))
+	}
+	if v.Difficulty() != 1.5 {
+		t.Errorf("difficulty = %f, want max bound 1.5", v.Difficulty())
+	}
+}
+
+func TestServer_SuggestDifficulty(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+
+	// Absurd suggestion is ignored
+	conn.Write([]byte(`{"id":2,"method":"mining.suggest_difficulty","params":[1e12]}` + "\n"))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read suggest response: %v", err)
+	}
+	var resp Response
+	json.Unmarshal(line, &resp)
+	if resp.Result != false {
+		t.Errorf("absurd suggestion result = %v, want false", resp.Result)
+	}
+
+	// Sane suggestion is applied and announced
+	conn.Write([]byte(`{"id":3,"method":"mining.suggest_difficulty","params":[16]}` + "\n"))
+	line, err = reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read set_difficulty: %v", err)
+	}
+	var notif Notification
+	json.Unmarshal(line, &notif)
+	if notif.Method != "mining.set_difficulty" {
+		t.Fatalf("expected mining.set_difficulty, got %q", notif.Method)
+	}
+	if len(notif.Params) != 1 || notif.Params[0] != 16.0 {
+		t.Errorf("set_difficulty params = %v, want [16]", notif.Params)
+	}
+	reader.ReadBytes('\n') // suggest response
+}
+
+func TestServer_RotateExtranonce(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	line, _ := reader.ReadBytes('\n')
+	var resp Response
+	json.Unmarshal(line, &resp)
+	result, _ := resp.Result.([]interface{})
+	if len(result) != 3 {
+		t.Fatalf("unexpected subscribe result: %v", resp.Result)
+	}
+	oldEN1, _ := result[1].(string)
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+
+	// Rotation is refused until the miner opts in
+	time.Sleep(50 * time.Millisecond)
+	if err := srv.RotateExtranonce(oldEN1); err != ErrExtranonceNotSubscribed {
+		t.Fatalf("RotateExtranonce before subscribe: err = %v, want ErrExtranonceNotSubscribed", err)
+	}
+
+	conn.Write([]byte(`{"id":2,"method":"mining.extranonce.subscribe","params":[]}` + "\n"))
+	reader.ReadBytes('\n')
+	conn.Write([]byte(`{"id":3,"method":"mining.authorize","params":["worker","x"]}` + "\n"))
+	reader.ReadBytes('\n')
+
+	time.Sleep(50 * time.Millisecond)
+	if err := srv.RotateExtranonce(oldEN1); err != nil {
+		t.Fatalf("RotateExtranonce: %v", err)
+	}
+
+	line, err = reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read set_extranonce: %v", err)
+	}
+	var notif Notification
+	json.Unmarshal(line, &notif)
+	if notif.Method != "mining.set_extranonce" || len(notif.Params) != 2 {
+		t.Fatalf("unexpected notification: %s", line)
+	}
+	newEN1, _ := notif.Params[0].(string)
+	if newEN1 == oldEN1 || len(newEN1) != len(oldEN1) {
+		t.Fatalf("new extranonce1 = %q, old = %q", newEN1, oldEN1)
+	}
+
+	// Subsequent shares carry the new extranonce1
+	conn.Write([]byte(`{"id":4,"method":"mining.submit","params":["worker","1","00000000","65000000","00000001"]}` + "\n"))
+	reader.ReadBytes('\n')
+
+	select {
+	case sub := <-srv.SubmitChannel():
+		if sub.Extranonce1 != newEN1 {
+			t.Errorf("submission extranonce1 = %s, want %s", sub.Extranonce1, newEN1)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for submission")
+	}
+}
+
+func TestServer_ReconnectAll(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+
+	srv.ReconnectAll("backup.example.com", 3334, 5)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read reconnect: %v", err)
+	}
+	var notif Notification
+	json.Unmarshal(line, &notif)
+	if notif.Method != "client.reconnect" {
+		t.Fatalf("method = %q, want client.reconnect", notif.Method)
+	}
+	if len(notif.Params) != 3 || notif.Params[0] != "backup.example.com" || notif.Params[1] != 3334.0 {
+		t.Errorf("unexpected reconnect params: %v", notif.Params)
+	}
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	srv.SetIdleTimeout(300 * time.Millisecond)
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+
+	// Traffic inside the timeout keeps the session alive past it.
+	for i := 0; i < 3; i++ {
+		time.Sleep(150 * time.Millisecond)
+		conn.Write([]byte(`{"id":2,"method":"mining.extranonce.subscribe","params":[]}` + "\n"))
+		if _, err := reader.ReadBytes('\n'); err != nil {
+			t.Fatalf("session dropped while active: %v", err)
+		}
+	}
+
+	// Job notifications do not count as activity from the miner.
+	srv.BroadcastJob(&Job{ID: "1"})
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatalf("idle session was not closed: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for srv.SessionCount() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := srv.SessionCount(); n != 0 {
+		t.Errorf("SessionCount = %d after idle timeout, want 0", n)
+	}
+}
+
+func TestServer_StopClosesPendingConnections(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	// A connection that never sends anything has no session yet.
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	srv.Stop()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read after Stop: %v, want EOF", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("connection closed %v after Stop", elapsed)
+	}
+}
+
+func TestServer_Keepalive(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	srv.SetKeepaliveInterval(50 * time.Millisecond)
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read keepalive: %v", err)
+	}
+	var notif Notification
+	json.Unmarshal(line, &notif)
+	if notif.Method != "mining.set_difficulty" || len(notif.Params) != 1 || notif.Params[0] != 1.0 {
+		t.Errorf("unexpected keepalive: %s", line)
+	}
+}
+
+func TestServer_KeepaliveDropsDeadSession(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+
+	serverSide, clientSide := net.Pipe()
+	clientSide.Close()
+	session := NewSession("dead", NewCodec(serverSide), "00000001", 4, 1.0, srv.submitCh, testLogger())
+	session.State = StateSubscribed
+	srv.sessions[session.ID] = session
+
+	srv.sendKeepalives()
+
+	if n := srv.SessionCount(); n != 0 {
+		t.Errorf("SessionCount = %d after failed keepalive, want 0", n)
+	}
+}
+
+func TestServer_Drain(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	addr := srv.listener.Addr().String()
+
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+	conn.Write([]byte(`{"id":2,"method":"mining.authorize","params":["worker","x"]}` + "\n"))
+	reader.ReadBytes('\n')
+
+	drained := make(chan error, 1)
+	go func() { drained <- srv.Drain(5 * time.Second) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !srv.IsDraining() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond) // let Drain close the listener
+	if c, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		c.Close()
+		t.Error("new connection accepted while draining")
+	}
+
+	// The existing miner still gets work.
+	srv.BroadcastJob(&Job{ID: "1"})
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read job while draining: %v", err)
+	}
+	var notif Notification
+	json.Unmarshal(line, &notif)
+	if notif.Method != "mining.notify" {
+		t.Errorf("got %s, want mining.notify", line)
+	}
+	if srv.SessionCount() != 1 {
+		t.Errorf("SessionCount = %d while draining, want 1", srv.SessionCount())
+	}
+
+	// Drain finishes as soon as the last miner leaves.
+	conn.Close()
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("Drain: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Drain did not return after the last session left")
+	}
+}
+
+func TestServer_DrainTimeout(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n')
+	reader.ReadBytes('\n')
+
+	start := time.Now()
+	if err := srv.Drain(200 * time.Millisecond); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Drain took %v with a 200ms timeout", elapsed)
+	}
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Errorf("session not closed after drain timeout: %v", err)
+	}
+}
+
+func TestServer_DuplicateSubmit(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+	conn.Write([]byte(`{"id":2,"method":"mining.authorize","params":["worker","x"]}` + "\n"))
+	reader.ReadBytes('\n')
+
+	submit := func(id int, nonce string) Response {
+		t.Helper()
+		msg := fmt.Sprintf(`{"id":%d,"method":"mining.submit","params":["worker","1","00000000","65000000","%s"]}`, id, nonce)
+		conn.Write([]byte(msg + "\n"))
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("read submit response: %v", err)
+		}
+		var resp Response
+		json.Unmarshal(line, &resp)
+		return resp
+	}
+
+	if resp := submit(3, "00000001"); resp.Error != nil {
+		t.Fatalf("first submit rejected: %v", resp.Error)
+	}
+	resp := submit(4, "00000001")
+	errArr, ok := resp.Error.([]interface{})
+	if !ok || len(errArr) < 1 || errArr[0] != 22.0 {
+		t.Errorf("duplicate submit error = %v, want code 22", resp.Error)
+	}
+	if resp := submit(5, "00000002"); resp.Error != nil {
+		t.Errorf("different nonce rejected: %v", resp.Error)
+	}
+}
+
+func TestServer_DuplicateSubmitAcrossCleanJob(t *testing.T) {
+	// Jobs superseded by a clean job stay valid during the stale grace
+	// period, so their submissions must still be deduplicated.
+	var mu sync.Mutex
+	valid := map[string]bool{"1": true}
+	srv := NewServer(1.0, testLogger())
+	srv.SetJobValidator(func(jobID string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return valid[jobID]
+	})
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+	conn.Write([]byte(`{"id":2,"method":"mining.authorize","params":["worker","x"]}` + "\n"))
+	reader.ReadBytes('\n')
+
+	submit := func(id int, job string) int {
+		t.Helper()
+		msg := fmt.Sprintf(`{"id":%d,"method":"mining.submit","params":["worker","%s","00000000","65000000","00000001"]}`, id, job)
+		conn.Write([]byte(msg + "\n"))
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("read submit response: %v", err)
+		}
+		return submitErrorCode(t, line)
+	}
+	cleanJob := func(id string) {
+		t.Helper()
+		mu.Lock()
+		valid[id] = true
+		mu.Unlock()
+		srv.BroadcastJob(&Job{ID: id, CleanJobs: true})
+		if _, err := reader.ReadBytes('\n'); err != nil { // mining.notify
+			t.Fatalf("read notify: %v", err)
+		}
+	}
+
+	if code := submit(3, "1"); code != 0 {
+		t.Fatalf("first submit rejected with code %d", code)
+	}
+	cleanJob("2")
+	if code := submit(4, "1"); code != 22 {
+		t.Errorf("resubmit within grace: code %d, want 22", code)
+	}
+
+	// Once the old job expires its keys are dropped and the job itself is
+	// rejected as stale.
+	mu.Lock()
+	valid["1"] = false
+	mu.Unlock()
+	cleanJob("3")
+	if code := submit(5, "1"); code != 21 {
+		t.Errorf("resubmit after grace: code %d, want 21", code)
+	}
+	if code := submit(6, "2"); code != 0 {
+		t.Errorf("same share for the new job rejected with code %d", code)
+	}
+}
+
+func TestServer_RejectHandler(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	srv.SetJobValidator(func(jobID string) bool { return jobID != "old" })
+	srv.SetShareValidator(func(sub *ShareSubmission) error {
+		if sub.Nonce == "0000dead" {
+			return ErrLowDifficulty
+		}
+		return nil
+	})
+	rejections := make(chan ShareRejection, 8)
+	srv.SetRejectHandler(func(rej ShareRejection) { rejections <- rej })
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+	conn.Write([]byte(`{"id":2,"method":"mining.authorize","params":["worker","x"]}` + "\n"))
+	reader.ReadBytes('\n')
+
+	tests := []struct {
+		job, extranonce2, nonce string
+		reason                  string // empty if accepted
+	}{
+		{"1", "00000000", "00000001", ""},
+		{"1", "00000000", "00000001", RejectDuplicate},
+		{"old", "00000000", "00000002", RejectStale},
+		{"1", "00", "00000003", RejectBadExtranonce},
+		{"1", "00000000", "xyz", RejectMalformed},
+		{"1", "00000000", "0000dead", RejectLowDifficulty},
+	}
+	for i, tt := range tests {
+		msg := fmt.Sprintf(`{"id":%d,"method":"mining.submit","params":["worker","%s","%s","65000000","%s"]}`, i+3, tt.job, tt.extranonce2, tt.nonce)
+		conn.Write([]byte(msg + "\n"))
+		if _, err := reader.ReadBytes('\n'); err != nil {
+			t.Fatalf("read submit response: %v", err)
+		}
+		if tt.reason == "" {
+			continue
+		}
+		select {
+		case rej := <-rejections:
+			if rej.Reason != tt.reason || rej.Worker != "worker" || rej.JobID != tt.job || rej.Err == nil {
+				t.Errorf("submit %d: rejection = %+v, want reason %s for job %s", i, rej, tt.reason, tt.job)
+			}
+		default:
+			t.Errorf("submit %d: reject handler not called", i)
+		}
+	}
+	if len(rejections) != 0 {
+		t.Errorf("%d unexpected rejections", len(rejections))
+	}
+}
+
+func TestServer_RejectedShareSkipsVardiff(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	srv.SetShareValidator(func(sub *ShareSubmission) error {
+		if sub.Nonce == "0000dead" {
+			return ErrLowDifficulty
+		}
+		return nil
+	})
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+	conn.Write([]byte(`{"id":2,"method":"mining.authorize","params":["worker","x"]}` + "\n"))
+	reader.ReadBytes('\n')
+
+	// Make the next recorded share due a retarget, and a slow one at that.
+	srv.sessionsMu.RLock()
+	for _, session := range srv.sessions {
+		session.mu.Lock()
+		session.Vardiff.lastRetarget = time.Now().Add(-time.Hour)
+		session.mu.Unlock()
+	}
+	srv.sessionsMu.RUnlock()
+
+	// A rejected share must not retarget, so the reply is the rejection
+	// itself rather than a mining.set_difficulty.
+	conn.Write([]byte(`{"id":3,"method":"mining.submit","params":["worker","1","00000000","65000000","0000dead"]}` + "\n"))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read submit response: %v", err)
+	}
+	if code := submitErrorCode(t, line); code != 23 {
+		t.Fatalf("rejected share: got %s, want a code 23 response", line)
+	}
+
+	// An accepted share does.
+	conn.Write([]byte(`{"id":4,"method":"mining.submit","params":["worker","1","00000000","65000000","00000001"]}` + "\n"))
+	line, err = reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read notification: %v", err)
+	}
+	var notif Notification
+	if err := json.Unmarshal(line, &notif); err != nil || notif.Method != "mining.set_difficulty" {
+		t.Errorf("accepted share: got %s, want mining.set_difficulty", line)
+	}
+}
+
+func TestServer_StaleJobRejected(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	srv.SetJobValidator(func(jobID string) bool { return jobID == "2" })
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+	conn.Write([]byte(`{"id":2,"method":"mining.authorize","params":["worker","x"]}` + "\n"))
+	reader.ReadBytes('\n')
+
+	conn.Write([]byte(`{"id":3,"method":"mining.submit","params":["worker","1","00000000","65000000","00000001"]}` + "\n"))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read submit response: %v", err)
+	}
+	var resp Response
+	json.Unmarshal(line, &resp)
+	errArr, ok := resp.Error.([]interface{})
+	if !ok || len(errArr) < 1 || errArr[0] != 21.0 {
+		t.Errorf("stale job submit error = %v, want code 21", resp.Error)
+	}
+}
+
+// submitErrorCode returns the error code of a response, or 0 on success.
+func submitErrorCode(t *testing.T, line []byte) int {
+	t.Helper()
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		t.Fatalf("unmarshal response %s: %v", line, err)
+	}
+	if resp.Error == nil {
+		return 0
+	}
+	errArr, ok := resp.Error.([]interface{})
+	if !ok || len(errArr) != 3 {
+		t.Fatalf("error is not a [code, message, traceback] tuple: %s", line)
+	}
+	code, _ := errArr[0].(float64)
+	return int(code)
+}
+
+func TestServer_LowDifficultyShare(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	srv.SetShareValidator(func(sub *ShareSubmission) error {
+		if sub.Nonce == "00000001" {
+			return ErrLowDifficulty
+		}
+		return nil
+	})
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	submit := func(id int, nonce string) int {
+		t.Helper()
+		msg := fmt.Sprintf(`{"id":%d,"method":"mining.submit","params":["worker","1","00000000","65000000","%s"]}`, id, nonce)
+		conn.Write([]byte(msg + "\n"))
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("read submit response: %v", err)
+		}
+		return submitErrorCode(t, line)
+	}
+
+	if code := submit(1, "00000002"); code != ErrCodeNotSubscribed {
+		t.Errorf("submit before subscribe: code %d, want %d", code, ErrCodeNotSubscribed)
+	}
+	conn.Write([]byte(`{"id":2,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+	if code := submit(3, "00000002"); code != ErrCodeUnauthorized {
+		t.Errorf("submit before authorize: code %d, want %d", code, ErrCodeUnauthorized)
+	}
+	conn.Write([]byte(`{"id":4,"method":"mining.authorize","params":["worker","x"]}` + "\n"))
+	reader.ReadBytes('\n')
+
+	if code := submit(5, "00000001"); code != ErrCodeLowDifficulty {
+		t.Errorf("low difficulty share: code %d, want %d", code, ErrCodeLowDifficulty)
+	}
+	if code := submit(6, "00000002"); code != 0 {
+		t.Errorf("valid share rejected with code %d", code)
+	}
+
+	// Only the accepted share is passed on.
+	select {
+	case sub := <-srv.SubmitChannel():
+		if sub.Nonce != "00000002" {
+			t.Errorf("rejected share reached SubmitChannel: nonce %s", sub.Nonce)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("accepted share not passed on")
+	}
+	select {
+	case sub := <-srv.SubmitChannel():
+		t.Errorf("unexpected submission: nonce %s", sub.Nonce)
+	default:
+	}
+}
+
+func TestServer_ShutdownClosesListenersFirst(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	addr := srv.listener.Addr().String()
+
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+
+	done := make(chan struct{})
+	go func() {
+		srv.Shutdown("backup.example.com", 3334, 500*time.Millisecond)
+		close(done)
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := reader.ReadBytes('\n'); err != nil {
+		t.Fatalf("read reconnect: %v", err)
+	}
+	// Miners told to reconnect must not land back on this server.
+	if c, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		c.Close()
+		t.Error("listener still accepting during shutdown")
+	}
+	<-done
+}
+
+func TestServer_ShutdownWithoutHost(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	conn, err := net.DialTimeout("tcp", srv.listener.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":["test"]}` + "\n"))
+	reader.ReadBytes('\n') // subscribe response
+	reader.ReadBytes('\n') // mining.set_difficulty notification
+
+	srv.Shutdown("", 0, time.Minute)
+
+	// No client.reconnect: the connection just closes.
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if line, err := reader.ReadBytes('\n'); err == nil {
+		t.Errorf("unexpected message on shutdown: %s", line)
+	}
+}
+
+func TestWithinMask(t *testing.T) {
+	tests := []struct {
+		bits, mask string
+		want       bool
+	}{
+		{"00004000", VersionRollingMask, true},
+		{"1fffe000", VersionRollingMask, true},
+		{"00000000", VersionRollingMask, true},
+		{"20000000", VersionRollingMask, false},
+		{"00001000", VersionRollingMask, false},
+		{"1fffe000", "00ffe000", false},
+		{"zzzzzzzz", VersionRollingMask, false},
+	}
+	for _, tt := range tests {
+		if got := withinMask(tt.bits, tt.mask); got != tt.want {
+			t.Errorf("withinMask(%s, %s) = %v, want %v", tt.bits, tt.mask, got, tt.want)
+		}
+	}
+}
+
 func TestServer_BroadcastJob(t *testing.T) {
 	srv := NewServer(1.0, testLogger())
 	err := srv.Start("127.0.0.1:0")
@@ -3,6 +3,7 @@ package stratum
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -21,6 +22,11 @@ const (
 )
 
 const (
+	// maxSeenSubmissions bounds the per-session duplicate detection set.
+	// It comfortably covers a miner's output across the stored job window
+	// at the submit rate limit.
+	maxSeenSubmissions = 4096
+
 	// VersionRollingMask defines which bits of the block version the miner
 	// may modify. Bits 13-28 (0x1fffe000) is the standard mask used by
 	// ASICs for extra nonce space via BIP 310 (version rolling).
@@ -40,9 +46,14 @@ type Session struct {
 	// Miner info
 	WorkerName      string
 	ConnectedAt     time.Time
+	Port            string // listen address this session connected through
 	Extranonce1     string // Hex-encoded unique per-session extranonce
 	Extranonce2Size int
 
+	// ExtranonceSubscribed is set once the miner sends
+	// mining.extranonce.subscribe, meaning it accepts mining.set_extranonce.
+	ExtranonceSubscribed bool
+
 	// Version rolling (BIP 310)
 	VersionRollingEnabled bool
 	VersionRollingMask    string
@@ -50,6 +61,21 @@ type Session struct {
 	// Current job
 	currentJobID string
 
+	// jobValid, if set, reports whether a job ID can still accept shares
+	jobValid func(jobID string) bool
+
+	// shareValid, if set, checks a submission before it is accepted and
+	// returns the error to report to the miner
+	shareValid func(sub *ShareSubmission) error
+
+	// shareRejected, if set, is told about every rejected submission
+	shareRejected func(rej ShareRejection)
+
+	// Recently seen submissions, for duplicate detection. seenOrder keeps
+	// insertion order so the oldest entry is evicted once the set is full.
+	seen      map[string]struct{}
+	seenOrder []seenSubmission
+
 	// Submit channel - sends validated submissions to the server
 	submitCh chan *ShareSubmission
 
@@ -66,8 +92,12 @@ type ShareSubmission struct {
 	NTime          string
 	Nonce          string
 	VersionBits    string  // BIP 310 version rolling bits (hex), empty if not used
+	VersionMask    string  // Negotiated BIP 310 mask (hex), empty if not used
 	Difficulty     float64 // Current stratum difficulty for this miner
 	PrevDifficulty float64 // Previous difficulty (before most recent retarget), 0 if none
+	// Checked is whatever the share validator attached to the submission,
+	// handed on through SubmitChannel so the check needn't be redone.
+	Checked any
 }
 
 // NewSession creates a new miner session.
@@ -83,6 +113,7 @@ func NewSession(id string, codec *Codec, extranonce1 string, extranonce2Size int
 		Extranonce2Size: extranonce2Size,
 		submitCh:        submitCh,
 		submitLimiter:   rate.NewLimiter(100, 20),
+		seen:            make(map[string]struct{}),
 	}
 }
 
@@ -103,10 +134,11 @@ func (s *Session) HandleRequest(req *Request) error {
 	case "mining.submit":
 		return s.handleSubmit(req)
 	case "mining.extranonce.subscribe":
+		s.ExtranonceSubscribed = true
 		return s.sendResult(req.ID, true)
 	default:
 		s.Logger.Debug("unknown method", zap.String("method", req.Method))
-		return s.sendError(req.ID, 20, "Unknown method")
+		return s.sendError(req.ID, NewError("Unknown method"))
 	}
 }
 
@@ -175,13 +207,34 @@ func intersectMasks(minerMask, serverMask string) string {
 	return fmt.Sprintf("%08x", miner&server)
 }
 
+// withinMask reports whether the hex version bits only set bits allowed by
+// the hex mask.
+func withinMask(versionBits, mask string) bool {
+	var bits, m uint32
+	if _, err := fmt.Sscanf(versionBits, "%x", &bits); err != nil {
+		return false
+	}
+	if _, err := fmt.Sscanf(mask, "%x", &m); err != nil {
+		return false
+	}
+	return bits&^m == 0
+}
+
 func (s *Session) handleSuggestDifficulty(req *Request) error {
 	var params []float64
 	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) < 1 {
-		return s.sendError(req.ID, 20, "Invalid suggest_difficulty params")
+		return s.sendError(req.ID, NewError("Invalid suggest_difficulty params"))
 	}
 
 	diff := params[0]
+	if !s.Vardiff.InBounds(diff) {
+		s.Logger.Debug("ignoring out-of-range suggested difficulty",
+			zap.Float64("suggested", diff),
+			zap.Float64("current", s.Vardiff.Difficulty()),
+		)
+		return s.sendResult(req.ID, false)
+	}
+
 	s.Vardiff.SetDifficulty(diff)
 	s.Logger.Info("miner suggested difficulty",
 		zap.Float64("suggested", diff),
@@ -245,7 +298,7 @@ const maxWorkerNameLen = 256
 func (s *Session) handleAuthorize(req *Request) error {
 	var params []string
 	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) < 1 {
-		return s.sendError(req.ID, 20, "Invalid authorize params")
+		return s.sendError(req.ID, NewError("Invalid authorize params"))
 	}
 
 	name := params[0]
@@ -260,32 +313,39 @@ func (s *Session) handleAuthorize(req *Request) error {
 }
 
 func (s *Session) handleSubmit(req *Request) error {
-	if s.State != StateAuthorized {
-		return s.sendError(req.ID, 24, "Not authorized")
+	switch s.State {
+	case StateConnected:
+		return s.rejectShare(req.ID, "", RejectUnauthorized, ErrNotSubscribed)
+	case StateSubscribed:
+		return s.rejectShare(req.ID, "", RejectUnauthorized, ErrUnauthorized)
 	}
 
 	if !s.submitLimiter.Allow() {
 		s.Logger.Warn("rate limit exceeded")
-		return s.sendError(req.ID, 25, "Rate limit exceeded")
+		return s.rejectShare(req.ID, "", RejectRateLimited, NewError("Rate limit exceeded"))
 	}
 
 	var params []string
 	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) < 5 {
-		return s.sendError(req.ID, 20, "Invalid submit params")
+		return s.rejectShare(req.ID, "", RejectMalformed, NewError("Invalid submit params"))
+	}
+
+	if s.jobValid != nil && !s.jobValid(params[1]) {
+		return s.rejectShare(req.ID, params[1], RejectStale, ErrJobNotFound)
 	}
 
 	// Validate extranonce2 length matches expected size (hex-encoded, so 2 chars per byte)
 	expectedEN2Len := s.Extranonce2Size * 2
 	if len(params[2]) != expectedEN2Len {
-		return s.sendError(req.ID, 20, fmt.Sprintf("Invalid extranonce2 length: got %d, want %d", len(params[2]), expectedEN2Len))
+		return s.rejectShare(req.ID, params[1], RejectBadExtranonce, NewError(fmt.Sprintf("Invalid extranonce2 length: got %d, want %d", len(params[2]), expectedEN2Len)))
 	}
 
 	// Validate ntime and nonce are 8-char hex strings (4 bytes each)
 	if !isHex(params[3], 8) {
-		return s.sendError(req.ID, 20, "Invalid ntime format")
+		return s.rejectShare(req.ID, params[1], RejectMalformed, NewError("Invalid ntime format"))
 	}
 	if !isHex(params[4], 8) {
-		return s.sendError(req.ID, 20, "Invalid nonce format")
+		return s.rejectShare(req.ID, params[1], RejectMalformed, NewError("Invalid nonce format"))
 	}
 
 	submission := &ShareSubmission{
@@ -303,18 +363,37 @@ func (s *Session) handleSubmit(req *Request) error {
 	// BIP 310: if version rolling is enabled, the 6th param is the rolled version bits
 	if s.VersionRollingEnabled && len(params) >= 6 {
 		if !isHex(params[5], 8) {
-			return s.sendError(req.ID, 20, "Invalid version bits format")
+			return s.rejectShare(req.ID, params[1], RejectBadVersion, NewError("Invalid version bits format"))
+		}
+		if !withinMask(params[5], s.VersionRollingMask) {
+			return s.rejectShare(req.ID, params[1], RejectBadVersion, NewError("Version bits outside negotiated mask"))
 		}
 		submission.VersionBits = params[5]
+		submission.VersionMask = s.VersionRollingMask
+	}
+
+	// Reject exact resubmissions without re-validating
+	key := submission.JobID + ":" + submission.Extranonce1 + ":" + submission.Extranonce2 + ":" +
+		submission.NTime + ":" + submission.Nonce + ":" + submission.VersionBits
+	if !s.markSeen(submission.JobID, key) {
+		return s.rejectShare(req.ID, params[1], RejectDuplicate, ErrDuplicateShare)
 	}
 
-	// Record for vardiff
-	if s.Vardiff.RecordShare() {
+	// Check the share's proof of work so the miner gets a verdict.
+	if s.shareValid != nil {
+		if err := s.shareValid(submission); err != nil {
+			serr := toError(err)
+			return s.rejectShare(req.ID, params[1], rejectReason(serr), serr)
+		}
+	}
+
+	// Record for vardiff; only accepted shares count toward the retarget.
+	if s.Vardiff.RecordShare(time.Now()) {
 		// Difficulty changed, notify miner
 		s.sendDifficulty(s.Vardiff.Difficulty())
 	}
 
-	// Send submission to server for validation
+	// Send submission to server for sharechain processing
 	select {
 	case s.submitCh <- submission:
 	default:
@@ -324,6 +403,97 @@ func (s *Session) handleSubmit(req *Request) error {
 	return s.sendResult(req.ID, true)
 }
 
+// ErrExtranonceNotSubscribed is returned by SetExtranonce when the miner
+// never sent mining.extranonce.subscribe.
+var ErrExtranonceNotSubscribed = errors.New("miner did not subscribe to extranonce changes")
+
+// SetExtranonce replaces the session's extranonce1 and extranonce2 size and
+// notifies the miner via mining.set_extranonce. The combined extranonce size
+// must not change, since the coinbase reserves a fixed amount of space for it.
+// Submissions received after this call are attributed to the new extranonce1;
+// the caller should follow up with a clean job.
+func (s *Session) SetExtranonce(extranonce1 string, extranonce2Size int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.ExtranonceSubscribed {
+		return ErrExtranonceNotSubscribed
+	}
+	if _, err := hex.DecodeString(extranonce1); err != nil {
+		return fmt.Errorf("invalid extranonce1: %w", err)
+	}
+	oldTotal := len(s.Extranonce1)/2 + s.Extranonce2Size
+	newTotal := len(extranonce1)/2 + extranonce2Size
+	if extranonce2Size <= 0 || newTotal != oldTotal {
+		return fmt.Errorf("extranonce size mismatch: got %d bytes, want %d", newTotal, oldTotal)
+	}
+
+	s.Extranonce1 = extranonce1
+	s.Extranonce2Size = extranonce2Size
+
+	s.Logger.Debug("extranonce changed",
+		zap.String("extranonce1", extranonce1),
+		zap.Int("extranonce2_size", extranonce2Size),
+	)
+
+	return s.Codec.SendNotification(&Notification{
+		ID:     nil,
+		Method: "mining.set_extranonce",
+		Params: []interface{}{extranonce1, extranonce2Size},
+	})
+}
+
+// currentExtranonce1 returns the session's extranonce1.
+func (s *Session) currentExtranonce1() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Extranonce1
+}
+
+// seenSubmission is a duplicate detection key and the job it was for.
+type seenSubmission struct {
+	jobID string
+	key   string
+}
+
+// markSeen records a submission key for jobID and returns false if it was
+// already seen.
+func (s *Session) markSeen(jobID, key string) bool {
+	if _, dup := s.seen[key]; dup {
+		return false
+	}
+	if len(s.seenOrder) >= maxSeenSubmissions {
+		delete(s.seen, s.seenOrder[0].key)
+		s.seenOrder = s.seenOrder[1:]
+	}
+	s.seen[key] = struct{}{}
+	s.seenOrder = append(s.seenOrder, seenSubmission{jobID: jobID, key: key})
+	return true
+}
+
+// forgetStaleSubmissions drops the seen keys of jobs that can no longer
+// accept shares. Superseded jobs stay valid for a grace period after a
+// clean job, and their keys are kept until then so a resubmission is
+// still caught. Without a job validator every job is taken as stale.
+func (s *Session) forgetStaleSubmissions() {
+	valid := make(map[string]bool)
+	kept := s.seenOrder[:0]
+	for _, seen := range s.seenOrder {
+		ok, checked := valid[seen.jobID]
+		if !checked {
+			ok = s.jobValid != nil && s.jobValid(seen.jobID)
+			valid[seen.jobID] = ok
+		}
+		if ok {
+			kept = append(kept, seen)
+		} else {
+			delete(s.seen, seen.key)
+		}
+	}
+	clear(s.seenOrder[len(kept):])
+	s.seenOrder = kept
+}
+
 // NotifyJob sends a mining.notify message to the miner.
 func (s *Session) NotifyJob(job *Job) error {
 	s.mu.Lock()
@@ -331,6 +501,12 @@ func (s *Session) NotifyJob(job *Job) error {
 
 	s.currentJobID = job.ID
 
+	// A clean job supersedes the old ones; forget submissions for those
+	// that no longer accept shares.
+	if job.CleanJobs {
+		s.forgetStaleSubmissions()
+	}
+
 	notif := &Notification{
 		ID:     nil,
 		Method: "mining.notify",
@@ -350,6 +526,38 @@ func (s *Session) NotifyJob(job *Job) error {
 	return s.Codec.SendNotification(notif)
 }
 
+// SendReconnect sends a client.reconnect notification asking the miner to
+// reconnect to host:port after waitSeconds. An empty host asks the miner to
+// reconnect to the same server.
+func (s *Session) SendReconnect(host string, port int, waitSeconds int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	params := []interface{}{}
+	if host != "" {
+		params = []interface{}{host, port, waitSeconds}
+	}
+	return s.Codec.SendNotification(&Notification{
+		ID:     nil,
+		Method: "client.reconnect",
+		Params: params,
+	})
+}
+
+// SendKeepalive re-sends the session's current difficulty. Miners treat an
+// unchanged mining.set_difficulty as a no-op, so it is a harmless way to
+// exercise the connection between jobs. Sessions that have not subscribed
+// yet are skipped.
+func (s *Session) SendKeepalive() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.State == StateConnected {
+		return nil
+	}
+	return s.sendDifficulty(s.Vardiff.Difficulty())
+}
+
 func (s *Session) sendDifficulty(diff float64) error {
 	notif := &Notification{
 		ID:     nil,
@@ -376,11 +584,29 @@ func (s *Session) sendResult(id interface{}, result interface{}) error {
 	})
 }
 
-func (s *Session) sendError(id interface{}, code int, msg string) error {
+// rejectShare reports a rejected mining.submit to the reject handler and
+// sends the error to the miner.
+func (s *Session) rejectShare(id interface{}, jobID, reason string, err error) error {
+	serr := toError(err)
+	if s.shareRejected != nil {
+		s.shareRejected(ShareRejection{
+			SessionID: s.ID,
+			Worker:    s.WorkerName,
+			JobID:     jobID,
+			Reason:    reason,
+			Err:       serr,
+		})
+	}
+	return s.sendError(id, serr)
+}
+
+// sendError sends an error response. Errors that are not stratum errors
+// are reported with code 20.
+func (s *Session) sendError(id interface{}, err error) error {
 	return s.Codec.SendResponse(&Response{
 		ID:     id,
 		Result: nil,
-		Error:  []interface{}{code, msg, nil},
+		Error:  toError(err),
 	})
 }
 
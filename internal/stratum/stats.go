@@ -0,0 +1,115 @@
+package stratum
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// workerStatsWindow is the sliding window used for per-worker hashrate.
+	workerStatsWindow = 10 * time.Minute
+
+	// workerStatsMinElapsed avoids inflated hashrate estimates from a burst
+	// of shares shortly after a worker first appears.
+	workerStatsMinElapsed = 30 * time.Second
+
+	// workerStatsExpiry drops workers that have not submitted anything for
+	// this long.
+	workerStatsExpiry = 24 * time.Hour
+)
+
+// WorkerStat is a snapshot of share statistics for one worker name.
+type WorkerStat struct {
+	Accepted  uint64
+	Rejected  uint64
+	LastShare time.Time
+	Hashrate  float64 // H/s estimated from accepted difficulty over workerStatsWindow
+}
+
+// workerShare is an accepted share kept for hashrate estimation.
+type workerShare struct {
+	time       time.Time
+	difficulty float64
+}
+
+// workerTracker accumulates stats for a single worker.
+type workerTracker struct {
+	stat      WorkerStat
+	firstSeen time.Time
+	recent    []workerShare
+}
+
+// prune drops accepted shares older than the hashrate window.
+func (w *workerTracker) prune(now time.Time) {
+	cutoff := now.Add(-workerStatsWindow)
+	i := 0
+	for i < len(w.recent) && w.recent[i].time.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		w.recent = w.recent[i:]
+	}
+}
+
+// hashrate estimates the worker's hashrate at now.
+func (w *workerTracker) hashrate(now time.Time) float64 {
+	elapsed := now.Sub(w.firstSeen)
+	if elapsed > workerStatsWindow {
+		elapsed = workerStatsWindow
+	}
+	if elapsed < workerStatsMinElapsed || len(w.recent) == 0 {
+		return 0
+	}
+	var totalDiff float64
+	for _, s := range w.recent {
+		totalDiff += s.difficulty
+	}
+	return totalDiff * math.Pow(2, 32) / elapsed.Seconds()
+}
+
+// RecordShareResult updates the stats for worker after a share has been
+// validated. difficulty is the stratum difficulty the share met and is only
+// used for accepted shares.
+func (s *Server) RecordShareResult(worker string, difficulty float64, accepted bool) {
+	now := time.Now()
+
+	s.workerStatsMu.Lock()
+	defer s.workerStatsMu.Unlock()
+
+	w, ok := s.workerStats[worker]
+	if !ok {
+		w = &workerTracker{firstSeen: now}
+		s.workerStats[worker] = w
+	}
+
+	w.stat.LastShare = now
+	if accepted {
+		w.stat.Accepted++
+		w.recent = append(w.recent, workerShare{time: now, difficulty: difficulty})
+	} else {
+		w.stat.Rejected++
+	}
+	w.prune(now)
+}
+
+// WorkerStats returns a snapshot of per-worker share statistics. Workers
+// that have been idle for longer than workerStatsExpiry are dropped.
+func (s *Server) WorkerStats() map[string]WorkerStat {
+	now := time.Now()
+
+	s.workerStatsMu.Lock()
+	defer s.workerStatsMu.Unlock()
+
+	out := make(map[string]WorkerStat, len(s.workerStats))
+	for name, w := range s.workerStats {
+		if now.Sub(w.stat.LastShare) > workerStatsExpiry {
+			delete(s.workerStats, name)
+			continue
+		}
+		w.prune(now)
+		stat := w.stat
+		stat.Hashrate = w.hashrate(now)
+		out[name] = stat
+	}
+	return out
+}
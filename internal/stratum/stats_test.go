@@ -0,0 +1,69 @@
+package stratum
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServer_WorkerStats(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+
+	srv.RecordShareResult("rig1", 2.0, true)
+	srv.RecordShareResult("rig1", 2.0, true)
+	srv.RecordShareResult("rig1", 2.0, false)
+	srv.RecordShareResult("rig2", 1.0, false)
+
+	stats := srv.WorkerStats()
+	if len(stats) != 2 {
+		t.Fatalf("workers = %d, want 2", len(stats))
+	}
+	if stats["rig1"].Accepted != 2 || stats["rig1"].Rejected != 1 {
+		t.Errorf("rig1 = %+v, want 2 accepted / 1 rejected", stats["rig1"])
+	}
+	if stats["rig2"].Accepted != 0 || stats["rig2"].Rejected != 1 {
+		t.Errorf("rig2 = %+v, want 0 accepted / 1 rejected", stats["rig2"])
+	}
+	if stats["rig1"].LastShare.IsZero() {
+		t.Error("rig1 last share time not set")
+	}
+
+	// Too little history for a hashrate estimate yet
+	if stats["rig1"].Hashrate != 0 {
+		t.Errorf("rig1 hashrate = %f, want 0 before min elapsed", stats["rig1"].Hashrate)
+	}
+
+	// Pretend rig1 has been around for the full window
+	srv.workerStatsMu.Lock()
+	srv.workerStats["rig1"].firstSeen = time.Now().Add(-2 * workerStatsWindow)
+	srv.workerStatsMu.Unlock()
+
+	want := 4.0 * math.Pow(2, 32) / workerStatsWindow.Seconds()
+	got := srv.WorkerStats()["rig1"].Hashrate
+	if math.Abs(got-want)/want > 0.01 {
+		t.Errorf("rig1 hashrate = %f, want ~%f", got, want)
+	}
+}
+
+func TestServer_WorkerStatsConcurrent(t *testing.T) {
+	srv := NewServer(1.0, testLogger())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				srv.RecordShareResult("rig", 1.0, j%10 != 0)
+				srv.WorkerStats()
+			}
+		}()
+	}
+	wg.Wait()
+
+	stat := srv.WorkerStats()["rig"]
+	if stat.Accepted+stat.Rejected != 800 {
+		t.Errorf("total shares = %d, want 800", stat.Accepted+stat.Rejected)
+	}
+}
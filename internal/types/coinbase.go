@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"strings"
 
 	"github.com/djkazic/p2pool-go/pkg/util"
 )
@@ -16,16 +17,60 @@ const (
 	SharechainCommitmentTag = "p2pool"
 )
 
+// maxExtranonceMarkerLen bounds ExtranonceLayout.Marker; the scriptSig
+// also has to fit the height push, the commitment and the extranonce.
+const maxExtranonceMarkerLen = 16
+
+// ExtranonceLayout places the extranonce in the coinbase scriptSig. The
+// zero value puts it at the end, after the sharechain commitment.
+type ExtranonceLayout struct {
+	// AfterHeight puts the extranonce straight after the BIP34 height push,
+	// before the sharechain commitment.
+	AfterHeight bool
+	// Marker, if set, is written immediately before the extranonce, for
+	// firmware that looks for the extranonce after a fixed tag.
+	Marker []byte
+}
+
+// Validate checks that the layout can be built: the marker must be short
+// and must neither contain the sharechain commitment tag nor end with the
+// start of it, which an extranonce could complete, as either would make the
+// commitment ambiguous.
+func (l ExtranonceLayout) Validate() error {
+	if len(l.Marker) > maxExtranonceMarkerLen {
+		return fmt.Errorf("extranonce marker is %d bytes, max %d", len(l.Marker), maxExtranonceMarkerLen)
+	}
+	if bytes.Contains(l.Marker, []byte(SharechainCommitmentTag)) {
+		return fmt.Errorf("extranonce marker contains the sharechain tag %q", SharechainCommitmentTag)
+	}
+	for i := 1; i < len(SharechainCommitmentTag); i++ {
+		if bytes.HasSuffix(l.Marker, []byte(SharechainCommitmentTag[:i])) {
+			return fmt.Errorf("extranonce marker ends with the start of the sharechain tag %q", SharechainCommitmentTag)
+		}
+	}
+	return nil
+}
+
 // CoinbaseBuilder builds coinbase transactions for shares.
 type CoinbaseBuilder struct {
-	network string
+	network NetworkParams
+	layout  ExtranonceLayout
 }
 
 // NewCoinbaseBuilder creates a new coinbase builder.
-func NewCoinbaseBuilder(network string) *CoinbaseBuilder {
+func NewCoinbaseBuilder(network NetworkParams) *CoinbaseBuilder {
 	return &CoinbaseBuilder{network: network}
 }
 
+// SetExtranonceLayout sets where BuildCoinbase places the extranonce.
+func (cb *CoinbaseBuilder) SetExtranonceLayout(layout ExtranonceLayout) error {
+	if err := layout.Validate(); err != nil {
+		return err
+	}
+	cb.layout = layout
+	return nil
+}
+
 // BuildCoinbase builds a complete coinbase transaction.
 // blockHeight is encoded in the scriptSig per BIP34.
 // shareCommitment is the sharechain data embedded in the scriptSig.
@@ -55,8 +100,11 @@ func (cb *CoinbaseBuilder) BuildCoinbase(
 	binary.Write(&buf, binary.LittleEndian, uint32(0xffffffff))
 
 	// Build scriptSig
-	scriptSig := buildScriptSig(blockHeight, shareCommitment, extranonceSize)
-	extranonceOffset := buf.Len() + len(util.WriteVarInt(uint64(len(scriptSig)))) + len(scriptSig) - extranonceSize
+	scriptSig, scriptOffset, err := buildScriptSig(blockHeight, shareCommitment, extranonceSize, cb.layout)
+	if err != nil {
+		return nil, 0, err
+	}
+	extranonceOffset := buf.Len() + len(util.WriteVarInt(uint64(len(scriptSig)))) + scriptOffset
 
 	buf.Write(util.WriteVarInt(uint64(len(scriptSig))))
 	buf.Write(scriptSig)
@@ -99,23 +147,47 @@ func (cb *CoinbaseBuilder) BuildCoinbase(
 	return buf.Bytes(), extranonceOffset, nil
 }
 
-// buildScriptSig builds the coinbase scriptSig with BIP34 height and sharechain commitment.
-func buildScriptSig(height int64, shareCommitment []byte, extranonceSize int) []byte {
+// buildScriptSig builds the coinbase scriptSig with BIP34 height and
+// sharechain commitment, leaving a zeroed extranonce placeholder where
+// layout puts it. It returns the scriptSig and the placeholder's offset in
+// it.
+func buildScriptSig(height int64, shareCommitment []byte, extranonceSize int, layout ExtranonceLayout) ([]byte, int, error) {
 	var buf bytes.Buffer
 
 	// BIP34: block height (serialized as minimal CScriptNum)
-	heightBytes := serializeHeight(height)
-	buf.Write(heightBytes)
+	buf.Write(serializeHeight(height))
+	heightEnd := buf.Len()
 
-	// Sharechain commitment
-	if len(shareCommitment) > 0 {
-		buf.Write(shareCommitment)
+	writeExtranonce := func() int {
+		buf.Write(layout.Marker)
+		offset := buf.Len()
+		// Extranonce placeholder (will be filled per-miner)
+		buf.Write(make([]byte, extranonceSize))
+		return offset
 	}
 
-	// Extranonce placeholder (will be filled per-miner)
-	buf.Write(make([]byte, extranonceSize))
+	var offset int
+	if layout.AfterHeight {
+		offset = writeExtranonce()
+	}
+	// Sharechain commitment
+	commitStart := buf.Len()
+	buf.Write(shareCommitment)
+	commitEnd := buf.Len()
+	if !layout.AfterHeight {
+		offset = writeExtranonce()
+	}
 
-	return buf.Bytes()
+	if offset < heightEnd {
+		return nil, 0, fmt.Errorf("extranonce at scriptSig offset %d overlaps the height push", offset)
+	}
+	if offset < commitEnd && offset+extranonceSize > commitStart {
+		return nil, 0, fmt.Errorf("extranonce at scriptSig offset %d overlaps the sharechain commitment", offset)
+	}
+	if buf.Len() > CoinbaseScriptSigMaxLen {
+		return nil, 0, fmt.Errorf("coinbase scriptSig is %d bytes, max %d", buf.Len(), CoinbaseScriptSigMaxLen)
+	}
+	return buf.Bytes(), offset, nil
 }
 
 // serializeHeight serializes a block height for BIP34 coinbase scriptSig.
@@ -146,174 +218,63 @@ func serializeHeight(height int64) []byte {
 }
 
 // addressToScript converts a Bitcoin address to a scriptPubKey.
-// Supports P2WPKH (bech32), P2WSH (bech32), and basic P2PKH/P2SH.
-func addressToScript(address string, network string) ([]byte, error) {
+// Supports bech32/bech32m witness addresses and base58check P2PKH/P2SH.
+func addressToScript(address string, network NetworkParams) ([]byte, error) {
 	// Handle bech32/bech32m addresses (testnet: tb1..., mainnet: bc1...)
-	prefix := "tb1"
-	if network == "mainnet" {
-		prefix = "bc1"
-	} else if network == "regtest" {
-		prefix = "bcrt1"
-	}
-
-	if len(address) > len(prefix) && address[:len(prefix)] == prefix {
-		return bech32AddressToScript(address)
+	if IsSegwitAddress(address, network) {
+		return bech32AddressToScript(address, network.Bech32HRP)
 	}
 
-	return nil, fmt.Errorf("unsupported address format: %s (only bech32 supported)", address)
+	return base58AddressToScript(address, network)
 }
 
-// bech32AddressToScript converts a bech32 address to a witness scriptPubKey.
-// For simplicity, we decode the witness program directly.
-func bech32AddressToScript(address string) ([]byte, error) {
-	hrp, data, err := bech32Decode(address)
+// base58AddressToScript converts a legacy base58check address to a P2PKH
+// or P2SH scriptPubKey, checking its version byte against the network.
+func base58AddressToScript(address string, network NetworkParams) ([]byte, error) {
+	version, hash, err := util.Base58CheckDecode(address)
 	if err != nil {
-		return nil, fmt.Errorf("bech32 decode: %w", err)
-	}
-	_ = hrp
-
-	if len(data) < 1 {
-		return nil, fmt.Errorf("empty bech32 data")
+		return nil, fmt.Errorf("unsupported address format: %s: %w", address, err)
+	}
+	if len(hash) != 20 {
+		return nil, fmt.Errorf("invalid address %s: hash is %d bytes, want 20", address, len(hash))
+	}
+
+	switch version {
+	case network.P2PKHVersion:
+		// OP_DUP OP_HASH160 <20> <hash> OP_EQUALVERIFY OP_CHECKSIG
+		script := []byte{0x76, 0xa9, 0x14}
+		script = append(script, hash...)
+		return append(script, 0x88, 0xac), nil
+	case network.P2SHVersion:
+		// OP_HASH160 <20> <hash> OP_EQUAL
+		script := []byte{0xa9, 0x14}
+		script = append(script, hash...)
+		return append(script, 0x87), nil
+	default:
+		return nil, fmt.Errorf("address %s is not a %s address (version 0x%02x)", address, network.Name, version)
 	}
+}
 
-	witnessVersion := data[0]
-	witnessProgram, err := convertBits(data[1:], 5, 8, false)
+// bech32AddressToScript converts a segwit address to its witness
+// scriptPubKey: OP_n <len> <program>.
+func bech32AddressToScript(address, hrp string) ([]byte, error) {
+	version, program, err := util.DecodeSegwitAddress(hrp, address)
 	if err != nil {
-		return nil, fmt.Errorf("convert bits: %w", err)
+		return nil, fmt.Errorf("invalid address %s: %w", address, err)
 	}
 
-	// Build witness scriptPubKey: OP_n <len> <program>
 	var script []byte
-	if witnessVersion == 0 {
+	if version == 0 {
 		script = append(script, 0x00) // OP_0
 	} else {
-		script = append(script, 0x50+witnessVersion) // OP_1 through OP_16
+		script = append(script, 0x50+version) // OP_1 through OP_16
 	}
-	script = append(script, byte(len(witnessProgram)))
-	script = append(script, witnessProgram...)
+	script = append(script, byte(len(program)))
+	script = append(script, program...)
 
 	return script, nil
 }
 
-// bech32Decode decodes a bech32/bech32m string with full checksum verification.
-func bech32Decode(s string) (string, []byte, error) {
-	// Find separator (last occurrence of '1')
-	sepIdx := -1
-	for i := len(s) - 1; i >= 0; i-- {
-		if s[i] == '1' {
-			sepIdx = i
-			break
-		}
-	}
-	if sepIdx < 1 || sepIdx+7 > len(s) {
-		return "", nil, fmt.Errorf("invalid bech32 separator position")
-	}
-
-	hrp := s[:sepIdx]
-	dataStr := s[sepIdx+1:]
-
-	charset := "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
-	charMap := make(map[byte]byte)
-	for i, c := range charset {
-		charMap[byte(c)] = byte(i)
-	}
-
-	data := make([]byte, len(dataStr))
-	for i := 0; i < len(dataStr); i++ {
-		c := dataStr[i]
-		if c >= 'A' && c <= 'Z' {
-			c = c - 'A' + 'a'
-		}
-		val, ok := charMap[c]
-		if !ok {
-			return "", nil, fmt.Errorf("invalid bech32 character: %c", c)
-		}
-		data[i] = val
-	}
-
-	if len(data) < 6 {
-		return "", nil, fmt.Errorf("bech32 data too short")
-	}
-
-	// Verify checksum
-	check := bech32Polymod(bech32HRPExpand(hrp), data)
-	if check != 1 && check != 0x2bc830a3 {
-		return "", nil, fmt.Errorf("invalid bech32 checksum")
-	}
-
-	// Strip checksum (last 6 characters)
-	data = data[:len(data)-6]
-
-	return hrp, data, nil
-}
-
-// bech32Polymod computes the bech32 polynomial checksum over the HRP expansion and data.
-func bech32Polymod(hrpExp []byte, data []byte) uint32 {
-	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
-	chk := uint32(1)
-	for _, v := range hrpExp {
-		b := chk >> 25
-		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
-		for i := 0; i < 5; i++ {
-			if (b>>uint(i))&1 == 1 {
-				chk ^= gen[i]
-			}
-		}
-	}
-	for _, v := range data {
-		b := chk >> 25
-		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
-		for i := 0; i < 5; i++ {
-			if (b>>uint(i))&1 == 1 {
-				chk ^= gen[i]
-			}
-		}
-	}
-	return chk
-}
-
-// bech32HRPExpand expands the HRP for checksum computation.
-func bech32HRPExpand(hrp string) []byte {
-	ret := make([]byte, 0, len(hrp)*2+1)
-	for _, c := range hrp {
-		ret = append(ret, byte(c>>5))
-	}
-	ret = append(ret, 0)
-	for _, c := range hrp {
-		ret = append(ret, byte(c&31))
-	}
-	return ret
-}
-
-// convertBits converts between bit groups.
-func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
-	acc := uint32(0)
-	bits := uint(0)
-	var result []byte
-	maxv := uint32((1 << toBits) - 1)
-
-	for _, val := range data {
-		acc = (acc << fromBits) | uint32(val)
-		bits += fromBits
-		for bits >= toBits {
-			bits -= toBits
-			result = append(result, byte((acc>>bits)&maxv))
-		}
-	}
-
-	if pad {
-		if bits > 0 {
-			result = append(result, byte((acc<<(toBits-bits))&maxv))
-		}
-	} else if bits >= fromBits {
-		return nil, fmt.Errorf("invalid padding")
-	} else if (acc<<(toBits-bits))&maxv != 0 {
-		return nil, fmt.Errorf("non-zero padding")
-	}
-
-	return result, nil
-}
-
 // AddCoinbaseWitness wraps a non-witness coinbase serialization with the segwit
 // marker/flag and witness data needed for block submission.
 func AddCoinbaseWitness(coinbase []byte) []byte {
@@ -342,6 +303,48 @@ func BuildShareCommitment(prevShareHash [32]byte) []byte {
 	return commitment
 }
 
+// ShareCommitmentHash returns the 32-byte value a share commits to in its
+// coinbase. A share without uncles commits to its PrevShareHash directly;
+// otherwise it commits to DoubleSHA256(prevShareHash || uncle1 || ...).
+func ShareCommitmentHash(prevShareHash [32]byte, uncles [][32]byte) [32]byte {
+	if len(uncles) == 0 {
+		return prevShareHash
+	}
+	data := make([]byte, 0, 32*(len(uncles)+1))
+	data = append(data, prevShareHash[:]...)
+	for _, u := range uncles {
+		data = append(data, u[:]...)
+	}
+	return util.DoubleSHA256(data)
+}
+
+// shareCommitmentV2Tag separates version 2 commitments from version 1 ones
+// over the same hashes.
+const shareCommitmentV2Tag = "p2pool-share-v2"
+
+// VersionedCommitmentHash returns the 32-byte value a share of the given
+// version commits to in its coinbase. Version 1 commits to
+// ShareCommitmentHash and ignores minerAddress and height. Version 2 also
+// binds the miner address, so a relaying node can't re-attribute the
+// share's PoW to another miner the coinbase pays, and the share's height,
+// so it can't be forged: DoubleSHA256(tag || prevShareHash || uncles... ||
+// varint(len(minerAddress)) || minerAddress || height as 8 bytes LE).
+func VersionedCommitmentHash(version uint32, prevShareHash [32]byte, uncles [][32]byte, minerAddress string, height int64) [32]byte {
+	if version < ShareVersion2 {
+		return ShareCommitmentHash(prevShareHash, uncles)
+	}
+	data := make([]byte, 0, len(shareCommitmentV2Tag)+32*(len(uncles)+1)+9+len(minerAddress)+8)
+	data = append(data, shareCommitmentV2Tag...)
+	data = append(data, prevShareHash[:]...)
+	for _, u := range uncles {
+		data = append(data, u[:]...)
+	}
+	data = append(data, util.WriteVarInt(uint64(len(minerAddress)))...)
+	data = append(data, minerAddress...)
+	data = binary.LittleEndian.AppendUint64(data, uint64(height))
+	return util.DoubleSHA256(data)
+}
+
 // CoinbaseOutput represents a parsed coinbase transaction output.
 type CoinbaseOutput struct {
 	Value  int64
@@ -349,7 +352,7 @@ type CoinbaseOutput struct {
 }
 
 // ExtractShareCommitment parses a serialized coinbase transaction and extracts
-// the PrevShareHash from the scriptSig by searching for the "p2pool" tag.
+// the PrevShareHash from the "p2pool" tagged commitment in the scriptSig.
 func ExtractShareCommitment(coinbaseTx []byte) ([32]byte, error) {
 	var zero [32]byte
 	tag := []byte(SharechainCommitmentTag)
@@ -383,21 +386,39 @@ func ExtractShareCommitment(coinbaseTx []byte) ([32]byte, error) {
 	}
 	pos += n
 
-	if pos+int(scriptLen) > len(coinbaseTx) {
+	if scriptLen > uint64(len(coinbaseTx)-pos) {
 		return zero, fmt.Errorf("coinbase too short for scriptSig")
 	}
 	scriptSig := coinbaseTx[pos : pos+int(scriptLen)]
 
-	// Search for "p2pool" tag in scriptSig
-	for i := 0; i <= len(scriptSig)-len(tag)-32; i++ {
-		if bytes.Equal(scriptSig[i:i+len(tag)], tag) {
-			var hash [32]byte
-			copy(hash[:], scriptSig[i+len(tag):i+len(tag)+32])
-			return hash, nil
+	// The commitment sits at a fixed place for each ExtranonceLayout:
+	// straight after the BIP34 height push, or at the end of the scriptSig
+	// when the extranonce comes first. Only look there, so tag bytes in
+	// the miner-controlled extranonce can't stand in for it.
+	if len(scriptSig) == 0 {
+		return zero, fmt.Errorf("empty coinbase scriptSig")
+	}
+	commitLen := len(tag) + 32
+	var found [][]byte
+	if start := 1 + int(scriptSig[0]); start+commitLen <= len(scriptSig) {
+		if c := scriptSig[start : start+commitLen]; bytes.HasPrefix(c, tag) {
+			found = append(found, c)
 		}
 	}
-
-	return zero, fmt.Errorf("sharechain commitment tag %q not found in scriptSig", SharechainCommitmentTag)
+	if end := len(scriptSig) - commitLen; end >= 0 {
+		if c := scriptSig[end:]; bytes.HasPrefix(c, tag) {
+			found = append(found, c)
+		}
+	}
+	if len(found) == 0 {
+		return zero, fmt.Errorf("sharechain commitment tag %q not found in scriptSig", SharechainCommitmentTag)
+	}
+	if len(found) == 2 && !bytes.Equal(found[0], found[1]) {
+		return zero, fmt.Errorf("ambiguous sharechain commitment in scriptSig")
+	}
+	var hash [32]byte
+	copy(hash[:], found[0][len(tag):])
+	return hash, nil
 }
 
 // ParseCoinbaseOutputs parses a serialized coinbase transaction and returns
@@ -432,7 +453,7 @@ func ParseCoinbaseOutputs(coinbaseTx []byte) ([]CoinbaseOutput, error) {
 	}
 	pos += n
 
-	if pos+int(scriptLen) > len(coinbaseTx) {
+	if scriptLen > uint64(len(coinbaseTx)-pos) {
 		return nil, fmt.Errorf("coinbase too short for scriptSig")
 	}
 	pos += int(scriptLen)
@@ -450,6 +471,11 @@ func ParseCoinbaseOutputs(coinbaseTx []byte) ([]CoinbaseOutput, error) {
 	}
 	pos += n
 
+	// Each output takes at least 9 bytes; bound the count by what's left
+	// before allocating for it.
+	if outputCount > uint64(len(coinbaseTx)-pos)/9 {
+		return nil, fmt.Errorf("coinbase too short for %d outputs", outputCount)
+	}
 	outputs := make([]CoinbaseOutput, 0, outputCount)
 	for i := uint64(0); i < outputCount; i++ {
 		// Value (8B LE int64)
@@ -466,7 +492,7 @@ func ParseCoinbaseOutputs(coinbaseTx []byte) ([]CoinbaseOutput, error) {
 		}
 		pos += n
 
-		if pos+int(spkLen) > len(coinbaseTx) {
+		if spkLen > uint64(len(coinbaseTx)-pos) {
 			return nil, fmt.Errorf("coinbase too short for output %d scriptPubKey", i)
 		}
 		script := make([]byte, spkLen)
@@ -481,7 +507,7 @@ func ParseCoinbaseOutputs(coinbaseTx []byte) ([]CoinbaseOutput, error) {
 
 // ValidateMinerInOutputs checks that at least one coinbase output pays to the
 // given miner address.
-func ValidateMinerInOutputs(outputs []CoinbaseOutput, minerAddress, network string) error {
+func ValidateMinerInOutputs(outputs []CoinbaseOutput, minerAddress string, network NetworkParams) error {
 	expectedScript, err := addressToScript(minerAddress, network)
 	if err != nil {
 		return fmt.Errorf("convert miner address to script: %w", err)
@@ -497,7 +523,24 @@ func ValidateMinerInOutputs(outputs []CoinbaseOutput, minerAddress, network stri
 }
 
 // ValidateAddress checks that the given address is valid for the specified network.
-func ValidateAddress(address, network string) error {
+func ValidateAddress(address string, network NetworkParams) error {
 	_, err := addressToScript(address, network)
 	return err
 }
+
+// ValidateShareAddress checks that address may be the miner address of a
+// share of the given version. Legacy P2PKH and P2SH addresses need version
+// 2: nodes predating it only accept segwit addresses, so a version 1 share
+// paying a legacy address would split the sharechain.
+func ValidateShareAddress(address string, version uint32, network NetworkParams) error {
+	if version < ShareVersion2 && !IsSegwitAddress(address, network) {
+		return fmt.Errorf("version %d shares need a segwit address, got %s", version, address)
+	}
+	return ValidateAddress(address, network)
+}
+
+// IsSegwitAddress reports whether address has the network's bech32 prefix.
+// It does not validate the rest of the address.
+func IsSegwitAddress(address string, network NetworkParams) bool {
+	return strings.HasPrefix(strings.ToLower(address), network.Bech32HRP+"1")
+}
@@ -0,0 +1,57 @@
+package types
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/djkazic/p2pool-go/pkg/util"
+)
+
+// FuzzParseCoinbase feeds arbitrary bytes to the coinbase parsers that run
+// on gossiped shares. They must return an error rather than panic, and what
+// they do return must be read from the input: parsed outputs re-serialize
+// to bytes found in the transaction, and an extracted commitment follows
+// the tag in it.
+func FuzzParseCoinbase(f *testing.F) {
+	builder := NewCoinbaseBuilder(TestNet3Params)
+	payouts := []PayoutEntry{
+		{Address: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", Amount: 3000000000},
+		{Address: "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", Amount: 2000000000},
+	}
+	tx, _, err := builder.BuildCoinbase(800000, BuildShareCommitment([32]byte{1}), payouts, "aa21a9ed", 8)
+	if err != nil {
+		f.Fatalf("BuildCoinbase: %v", err)
+	}
+	f.Add(tx)
+	f.Add(tx[:len(tx)/2])
+	f.Add([]byte{})
+	f.Add([]byte{0x01, 0x00, 0x00, 0x00, 0x01})
+	// A scriptSig length that overflows int.
+	f.Add(append(append([]byte{1, 0, 0, 0, 1}, make([]byte, 36)...), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff))
+	// An output count too large to allocate.
+	f.Add(append(append([]byte{1, 0, 0, 0, 1}, make([]byte, 41)...), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f))
+
+	f.Fuzz(func(t *testing.T, tx []byte) {
+		outputs, err := ParseCoinbaseOutputs(tx)
+		if err == nil {
+			var serialized []byte
+			for _, out := range outputs {
+				serialized = binary.LittleEndian.AppendUint64(serialized, uint64(out.Value))
+				serialized = append(serialized, util.WriteVarInt(uint64(len(out.Script)))...)
+				serialized = append(serialized, out.Script...)
+			}
+			if !bytes.Contains(tx, serialized) {
+				t.Errorf("parsed %d outputs not found in the transaction", len(outputs))
+			}
+		}
+
+		hash, err := ExtractShareCommitment(tx)
+		if err == nil {
+			want := append([]byte(SharechainCommitmentTag), hash[:]...)
+			if !bytes.Contains(tx, want) {
+				t.Errorf("commitment %x not found in the transaction", hash)
+			}
+		}
+	})
+}
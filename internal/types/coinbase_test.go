@@ -2,6 +2,7 @@ package types
 
 import (
 	"bytes"
+	"encoding/hex"
 	"testing"
 )
 
@@ -36,7 +37,7 @@ func TestSerializeHeight(t *testing.T) {
 
 func TestBech32AddressToScript(t *testing.T) {
 	// P2WPKH testnet address
-	script, err := bech32AddressToScript("tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx")
+	script, err := bech32AddressToScript("tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", "tb")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -54,7 +55,7 @@ func TestBech32AddressToScript(t *testing.T) {
 }
 
 func TestBuildCoinbase(t *testing.T) {
-	builder := NewCoinbaseBuilder("testnet3")
+	builder := NewCoinbaseBuilder(TestNet3Params)
 
 	payouts := []PayoutEntry{
 		{Address: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", Amount: 5000000000},
@@ -82,6 +83,53 @@ func TestBuildCoinbase(t *testing.T) {
 	}
 }
 
+func TestBuildCoinbase_ExtranonceLayout(t *testing.T) {
+	payouts := []PayoutEntry{{Address: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", Amount: 5000000000}}
+	commitment := BuildShareCommitment([32]byte{})
+
+	// version (4) + input count (1) + outpoint (36) + scriptSig length (1)
+	const scriptStart = 42
+	heightLen := len(serializeHeight(800000))
+
+	builder := NewCoinbaseBuilder(TestNet3Params)
+	if err := builder.SetExtranonceLayout(ExtranonceLayout{AfterHeight: true}); err != nil {
+		t.Fatalf("SetExtranonceLayout: %v", err)
+	}
+	_, offset, err := builder.BuildCoinbase(800000, commitment, payouts, "", 8)
+	if err != nil {
+		t.Fatalf("BuildCoinbase: %v", err)
+	}
+	if offset != scriptStart+heightLen {
+		t.Errorf("after-height offset = %d, want %d", offset, scriptStart+heightLen)
+	}
+
+	if err := builder.SetExtranonceLayout(ExtranonceLayout{Marker: []byte("xx")}); err != nil {
+		t.Fatalf("SetExtranonceLayout: %v", err)
+	}
+	_, offset, err = builder.BuildCoinbase(800000, commitment, payouts, "", 8)
+	if err != nil {
+		t.Fatalf("BuildCoinbase: %v", err)
+	}
+	if want := scriptStart + heightLen + len(commitment) + 2; offset != want {
+		t.Errorf("marker offset = %d, want %d", offset, want)
+	}
+
+	// The scriptSig must stay within consensus limits.
+	if _, _, err := builder.BuildCoinbase(800000, commitment, payouts, "", 60); err == nil {
+		t.Error("expected error for an oversized scriptSig")
+	}
+
+	for _, bad := range []ExtranonceLayout{
+		{Marker: []byte("xp2pool")},
+		{Marker: []byte("xxp2p")},
+		{Marker: make([]byte, maxExtranonceMarkerLen+1)},
+	} {
+		if err := builder.SetExtranonceLayout(bad); err == nil {
+			t.Errorf("SetExtranonceLayout(%q): expected error", bad.Marker)
+		}
+	}
+}
+
 func TestBuildShareCommitment(t *testing.T) {
 	var hash [32]byte
 	hash[0] = 0xab
@@ -104,10 +152,34 @@ func TestBuildShareCommitment(t *testing.T) {
 	}
 }
 
+func TestVersionedCommitmentHash(t *testing.T) {
+	prev := [32]byte{1}
+	uncles := [][32]byte{{2}}
+	const addr1 = "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"
+	const addr2 = "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r"
+
+	if VersionedCommitmentHash(ShareVersion1, prev, uncles, addr1, 5) != ShareCommitmentHash(prev, uncles) {
+		t.Error("v1 commitment should not depend on the miner address or height")
+	}
+	v2 := VersionedCommitmentHash(ShareVersion2, prev, uncles, addr1, 5)
+	if v2 == ShareCommitmentHash(prev, uncles) {
+		t.Error("v2 commitment should differ from v1")
+	}
+	if v2 == VersionedCommitmentHash(ShareVersion2, prev, uncles, addr2, 5) {
+		t.Error("v2 commitment should bind the miner address")
+	}
+	if v2 == VersionedCommitmentHash(ShareVersion2, prev, nil, addr1, 5) {
+		t.Error("v2 commitment should bind the uncles")
+	}
+	if v2 == VersionedCommitmentHash(ShareVersion2, prev, uncles, addr1, 6) {
+		t.Error("v2 commitment should bind the height")
+	}
+}
+
 // buildTestCoinbase is a helper that builds a coinbase with the given prevShareHash and miner address.
 func buildTestCoinbase(t *testing.T, prevShareHash [32]byte, minerAddr string) []byte {
 	t.Helper()
-	builder := NewCoinbaseBuilder("testnet3")
+	builder := NewCoinbaseBuilder(TestNet3Params)
 	commitment := BuildShareCommitment(prevShareHash)
 	payouts := []PayoutEntry{
 		{Address: minerAddr, Amount: 5000000000},
@@ -136,9 +208,44 @@ func TestExtractShareCommitment(t *testing.T) {
 	}
 }
 
+func TestExtractShareCommitment_Layouts(t *testing.T) {
+	var prevShareHash [32]byte
+	prevShareHash[0] = 0xde
+	commitment := BuildShareCommitment(prevShareHash)
+	payouts := []PayoutEntry{{Address: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", Amount: 5000000000}}
+	var fakeHash [32]byte
+	fakeHash[0] = 0xff
+	fake := BuildShareCommitment(fakeHash)
+
+	for _, layout := range []ExtranonceLayout{{}, {AfterHeight: true}, {AfterHeight: true, Marker: []byte("xx")}} {
+		builder := NewCoinbaseBuilder(TestNet3Params)
+		if err := builder.SetExtranonceLayout(layout); err != nil {
+			t.Fatalf("SetExtranonceLayout: %v", err)
+		}
+		tx, offset, err := builder.BuildCoinbase(800000, commitment, payouts, "", len(fake))
+		if err != nil {
+			t.Fatalf("BuildCoinbase: %v", err)
+		}
+		extracted, err := ExtractShareCommitment(tx)
+		if err != nil {
+			t.Fatalf("ExtractShareCommitment(%+v): %v", layout, err)
+		}
+		if extracted != prevShareHash {
+			t.Errorf("ExtractShareCommitment(%+v) = %x, want %x", layout, extracted, prevShareHash)
+		}
+
+		// A tagged commitment in the extranonce must not be taken for the
+		// real one.
+		copy(tx[offset:], fake)
+		if extracted, err := ExtractShareCommitment(tx); err == nil && extracted != prevShareHash {
+			t.Errorf("ExtractShareCommitment(%+v) took the extranonce commitment %x", layout, extracted)
+		}
+	}
+}
+
 func TestExtractShareCommitment_Missing(t *testing.T) {
 	// Build a coinbase without the sharechain commitment (empty commitment)
-	builder := NewCoinbaseBuilder("testnet3")
+	builder := NewCoinbaseBuilder(TestNet3Params)
 	payouts := []PayoutEntry{
 		{Address: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", Amount: 5000000000},
 	}
@@ -154,7 +261,7 @@ func TestExtractShareCommitment_Missing(t *testing.T) {
 }
 
 func TestParseCoinbaseOutputs(t *testing.T) {
-	builder := NewCoinbaseBuilder("testnet3")
+	builder := NewCoinbaseBuilder(TestNet3Params)
 	commitment := BuildShareCommitment([32]byte{})
 	payouts := []PayoutEntry{
 		{Address: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", Amount: 3000000000},
@@ -203,7 +310,7 @@ func TestValidateMinerInOutputs(t *testing.T) {
 		t.Fatalf("ParseCoinbaseOutputs failed: %v", err)
 	}
 
-	err = ValidateMinerInOutputs(outputs, minerAddr, "testnet3")
+	err = ValidateMinerInOutputs(outputs, minerAddr, TestNet3Params)
 	if err != nil {
 		t.Errorf("ValidateMinerInOutputs failed: %v", err)
 	}
@@ -221,48 +328,145 @@ func TestValidateMinerInOutputs_Missing(t *testing.T) {
 	// Validate with a different address — construct a different scriptPubKey manually
 	// Use a different valid testnet address
 	differentAddr := "tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy"
-	err = ValidateMinerInOutputs(outputs, differentAddr, "testnet3")
+	err = ValidateMinerInOutputs(outputs, differentAddr, TestNet3Params)
 	if err == nil {
 		t.Error("expected error when miner address not in outputs")
 	}
 }
 
+func TestAddressToScript_Legacy(t *testing.T) {
+	// All four encode the same hash160.
+	const hash = "751e76e8199196d454941c45d1b3a323f1433bd6"
+	p2pkh := "76a914" + hash + "88ac"
+	p2sh := "a914" + hash + "87"
+
+	tests := []struct {
+		address string
+		network NetworkParams
+		script  string
+	}{
+		{"1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH", MainNetParams, p2pkh},
+		{"3CNHUhP3uyB9EUtRLsmvFUmvGdjGdkTxJw", MainNetParams, p2sh},
+		{"mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", TestNet3Params, p2pkh},
+		{"2N3vVYSK5XRgVSGWy21PnsRmBUywSQNdCsf", TestNet3Params, p2sh},
+		{"mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", RegTestParams, p2pkh},
+	}
+	for _, tt := range tests {
+		script, err := addressToScript(tt.address, tt.network)
+		if err != nil {
+			t.Errorf("addressToScript(%s, %s): %v", tt.address, tt.network.Name, err)
+			continue
+		}
+		if got := hex.EncodeToString(script); got != tt.script {
+			t.Errorf("addressToScript(%s) = %s, want %s", tt.address, got, tt.script)
+		}
+	}
+
+	// Wrong network and corrupted checksum.
+	for _, tt := range []struct {
+		address string
+		network NetworkParams
+	}{
+		{"1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH", TestNet3Params},
+		{"2N3vVYSK5XRgVSGWy21PnsRmBUywSQNdCsf", MainNetParams},
+		{"1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMJ", MainNetParams},
+	} {
+		if err := ValidateAddress(tt.address, tt.network); err == nil {
+			t.Errorf("ValidateAddress(%s, %s): expected error", tt.address, tt.network.Name)
+		}
+	}
+}
+
+func TestValidateShareAddress(t *testing.T) {
+	const segwit = "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"
+	const legacy = "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r"
+
+	if err := ValidateShareAddress(segwit, ShareVersion1, TestNet3Params); err != nil {
+		t.Errorf("v1 segwit address: %v", err)
+	}
+	if err := ValidateShareAddress(legacy, ShareVersion1, TestNet3Params); err == nil {
+		t.Error("v1 share with a legacy address should be rejected")
+	}
+	if err := ValidateShareAddress(legacy, ShareVersion2, TestNet3Params); err != nil {
+		t.Errorf("v2 legacy address: %v", err)
+	}
+}
+
+func TestValidateMinerInOutputs_P2SH(t *testing.T) {
+	minerAddr := "2N3vVYSK5XRgVSGWy21PnsRmBUywSQNdCsf"
+	builder := NewCoinbaseBuilder(TestNet3Params)
+	payouts := []PayoutEntry{
+		{Address: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", Amount: 3000000000},
+		{Address: minerAddr, Amount: 2000000000},
+	}
+	tx, _, err := builder.BuildCoinbase(800000, BuildShareCommitment([32]byte{}), payouts, "", 8)
+	if err != nil {
+		t.Fatalf("BuildCoinbase: %v", err)
+	}
+	outputs, err := ParseCoinbaseOutputs(tx)
+	if err != nil {
+		t.Fatalf("ParseCoinbaseOutputs: %v", err)
+	}
+	if err := ValidateMinerInOutputs(outputs, minerAddr, TestNet3Params); err != nil {
+		t.Errorf("ValidateMinerInOutputs: %v", err)
+	}
+	if err := ValidateMinerInOutputs(outputs, "mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r", TestNet3Params); err == nil {
+		t.Error("P2PKH with the same hash must not match a P2SH output")
+	}
+}
+
 func TestValidateAddress(t *testing.T) {
 	// Valid testnet address
-	err := ValidateAddress("tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", "testnet3")
+	err := ValidateAddress("tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", TestNet3Params)
 	if err != nil {
 		t.Errorf("ValidateAddress failed for valid address: %v", err)
 	}
 
 	// Valid testnet P2WSH address
-	err = ValidateAddress("tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy", "testnet3")
+	err = ValidateAddress("tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy", TestNet3Params)
 	if err != nil {
 		t.Errorf("ValidateAddress failed for valid P2WSH address: %v", err)
 	}
 
 	// Valid mainnet address
-	err = ValidateAddress("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", "mainnet")
+	err = ValidateAddress("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", MainNetParams)
 	if err != nil {
 		t.Errorf("ValidateAddress failed for valid mainnet address: %v", err)
 	}
 
 	// Invalid checksum (last char changed)
-	err = ValidateAddress("tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"[:len("tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx")-1]+"q", "testnet3")
+	err = ValidateAddress("tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"[:len("tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx")-1]+"q", TestNet3Params)
 	if err == nil {
 		t.Error("expected error for invalid bech32 checksum")
 	}
 
 	// Invalid address
-	err = ValidateAddress("not-an-address", "testnet3")
+	err = ValidateAddress("not-an-address", TestNet3Params)
 	if err == nil {
 		t.Error("expected error for invalid address")
 	}
 
 	// Wrong network (mainnet address on testnet)
-	err = ValidateAddress("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", "testnet3")
+	err = ValidateAddress("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", TestNet3Params)
 	if err == nil {
 		t.Error("expected error for wrong network address")
 	}
+
+	// Taproot (witness v1, bech32m) and an uppercase address
+	err = ValidateAddress("bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", MainNetParams)
+	if err != nil {
+		t.Errorf("ValidateAddress failed for taproot address: %v", err)
+	}
+	err = ValidateAddress("BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", MainNetParams)
+	if err != nil {
+		t.Errorf("ValidateAddress failed for uppercase address: %v", err)
+	}
+
+	// Witness v0 program with a bech32m checksum (BIP350)
+	err = ValidateAddress("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh", MainNetParams)
+	if err == nil {
+		t.Error("expected error for v0 address with bech32m checksum")
+	}
 }
 
 func TestExtractShareCommitment_ZeroHash(t *testing.T) {
@@ -280,7 +484,7 @@ func TestExtractShareCommitment_ZeroHash(t *testing.T) {
 }
 
 func TestParseCoinbaseOutputs_WithWitnessCommitment(t *testing.T) {
-	builder := NewCoinbaseBuilder("testnet3")
+	builder := NewCoinbaseBuilder(TestNet3Params)
 	commitment := BuildShareCommitment([32]byte{})
 	payouts := []PayoutEntry{
 		{Address: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", Amount: 5000000000},
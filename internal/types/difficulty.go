@@ -6,13 +6,10 @@ import (
 	"github.com/djkazic/p2pool-go/pkg/util"
 )
 
+// Bitcoin's maximum block targets and the sharechain's easiest share
+// targets differ per network; see NetworkParams.PowLimit and
+// NetworkParams.MaxShareTarget.
 var (
-	// TestnetMaxTarget is Bitcoin testnet's maximum target (difficulty 1).
-	TestnetMaxTarget = util.CompactToTarget(0x1d00ffff)
-
-	// MainnetMaxTarget is Bitcoin mainnet's maximum target (difficulty 1).
-	MainnetMaxTarget = util.CompactToTarget(0x1d00ffff)
-
 	// DefaultShareTarget is the initial sharechain target (much easier than Bitcoin).
 	// This corresponds to a difficulty of ~1, appropriate for testnet CPU mining.
 	DefaultShareTarget = util.CompactToTarget(0x1d00ffff)
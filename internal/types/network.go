@@ -0,0 +1,121 @@
+package types
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/djkazic/p2pool-go/pkg/util"
+)
+
+// NetworkParams holds the consensus-relevant constants of a Bitcoin
+// network. The values are shared; callers must not modify PowLimit.
+type NetworkParams struct {
+	// Name is the network name used in config, e.g. "testnet3".
+	Name string
+	// Bech32HRP is the human-readable part of segwit addresses.
+	Bech32HRP string
+	// P2PKHVersion and P2SHVersion are the base58check version bytes of
+	// legacy addresses.
+	P2PKHVersion byte
+	P2SHVersion  byte
+	// PowLimit is Bitcoin's maximum block target (difficulty 1).
+	PowLimit *big.Int
+	// DefaultRPCPort is bitcoind's default RPC port.
+	DefaultRPCPort int
+	// Magic tags shares gossiped for this network so peers on another
+	// network reject them.
+	Magic uint8
+	// MaxShareBits is the compact form of the easiest sharechain target.
+	// Mainnet shares must reach Bitcoin difficulty 1 so a deployment can't
+	// be flooded with trivially easy shares; the test networks allow
+	// regtest-easy shares so CPU miners can take part.
+	MaxShareBits uint32
+	// SubsidyHalvingInterval is how many blocks pass between halvings of
+	// the block subsidy.
+	SubsidyHalvingInterval int64
+}
+
+// MaxShareTarget returns the easiest sharechain target on the network.
+func (p NetworkParams) MaxShareTarget() *big.Int {
+	return util.CompactToTarget(p.MaxShareBits)
+}
+
+// BlockSubsidy returns the new coins a block at height may claim, before
+// fees.
+func (p NetworkParams) BlockSubsidy(height int64) int64 {
+	halvings := height / p.SubsidyHalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+	return 50 * 100_000_000 >> halvings
+}
+
+var (
+	MainNetParams = NetworkParams{
+		Name:                   "mainnet",
+		Bech32HRP:              "bc",
+		P2PKHVersion:           0x00,
+		P2SHVersion:            0x05,
+		PowLimit:               util.CompactToTarget(0x1d00ffff),
+		DefaultRPCPort:         8332,
+		Magic:                  1,
+		MaxShareBits:           0x1d00ffff,
+		SubsidyHalvingInterval: 210000,
+	}
+	TestNet3Params = NetworkParams{
+		Name:                   "testnet3",
+		Bech32HRP:              "tb",
+		P2PKHVersion:           0x6f,
+		P2SHVersion:            0xc4,
+		PowLimit:               util.CompactToTarget(0x1d00ffff),
+		DefaultRPCPort:         18332,
+		Magic:                  2,
+		MaxShareBits:           0x207fffff,
+		SubsidyHalvingInterval: 210000,
+	}
+	TestNet4Params = NetworkParams{
+		Name:                   "testnet4",
+		Bech32HRP:              "tb",
+		P2PKHVersion:           0x6f,
+		P2SHVersion:            0xc4,
+		PowLimit:               util.CompactToTarget(0x1d00ffff),
+		DefaultRPCPort:         48332,
+		Magic:                  3,
+		MaxShareBits:           0x207fffff,
+		SubsidyHalvingInterval: 210000,
+	}
+	SigNetParams = NetworkParams{
+		Name:                   "signet",
+		Bech32HRP:              "tb",
+		P2PKHVersion:           0x6f,
+		P2SHVersion:            0xc4,
+		PowLimit:               util.CompactToTarget(0x1e0377ae),
+		DefaultRPCPort:         38332,
+		Magic:                  4,
+		MaxShareBits:           0x207fffff,
+		SubsidyHalvingInterval: 210000,
+	}
+	RegTestParams = NetworkParams{
+		Name:                   "regtest",
+		Bech32HRP:              "bcrt",
+		P2PKHVersion:           0x6f,
+		P2SHVersion:            0xc4,
+		PowLimit:               util.CompactToTarget(0x207fffff),
+		DefaultRPCPort:         18443,
+		Magic:                  5,
+		MaxShareBits:           0x207fffff,
+		SubsidyHalvingInterval: 150,
+	}
+)
+
+var networks = []NetworkParams{MainNetParams, TestNet3Params, TestNet4Params, SigNetParams, RegTestParams}
+
+// ParseNetwork returns the parameters of a network by name.
+func ParseNetwork(name string) (NetworkParams, error) {
+	for _, params := range networks {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+	return NetworkParams{}, fmt.Errorf("unknown bitcoin network %q (want mainnet, testnet3, testnet4, signet or regtest)", name)
+}
@@ -0,0 +1,64 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/djkazic/p2pool-go/pkg/util"
+)
+
+func TestParseNetwork(t *testing.T) {
+	for _, name := range []string{"mainnet", "testnet3", "testnet4", "signet", "regtest"} {
+		params, err := ParseNetwork(name)
+		if err != nil {
+			t.Errorf("ParseNetwork(%q): %v", name, err)
+			continue
+		}
+		if params.Name != name || params.PowLimit == nil || params.Magic == 0 {
+			t.Errorf("ParseNetwork(%q) = %+v", name, params)
+		}
+		if !util.IsCanonicalCompact(params.MaxShareBits) || params.MaxShareTarget().Cmp(params.PowLimit) < 0 {
+			t.Errorf("%s: max share bits %08x should be canonical and no harder than difficulty 1", name, params.MaxShareBits)
+		}
+	}
+	for _, name := range []string{"", "testnet", "Mainnet"} {
+		if _, err := ParseNetwork(name); err == nil {
+			t.Errorf("ParseNetwork(%q): expected error", name)
+		}
+	}
+}
+
+func TestNetworkParams_AddressPrefixes(t *testing.T) {
+	// A regtest bech32 address is only valid on regtest, even though
+	// regtest shares testnet's base58 versions.
+	const regtestAddr = "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"
+	if err := ValidateAddress(regtestAddr, RegTestParams); err != nil {
+		t.Errorf("regtest address on regtest: %v", err)
+	}
+	for _, params := range []NetworkParams{MainNetParams, TestNet3Params, SigNetParams} {
+		if err := ValidateAddress(regtestAddr, params); err == nil {
+			t.Errorf("regtest address accepted on %s", params.Name)
+		}
+	}
+	if err := ValidateAddress("tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", TestNet4Params); err != nil {
+		t.Errorf("testnet address on testnet4: %v", err)
+	}
+}
+
+func TestNetworkParams_BlockSubsidy(t *testing.T) {
+	for _, tt := range []struct {
+		params NetworkParams
+		height int64
+		want   int64
+	}{
+		{MainNetParams, 0, 5000000000},
+		{MainNetParams, 209999, 5000000000},
+		{MainNetParams, 210000, 2500000000},
+		{MainNetParams, 840000, 312500000},
+		{MainNetParams, 64 * 210000, 0},
+		{RegTestParams, 150, 2500000000},
+	} {
+		if got := tt.params.BlockSubsidy(tt.height); got != tt.want {
+			t.Errorf("%s height %d: subsidy %d, want %d", tt.params.Name, tt.height, got, tt.want)
+		}
+	}
+}
@@ -16,6 +16,13 @@ type BlockTemplateData struct {
 	CurTime           string // hex timestamp
 	CoinbaseValue     int64
 	WitnessCommitment string // hex
-	Network           string
+	Network           NetworkParams
 	TxHashes          []string // transaction hashes (hex)
+	ExtranonceLayout  ExtranonceLayout
+	// ShareVersion selects the coinbase commitment; zero means
+	// ShareVersion1. MinerAddress and ShareHeight, the sharechain height of
+	// the share being built, are committed by version 2 shares.
+	ShareVersion uint32
+	MinerAddress string
+	ShareHeight  int64
 }
@@ -2,12 +2,25 @@ package types
 
 import (
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/djkazic/p2pool-go/pkg/util"
 )
 
+// Share versions. Each version fixes what a share's coinbase commits to;
+// see VersionedCommitmentHash.
+const (
+	// ShareVersion1 commits PrevShareHash and uncles.
+	ShareVersion1 uint32 = 1
+	// ShareVersion2 also commits MinerAddress and Height.
+	ShareVersion2 uint32 = 2
+)
+
 // ShareHeader represents the header of a share, which is also a valid Bitcoin block header.
 type ShareHeader struct {
 	Version       int32    `json:"version"`
@@ -47,8 +60,29 @@ type Share struct {
 	CoinbaseTx      []byte   `json:"coinbase_tx"`      // Full serialized coinbase transaction
 	ShareChainNonce uint64   `json:"sharechain_nonce"` // Nonce for sharechain commitment
 
-	// Cached/computed fields
-	hash *[32]byte
+	// Uncles references recent shares that lost a sharechain race. They are
+	// committed in the coinbase alongside PrevShareHash and earn a reduced
+	// PPLNS weight.
+	Uncles [][32]byte `json:"uncles,omitempty"`
+
+	// Height is the share's height above genesis, one more than its
+	// parent's. Version 2 shares commit to it in the coinbase; version 1
+	// shares leave it zero and their height is derived from ancestry.
+	Height int64 `json:"height,omitempty"`
+
+	// Cached/computed fields. ShareTarget and the header are treated as
+	// immutable once a share is built; mutating them leaves these stale.
+	hash       *[32]byte
+	weightOnce sync.Once
+	weight     *shareWeight
+}
+
+// shareWeight caches a share's weight for the maxTarget it was computed
+// against. It is set once, under weightOnce, since shares are read from
+// several goroutines.
+type shareWeight struct {
+	maxTarget *big.Int
+	weight    *big.Int
 }
 
 // Hash returns the share's hash (Bitcoin block header hash). Cached after first computation.
@@ -61,6 +95,27 @@ func (s *Share) Hash() [32]byte {
 	return h
 }
 
+// Weight returns the share's PPLNS weight, maxTarget / ShareTarget (its
+// difficulty), or 1 if it has no target. Cached for the first maxTarget
+// it is asked about; the result is shared and must not be modified.
+func (s *Share) Weight(maxTarget *big.Int) *big.Int {
+	s.weightOnce.Do(func() {
+		s.weight = &shareWeight{maxTarget: maxTarget, weight: s.computeWeight(maxTarget)}
+	})
+	if s.weight.maxTarget.Cmp(maxTarget) == 0 {
+		return s.weight.weight
+	}
+	return s.computeWeight(maxTarget)
+}
+
+func (s *Share) computeWeight(maxTarget *big.Int) *big.Int {
+	weight := big.NewInt(1)
+	if s.ShareTarget != nil && s.ShareTarget.Sign() != 0 {
+		weight.Div(maxTarget, s.ShareTarget)
+	}
+	return weight
+}
+
 // Time returns the share's timestamp as a time.Time.
 func (s *Share) Time() time.Time {
 	return time.Unix(int64(s.Header.Timestamp), 0)
@@ -91,6 +146,21 @@ func (s *Share) IsBlock() bool {
 	return s.MeetsBitcoinTarget()
 }
 
+// CommitmentHash returns the value the share's coinbase must commit to for
+// its ShareVersion.
+func (s *Share) CommitmentHash() [32]byte {
+	return VersionedCommitmentHash(s.ShareVersion, s.PrevShareHash, s.Uncles, s.MinerAddress, s.Height)
+}
+
+// CommittedHeight returns the height the share commits to, or false if its
+// version carries no height.
+func (s *Share) CommittedHeight() (int64, bool) {
+	if s.ShareVersion < ShareVersion2 {
+		return 0, false
+	}
+	return s.Height, true
+}
+
 // HashHex returns the hash as a human-readable hex string (reversed, Bitcoin display order).
 func (s *Share) HashHex() string {
 	hash := s.Hash()
@@ -101,3 +171,79 @@ func (s *Share) HashHex() string {
 func (s *Share) PrevShareHashHex() string {
 	return util.HashToHex(s.PrevShareHash)
 }
+
+// shareTargetJSON is the JSON form of a share target: the compact bits for
+// readability plus the full 32-byte big-endian target so it round-trips
+// exactly.
+type shareTargetJSON struct {
+	Bits  uint32 `json:"bits"`
+	Bytes string `json:"bytes"`
+}
+
+// shareAlias has Share's fields without its methods, so encoding it does not
+// recurse into MarshalJSON/UnmarshalJSON.
+type shareAlias Share
+
+// MarshalJSON encodes the share with ShareTarget as compact bits plus the
+// full target bytes.
+func (s *Share) MarshalJSON() ([]byte, error) {
+	var target *shareTargetJSON
+	if s.ShareTarget != nil {
+		if s.ShareTarget.Sign() < 0 || s.ShareTarget.BitLen() > 256 {
+			return nil, fmt.Errorf("share target out of range")
+		}
+		var buf [32]byte
+		s.ShareTarget.FillBytes(buf[:])
+		target = &shareTargetJSON{
+			Bits:  util.TargetToCompact(s.ShareTarget),
+			Bytes: hex.EncodeToString(buf[:]),
+		}
+	}
+	return json.Marshal(struct {
+		*shareAlias
+		ShareTarget *shareTargetJSON `json:"share_target"`
+	}{
+		shareAlias:  (*shareAlias)(s),
+		ShareTarget: target,
+	})
+}
+
+// UnmarshalJSON decodes a share written by MarshalJSON. The full target
+// bytes are authoritative and must agree with the compact bits; if only
+// bits are present the target is expanded from them.
+func (s *Share) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*shareAlias
+		ShareTarget *shareTargetJSON `json:"share_target"`
+	}{
+		shareAlias: (*shareAlias)(s),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	s.hash = nil
+	s.weightOnce = sync.Once{}
+	s.weight = nil
+	s.ShareTarget = nil
+	if aux.ShareTarget == nil {
+		return nil
+	}
+
+	if aux.ShareTarget.Bytes == "" {
+		s.ShareTarget = util.CompactToTarget(aux.ShareTarget.Bits)
+		return nil
+	}
+	raw, err := hex.DecodeString(aux.ShareTarget.Bytes)
+	if err != nil {
+		return fmt.Errorf("decode share target bytes: %w", err)
+	}
+	if len(raw) != 32 {
+		return fmt.Errorf("share target is %d bytes, want 32", len(raw))
+	}
+	target := new(big.Int).SetBytes(raw)
+	if bits := util.TargetToCompact(target); bits != aux.ShareTarget.Bits {
+		return fmt.Errorf("share target bits %08x do not match target bytes (%08x)", aux.ShareTarget.Bits, bits)
+	}
+	s.ShareTarget = target
+	return nil
+}
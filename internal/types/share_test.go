@@ -1,7 +1,9 @@
 package types
 
 import (
+	"encoding/json"
 	"math/big"
+	"sync"
 	"testing"
 
 	"github.com/djkazic/p2pool-go/pkg/util"
@@ -113,3 +115,104 @@ func TestShareDifficulty(t *testing.T) {
 		t.Errorf("difficulty = %f, want 1.0", diff)
 	}
 }
+
+func TestShare_JSONRoundTrip(t *testing.T) {
+	target, _ := new(big.Int).SetString("00000fffff1234567890abcdef1234567890abcdef1234567890abcdef123456", 16)
+	s := &Share{
+		Header: ShareHeader{
+			Version:   0x20000000,
+			Timestamp: 1700000000,
+			Bits:      0x1d00ffff,
+			Nonce:     42,
+		},
+		ShareVersion:  1,
+		PrevShareHash: [32]byte{1, 2, 3},
+		ShareTarget:   target,
+		MinerAddress:  "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx",
+		CoinbaseTx:    []byte{0xde, 0xad, 0xbe, 0xef},
+		Uncles:        [][32]byte{{9}},
+	}
+	wantHash := s.Hash()
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var got Share
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got.ShareTarget == nil || got.ShareTarget.Cmp(target) != 0 {
+		t.Errorf("ShareTarget = %v, want %v", got.ShareTarget, target)
+	}
+	if got.Hash() != wantHash {
+		t.Error("hash changed across round trip")
+	}
+	if got.MinerAddress != s.MinerAddress || got.PrevShareHash != s.PrevShareHash ||
+		len(got.Uncles) != 1 || got.Uncles[0] != s.Uncles[0] {
+		t.Error("share fields not preserved")
+	}
+
+	// A target whose bits disagree with its bytes is rejected.
+	bad := []byte(`{"share_target":{"bits":486604799,"bytes":"` +
+		"00000fffff1234567890abcdef1234567890abcdef1234567890abcdef123456" + `"}}`)
+	if err := json.Unmarshal(bad, &got); err == nil {
+		t.Error("expected error for inconsistent target bits")
+	}
+
+	// Bits alone expand to the compact target.
+	var fromBits Share
+	if err := json.Unmarshal([]byte(`{"share_target":{"bits":486604799}}`), &fromBits); err != nil {
+		t.Fatalf("Unmarshal bits only: %v", err)
+	}
+	if fromBits.ShareTarget.Cmp(util.CompactToTarget(0x1d00ffff)) != 0 {
+		t.Error("bits-only target not expanded")
+	}
+}
+
+func TestShare_WeightCached(t *testing.T) {
+	maxTarget := util.CompactToTarget(0x207fffff)
+	s := &Share{ShareTarget: new(big.Int).Div(maxTarget, big.NewInt(1000))}
+
+	w := s.Weight(maxTarget)
+	if w.Cmp(big.NewInt(1000)) != 0 {
+		t.Fatalf("Weight = %v, want 1000", w)
+	}
+	if s.Weight(maxTarget) != w {
+		t.Error("second call should return the cached value")
+	}
+
+	// A different max target is recomputed rather than served stale.
+	half := new(big.Int).Rsh(maxTarget, 1)
+	if got := s.Weight(half); got.Cmp(big.NewInt(500)) != 0 {
+		t.Errorf("Weight(half) = %v, want 500", got)
+	}
+
+	if got := (&Share{}).Weight(maxTarget); got.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("Weight without target = %v, want 1", got)
+	}
+
+	// Shares are read from several goroutines; run under -race.
+	shared := &Share{ShareTarget: new(big.Int).Div(maxTarget, big.NewInt(1000))}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := shared.Weight(maxTarget); got.Cmp(big.NewInt(1000)) != 0 {
+				t.Errorf("concurrent Weight = %v, want 1000", got)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkShareHeader_Hash(b *testing.B) {
+	h := ShareHeader{Version: 0x20000000, Timestamp: 1700000000, Bits: 0x1d00ffff}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.Nonce = uint32(i)
+		h.Hash()
+	}
+}
@@ -0,0 +1,127 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// adminConnectTimeout bounds the dial made by /admin/connect.
+const adminConnectTimeout = 15 * time.Second
+
+// ErrBadRequest marks admin operation errors caused by the request itself,
+// such as an unparseable address. They are answered with 400 rather than
+// 502.
+var ErrBadRequest = errors.New("bad request")
+
+// Admin holds the node operations exposed under /admin/.
+type Admin struct {
+	// ConnectPeer dials a peer multiaddr and, if sync is set, starts a
+	// share sync from it.
+	ConnectPeer func(ctx context.Context, addr string, sync bool) error
+
+	// Token, if set, is required of every request as
+	// "Authorization: Bearer <token>", local or not. Without it only
+	// loopback clients are served.
+	Token string
+}
+
+// NewAdminHandler creates the handler for /admin/ endpoints.
+//
+// POST /admin/connect with {"addr": "<multiaddr>/p2p/<id>", "sync": true}
+// dials a peer at runtime. sync defaults to true.
+//
+// Loopback alone doesn't prove the request came from the operator: a web
+// page open in a local browser can post to localhost too. So bodies must
+// be sent as application/json, which a cross-site page can't do without
+// a CORS preflight we never answer, and a browser request from another
+// origin is refused outright.
+func NewAdminHandler(admin Admin) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/admin/connect", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeAdminError(w, http.StatusMethodNotAllowed, "POST required")
+			return
+		}
+		if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+			writeAdminError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+			return
+		}
+		var req struct {
+			Addr string `json:"addr"`
+			Sync *bool  `json:"sync"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.Addr == "" {
+			writeAdminError(w, http.StatusBadRequest, `body must be {"addr": "<multiaddr>"}`)
+			return
+		}
+		sync := req.Sync == nil || *req.Sync
+
+		ctx, cancel := context.WithTimeout(r.Context(), adminConnectTimeout)
+		defer cancel()
+		if err := admin.ConnectPeer(ctx, req.Addr, sync); err != nil {
+			status := http.StatusBadGateway
+			if errors.Is(err, ErrBadRequest) {
+				status = http.StatusBadRequest
+			}
+			writeAdminError(w, status, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "connected"})
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !sameOrigin(r) {
+			writeAdminError(w, http.StatusForbidden, "cross-origin admin requests are not allowed")
+			return
+		}
+		if !adminAllowed(r, admin.Token) {
+			writeAdminError(w, http.StatusForbidden, "admin API requires a valid token, or a localhost client when no token is set")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminAllowed checks the token when one is configured, and otherwise
+// admits only loopback clients.
+func adminAllowed(r *http.Request, token string) bool {
+	if token != "" {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// sameOrigin reports whether a request carries no Origin, as from curl,
+// or one matching the host it was sent to.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
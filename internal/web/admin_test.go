@@ -0,0 +1,133 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func adminRequest(remote, token, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/admin/connect", strings.NewReader(body))
+	r.RemoteAddr = remote
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestAdminConnect(t *testing.T) {
+	var gotAddr string
+	var gotSync bool
+	h := NewAdminHandler(Admin{
+		ConnectPeer: func(ctx context.Context, addr string, sync bool) error {
+			gotAddr, gotSync = addr, sync
+			switch addr {
+			case "bad":
+				return fmt.Errorf("%w: unparseable", ErrBadRequest)
+			case "/ip4/192.0.2.1/tcp/9171/p2p/unreachable":
+				return errors.New("dial: timeout")
+			}
+			return nil
+		},
+	})
+
+	tests := []struct {
+		name   string
+		remote string
+		body   string
+		status int
+	}{
+		{"connect", "127.0.0.1:5000", `{"addr":"/ip4/192.0.2.1/tcp/9171/p2p/ok"}`, http.StatusOK},
+		{"ipv6 loopback", "[::1]:5000", `{"addr":"/ip4/192.0.2.1/tcp/9171/p2p/ok"}`, http.StatusOK},
+		{"remote client", "192.0.2.9:5000", `{"addr":"/ip4/192.0.2.1/tcp/9171/p2p/ok"}`, http.StatusForbidden},
+		{"bad address", "127.0.0.1:5000", `{"addr":"bad"}`, http.StatusBadRequest},
+		{"unreachable", "127.0.0.1:5000", `{"addr":"/ip4/192.0.2.1/tcp/9171/p2p/unreachable"}`, http.StatusBadGateway},
+		{"missing addr", "127.0.0.1:5000", `{}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, adminRequest(tt.remote, "", tt.body))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d (%s)", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, adminRequest("127.0.0.1:5000", "", `{"addr":"/ip4/192.0.2.1/tcp/9171/p2p/ok","sync":false}`))
+	if rec.Code != http.StatusOK || gotAddr != "/ip4/192.0.2.1/tcp/9171/p2p/ok" || gotSync {
+		t.Errorf("sync=false not passed through: status %d, addr %q, sync %v", rec.Code, gotAddr, gotSync)
+	}
+
+	rec = httptest.NewRecorder()
+	get := httptest.NewRequest(http.MethodGet, "/admin/connect", nil)
+	get.RemoteAddr = "127.0.0.1:5000"
+	h.ServeHTTP(rec, get)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", rec.Code)
+	}
+}
+
+func TestAdminRejectsBrowserRequests(t *testing.T) {
+	called := false
+	h := NewAdminHandler(Admin{
+		ConnectPeer: func(context.Context, string, bool) error { called = true; return nil },
+	})
+	body := `{"addr":"/ip4/192.0.2.1/tcp/9171/p2p/ok"}`
+
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		origin      string
+		status      int
+	}{
+		{"form post", "text/plain", "", http.StatusUnsupportedMediaType},
+		{"no content type", "", "", http.StatusUnsupportedMediaType},
+		{"cross-origin", "application/json", "http://evil.example", http.StatusForbidden},
+		{"same origin", "application/json; charset=utf-8", "http://example.com", http.StatusOK},
+	} {
+		called = false
+		r := adminRequest("127.0.0.1:5000", "", body)
+		r.Header.Set("Content-Type", tt.contentType)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if called != (tt.status == http.StatusOK) {
+			t.Errorf("%s: ConnectPeer called = %v", tt.name, called)
+		}
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	h := NewAdminHandler(Admin{
+		ConnectPeer: func(context.Context, string, bool) error { return nil },
+		Token:       "s3cret",
+	})
+	body := `{"addr":"/ip4/192.0.2.1/tcp/9171/p2p/ok"}`
+
+	for _, tt := range []struct {
+		name   string
+		remote string
+		token  string
+		status int
+	}{
+		{"remote with token", "192.0.2.9:5000", "s3cret", http.StatusOK},
+		{"remote wrong token", "192.0.2.9:5000", "nope", http.StatusForbidden},
+		{"local without token", "127.0.0.1:5000", "", http.StatusForbidden},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, adminRequest(tt.remote, tt.token, body))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+		}
+	}
+}
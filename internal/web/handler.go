@@ -47,6 +47,32 @@ type StatusData struct {
 	Miners             []MinerStat         `json:"miners"`
 }
 
+// StatsData is the compact node summary served at /stats for tools and
+// front-ends that don't need the full dashboard payload.
+type StatsData struct {
+	Height        int64        `json:"height"`
+	TipHash       string       `json:"tip_hash"`
+	Difficulty    float64      `json:"difficulty"`
+	Miners        int          `json:"miners"`
+	Peers         int          `json:"peers"`
+	PoolHashrate  float64      `json:"pool_hashrate"`
+	LocalHashrate float64      `json:"local_hashrate"`
+	BlocksFound   int          `json:"blocks_found"`
+	Payouts       []PayoutInfo `json:"payouts"`
+	RecentBlocks  []BlockInfo  `json:"recent_blocks"`
+	PeerDetails   []PeerInfo   `json:"peer_details"`
+}
+
+// BlockInfo describes a block found by the pool and what its coinbase paid.
+type BlockInfo struct {
+	Hash    string       `json:"hash"`
+	Height  int64        `json:"height"`
+	Reward  int64        `json:"reward"`
+	Finder  string       `json:"finder"`
+	FoundAt int64        `json:"found_at"`
+	Payouts []PayoutInfo `json:"payouts"`
+}
+
 // PayoutInfo describes a single payout output for the dashboard.
 type PayoutInfo struct {
 	Address string  `json:"address"`
@@ -82,11 +108,18 @@ type MinerStat struct {
 	ConnectedSecs int64   `json:"connected_secs"`
 }
 
-// PeerInfo describes a connected peer for the dashboard.
+// PeerInfo describes a connected peer for the dashboard and /stats.
 type PeerInfo struct {
-	ID      string `json:"id"`
-	Latency int64  `json:"latency_us"`
-	Address string `json:"address"`
+	ID          string   `json:"id"`
+	Latency     int64    `json:"latency_us"`
+	Address     string   `json:"address"`
+	Direction   string   `json:"direction"`
+	ConnectedAt int64    `json:"connected_at"`
+	Agent       string   `json:"agent"`
+	Protocols   []string `json:"protocols"`
+	LastSync    int64    `json:"last_sync"` // unix seconds, 0 if never synced from
+	BytesIn     int64    `json:"bytes_in"`
+	BytesOut    int64    `json:"bytes_out"`
 }
 
 // HistoryPoint is a single data point for dashboard graphs.
@@ -124,7 +157,7 @@ type statusCache struct {
 
 const statusCacheTTL = 2 * time.Second
 
-func (c *statusCache) get(dataFunc func() *StatusData) []byte {
+func (c *statusCache) get(dataFunc func() any) []byte {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if time.Now().Before(c.expires) {
@@ -137,9 +170,10 @@ func (c *statusCache) get(dataFunc func() *StatusData) []byte {
 }
 
 // NewHandler creates an HTTP handler serving the dashboard and JSON API.
-func NewHandler(dataFunc func() *StatusData, shareLookup ShareLookupFunc) http.Handler {
+func NewHandler(dataFunc func() *StatusData, statsFunc func() *StatsData, shareLookup ShareLookupFunc) http.Handler {
 	mux := http.NewServeMux()
 	cache := &statusCache{}
+	statsCache := &statusCache{}
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
@@ -156,7 +190,13 @@ func NewHandler(dataFunc func() *StatusData, shareLookup ShareLookupFunc) http.H
 	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.Write(cache.get(dataFunc))
+		w.Write(cache.get(func() any { return dataFunc() }))
+	})
+
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write(statsCache.get(func() any { return statsFunc() }))
 	})
 
 	mux.HandleFunc("/api/share/", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,31 @@
+package web
+
+import "net/http"
+
+// NewHealthHandler creates the handler for the orchestration probes.
+//
+// GET /healthz answers 200 while the process is serving HTTP. GET /readyz
+// answers 200 if ready returns nil and 503 with its error otherwise, so a
+// load balancer stops routing miners to a node that can't give them work.
+// ready is called on every probe and must only read cached state.
+func NewHealthHandler(ready func() error) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := ready(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("not ready: " + err.Error() + "\n"))
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+
+	return mux
+}
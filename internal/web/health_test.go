@@ -0,0 +1,37 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	var readyErr error
+	h := NewHealthHandler(func() error { return readyErr })
+
+	probe := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := probe("/healthz"); w.Code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200", w.Code)
+	}
+	if w := probe("/readyz"); w.Code != http.StatusOK {
+		t.Errorf("/readyz = %d, want 200", w.Code)
+	}
+
+	readyErr = errors.New("no block template fetched yet")
+	w := probe("/readyz")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "no block template") {
+		t.Errorf("/readyz = %d %q, want 503 with the reason", w.Code, w.Body.String())
+	}
+	// Liveness doesn't depend on readiness.
+	if w := probe("/healthz"); w.Code != http.StatusOK {
+		t.Errorf("/healthz while not ready = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,99 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWindowPageSize = 100
+	maxWindowPageSize     = 1000
+)
+
+// WindowShare describes a share credited in the PPLNS window.
+type WindowShare struct {
+	Hash      string `json:"hash"`
+	Miner     string `json:"miner"`
+	Timestamp int64  `json:"timestamp"`
+	Weight    string `json:"weight"` // decimal; the share's difficulty, halved for uncles
+	Uncle     bool   `json:"uncle"`
+}
+
+// WindowPage is one page of the PPLNS window served at /stats/window.
+type WindowPage struct {
+	Total   int           `json:"total"` // entries matching the filter
+	Offset  int           `json:"offset"`
+	Limit   int           `json:"limit"`
+	Entries []WindowShare `json:"entries"`
+}
+
+// NewWindowHandler creates the handler for GET /stats/window, a paginated
+// view of the shares in the current PPLNS window so miners can check their
+// shares are credited. windowFunc returns the entries, newest first; its
+// result is cached briefly and must not be modified.
+//
+// Query parameters: offset (default 0), limit (default 100, at most 1000)
+// and miner, which keeps only that address's shares.
+func NewWindowHandler(windowFunc func() []WindowShare) http.Handler {
+	var (
+		mu      sync.Mutex
+		entries []WindowShare
+		expires time.Time
+	)
+	snapshot := func() []WindowShare {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Now().After(expires) {
+			entries = windowFunc()
+			expires = time.Now().Add(statusCacheTTL)
+		}
+		return entries
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		query := r.URL.Query()
+		offset, err := queryInt(query.Get("offset"), 0)
+		if err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid offset"})
+			return
+		}
+		limit, err := queryInt(query.Get("limit"), defaultWindowPageSize)
+		if err != nil || limit < 1 || limit > maxWindowPageSize {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "limit must be 1-1000"})
+			return
+		}
+
+		all := snapshot()
+		if miner := query.Get("miner"); miner != "" {
+			var matched []WindowShare
+			for _, e := range all {
+				if e.Miner == miner {
+					matched = append(matched, e)
+				}
+			}
+			all = matched
+		}
+
+		page := WindowPage{Total: len(all), Offset: offset, Limit: limit, Entries: []WindowShare{}}
+		if offset < len(all) {
+			page.Entries = all[offset:min(offset+limit, len(all))]
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+}
+
+// queryInt parses an integer query parameter, returning def if it is empty.
+func queryInt(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
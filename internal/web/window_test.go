@@ -0,0 +1,60 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWindowHandler(t *testing.T) {
+	var shares []WindowShare
+	for i := 0; i < 250; i++ {
+		miner := "miner1"
+		if i%5 == 0 {
+			miner = "miner2"
+		}
+		shares = append(shares, WindowShare{Hash: fmt.Sprintf("%064x", i), Miner: miner, Weight: "1"})
+	}
+	calls := 0
+	h := NewWindowHandler(func() []WindowShare { calls++; return shares })
+
+	get := func(query string) (int, WindowPage) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats/window"+query, nil))
+		var page WindowPage
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+				t.Fatalf("decode %q: %v", query, err)
+			}
+		}
+		return w.Code, page
+	}
+
+	code, page := get("")
+	if code != http.StatusOK || page.Total != 250 || len(page.Entries) != 100 || page.Entries[0].Hash != shares[0].Hash {
+		t.Errorf("default page = %d, total %d, %d entries", code, page.Total, len(page.Entries))
+	}
+	code, page = get("?offset=200&limit=100")
+	if code != http.StatusOK || len(page.Entries) != 50 || page.Entries[0].Hash != shares[200].Hash {
+		t.Errorf("last page = %d, %d entries", code, len(page.Entries))
+	}
+	code, page = get("?offset=300")
+	if code != http.StatusOK || page.Entries == nil || len(page.Entries) != 0 {
+		t.Errorf("page past the end = %d, %v", code, page.Entries)
+	}
+	code, page = get("?miner=miner2&limit=10")
+	if code != http.StatusOK || page.Total != 50 || len(page.Entries) != 10 || page.Entries[1].Hash != shares[5].Hash {
+		t.Errorf("miner filter = %d, total %d, %d entries", code, page.Total, len(page.Entries))
+	}
+	if calls != 1 {
+		t.Errorf("window computed %d times, want 1 (cached)", calls)
+	}
+
+	for _, query := range []string{"?offset=-1", "?offset=x", "?limit=0", "?limit=1001"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", query, code)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -22,6 +23,9 @@ const (
 	// JobRefreshInterval is how often to send a non-clean job refresh
 	// to keep miners connected and give them updated timestamps/transactions.
 	JobRefreshInterval = 30 * time.Second
+
+	// maxBackoff caps the delay between template fetch retries.
+	maxBackoff = 60 * time.Second
 )
 
 const maxStoredJobs = 20
@@ -31,12 +35,29 @@ type Generator struct {
 	rpc    bitcoin.BitcoinRPC
 	logger *zap.Logger
 
-	network        string
-	extranonceSize int
+	network          types.NetworkParams
+	extranonceSize   int
+	extranonceLayout types.ExtranonceLayout
+
+	// shareVersionFn and minerAddress select the sharechain commitment in
+	// the coinbase; see SetShareVersion.
+	shareVersionFn func(prevShareHash [32]byte) (uint32, int64)
+	minerAddress   string
 
+	// txFilter picks the template transactions jobs include; see
+	// SetTxFilter.
+	txFilter TxFilter
+
+	// currentTemplate is the template as fetched; jobTemplate is it with
+	// txFilter applied, which jobs are built from.
 	currentTemplate *bitcoin.BlockTemplate
+	jobTemplate     *bitcoin.BlockTemplate
 	templateMu      sync.RWMutex
 
+	// fetchFailingSince is when the current run of failed template
+	// fetches began, zero after a success. Guarded by templateMu.
+	fetchFailingSince time.Time
+
 	jobCounter atomic.Uint64
 	jobCh      chan *JobData
 
@@ -44,18 +65,32 @@ type Generator struct {
 	jobs   map[string]*JobData
 	jobsMu sync.RWMutex
 
-	payoutsFn       func() []types.PayoutEntry
+	payoutsFn       func(coinbaseValue int64) []types.PayoutEntry
 	prevShareHashFn func() [32]byte
+	unclesFn        func(prevShareHash [32]byte) [][32]byte
 
 	lastJobTime time.Time
+
+	// staleGrace is how long jobs superseded by a clean job remain valid.
+	staleGrace time.Duration
+
+	// quickJobTxs is how many transactions the job sent first on a new
+	// block carries, or -1 to send only the full job; see SetQuickJobTxs.
+	quickJobTxs int
+
+	// tipJobHash is the block a coinbase-only job was sent for ahead of
+	// its template, until that template is fetched; see pollTip. Only
+	// used by the poll loop.
+	tipJobHash string
 }
 
-// NewGenerator creates a new work generator.
+// NewGenerator creates a new work generator. payoutsFn returns the PPLNS
+// outputs for a coinbase paying coinbaseValue.
 func NewGenerator(
 	rpc bitcoin.BitcoinRPC,
-	network string,
+	network types.NetworkParams,
 	extranonceSize int,
-	payoutsFn func() []types.PayoutEntry,
+	payoutsFn func(coinbaseValue int64) []types.PayoutEntry,
 	prevShareHashFn func() [32]byte,
 	logger *zap.Logger,
 ) *Generator {
@@ -68,6 +103,7 @@ func NewGenerator(
 		jobs:            make(map[string]*JobData),
 		payoutsFn:       payoutsFn,
 		prevShareHashFn: prevShareHashFn,
+		quickJobTxs:     -1,
 	}
 }
 
@@ -88,18 +124,72 @@ func (g *Generator) CurrentTemplate() *bitcoin.BlockTemplate {
 	return g.currentTemplate
 }
 
+// JobTemplate returns the template jobs are built from: the current
+// template without the transactions the filter set by SetTxFilter excludes.
+// Payouts for a job must be computed from its coinbase value.
+func (g *Generator) JobTemplate() *bitcoin.BlockTemplate {
+	g.templateMu.RLock()
+	defer g.templateMu.RUnlock()
+	return g.jobTemplate
+}
+
+// TemplateHealth returns an error if no template has been fetched yet, or
+// if fetches have been failing for longer than the longest retry backoff.
+// It only reads cached state, so it is cheap enough for readiness probes.
+func (g *Generator) TemplateHealth() error {
+	g.templateMu.RLock()
+	defer g.templateMu.RUnlock()
+	if !g.fetchFailingSince.IsZero() {
+		if d := time.Since(g.fetchFailingSince); d > maxBackoff {
+			return fmt.Errorf("bitcoind template fetches failing for %s", d.Round(time.Second))
+		}
+	}
+	if g.currentTemplate == nil {
+		return fmt.Errorf("no block template fetched yet")
+	}
+	return nil
+}
+
 // GenerateJob creates a new job from the current template.
 func (g *Generator) GenerateJob() (*JobData, error) {
 	g.templateMu.RLock()
-	tmpl := g.currentTemplate
+	tmpl := g.jobTemplate
 	g.templateMu.RUnlock()
 
 	if tmpl == nil {
 		return nil, fmt.Errorf("no block template available")
 	}
+	return g.generateJob(tmpl)
+}
+
+// generateJob creates and stores a new job from tmpl.
+func (g *Generator) generateJob(tmpl *bitcoin.BlockTemplate) (*JobData, error) {
+	seq := g.jobCounter.Add(1)
+	job, err := g.buildJob(fmt.Sprintf("%x", seq), tmpl)
+	if err != nil {
+		return nil, err
+	}
+	job.Seq = seq
+	job.CreatedAt = time.Now()
+
+	g.storeJob(job)
+	return job, nil
+}
 
-	payouts := g.payoutsFn()
+// buildJob builds a job from tmpl paying the current PPLNS outputs.
+func (g *Generator) buildJob(jobID string, tmpl *bitcoin.BlockTemplate) (*JobData, error) {
+	payouts := g.payoutsFn(tmpl.CoinbaseValue)
 	prevShareHash := g.prevShareHashFn()
+	shareVersion := types.ShareVersion1
+	var shareHeight int64
+	if g.shareVersionFn != nil {
+		shareVersion, shareHeight = g.shareVersionFn(prevShareHash)
+	}
+	// Only version 2 shares may reference uncles.
+	var uncles [][32]byte
+	if g.unclesFn != nil && shareVersion >= types.ShareVersion2 {
+		uncles = g.unclesFn(prevShareHash)
+	}
 
 	// Convert template to internal format
 	tmplData := &types.BlockTemplateData{
@@ -112,26 +202,135 @@ func (g *Generator) GenerateJob() (*JobData, error) {
 		WitnessCommitment: tmpl.DefaultWitnessCommitment,
 		Network:           g.network,
 		TxHashes:          extractTxHashes(tmpl),
+		ExtranonceLayout:  g.extranonceLayout,
+		ShareVersion:      shareVersion,
+		MinerAddress:      g.minerAddress,
+		ShareHeight:       shareHeight,
 	}
 
-	seq := g.jobCounter.Add(1)
-	jobID := fmt.Sprintf("%x", seq)
-	job, err := BuildJobFromTemplate(jobID, tmplData, payouts, prevShareHash, g.extranonceSize)
+	job, err := BuildJobFromTemplate(jobID, tmplData, payouts, prevShareHash, uncles, g.extranonceSize)
 	if err != nil {
 		return nil, fmt.Errorf("build job: %w", err)
 	}
-	job.Seq = seq
 	job.Template = tmpl
-
-	g.storeJob(job)
 	return job, nil
 }
 
-// GetJob returns a stored job by ID, or nil if not found.
+// SelfCheck builds a job from tmpl and reconstructs the block a miner
+// would produce, checking it against the template. It catches coinbase,
+// merkle and byte-order regressions at startup rather than when bitcoind
+// rejects a block we found. The job is not issued to miners.
+func (g *Generator) SelfCheck(tmpl *bitcoin.BlockTemplate) error {
+	tmpl, err := g.filterTemplate(tmpl)
+	if err != nil {
+		return err
+	}
+	job, err := g.buildJob("selfcheck", tmpl)
+	if err != nil {
+		return err
+	}
+	return CheckReconstruction(job, g.extranonceSize)
+}
+
+// SetStaleGrace sets how long jobs superseded by a clean job keep accepting
+// submissions. The default of zero rejects them as soon as the clean job is
+// issued.
+func (g *Generator) SetStaleGrace(d time.Duration) {
+	g.jobsMu.Lock()
+	defer g.jobsMu.Unlock()
+	g.staleGrace = d
+}
+
+// SetQuickJobTxs makes the generator answer a new block with a quick job
+// before the job with the full template. Each poll first asks bitcoind for
+// its best block, and a new one gets a coinbase-only job at once, built
+// from the previous template; otherwise the quick job carries the n
+// template transactions paying the highest fee rate, 0 for a coinbase-only
+// block. Miners then leave the stale tip as soon as possible, and shares
+// for the quick job stay valid after the full job arrives. A negative n,
+// the default, sends only the full job. It must be called before Start.
+func (g *Generator) SetQuickJobTxs(n int) {
+	g.quickJobTxs = n
+}
+
+// SetExtranonceLayout sets where jobs place the extranonce in the coinbase
+// scriptSig. It must be called before Start.
+func (g *Generator) SetExtranonceLayout(layout types.ExtranonceLayout) error {
+	if err := layout.Validate(); err != nil {
+		return err
+	}
+	g.extranonceLayout = layout
+	return nil
+}
+
+// SetShareVersion sets the callback picking the version and height of
+// shares built on a given sharechain parent, and the miner address
+// committed in the coinbase where the version requires it. Without it jobs
+// build types.ShareVersion1 shares. It must be called before Start.
+func (g *Generator) SetShareVersion(fn func(prevShareHash [32]byte) (uint32, int64), minerAddress string) {
+	g.shareVersionFn = fn
+	g.minerAddress = minerAddress
+}
+
+// SetTxFilter sets the filter deciding which template transactions jobs
+// include, for operators excluding transactions by policy or to stay under
+// a size limit. Transactions spending outputs of excluded ones are excluded
+// too; see FilterTemplate. Shares from peers are still checked against the
+// unfiltered template. It must be called before Start.
+func (g *Generator) SetTxFilter(filter TxFilter) {
+	g.txFilter = filter
+}
+
+// filterTemplate applies the transaction filter, if any, to tmpl.
+func (g *Generator) filterTemplate(tmpl *bitcoin.BlockTemplate) (*bitcoin.BlockTemplate, error) {
+	if g.txFilter == nil {
+		return tmpl, nil
+	}
+	filtered, removed, err := FilterTemplate(tmpl, g.txFilter)
+	if err != nil {
+		return nil, fmt.Errorf("filter template transactions: %w", err)
+	}
+	if removed > 0 {
+		g.logger.Debug("excluded template transactions",
+			zap.Int("excluded", removed),
+			zap.Int64("fees", tmpl.CoinbaseValue-filtered.CoinbaseValue),
+		)
+	}
+	return filtered, nil
+}
+
+// SetUnclesFunc sets the callback used to pick uncle shares to commit to
+// alongside the sharechain parent. It is only consulted for version 2
+// shares; see SetShareVersion. It must be called before Start.
+func (g *Generator) SetUnclesFunc(fn func(prevShareHash [32]byte) [][32]byte) {
+	g.unclesFn = fn
+}
+
+// GetJob returns a stored job by ID, or nil if not found or if the job was
+// superseded by a clean job longer than the stale grace period ago.
 func (g *Generator) GetJob(id string) *JobData {
 	g.jobsMu.RLock()
 	defer g.jobsMu.RUnlock()
-	return g.jobs[id]
+	job := g.jobs[id]
+	if job != nil && job.Stale && time.Since(job.StaleAt) >= g.staleGrace {
+		return nil
+	}
+	return job
+}
+
+// markStale flags every stored job except keepID as stale. Jobs stay in the
+// history so they can be reported, but GetJob stops returning them once the
+// grace period expires.
+func (g *Generator) markStale(keepID string) {
+	g.jobsMu.Lock()
+	defer g.jobsMu.Unlock()
+	now := time.Now()
+	for id, j := range g.jobs {
+		if id != keepID && !j.Stale {
+			j.Stale = true
+			j.StaleAt = now
+		}
+	}
 }
 
 func (g *Generator) storeJob(job *JobData) {
@@ -206,15 +405,30 @@ func backoffDuration(failures int) time.Duration {
 	d := PollInterval
 	for i := 1; i < failures; i++ {
 		d *= 2
-		if d > 60*time.Second {
-			return 60 * time.Second
+		if d > maxBackoff {
+			return maxBackoff
 		}
 	}
 	return d
 }
 
 func (g *Generator) fetchTemplate(ctx context.Context) error {
+	var best string
+	if g.quickJobTxs >= 0 {
+		best = g.pollTip(ctx)
+	}
+
 	tmpl, err := g.rpc.GetBlockTemplate(ctx)
+	if err != nil {
+		g.templateMu.Lock()
+		if g.fetchFailingSince.IsZero() {
+			g.fetchFailingSince = time.Now()
+		}
+		g.templateMu.Unlock()
+		return err
+	}
+
+	jobTmpl, err := g.filterTemplate(tmpl)
 	if err != nil {
 		return err
 	}
@@ -222,9 +436,15 @@ func (g *Generator) fetchTemplate(ctx context.Context) error {
 	g.templateMu.Lock()
 	oldTemplate := g.currentTemplate
 	g.currentTemplate = tmpl
+	g.jobTemplate = jobTmpl
+	g.fetchFailingSince = time.Time{}
 	g.templateMu.Unlock()
 
 	newBlock := oldTemplate == nil || tmpl.PreviousBlockHash != oldTemplate.PreviousBlockHash
+	// A soft fork activating changes the version bits miners must put in
+	// the header, so work on the old version has to be discarded too.
+	rulesChanged := !newBlock &&
+		(tmpl.Version != oldTemplate.Version || !slices.Equal(tmpl.Rules, oldTemplate.Rules))
 
 	if newBlock {
 		g.logger.Info("new block template",
@@ -232,29 +452,141 @@ func (g *Generator) fetchTemplate(ctx context.Context) error {
 			zap.String("prevhash", tmpl.PreviousBlockHash[:16]+"..."),
 		)
 	}
+	if rulesChanged {
+		g.logger.Info("block template version or rules changed",
+			zap.String("old_version", fmt.Sprintf("%08x", uint32(oldTemplate.Version))),
+			zap.String("new_version", fmt.Sprintf("%08x", uint32(tmpl.Version))),
+			zap.Strings("old_rules", oldTemplate.Rules),
+			zap.Strings("new_rules", tmpl.Rules),
+		)
+	}
 
-	// Send a new job when: new block (clean), or periodic refresh to keep miners alive
-	needsRefresh := !newBlock && time.Since(g.lastJobTime) >= JobRefreshInterval
+	// Send a new job when: new block or rules (clean), or periodic refresh
+	// to keep miners alive
+	clean := newBlock || rulesChanged
+	needsRefresh := !clean && time.Since(g.lastJobTime) >= JobRefreshInterval
+
+	if g.tipJobHash != "" {
+		switch {
+		case tmpl.PreviousBlockHash == g.tipJobHash:
+			// Miners are already on this block's tip job: the full job
+			// follows as a refresh, leaving it valid.
+			clean = false
+			needsRefresh = true
+		case best != tmpl.PreviousBlockHash:
+			// bitcoind's template lags its tip; don't pull miners back.
+			return nil
+		default:
+			// The tip moved on again before its template was fetched.
+			clean = true
+		}
+		g.tipJobHash = ""
+	} else if newBlock && g.quickJobTxs >= 0 && len(jobTmpl.Transactions) > g.quickJobTxs {
+		// The full job then follows as a refresh, leaving the quick one
+		// valid.
+		if g.sendQuickJob(jobTmpl) {
+			clean = false
+			needsRefresh = true
+		}
+	}
 
-	if newBlock || needsRefresh {
+	if clean || needsRefresh {
 		job, err := g.GenerateJob()
 		if err != nil {
 			g.logger.Error("failed to generate job", zap.Error(err))
 			return nil
 		}
-		job.CleanJobs = newBlock
-
-		select {
-		case g.jobCh <- job:
-			g.lastJobTime = time.Now()
-		default:
-			g.logger.Warn("job channel full")
+		job.CleanJobs = clean
+		if clean {
+			g.markStale(job.ID)
 		}
+
+		g.sendJob(job)
 	}
 
 	return nil
 }
 
+// pollTip asks bitcoind for its best block and, if it is one the current
+// template doesn't build on yet, sends a clean coinbase-only job on it
+// without waiting for the template fetch. It returns the best block hash,
+// or "" if it couldn't be read.
+func (g *Generator) pollTip(ctx context.Context) string {
+	g.templateMu.RLock()
+	prev := g.jobTemplate
+	g.templateMu.RUnlock()
+	if prev == nil {
+		return ""
+	}
+
+	best, err := g.rpc.GetBestBlockHash(ctx)
+	if err != nil {
+		g.logger.Debug("failed to poll best block", zap.Error(err))
+		return ""
+	}
+	if best == prev.PreviousBlockHash || best == g.tipJobHash {
+		return best
+	}
+	block, err := g.rpc.GetBlock(ctx, best)
+	if err != nil {
+		g.logger.Debug("failed to fetch new tip", zap.String("hash", best), zap.Error(err))
+		return best
+	}
+	tmpl, err := TipTemplate(prev, block, g.network)
+	if err != nil {
+		g.logger.Debug("no tip job for new block", zap.String("hash", best), zap.Error(err))
+		return best
+	}
+	job, err := g.generateJob(tmpl)
+	if err != nil {
+		g.logger.Error("failed to generate tip job", zap.Error(err))
+		return best
+	}
+	job.CleanJobs = true
+	g.markStale(job.ID)
+	g.logger.Debug("sent tip job",
+		zap.String("job_id", job.ID),
+		zap.Int64("height", tmpl.Height),
+	)
+	g.sendJob(job)
+	g.tipJobHash = best
+	return best
+}
+
+// sendQuickJob sends a clean job built from the quick subset of tmpl,
+// reporting whether it was built.
+func (g *Generator) sendQuickJob(tmpl *bitcoin.BlockTemplate) bool {
+	quick, err := QuickTemplate(tmpl, g.quickJobTxs)
+	if err != nil {
+		g.logger.Error("failed to build quick template", zap.Error(err))
+		return false
+	}
+	job, err := g.generateJob(quick)
+	if err != nil {
+		g.logger.Error("failed to generate quick job", zap.Error(err))
+		return false
+	}
+	job.CleanJobs = true
+	g.markStale(job.ID)
+	g.logger.Debug("sent quick job",
+		zap.String("job_id", job.ID),
+		zap.Int("transactions", len(quick.Transactions)),
+		zap.Int("template_transactions", len(tmpl.Transactions)),
+	)
+	g.sendJob(job)
+	return true
+}
+
+// sendJob queues job for the stratum server without blocking.
+func (g *Generator) sendJob(job *JobData) {
+	select {
+	case g.jobCh <- job:
+		g.lastJobTime = time.Now()
+	default:
+		g.logger.Warn("job channel full")
+	}
+}
+
 func extractTxHashes(tmpl *bitcoin.BlockTemplate) []string {
 	hashes := make([]string, len(tmpl.Transactions))
 	for i, tx := range tmpl.Transactions {
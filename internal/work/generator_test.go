@@ -0,0 +1,240 @@
+package work
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/djkazic/p2pool-go/internal/bitcoin"
+	"github.com/djkazic/p2pool-go/internal/types"
+
+	"go.uber.org/zap"
+)
+
+func testGenerator(rpc bitcoin.BitcoinRPC) *Generator {
+	payouts := func(int64) []types.PayoutEntry {
+		return []types.PayoutEntry{{Address: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", Amount: 5000000000}}
+	}
+	prevShare := func() [32]byte { return [32]byte{} }
+	return NewGenerator(rpc, types.TestNet3Params, 8, payouts, prevShare, zap.NewNop())
+}
+
+// advanceBlock swaps in a template for the next block.
+func advanceBlock(rpc *bitcoin.MockRPC, prevHash string) {
+	tmpl := *rpc.BlockTemplate
+	tmpl.PreviousBlockHash = prevHash
+	tmpl.Height++
+	rpc.BlockTemplate = &tmpl
+}
+
+func TestGenerator_CleanJobMarksPreviousStale(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	g := testGenerator(rpc)
+	ctx := context.Background()
+
+	if err := g.fetchTemplate(ctx); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	first := <-g.jobCh
+
+	advanceBlock(rpc, "00000000000000000001aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	if err := g.fetchTemplate(ctx); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	second := <-g.jobCh
+
+	if !second.CleanJobs {
+		t.Fatal("expected clean job for new block")
+	}
+	if g.GetJob(first.ID) != nil {
+		t.Error("superseded job should not be returned with zero grace")
+	}
+	if g.GetJob(second.ID) == nil {
+		t.Error("current job should be returned")
+	}
+
+	g.SetStaleGrace(time.Minute)
+	if g.GetJob(first.ID) == nil {
+		t.Error("superseded job should be returned within grace period")
+	}
+}
+
+func TestGenerator_VersionChangeSendsCleanJob(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	g := testGenerator(rpc)
+	ctx := context.Background()
+
+	if err := g.fetchTemplate(ctx); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	first := <-g.jobCh
+
+	// Same block, but a deployment went active.
+	tmpl := *rpc.BlockTemplate
+	tmpl.Version = 0x20000004
+	rpc.BlockTemplate = &tmpl
+	if err := g.fetchTemplate(ctx); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	var second *JobData
+	select {
+	case second = <-g.jobCh:
+	default:
+		t.Fatal("expected a job for the new template version")
+	}
+	if !second.CleanJobs {
+		t.Error("expected clean job for a version change")
+	}
+	if second.Version != "20000004" {
+		t.Errorf("job version = %s, want 20000004", second.Version)
+	}
+	if g.GetJob(first.ID) != nil {
+		t.Error("job with the old version should be stale")
+	}
+
+	// Rules changing is also a clean job; an unchanged template is not.
+	tmpl2 := tmpl
+	tmpl2.Rules = []string{"csv", "!segwit", "taproot"}
+	rpc.BlockTemplate = &tmpl2
+	if err := g.fetchTemplate(ctx); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	select {
+	case job := <-g.jobCh:
+		if !job.CleanJobs {
+			t.Error("expected clean job for a rules change")
+		}
+	default:
+		t.Fatal("expected a job for the new rules")
+	}
+	if err := g.fetchTemplate(ctx); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	select {
+	case job := <-g.jobCh:
+		t.Errorf("unexpected job %s for an unchanged template", job.ID)
+	default:
+	}
+}
+
+func TestGenerator_RefreshKeepsHistory(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	g := testGenerator(rpc)
+	ctx := context.Background()
+
+	if err := g.fetchTemplate(ctx); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	first := <-g.jobCh
+
+	// Force a non-clean refresh on the same block
+	g.lastJobTime = time.Now().Add(-JobRefreshInterval)
+	if err := g.fetchTemplate(ctx); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	refresh := <-g.jobCh
+
+	if refresh.CleanJobs {
+		t.Fatal("refresh job should not be clean")
+	}
+	if g.GetJob(first.ID) == nil {
+		t.Error("job from the same block should remain valid after a refresh")
+	}
+}
+
+func TestGenerator_JobCreatedAt(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	g := testGenerator(rpc)
+
+	before := time.Now()
+	if err := g.fetchTemplate(context.Background()); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	job := <-g.jobCh
+	if job.CreatedAt.Before(before) || job.CreatedAt.After(time.Now()) {
+		t.Errorf("CreatedAt = %v, want between %v and now", job.CreatedAt, before)
+	}
+}
+
+func TestGenerator_ShareVersion(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	g := testGenerator(rpc)
+	const miner = "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"
+
+	if err := g.fetchTemplate(context.Background()); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	job := <-g.jobCh
+	if job.ShareVersion != types.ShareVersion1 {
+		t.Errorf("default share version = %d, want 1", job.ShareVersion)
+	}
+
+	g.SetShareVersion(func([32]byte) (uint32, int64) { return types.ShareVersion2, 42 }, miner)
+	job, err := g.GenerateJob()
+	if err != nil {
+		t.Fatalf("GenerateJob: %v", err)
+	}
+	committed, err := types.ExtractShareCommitment(job.CoinbaseTx)
+	if err != nil {
+		t.Fatalf("ExtractShareCommitment: %v", err)
+	}
+	if job.ShareVersion != types.ShareVersion2 || job.ShareHeight != 42 {
+		t.Errorf("job share version, height = %d, %d; want 2, 42", job.ShareVersion, job.ShareHeight)
+	}
+	if committed != types.VersionedCommitmentHash(types.ShareVersion2, job.PrevShareHash, job.Uncles, miner, 42) {
+		t.Error("coinbase doesn't commit to the miner and height")
+	}
+}
+
+func TestGenerator_SelfCheck(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	rpc.BlockTemplate.Transactions = largeTemplate(5).Transactions
+	g := testGenerator(rpc)
+
+	if err := g.SelfCheck(rpc.BlockTemplate); err != nil {
+		t.Fatalf("SelfCheck: %v", err)
+	}
+	if g.CurrentTemplate() != nil {
+		t.Error("SelfCheck should not install the template")
+	}
+}
+
+func TestGenerator_TemplateHealth(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	g := testGenerator(rpc)
+
+	if err := g.TemplateHealth(); err == nil {
+		t.Error("expected not healthy before the first template")
+	}
+	if err := g.fetchTemplate(context.Background()); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	if err := g.TemplateHealth(); err != nil {
+		t.Errorf("TemplateHealth after fetch: %v", err)
+	}
+
+	// A failure within the retry backoff keeps the node ready.
+	rpc.GetBlockTemplateErr = errors.New("connection refused")
+	if err := g.fetchTemplate(context.Background()); err == nil {
+		t.Fatal("expected fetch error")
+	}
+	if err := g.TemplateHealth(); err != nil {
+		t.Errorf("TemplateHealth after one failure: %v", err)
+	}
+
+	// Failing beyond it does not, until a fetch succeeds again.
+	g.templateMu.Lock()
+	g.fetchFailingSince = time.Now().Add(-2 * maxBackoff)
+	g.templateMu.Unlock()
+	if err := g.TemplateHealth(); err == nil {
+		t.Error("expected not healthy after prolonged failures")
+	}
+	rpc.GetBlockTemplateErr = nil
+	if err := g.fetchTemplate(context.Background()); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	if err := g.TemplateHealth(); err != nil {
+		t.Errorf("TemplateHealth after recovery: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package work
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/djkazic/p2pool-go/internal/bitcoin"
+	"github.com/djkazic/p2pool-go/internal/types"
+)
+
+// difficultyAdjustmentInterval is how many blocks share a difficulty.
+const difficultyAdjustmentInterval = 2016
+
+// QuickTemplate returns tmpl keeping only its n transactions paying the
+// highest fee rate, for the job sent the moment a new block arrives: miners
+// switch to the new tip without waiting on a job committing to the whole
+// mempool. n = 0 keeps none, leaving a coinbase-only block. A kept
+// transaction spending one that did not make the cut is dropped as well, so
+// fewer than n may remain. The coinbase value and witness commitment are
+// adjusted as in FilterTemplate.
+func QuickTemplate(tmpl *bitcoin.BlockTemplate, n int) (*bitcoin.BlockTemplate, error) {
+	if n >= len(tmpl.Transactions) {
+		return tmpl, nil
+	}
+	order := make([]int, len(tmpl.Transactions))
+	for i := range order {
+		order[i] = i
+	}
+	// Compare Fee/Weight by cross-multiplying. Ties keep template order,
+	// which bitcoind sorts by fee rate.
+	slices.SortStableFunc(order, func(a, b int) int {
+		ta, tb := tmpl.Transactions[a], tmpl.Transactions[b]
+		return cmp.Compare(tb.Fee*int64(max(ta.Weight, 1)), ta.Fee*int64(max(tb.Weight, 1)))
+	})
+	keep := make(map[string]bool, n)
+	for _, i := range order[:n] {
+		keep[tmpl.Transactions[i].TxID] = true
+	}
+
+	quick, _, err := FilterTemplate(tmpl, func(tx bitcoin.TemplateTransaction) bool {
+		return keep[tx.TxID]
+	})
+	return quick, err
+}
+
+// TipTemplate derives a coinbase-only template building on tip from prev,
+// the last template fetched, so a job on a new block can go out before
+// bitcoind has assembled its template for it. prev's transactions may be
+// in tip, so none are carried over, and the coinbase claims the subsidy
+// alone. The difficulty is taken from prev, so a tip starting a new
+// difficulty period is refused; test networks' minimum-difficulty blocks
+// can still make it wrong, costing only a block found in the meantime.
+func TipTemplate(prev *bitcoin.BlockTemplate, tip *bitcoin.BlockInfo, network types.NetworkParams) (*bitcoin.BlockTemplate, error) {
+	height := tip.Height + 1
+	if height/difficultyAdjustmentInterval != prev.Height/difficultyAdjustmentInterval {
+		return nil, fmt.Errorf("block %d is in another difficulty period than the template's %d", height, prev.Height)
+	}
+
+	tmpl := prev.WithTransactions(nil)
+	tmpl.PreviousBlockHash = tip.Hash
+	tmpl.Height = height
+	tmpl.CoinbaseValue = network.BlockSubsidy(height)
+	tmpl.CurTime = max(prev.CurTime, tip.Time+1)
+	if prev.DefaultWitnessCommitment != "" {
+		commitment, err := witnessCommitment(nil)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.DefaultWitnessCommitment = commitment
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,220 @@
+package work
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/djkazic/p2pool-go/internal/bitcoin"
+	"github.com/djkazic/p2pool-go/internal/types"
+
+	"go.uber.org/zap"
+)
+
+// quickTestTemplate returns the mock template with five transactions paying
+// fees of 100, 500, 300, 400 and 200 sats; the fourth spends the first. The
+// 500 sat one weighs 5000, the rest 1000, so its fee rate ranks fourth.
+func quickTestTemplate() *bitcoin.BlockTemplate {
+	tmpl := *bitcoin.NewMockRPC().BlockTemplate
+	tmpl.Transactions = largeTemplate(5).Transactions
+	for i, fee := range []int64{100, 500, 300, 400, 200} {
+		tx := &tmpl.Transactions[i]
+		tx.Hash = tx.TxID
+		tx.Fee = fee
+		tx.Weight = 1000
+		if fee == 500 {
+			tx.Weight = 5000
+		}
+		tmpl.CoinbaseValue += fee
+	}
+	tmpl.Transactions[3].Depends = []int{1}
+	return &tmpl
+}
+
+func TestQuickTemplate(t *testing.T) {
+	tmpl := quickTestTemplate()
+	txs := tmpl.Transactions
+
+	quick, err := QuickTemplate(tmpl, 2)
+	if err != nil {
+		t.Fatalf("QuickTemplate: %v", err)
+	}
+	// The 400 sat transaction ranks first but spends one left out.
+	if len(quick.Transactions) != 1 || quick.Transactions[0].TxID != txs[2].TxID {
+		t.Fatalf("kept %d transactions, want only the 300 sat one", len(quick.Transactions))
+	}
+	if quick.CoinbaseValue != tmpl.CoinbaseValue-1200 {
+		t.Errorf("coinbase value = %d, want %d", quick.CoinbaseValue, tmpl.CoinbaseValue-1200)
+	}
+
+	empty, err := QuickTemplate(tmpl, 0)
+	if err != nil {
+		t.Fatalf("QuickTemplate: %v", err)
+	}
+	if len(empty.Transactions) != 0 || empty.CoinbaseValue != tmpl.CoinbaseValue-1500 {
+		t.Errorf("coinbase-only template has %d transactions, value %d", len(empty.Transactions), empty.CoinbaseValue)
+	}
+
+	if all, _ := QuickTemplate(tmpl, 5); all != tmpl {
+		t.Error("template with no more than n transactions should be returned as is")
+	}
+	if len(tmpl.Transactions) != 5 {
+		t.Error("QuickTemplate modified the template")
+	}
+}
+
+func TestGenerator_QuickJob(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	rpc.BlockTemplate = quickTestTemplate()
+	rpc.BlockTemplate.Transactions[3].Depends = nil
+	full := rpc.BlockTemplate
+
+	var coinbaseValues []int64
+	payouts := func(coinbaseValue int64) []types.PayoutEntry {
+		coinbaseValues = append(coinbaseValues, coinbaseValue)
+		return []types.PayoutEntry{{Address: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", Amount: coinbaseValue}}
+	}
+	g := NewGenerator(rpc, types.TestNet3Params, 8, payouts, func() [32]byte { return [32]byte{} }, zap.NewNop())
+	g.SetQuickJobTxs(3)
+
+	if err := g.fetchTemplate(context.Background()); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	quick := <-g.JobChannel()
+	fullJob := <-g.JobChannel()
+
+	if !quick.CleanJobs || len(quick.Template.Transactions) != 3 {
+		t.Fatalf("quick job: clean = %v, %d transactions; want clean with 3", quick.CleanJobs, len(quick.Template.Transactions))
+	}
+	for _, tx := range quick.Template.Transactions {
+		if tx.Fee == 100 || tx.Fee == 500 {
+			t.Errorf("quick job includes the %d sat transaction, whose fee rate is among the lowest", tx.Fee)
+		}
+	}
+	if err := CheckReconstruction(quick, 8); err != nil {
+		t.Errorf("quick job CheckReconstruction: %v", err)
+	}
+
+	if fullJob.CleanJobs || fullJob.Template != full {
+		t.Fatal("full job should follow without clean_jobs, from the full template")
+	}
+	if err := CheckReconstruction(fullJob, 8); err != nil {
+		t.Errorf("full job CheckReconstruction: %v", err)
+	}
+	if g.GetJob(quick.ID) == nil {
+		t.Error("quick job should stay valid after the full job")
+	}
+	want := []int64{full.CoinbaseValue - 600, full.CoinbaseValue}
+	if len(coinbaseValues) != 2 || coinbaseValues[0] != want[0] || coinbaseValues[1] != want[1] {
+		t.Errorf("payouts computed for %v, want %v", coinbaseValues, want)
+	}
+
+	// Without a new block only refreshes are sent.
+	if err := g.fetchTemplate(context.Background()); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	select {
+	case job := <-g.JobChannel():
+		t.Errorf("unexpected job %s for the same block", job.ID)
+	default:
+	}
+}
+
+func TestTipTemplate(t *testing.T) {
+	prev := quickTestTemplate()
+	prev.DefaultWitnessCommitment = "6a24aa21a9ed" + strings.Repeat("00", 32)
+	tip := &bitcoin.BlockInfo{Hash: strings.Repeat("ab", 32), Height: prev.Height, Time: prev.CurTime + 600}
+
+	tmpl, err := TipTemplate(prev, tip, types.MainNetParams)
+	if err != nil {
+		t.Fatalf("TipTemplate: %v", err)
+	}
+	if tmpl.PreviousBlockHash != tip.Hash || tmpl.Height != tip.Height+1 || len(tmpl.Transactions) != 0 {
+		t.Errorf("template builds on %s at %d with %d transactions", tmpl.PreviousBlockHash, tmpl.Height, len(tmpl.Transactions))
+	}
+	if tmpl.CoinbaseValue != 625000000 {
+		t.Errorf("coinbase value = %d, want the 6.25 BTC subsidy alone", tmpl.CoinbaseValue)
+	}
+	if want, _ := witnessCommitment(nil); tmpl.DefaultWitnessCommitment != want {
+		t.Errorf("witness commitment = %s, want %s", tmpl.DefaultWitnessCommitment, want)
+	}
+	if tmpl.CurTime != tip.Time+1 || tmpl.Bits != prev.Bits {
+		t.Errorf("curtime %d bits %s", tmpl.CurTime, tmpl.Bits)
+	}
+	if len(prev.Transactions) != 5 || prev.PreviousBlockHash == tip.Hash {
+		t.Error("TipTemplate modified the previous template")
+	}
+
+	// The difficulty may change with the next block.
+	tip.Height = 806399
+	if _, err := TipTemplate(prev, tip, types.MainNetParams); err == nil {
+		t.Error("expected refusal for a tip starting a new difficulty period")
+	}
+}
+
+func TestGenerator_TipJob(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	rpc.BlockTemplate = quickTestTemplate()
+	rpc.BlockTemplate.Transactions[3].Depends = nil
+	payouts := func(coinbaseValue int64) []types.PayoutEntry {
+		return []types.PayoutEntry{{Address: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", Amount: coinbaseValue}}
+	}
+	g := NewGenerator(rpc, types.TestNet3Params, 8, payouts, func() [32]byte { return [32]byte{} }, zap.NewNop())
+	g.SetQuickJobTxs(3)
+
+	if err := g.fetchTemplate(context.Background()); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	<-g.JobChannel()
+	<-g.JobChannel()
+
+	// bitcoind has a new tip but still serves the old template.
+	tipHash := strings.Repeat("ab", 32)
+	rpc.BestBlockHash = tipHash
+	rpc.SetBlock(tipHash, &bitcoin.BlockInfo{Hash: tipHash, Height: 800000, Time: 1700000600})
+	if err := g.fetchTemplate(context.Background()); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	tipJob := <-g.JobChannel()
+	if !tipJob.CleanJobs || tipJob.Template.PreviousBlockHash != tipHash || len(tipJob.Template.Transactions) != 0 {
+		t.Fatalf("tip job: clean = %v, prev %s, %d transactions", tipJob.CleanJobs, tipJob.Template.PreviousBlockHash, len(tipJob.Template.Transactions))
+	}
+	if tipJob.Template.CoinbaseValue != types.TestNet3Params.BlockSubsidy(800001) {
+		t.Errorf("tip job coinbase value = %d", tipJob.Template.CoinbaseValue)
+	}
+	if err := CheckReconstruction(tipJob, 8); err != nil {
+		t.Errorf("tip job CheckReconstruction: %v", err)
+	}
+
+	// The lagging template must not pull miners back to the old tip.
+	if err := g.fetchTemplate(context.Background()); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	select {
+	case job := <-g.JobChannel():
+		t.Fatalf("unexpected job %s from the stale template", job.ID)
+	default:
+	}
+
+	// Its template arrives: the full job follows without clean_jobs.
+	next := quickTestTemplate()
+	next.Transactions[3].Depends = nil
+	next.PreviousBlockHash = tipHash
+	next.Height = 800001
+	rpc.BlockTemplate = next
+	if err := g.fetchTemplate(context.Background()); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	fullJob := <-g.JobChannel()
+	if fullJob.CleanJobs || fullJob.Template != next {
+		t.Fatal("full job should follow the tip job without clean_jobs")
+	}
+	if g.GetJob(tipJob.ID) == nil {
+		t.Error("tip job should stay valid after the full job")
+	}
+	select {
+	case job := <-g.JobChannel():
+		t.Errorf("unexpected extra job %s", job.ID)
+	default:
+	}
+}
@@ -2,8 +2,10 @@ package work
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/djkazic/p2pool-go/internal/bitcoin"
 	"github.com/djkazic/p2pool-go/internal/types"
@@ -13,9 +15,15 @@ import (
 // SplitCoinbase splits a coinbase transaction at the extranonce position.
 // Returns coinbase1 (hex before extranonce) and coinbase2 (hex after extranonce).
 func SplitCoinbase(coinbaseTx []byte, extranonceOffset int, extranonceSize int) (string, string) {
-	coinbase1 := hex.EncodeToString(coinbaseTx[:extranonceOffset])
-	coinbase2 := hex.EncodeToString(coinbaseTx[extranonceOffset+extranonceSize:])
-	return coinbase1, coinbase2
+	coinbase1, coinbase2 := SplitCoinbaseBytes(coinbaseTx, extranonceOffset, extranonceSize)
+	return hex.EncodeToString(coinbase1), hex.EncodeToString(coinbase2)
+}
+
+// SplitCoinbaseBytes is SplitCoinbase without the hex encoding. The parts
+// alias coinbaseTx rather than copying it; their capacity is capped so
+// appending to coinbase1 cannot overwrite the extranonce or coinbase2.
+func SplitCoinbaseBytes(coinbaseTx []byte, extranonceOffset int, extranonceSize int) ([]byte, []byte) {
+	return coinbaseTx[:extranonceOffset:extranonceOffset], coinbaseTx[extranonceOffset+extranonceSize:]
 }
 
 // ComputeMerkleBranches computes the Merkle branches for the Stratum protocol.
@@ -56,8 +64,9 @@ func ComputeMerkleBranches(txHashes []string) ([]string, error) {
 			} else {
 				right = left // duplicate last element for odd count
 			}
-			combined := append(left, right...)
-			hash := util.DoubleSHA256(combined)
+			// Hash without appending to left, which may share a
+			// backing array with the caller's data.
+			hash := util.DoubleSHA256Pair(left, right)
 			newHashes = append(newHashes, hash[:])
 		}
 		hashes = newHashes
@@ -77,8 +86,7 @@ func ComputeMerkleRoot(coinbaseHash []byte, branches []string) ([]byte, error) {
 		if err != nil {
 			return nil, fmt.Errorf("invalid branch hash: %w", err)
 		}
-		combined := append(current, branchBytes...)
-		hash := util.DoubleSHA256(combined)
+		hash := util.DoubleSHA256Pair(current, branchBytes)
 		current = hash[:]
 	}
 
@@ -91,11 +99,16 @@ func BuildJobFromTemplate(
 	tmpl *types.BlockTemplateData,
 	payouts []types.PayoutEntry,
 	prevShareHash [32]byte,
+	uncles [][32]byte,
 	extranonceSize int,
 ) (*JobData, error) {
 	// Build coinbase
 	builder := types.NewCoinbaseBuilder(tmpl.Network)
-	commitment := types.BuildShareCommitment(prevShareHash)
+	if err := builder.SetExtranonceLayout(tmpl.ExtranonceLayout); err != nil {
+		return nil, fmt.Errorf("extranonce layout: %w", err)
+	}
+	shareVersion := max(tmpl.ShareVersion, types.ShareVersion1)
+	commitment := types.BuildShareCommitment(types.VersionedCommitmentHash(shareVersion, prevShareHash, uncles, tmpl.MinerAddress, tmpl.ShareHeight))
 
 	coinbaseTx, extranonceOffset, err := builder.BuildCoinbase(
 		tmpl.Height,
@@ -109,7 +122,7 @@ func BuildJobFromTemplate(
 	}
 
 	// Split coinbase at extranonce position
-	coinbase1, coinbase2 := SplitCoinbase(coinbaseTx, extranonceOffset, extranonceSize)
+	coinbase1, coinbase2 := SplitCoinbaseBytes(coinbaseTx, extranonceOffset, extranonceSize)
 
 	// Compute Merkle branches from template transactions
 	branches, err := ComputeMerkleBranches(tmpl.TxHashes)
@@ -126,15 +139,22 @@ func BuildJobFromTemplate(
 	return &JobData{
 		ID:               jobID,
 		PrevBlockHash:    prevHashStratum,
-		Coinbase1:        coinbase1,
-		Coinbase2:        coinbase2,
+		Coinbase1:        hex.EncodeToString(coinbase1),
+		Coinbase2:        hex.EncodeToString(coinbase2),
 		CoinbaseTx:       coinbaseTx,
+		coinbase1:        coinbase1,
+		coinbase2:        coinbase2,
 		ExtranonceOffset: extranonceOffset,
 		MerkleBranches:   branches,
 		Version:          tmpl.Version,
 		NBits:            tmpl.Bits,
 		NTime:            tmpl.CurTime,
 		Height:           tmpl.Height,
+		ShareVersion:     shareVersion,
+		ShareHeight:      tmpl.ShareHeight,
+		PrevShareHash:    prevShareHash,
+		Payouts:          payouts,
+		Uncles:           uncles,
 	}, nil
 }
 
@@ -142,6 +162,7 @@ func BuildJobFromTemplate(
 type JobData struct {
 	ID               string
 	Seq              uint64
+	CreatedAt        time.Time // when the generator issued the job
 	PrevBlockHash    string
 	Coinbase1        string
 	Coinbase2        string
@@ -154,6 +175,44 @@ type JobData struct {
 	Height           int64
 	CleanJobs        bool                   // true for new block, false for refresh
 	Template         *bitcoin.BlockTemplate // template used to build this job
+
+	// ShareVersion is the version of shares built from this job, and
+	// ShareHeight, PrevShareHash and Uncles are the sharechain references
+	// committed in its coinbase.
+	ShareVersion  uint32
+	ShareHeight   int64
+	PrevShareHash [32]byte
+	Uncles        [][32]byte
+
+	// Payouts are the PPLNS outputs paid by this job's coinbase.
+	Payouts []types.PayoutEntry
+
+	// Stale is set (under the generator's job lock) once a clean job for a
+	// newer block supersedes this one; StaleAt records when that happened.
+	Stale   bool
+	StaleAt time.Time
+
+	// coinbase1 and coinbase2 are Coinbase1 and Coinbase2 as bytes,
+	// aliasing CoinbaseTx; see CoinbaseSplit.
+	coinbase1, coinbase2 []byte
+}
+
+// CoinbaseSplit returns the coinbase bytes before and after the extranonce.
+// Jobs from BuildJobFromTemplate carry them precomputed; otherwise they are
+// decoded from Coinbase1 and Coinbase2. The slices must not be modified.
+func (j *JobData) CoinbaseSplit() ([]byte, []byte, error) {
+	if j.coinbase1 != nil {
+		return j.coinbase1, j.coinbase2, nil
+	}
+	coinbase1, err := hex.DecodeString(j.Coinbase1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode coinbase1: %w", err)
+	}
+	coinbase2, err := hex.DecodeString(j.Coinbase2)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode coinbase2: %w", err)
+	}
+	return coinbase1, coinbase2, nil
 }
 
 // ReconstructHeader rebuilds the 80-byte block header and coinbase from a job
@@ -165,11 +224,18 @@ type JobData struct {
 // Stratum v1 format (4-byte-word-swapped internal order) and decoded accordingly.
 func ReconstructHeader(job *JobData, version, extranonce1, extranonce2, ntime, nonce string) ([]byte, []byte, error) {
 	// 1. Reconstruct full coinbase transaction
-	coinbaseHex := job.Coinbase1 + extranonce1 + extranonce2 + job.Coinbase2
-	coinbaseBytes, err := hex.DecodeString(coinbaseHex)
+	coinbase1, coinbase2, err := job.CoinbaseSplit()
+	if err != nil {
+		return nil, nil, err
+	}
+	extranonce, err := hex.DecodeString(extranonce1 + extranonce2)
 	if err != nil {
-		return nil, nil, fmt.Errorf("decode coinbase hex: %w", err)
+		return nil, nil, fmt.Errorf("decode extranonce hex: %w", err)
 	}
+	coinbaseBytes := make([]byte, 0, len(coinbase1)+len(extranonce)+len(coinbase2))
+	coinbaseBytes = append(coinbaseBytes, coinbase1...)
+	coinbaseBytes = append(coinbaseBytes, extranonce...)
+	coinbaseBytes = append(coinbaseBytes, coinbase2...)
 
 	// 2. Hash coinbase (double-SHA256)
 	coinbaseHash := util.DoubleSHA256(coinbaseBytes)
@@ -218,67 +284,87 @@ func ReconstructHeader(job *JobData, version, extranonce1, extranonce2, ntime, n
 	return header, coinbaseBytes, nil
 }
 
+// CheckVersionRolling checks a miner's block version against the job. Under
+// BIP 310 miners roll the bits in mask, so the version may differ from
+// job.Version there but nowhere else; with a zero mask it must match
+// exactly. Both versions are big-endian hex.
+func CheckVersionRolling(job *JobData, version string, mask uint32) error {
+	got, err := hexBEToLE(version, 4)
+	if err != nil {
+		return fmt.Errorf("decode version: %w", err)
+	}
+	base, err := hexBEToLE(job.Version, 4)
+	if err != nil {
+		return fmt.Errorf("decode job version: %w", err)
+	}
+	rolled := binary.LittleEndian.Uint32(got) ^ binary.LittleEndian.Uint32(base)
+	if rolled&^mask != 0 {
+		return fmt.Errorf("version %s changes bits %08x outside rolling mask %08x", version, rolled&^mask, mask)
+	}
+	return nil
+}
+
 // ReconstructBlock builds the full serialized block for submission to bitcoind.
 // It combines the header, coinbase transaction, and all transactions from the
 // block template. The coinbase is wrapped with segwit witness data for submission.
 func ReconstructBlock(header []byte, coinbase []byte, tmpl *bitcoin.BlockTemplate) (string, error) {
-	var buf bytes.Buffer
+	txData, err := tmpl.TransactionData()
+	if err != nil {
+		return "", err
+	}
 
-	// Block header (80 bytes)
-	buf.Write(header)
+	// Coinbase transaction (add witness data for block submission)
+	witnessCoinbase := types.AddCoinbaseWitness(coinbase)
 
 	// Transaction count (coinbase + template transactions)
-	txCount := 1 + len(tmpl.Transactions)
-	buf.Write(util.WriteVarInt(uint64(txCount)))
+	txCount := util.WriteVarInt(uint64(1 + len(txData)))
 
-	// Coinbase transaction (add witness data for block submission)
-	witnessCoinbase := types.AddCoinbaseWitness(coinbase)
-	buf.Write(witnessCoinbase)
+	size := len(header) + len(txCount) + len(witnessCoinbase)
+	for _, tx := range txData {
+		size += len(tx)
+	}
 
-	// Remaining transactions from the template
-	for _, tx := range tmpl.Transactions {
-		txBytes, err := hex.DecodeString(tx.Data)
-		if err != nil {
-			return "", fmt.Errorf("decode template tx %s: %w", tx.TxID, err)
-		}
-		buf.Write(txBytes)
+	block := make([]byte, 0, size)
+	block = append(block, header...)
+	block = append(block, txCount...)
+	block = append(block, witnessCoinbase...)
+	for _, tx := range txData {
+		block = append(block, tx...)
 	}
 
-	return hex.EncodeToString(buf.Bytes()), nil
+	return hex.EncodeToString(block), nil
 }
 
 // ComputeFullMerkleRoot builds the merkle root from a list of txid hashes (internal
 // byte order). This is used for pre-submission verification — it independently
 // computes the merkle root using the standard Bitcoin algorithm (not branches).
+// Each level is hashed in place in a single fixed-size buffer.
 func ComputeFullMerkleRoot(txids [][]byte) []byte {
 	if len(txids) == 0 {
 		return nil
 	}
 
-	// Copy so we don't mutate the caller's slice
-	hashes := make([][]byte, len(txids))
+	// Copy into fixed-size hashes so we don't mutate the caller's slices.
+	level := make([][32]byte, len(txids), len(txids)+1)
 	for i, h := range txids {
-		c := make([]byte, len(h))
-		copy(c, h)
-		hashes[i] = c
+		copy(level[i][:], h)
 	}
 
-	for len(hashes) > 1 {
-		if len(hashes)%2 != 0 {
-			dup := make([]byte, len(hashes[len(hashes)-1]))
-			copy(dup, hashes[len(hashes)-1])
-			hashes = append(hashes, dup)
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
 		}
-		var newLevel [][]byte
-		for i := 0; i < len(hashes); i += 2 {
-			combined := append(hashes[i], hashes[i+1]...)
-			h := util.DoubleSHA256(combined)
-			newLevel = append(newLevel, h[:])
+		// Node j is written after reading nodes 2j and 2j+1, so the next
+		// level can overwrite the front of this one.
+		next := level[:len(level)/2]
+		for j := range next {
+			next[j] = util.DoubleSHA256Pair(level[2*j][:], level[2*j+1][:])
 		}
-		hashes = newLevel
+		level = next
 	}
 
-	return hashes[0]
+	root := level[0]
+	return root[:]
 }
 
 // VerifyMerkleRoot independently computes the expected merkle root from the
@@ -325,6 +411,97 @@ func VerifyMerkleRoot(header []byte, coinbase []byte, tmpl *bitcoin.BlockTemplat
 	return nil
 }
 
+// VerifyWitnessCommitment checks that the non-witness coinbase carries the
+// witness commitment of the template's transactions, recomputed from their
+// wtxids rather than taken from the template, so a commitment that doesn't
+// match the transactions the block will hold is caught. Under BIP 141 the
+// last output starting with the commitment header is the one that counts;
+// bitcoind rejects a block whose commitment is missing or wrong with
+// bad-witness-merkle-match. A template without a commitment requires the
+// coinbase to have none either.
+func VerifyWitnessCommitment(coinbase []byte, tmpl *bitcoin.BlockTemplate) error {
+	outputs, err := types.ParseCoinbaseOutputs(coinbase)
+	if err != nil {
+		return fmt.Errorf("parse coinbase outputs: %w", err)
+	}
+	header, _ := hex.DecodeString(witnessCommitmentHeader)
+	var found []byte
+	for _, out := range outputs {
+		if len(out.Script) >= len(header)+32 && bytes.HasPrefix(out.Script, header) {
+			found = out.Script
+		}
+	}
+
+	if tmpl.DefaultWitnessCommitment == "" {
+		if found != nil {
+			return fmt.Errorf("coinbase commits to witness data %x the template has none", found)
+		}
+		return nil
+	}
+	commitment, err := witnessCommitment(tmpl.Transactions)
+	if err != nil {
+		return fmt.Errorf("compute witness commitment: %w", err)
+	}
+	want, _ := hex.DecodeString(commitment)
+	if found == nil {
+		return fmt.Errorf("coinbase has no witness commitment, transactions need %s", commitment)
+	}
+	if !bytes.Equal(found, want) {
+		return fmt.Errorf("witness commitment mismatch: coinbase=%x transactions=%s", found, commitment)
+	}
+	return nil
+}
+
+// CheckReconstruction rebuilds the block for job from a zero extranonce and
+// nonce, as a miner submission would be rebuilt, and checks it against the
+// job's template: the coinbase must survive the coinbase1/coinbase2 split,
+// the merkle root must cover every transaction, and the header's version,
+// previous block hash, time and bits must match the template.
+// extranonceSize is the total extranonce length in bytes.
+func CheckReconstruction(job *JobData, extranonceSize int) error {
+	tmpl := job.Template
+	if tmpl == nil {
+		return fmt.Errorf("job %s has no template", job.ID)
+	}
+
+	extranonce := hex.EncodeToString(make([]byte, extranonceSize))
+	header, coinbase, err := ReconstructHeader(job, job.Version, extranonce, "", job.NTime, "00000000")
+	if err != nil {
+		return fmt.Errorf("reconstruct header: %w", err)
+	}
+	if !bytes.Equal(coinbase, job.CoinbaseTx) {
+		return fmt.Errorf("coinbase split at offset %d does not rebuild the coinbase", job.ExtranonceOffset)
+	}
+	if err := VerifyMerkleRoot(header, coinbase, tmpl); err != nil {
+		return err
+	}
+	if err := VerifyWitnessCommitment(coinbase, tmpl); err != nil {
+		return err
+	}
+
+	prevHash, err := hex.DecodeString(tmpl.PreviousBlockHash)
+	if err != nil {
+		return fmt.Errorf("decode template prevhash: %w", err)
+	}
+	if !bytes.Equal(header[4:36], util.ReverseBytes(prevHash)) {
+		return fmt.Errorf("header prevhash %x does not match template %s", header[4:36], tmpl.PreviousBlockHash)
+	}
+	if v := int32(binary.LittleEndian.Uint32(header[0:4])); v != tmpl.Version {
+		return fmt.Errorf("header version %08x does not match template %08x", uint32(v), uint32(tmpl.Version))
+	}
+	if t := binary.LittleEndian.Uint32(header[68:72]); int64(t) != tmpl.CurTime {
+		return fmt.Errorf("header time %d does not match template %d", t, tmpl.CurTime)
+	}
+	if bits := fmt.Sprintf("%08x", binary.LittleEndian.Uint32(header[72:76])); bits != tmpl.Bits {
+		return fmt.Errorf("header bits %s does not match template %s", bits, tmpl.Bits)
+	}
+
+	if _, err := ReconstructBlock(header, coinbase, tmpl); err != nil {
+		return fmt.Errorf("reconstruct block: %w", err)
+	}
+	return nil
+}
+
 // hexBEToLE decodes a big-endian hex string and reverses it to little-endian byte order.
 func hexBEToLE(hexStr string, expectedLen int) ([]byte, error) {
 	b, err := hex.DecodeString(hexStr)
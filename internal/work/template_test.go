@@ -3,9 +3,14 @@ package work
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/djkazic/p2pool-go/internal/bitcoin"
+	"github.com/djkazic/p2pool-go/internal/types"
 	"github.com/djkazic/p2pool-go/pkg/util"
+	"github.com/djkazic/p2pool-go/testutil"
 )
 
 // TestMerkleRootConsistency verifies that ComputeFullMerkleRoot produces the
@@ -59,6 +64,209 @@ func TestMerkleRootConsistency(t *testing.T) {
 }
 
 // TestMerkleBranchesEmpty verifies the edge case of no transactions.
+// TestMerkleRoot_SpareCapacityInputs checks that merkle helpers never write
+// into their inputs' spare capacity. Each hash is a sub-slice of one shared
+// buffer, so appending to one would overwrite its neighbours.
+func TestMerkleRoot_SpareCapacityInputs(t *testing.T) {
+	const n = 5
+	buf := make([]byte, (n+1)*32)
+	for i := range buf {
+		buf[i] = byte(i * 7)
+	}
+	orig := append([]byte(nil), buf...)
+
+	shared := make([][]byte, n)
+	fresh := make([][]byte, n)
+	for i := range shared {
+		shared[i] = buf[i*32 : (i+1)*32]
+		fresh[i] = append([]byte(nil), shared[i]...)
+	}
+
+	if got, want := ComputeFullMerkleRoot(shared), ComputeFullMerkleRoot(fresh); !bytes.Equal(got, want) {
+		t.Errorf("ComputeFullMerkleRoot = %x, want %x", got, want)
+	}
+
+	var txHashes []string
+	for _, h := range fresh[1:] {
+		txHashes = append(txHashes, hex.EncodeToString(h))
+	}
+	branches, err := ComputeMerkleBranches(txHashes)
+	if err != nil {
+		t.Fatalf("ComputeMerkleBranches: %v", err)
+	}
+	got, err := ComputeMerkleRoot(shared[0], branches)
+	if err != nil {
+		t.Fatalf("ComputeMerkleRoot: %v", err)
+	}
+	if want := ComputeFullMerkleRoot(fresh); !bytes.Equal(got, want) {
+		t.Errorf("ComputeMerkleRoot = %x, want %x", got, want)
+	}
+
+	if !bytes.Equal(buf, orig) {
+		t.Error("merkle helpers modified their input buffer")
+	}
+}
+
+func TestBuildJob_CoinbaseSplitMatchesHex(t *testing.T) {
+	tmpl := &types.BlockTemplateData{
+		Height:        800000,
+		PrevBlockHash: strings.Repeat("00", 32),
+		Version:       "20000000",
+		Bits:          "207fffff",
+		CurTime:       "65000000",
+		CoinbaseValue: 5000000000,
+		Network:       testutil.RegtestNetwork,
+	}
+	payouts := []types.PayoutEntry{{Address: testutil.RegtestMinerAddress, Amount: 5000000000}}
+	job, err := BuildJobFromTemplate("1", tmpl, payouts, [32]byte{}, nil, 8)
+	if err != nil {
+		t.Fatalf("BuildJobFromTemplate: %v", err)
+	}
+
+	// The cached byte split matches a fresh split of the coinbase and the
+	// job's hex fields.
+	cb1, cb2, err := job.CoinbaseSplit()
+	if err != nil {
+		t.Fatalf("CoinbaseSplit: %v", err)
+	}
+	want1, want2 := SplitCoinbaseBytes(job.CoinbaseTx, job.ExtranonceOffset, 8)
+	if !bytes.Equal(cb1, want1) || !bytes.Equal(cb2, want2) {
+		t.Error("cached split differs from recomputed split")
+	}
+	if hex.EncodeToString(cb1) != job.Coinbase1 || hex.EncodeToString(cb2) != job.Coinbase2 {
+		t.Error("cached split differs from Coinbase1/Coinbase2")
+	}
+
+	// Reconstruction from the cached bytes equals reconstruction from hex.
+	hexOnly := &JobData{
+		PrevBlockHash: job.PrevBlockHash,
+		Coinbase1:     job.Coinbase1,
+		Coinbase2:     job.Coinbase2,
+		NBits:         job.NBits,
+	}
+	h1, c1, err := ReconstructHeader(job, "20000000", "00000001", "00000002", "65000000", "00000000")
+	if err != nil {
+		t.Fatalf("ReconstructHeader: %v", err)
+	}
+	h2, c2, err := ReconstructHeader(hexOnly, "20000000", "00000001", "00000002", "65000000", "00000000")
+	if err != nil {
+		t.Fatalf("ReconstructHeader (hex): %v", err)
+	}
+	if !bytes.Equal(h1, h2) || !bytes.Equal(c1, c2) {
+		t.Error("cached and hex reconstruction differ")
+	}
+	if !bytes.Equal(c1[job.ExtranonceOffset:job.ExtranonceOffset+8], testutil.MustDecodeHex(t, "0000000100000002")) {
+		t.Error("extranonce not placed at the job's offset")
+	}
+}
+
+func TestBuildJob_ShareVersion(t *testing.T) {
+	payouts := []types.PayoutEntry{{Address: testutil.RegtestMinerAddress, Amount: 5000000000}}
+	for _, version := range []uint32{0, types.ShareVersion1, types.ShareVersion2} {
+		tmpl := &types.BlockTemplateData{
+			Height:        800000,
+			PrevBlockHash: strings.Repeat("00", 32),
+			Version:       "20000000",
+			Bits:          "207fffff",
+			CurTime:       "65000000",
+			CoinbaseValue: 5000000000,
+			Network:       testutil.RegtestNetwork,
+			ShareVersion:  version,
+			MinerAddress:  testutil.RegtestMinerAddress,
+			ShareHeight:   9,
+		}
+		job, err := BuildJobFromTemplate("1", tmpl, payouts, [32]byte{7}, [][32]byte{{8}}, 8)
+		if err != nil {
+			t.Fatalf("v%d: BuildJobFromTemplate: %v", version, err)
+		}
+		want := max(version, types.ShareVersion1)
+		if job.ShareVersion != want {
+			t.Errorf("v%d: job share version = %d, want %d", version, job.ShareVersion, want)
+		}
+		committed, err := types.ExtractShareCommitment(job.CoinbaseTx)
+		if err != nil {
+			t.Fatalf("v%d: ExtractShareCommitment: %v", version, err)
+		}
+		if committed != types.VersionedCommitmentHash(want, [32]byte{7}, [][32]byte{{8}}, testutil.RegtestMinerAddress, 9) {
+			t.Errorf("v%d: coinbase commits to the wrong value", version)
+		}
+	}
+}
+
+func TestBuildJob_ExtranonceLayouts(t *testing.T) {
+	layouts := map[string]types.ExtranonceLayout{
+		"end":                 {},
+		"after height":        {AfterHeight: true},
+		"after marker":        {Marker: []byte{0xfa, 0xbe}},
+		"after height+marker": {AfterHeight: true, Marker: []byte{0xfa, 0xbe}},
+	}
+	payouts := []types.PayoutEntry{{Address: testutil.RegtestMinerAddress, Amount: 5000000000}}
+	for name, layout := range layouts {
+		t.Run(name, func(t *testing.T) {
+			tmpl := &types.BlockTemplateData{
+				Height:           800000,
+				PrevBlockHash:    strings.Repeat("00", 32),
+				Version:          "20000000",
+				Bits:             "207fffff",
+				CurTime:          "65000000",
+				CoinbaseValue:    5000000000,
+				Network:          testutil.RegtestNetwork,
+				TxHashes:         []string{strings.Repeat("11", 32), strings.Repeat("22", 32)},
+				ExtranonceLayout: layout,
+			}
+			job, err := BuildJobFromTemplate("1", tmpl, payouts, [32]byte{7}, nil, 8)
+			if err != nil {
+				t.Fatalf("BuildJobFromTemplate: %v", err)
+			}
+			off := job.ExtranonceOffset
+			if !bytes.Equal(job.CoinbaseTx[off-len(layout.Marker):off], layout.Marker) {
+				t.Error("extranonce does not follow the marker")
+			}
+
+			header, coinbase, err := ReconstructHeader(job, "20000000", "00000001", "00000002", "65000000", "00000000")
+			if err != nil {
+				t.Fatalf("ReconstructHeader: %v", err)
+			}
+			if !bytes.Equal(coinbase[off:off+8], testutil.MustDecodeHex(t, "0000000100000002")) {
+				t.Error("extranonce not placed at the job's offset")
+			}
+			tmplTxs := &bitcoin.BlockTemplate{Transactions: []bitcoin.TemplateTransaction{
+				{TxID: strings.Repeat("11", 32)}, {TxID: strings.Repeat("22", 32)},
+			}}
+			if err := VerifyMerkleRoot(header, coinbase, tmplTxs); err != nil {
+				t.Errorf("VerifyMerkleRoot: %v", err)
+			}
+			if hash, err := types.ExtractShareCommitment(coinbase); err != nil || hash != [32]byte{7} {
+				t.Errorf("commitment = %x, %v; want the prev share hash", hash[:4], err)
+			}
+		})
+	}
+}
+
+func TestCheckVersionRolling(t *testing.T) {
+	job := &JobData{Version: "20000000"}
+	tests := []struct {
+		version string
+		mask    uint32
+		ok      bool
+	}{
+		{"20000000", 0, true},
+		{"20004000", 0, false},
+		{"20004000", 0x1fffe000, true},
+		{"3fffe000", 0x1fffe000, true},
+		{"20000001", 0x1fffe000, false}, // low bit outside the mask
+		{"e0000000", 0x1fffe000, false}, // top bits outside the mask
+		{"2000", 0x1fffe000, false},
+		{"zz000000", 0x1fffe000, false},
+	}
+	for _, tt := range tests {
+		err := CheckVersionRolling(job, tt.version, tt.mask)
+		if (err == nil) != tt.ok {
+			t.Errorf("CheckVersionRolling(%s, %08x) = %v, want ok=%v", tt.version, tt.mask, err, tt.ok)
+		}
+	}
+}
+
 func TestMerkleBranchesEmpty(t *testing.T) {
 	branches, err := ComputeMerkleBranches(nil)
 	if err != nil {
@@ -109,3 +317,199 @@ func TestHexBEToLE(t *testing.T) {
 		t.Errorf("got %x, expected %x", result, expected)
 	}
 }
+
+// TestReconstructBlock_MinedShare reconstructs a block from a genuinely mined
+// share and checks the header's merkle root commits to its coinbase.
+func TestReconstructBlock_MinedShare(t *testing.T) {
+	share := testutil.MineShareChain(1)[0]
+	header := share.Header.Serialize()
+
+	blockHex, err := ReconstructBlock(header, share.CoinbaseTx, &bitcoin.BlockTemplate{})
+	if err != nil {
+		t.Fatalf("ReconstructBlock: %v", err)
+	}
+	block, err := hex.DecodeString(blockHex)
+	if err != nil {
+		t.Fatalf("decode block: %v", err)
+	}
+	if !bytes.Equal(block[:80], header) {
+		t.Error("block should start with the share header")
+	}
+
+	txid := util.DoubleSHA256(share.CoinbaseTx)
+	root := ComputeFullMerkleRoot([][]byte{txid[:]})
+	if !bytes.Equal(root, share.Header.MerkleRoot[:]) {
+		t.Errorf("merkle root = %x, want %x", share.Header.MerkleRoot, root)
+	}
+	if !util.HashMeetsTarget(share.Header.Hash(), testutil.RegtestTarget()) {
+		t.Error("mined header should meet the regtest target")
+	}
+}
+
+func BenchmarkComputeFullMerkleRoot(b *testing.B) {
+	txids := make([][]byte, 3000)
+	for i := range txids {
+		h := util.DoubleSHA256([]byte{byte(i), byte(i >> 8)})
+		txids[i] = h[:]
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ComputeFullMerkleRoot(txids)
+	}
+}
+
+// largeTemplate returns a template with n ~250-byte transactions.
+func largeTemplate(n int) *bitcoin.BlockTemplate {
+	tmpl := &bitcoin.BlockTemplate{}
+	for i := 0; i < n; i++ {
+		tx := bytes.Repeat([]byte{byte(i), byte(i >> 8)}, 125)
+		tmpl.Transactions = append(tmpl.Transactions, bitcoin.TemplateTransaction{
+			Data: hex.EncodeToString(tx),
+			TxID: hex.EncodeToString(tx[:32]),
+		})
+	}
+	return tmpl
+}
+
+func TestReconstructBlock_MatchesNaiveSerialization(t *testing.T) {
+	tmpl := largeTemplate(300)
+	header := bytes.Repeat([]byte{0x11}, 80)
+	coinbase := testutil.MineShareChain(1)[0].CoinbaseTx
+
+	var want bytes.Buffer
+	want.Write(header)
+	want.Write(util.WriteVarInt(uint64(1 + len(tmpl.Transactions))))
+	want.Write(types.AddCoinbaseWitness(coinbase))
+	for _, tx := range tmpl.Transactions {
+		raw, _ := hex.DecodeString(tx.Data)
+		want.Write(raw)
+	}
+
+	// Twice: the second call uses the template's cached transaction bytes.
+	for i := 0; i < 2; i++ {
+		got, err := ReconstructBlock(header, coinbase, tmpl)
+		if err != nil {
+			t.Fatalf("ReconstructBlock: %v", err)
+		}
+		if got != hex.EncodeToString(want.Bytes()) {
+			t.Fatalf("call %d: block hex differs from naive serialization", i)
+		}
+	}
+
+	bad := &bitcoin.BlockTemplate{Transactions: []bitcoin.TemplateTransaction{{Data: "zz", TxID: "bad"}}}
+	if _, err := ReconstructBlock(header, coinbase, bad); err == nil {
+		t.Error("expected error for undecodable template transaction")
+	}
+}
+
+func BenchmarkReconstructBlock(b *testing.B) {
+	tmpl := largeTemplate(3000)
+	header := make([]byte, 80)
+	coinbase := testutil.MineShareChain(1)[0].CoinbaseTx
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReconstructBlock(header, coinbase, tmpl); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCheckReconstruction_DetectsCorruptJob(t *testing.T) {
+	tmpl := bitcoin.NewMockRPC().BlockTemplate
+	tmpl.Transactions = largeTemplate(3).Transactions
+	g := testGenerator(bitcoin.NewMockRPC())
+	job, err := g.buildJob("test", tmpl)
+	if err != nil {
+		t.Fatalf("buildJob: %v", err)
+	}
+	if err := CheckReconstruction(job, 8); err != nil {
+		t.Fatalf("CheckReconstruction: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		corrupt func(j *JobData)
+	}{
+		{"merkle branch", func(j *JobData) {
+			j.MerkleBranches = append([]string{strings.Repeat("ff", 32)}, j.MerkleBranches[1:]...)
+		}},
+		{"prevhash", func(j *JobData) { j.PrevBlockHash = strings.Repeat("11", 32) }},
+		{"version", func(j *JobData) { j.Version = "30000000" }},
+		{"bits", func(j *JobData) { j.NBits = "1d00fffe" }},
+		{"no template", func(j *JobData) { j.Template = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bad := *job
+			tt.corrupt(&bad)
+			if err := CheckReconstruction(&bad, 8); err == nil {
+				t.Error("corrupt job passed the check")
+			}
+		})
+	}
+}
+
+func TestVerifyWitnessCommitment(t *testing.T) {
+	tmpl := *bitcoin.NewMockRPC().BlockTemplate
+	tmpl.Transactions = largeTemplate(3).Transactions
+	for i := range tmpl.Transactions {
+		// Segwit transactions: the wtxid differs from the txid.
+		tx := &tmpl.Transactions[i]
+		tx.Hash = strings.Repeat(fmt.Sprintf("%02x", i+1), 32)
+	}
+	commitment, err := witnessCommitment(tmpl.Transactions)
+	if err != nil {
+		t.Fatalf("witnessCommitment: %v", err)
+	}
+	tmpl.DefaultWitnessCommitment = commitment
+
+	g := testGenerator(bitcoin.NewMockRPC())
+	job, err := g.buildJob("segwit", &tmpl)
+	if err != nil {
+		t.Fatalf("buildJob: %v", err)
+	}
+	if err := VerifyWitnessCommitment(job.CoinbaseTx, &tmpl); err != nil {
+		t.Fatalf("VerifyWitnessCommitment: %v", err)
+	}
+	if err := CheckReconstruction(job, 8); err != nil {
+		t.Errorf("CheckReconstruction: %v", err)
+	}
+
+	// The template's transactions changed under the job.
+	other := tmpl
+	other.Transactions = tmpl.Transactions[:2]
+	if err := VerifyWitnessCommitment(job.CoinbaseTx, &other); err == nil {
+		t.Error("mismatched commitment passed")
+	}
+
+	// A template whose stated commitment doesn't match its transactions:
+	// the coinbase is held to the transactions.
+	wrong := tmpl
+	wrong.DefaultWitnessCommitment = witnessCommitmentHeader + strings.Repeat("00", 32)
+	wrongJob, err := g.buildJob("wrong", &wrong)
+	if err != nil {
+		t.Fatalf("buildJob: %v", err)
+	}
+	if err := VerifyWitnessCommitment(wrongJob.CoinbaseTx, &wrong); err == nil {
+		t.Error("commitment not matching the transactions passed")
+	}
+
+	// A coinbase built without the commitment.
+	noWitness := tmpl
+	noWitness.DefaultWitnessCommitment = ""
+	bare, err := g.buildJob("bare", &noWitness)
+	if err != nil {
+		t.Fatalf("buildJob: %v", err)
+	}
+	if err := VerifyWitnessCommitment(bare.CoinbaseTx, &tmpl); err == nil {
+		t.Error("missing commitment passed")
+	}
+	if err := VerifyWitnessCommitment(bare.CoinbaseTx, &noWitness); err != nil {
+		t.Errorf("template without a commitment: %v", err)
+	}
+	if err := VerifyWitnessCommitment(job.CoinbaseTx, &noWitness); err == nil {
+		t.Error("unexpected commitment passed")
+	}
+}
@@ -0,0 +1,101 @@
+package work
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/djkazic/p2pool-go/internal/bitcoin"
+	"github.com/djkazic/p2pool-go/pkg/util"
+)
+
+// TxFilter reports whether a block template transaction may be included in
+// the blocks the pool builds. See Generator.SetTxFilter.
+type TxFilter func(tx bitcoin.TemplateTransaction) bool
+
+// witnessCommitmentHeader prefixes the witness commitment output script:
+// OP_RETURN, a 36-byte push and the BIP 141 commitment tag.
+const witnessCommitmentHeader = "6a24aa21a9ed"
+
+// FilterTemplate returns tmpl without the transactions keep rejects, and
+// the number removed. A transaction spending outputs of a removed one is
+// removed too, since the block would be invalid with it. The coinbase value
+// drops by the removed transactions' fees and the witness commitment is
+// recomputed over those that remain, so the merkle root, coinbase and block
+// all stay consistent. tmpl itself is returned if nothing is removed.
+//
+// Dependencies are taken from the template's depends lists, which bitcoind
+// always fills in. A template without them gives no protection: removing a
+// transaction that another one spends then builds an invalid block.
+func FilterTemplate(tmpl *bitcoin.BlockTemplate, keep TxFilter) (*bitcoin.BlockTemplate, int, error) {
+	removed := make([]bool, len(tmpl.Transactions))
+	var count int
+	var fees int64
+	for i, tx := range tmpl.Transactions {
+		drop := !keep(tx)
+		for _, dep := range tx.Depends {
+			// Parents always come before the transactions spending them.
+			if dep < 1 || dep > i {
+				return nil, 0, fmt.Errorf("transaction %s depends on invalid index %d", tx.TxID, dep)
+			}
+			drop = drop || removed[dep-1]
+		}
+		if drop {
+			removed[i] = true
+			count++
+			fees += tx.Fee
+		}
+	}
+	if count == 0 {
+		return tmpl, 0, nil
+	}
+
+	// Depends refers to template positions, which shift as transactions
+	// are removed.
+	position := make([]int, len(tmpl.Transactions))
+	kept := make([]bitcoin.TemplateTransaction, 0, len(tmpl.Transactions)-count)
+	for i, tx := range tmpl.Transactions {
+		if removed[i] {
+			continue
+		}
+		if len(tx.Depends) > 0 {
+			depends := make([]int, len(tx.Depends))
+			for j, dep := range tx.Depends {
+				depends[j] = position[dep-1]
+			}
+			tx.Depends = depends
+		}
+		kept = append(kept, tx)
+		position[i] = len(kept)
+	}
+
+	filtered := tmpl.WithTransactions(kept)
+	filtered.CoinbaseValue -= fees
+	if tmpl.DefaultWitnessCommitment != "" {
+		commitment, err := witnessCommitment(kept)
+		if err != nil {
+			return nil, 0, err
+		}
+		filtered.DefaultWitnessCommitment = commitment
+	}
+	return filtered, count, nil
+}
+
+// witnessCommitment returns the hex witness commitment output script
+// (BIP 141) for a block holding txs after the coinbase, whose witness
+// reserved value is all zeros as types.AddCoinbaseWitness writes it.
+func witnessCommitment(txs []bitcoin.TemplateTransaction) (string, error) {
+	// The coinbase's wtxid is defined as zero.
+	wtxids := make([][]byte, 1+len(txs))
+	wtxids[0] = make([]byte, 32)
+	for i, tx := range txs {
+		b, err := hex.DecodeString(tx.Hash)
+		if err != nil || len(b) != 32 {
+			return "", fmt.Errorf("invalid wtxid %q for transaction %s", tx.Hash, tx.TxID)
+		}
+		// getblocktemplate returns wtxids in display order (reversed).
+		wtxids[i+1] = util.ReverseBytes(b)
+	}
+	root := ComputeFullMerkleRoot(wtxids)
+	commitment := util.DoubleSHA256(append(root, make([]byte, 32)...))
+	return witnessCommitmentHeader + hex.EncodeToString(commitment[:]), nil
+}
@@ -0,0 +1,117 @@
+package work
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/djkazic/p2pool-go/internal/bitcoin"
+)
+
+// filterTestTemplate returns a template of four transactions, where "b"
+// spends "a" and "d" spends "c".
+func filterTestTemplate() *bitcoin.BlockTemplate {
+	tx := func(id string, fee int64, depends ...int) bitcoin.TemplateTransaction {
+		return bitcoin.TemplateTransaction{
+			TxID:    strings.Repeat(id, 32),
+			Hash:    strings.Repeat(id+id, 16),
+			Fee:     fee,
+			Depends: depends,
+		}
+	}
+	txs := []bitcoin.TemplateTransaction{tx("aa", 100), tx("bb", 200, 1), tx("cc", 300), tx("dd", 50, 3)}
+	commitment, _ := witnessCommitment(txs)
+	return &bitcoin.BlockTemplate{
+		CoinbaseValue:            5000000650,
+		Transactions:             txs,
+		DefaultWitnessCommitment: commitment,
+	}
+}
+
+func TestFilterTemplate(t *testing.T) {
+	tmpl := filterTestTemplate()
+	excludeA := func(tx bitcoin.TemplateTransaction) bool { return tx.TxID != tmpl.Transactions[0].TxID }
+
+	filtered, removed, err := FilterTemplate(tmpl, excludeA)
+	if err != nil {
+		t.Fatalf("FilterTemplate: %v", err)
+	}
+	// b spends a, so it goes too.
+	if removed != 2 || len(filtered.Transactions) != 2 {
+		t.Fatalf("removed %d, kept %d; want 2, 2", removed, len(filtered.Transactions))
+	}
+	if filtered.Transactions[0].TxID != tmpl.Transactions[2].TxID || filtered.Transactions[1].TxID != tmpl.Transactions[3].TxID {
+		t.Error("wrong transactions kept")
+	}
+	if !slices.Equal(filtered.Transactions[1].Depends, []int{1}) {
+		t.Errorf("kept dependency = %v, want [1]", filtered.Transactions[1].Depends)
+	}
+	if filtered.CoinbaseValue != 5000000350 {
+		t.Errorf("coinbase value = %d, want 5000000350", filtered.CoinbaseValue)
+	}
+	want, _ := witnessCommitment(filtered.Transactions)
+	if filtered.DefaultWitnessCommitment != want || want == tmpl.DefaultWitnessCommitment {
+		t.Error("witness commitment not recomputed for the kept transactions")
+	}
+	if len(tmpl.Transactions) != 4 || tmpl.CoinbaseValue != 5000000650 || !slices.Equal(tmpl.Transactions[3].Depends, []int{3}) {
+		t.Error("FilterTemplate modified its input")
+	}
+
+	if same, removed, _ := FilterTemplate(tmpl, func(bitcoin.TemplateTransaction) bool { return true }); same != tmpl || removed != 0 {
+		t.Error("keeping everything should return the template itself")
+	}
+
+	tmpl.Transactions[0].Depends = []int{2}
+	if _, _, err := FilterTemplate(tmpl, excludeA); err == nil {
+		t.Error("expected error for a dependency on a later transaction")
+	}
+}
+
+func TestWitnessCommitment_Empty(t *testing.T) {
+	// The commitment bitcoind returns for a block with only a coinbase.
+	const want = "6a24aa21a9ede2f61c3f71d1defd3fa999dfa36953755c690689799962b48bebd836974e8cf9"
+	if got, err := witnessCommitment(nil); err != nil || got != want {
+		t.Errorf("witnessCommitment(nil) = %s, %v; want %s", got, err, want)
+	}
+}
+
+func TestGenerator_TxFilter(t *testing.T) {
+	rpc := bitcoin.NewMockRPC()
+	rpc.BlockTemplate.Transactions = largeTemplate(5).Transactions
+	for i := range rpc.BlockTemplate.Transactions {
+		tx := &rpc.BlockTemplate.Transactions[i]
+		tx.Hash = tx.TxID
+		tx.Fee = 1000
+	}
+	rpc.BlockTemplate.CoinbaseValue += 5000
+	excluded := rpc.BlockTemplate.Transactions[1].TxID
+
+	g := testGenerator(rpc)
+	g.SetTxFilter(func(tx bitcoin.TemplateTransaction) bool { return tx.TxID != excluded })
+	if err := g.fetchTemplate(context.Background()); err != nil {
+		t.Fatalf("fetchTemplate: %v", err)
+	}
+	job := <-g.JobChannel()
+
+	if len(g.CurrentTemplate().Transactions) != 5 {
+		t.Error("current template should keep every transaction")
+	}
+	if job.Template != g.JobTemplate() || len(job.Template.Transactions) != 4 {
+		t.Fatal("job not built from the filtered template")
+	}
+	for _, tx := range job.Template.Transactions {
+		if tx.TxID == excluded {
+			t.Error("excluded transaction in the job")
+		}
+	}
+	if job.Template.CoinbaseValue != g.CurrentTemplate().CoinbaseValue-1000 {
+		t.Errorf("coinbase value = %d, want the excluded fee less", job.Template.CoinbaseValue)
+	}
+	if err := CheckReconstruction(job, 8); err != nil {
+		t.Errorf("CheckReconstruction: %v", err)
+	}
+	if err := g.SelfCheck(rpc.BlockTemplate); err != nil {
+		t.Errorf("SelfCheck: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// ErrBase58Checksum is returned when a base58check string's checksum does
+// not match its payload.
+var ErrBase58Checksum = errors.New("base58check checksum mismatch")
+
+var base58Index = func() [256]int {
+	var idx [256]int
+	for i := range idx {
+		idx[i] = -1
+	}
+	for i, c := range base58Alphabet {
+		idx[c] = i
+	}
+	return idx
+}()
+
+// Base58Encode encodes b in Bitcoin's base58 alphabet. Leading zero bytes
+// become leading '1's.
+func Base58Encode(b []byte) string {
+	zeros := 0
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for i := 0; i < zeros; i++ {
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// Base58Decode decodes a base58 string.
+func Base58Decode(s string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for i := 0; i < len(s); i++ {
+		v := base58Index[s[i]]
+		if v < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", s[i])
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(v)))
+	}
+
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+// Base58CheckEncode encodes a version byte and payload with a 4-byte
+// double-SHA256 checksum, as used by legacy Bitcoin addresses.
+func Base58CheckEncode(version byte, payload []byte) string {
+	b := append([]byte{version}, payload...)
+	sum := DoubleSHA256(b)
+	return Base58Encode(append(b, sum[:4]...))
+}
+
+// Base58CheckDecode decodes a base58check string into its version byte and
+// payload, verifying the checksum.
+func Base58CheckDecode(s string) (byte, []byte, error) {
+	b, err := Base58Decode(s)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(b) < 5 {
+		return 0, nil, fmt.Errorf("base58check data too short")
+	}
+	body, checksum := b[:len(b)-4], b[len(b)-4:]
+	sum := DoubleSHA256(body)
+	if !bytes.Equal(sum[:4], checksum) {
+		return 0, nil, ErrBase58Checksum
+	}
+	return body[0], body[1:], nil
+}
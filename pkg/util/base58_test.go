@@ -0,0 +1,66 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBase58RoundTrip(t *testing.T) {
+	tests := []struct {
+		hex string
+		enc string
+	}{
+		{"", ""},
+		{"00", "1"},
+		{"0000", "11"},
+		{"61", "2g"},
+		{"626262", "a3gV"},
+		{"00000000000000000000", "1111111111"},
+		{"516b6fcd0f", "ABnLTmg"},
+		{"572e4794", "3EFU7m"},
+	}
+	for _, tt := range tests {
+		b, _ := HexToBytes(tt.hex)
+		if got := Base58Encode(b); got != tt.enc {
+			t.Errorf("Base58Encode(%s) = %q, want %q", tt.hex, got, tt.enc)
+		}
+		dec, err := Base58Decode(tt.enc)
+		if err != nil {
+			t.Errorf("Base58Decode(%q): %v", tt.enc, err)
+			continue
+		}
+		if !bytes.Equal(dec, b) {
+			t.Errorf("Base58Decode(%q) = %x, want %s", tt.enc, dec, tt.hex)
+		}
+	}
+
+	for _, bad := range []string{"0", "O", "I", "l", "abc+"} {
+		if _, err := Base58Decode(bad); err == nil {
+			t.Errorf("Base58Decode(%q): expected error", bad)
+		}
+	}
+}
+
+func TestBase58Check(t *testing.T) {
+	hash, _ := HexToBytes("751e76e8199196d454941c45d1b3a323f1433bd6")
+	const addr = "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"
+
+	if got := Base58CheckEncode(0x00, hash); got != addr {
+		t.Errorf("Base58CheckEncode = %s, want %s", got, addr)
+	}
+	version, payload, err := Base58CheckDecode(addr)
+	if err != nil {
+		t.Fatalf("Base58CheckDecode: %v", err)
+	}
+	if version != 0x00 || !bytes.Equal(payload, hash) {
+		t.Errorf("got version %d payload %x", version, payload)
+	}
+
+	if _, _, err := Base58CheckDecode("1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMJ"); !errors.Is(err, ErrBase58Checksum) {
+		t.Errorf("corrupted address: err = %v, want ErrBase58Checksum", err)
+	}
+	if _, _, err := Base58CheckDecode("1111"); err == nil {
+		t.Error("short input: expected error")
+	}
+}
@@ -0,0 +1,255 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Bech32Encoding selects the checksum constant of a bech32 string: BIP173
+// bech32 for witness version 0, BIP350 bech32m for version 1 and above.
+type Bech32Encoding int
+
+const (
+	Bech32 Bech32Encoding = iota + 1
+	Bech32m
+)
+
+const (
+	bech32Charset   = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32Const     = 1
+	bech32mConst    = 0x2bc830a3
+	bech32MaxLength = 90
+)
+
+// ErrBech32Checksum is returned when a bech32 string's checksum matches
+// neither bech32 nor bech32m.
+var ErrBech32Checksum = errors.New("invalid bech32 checksum")
+
+var bech32Index = func() [256]int8 {
+	var idx [256]int8
+	for i := range idx {
+		idx[i] = -1
+	}
+	for i, c := range bech32Charset {
+		idx[c] = int8(i)
+	}
+	return idx
+}()
+
+func (e Bech32Encoding) String() string {
+	switch e {
+	case Bech32:
+		return "bech32"
+	case Bech32m:
+		return "bech32m"
+	default:
+		return fmt.Sprintf("Bech32Encoding(%d)", int(e))
+	}
+}
+
+func (e Bech32Encoding) constant() uint32 {
+	if e == Bech32m {
+		return bech32mConst
+	}
+	return bech32Const
+}
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (b>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	ret := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]>>5)
+	}
+	ret = append(ret, 0)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]&31)
+	}
+	return ret
+}
+
+// Bech32Encode encodes hrp and 5-bit data groups with the checksum of enc.
+// hrp must be lowercase.
+func Bech32Encode(hrp string, data []byte, enc Bech32Encoding) (string, error) {
+	if len(hrp) < 1 || len(hrp)+1+len(data)+6 > bech32MaxLength {
+		return "", fmt.Errorf("bech32 string length out of range")
+	}
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 || (hrp[i] >= 'A' && hrp[i] <= 'Z') {
+			return "", fmt.Errorf("invalid bech32 hrp character %q", hrp[i])
+		}
+	}
+
+	values := append(bech32HRPExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	polymod := bech32Polymod(values) ^ enc.constant()
+
+	var sb strings.Builder
+	sb.Grow(len(hrp) + 1 + len(data) + 6)
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range data {
+		if v > 31 {
+			return "", fmt.Errorf("invalid bech32 data value %d", v)
+		}
+		sb.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return sb.String(), nil
+}
+
+// Bech32Decode decodes a bech32 or bech32m string, verifying its checksum.
+// It returns the lowercase hrp, the 5-bit data groups without the checksum
+// and which checksum the string carries. Mixed-case strings are rejected.
+func Bech32Decode(s string) (string, []byte, Bech32Encoding, error) {
+	if len(s) > bech32MaxLength {
+		return "", nil, 0, fmt.Errorf("bech32 string too long: %d characters", len(s))
+	}
+	var hasLower, hasUpper bool
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 33 || c > 126 {
+			return "", nil, 0, fmt.Errorf("invalid bech32 character %q", c)
+		}
+		hasLower = hasLower || (c >= 'a' && c <= 'z')
+		hasUpper = hasUpper || (c >= 'A' && c <= 'Z')
+	}
+	if hasLower && hasUpper {
+		return "", nil, 0, fmt.Errorf("mixed-case bech32 string")
+	}
+	s = strings.ToLower(s)
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, 0, fmt.Errorf("invalid bech32 separator position")
+	}
+	hrp := s[:sep]
+	data := make([]byte, len(s)-sep-1)
+	for i := range data {
+		v := bech32Index[s[sep+1+i]]
+		if v < 0 {
+			return "", nil, 0, fmt.Errorf("invalid bech32 character %q", s[sep+1+i])
+		}
+		data[i] = byte(v)
+	}
+
+	var enc Bech32Encoding
+	switch bech32Polymod(append(bech32HRPExpand(hrp), data...)) {
+	case bech32Const:
+		enc = Bech32
+	case bech32mConst:
+		enc = Bech32m
+	default:
+		return "", nil, 0, ErrBech32Checksum
+	}
+	return hrp, data[:len(data)-6], enc, nil
+}
+
+// ConvertBits regroups data from fromBits-bit to toBits-bit values. With
+// pad, a final partial group is zero-padded; without it, leftover bits must
+// be fewer than fromBits and all zero.
+func ConvertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	var result []byte
+	maxv := uint32((1 << toBits) - 1)
+
+	for _, val := range data {
+		if uint32(val)>>fromBits != 0 {
+			return nil, fmt.Errorf("invalid %d-bit value %d", fromBits, val)
+		}
+		acc = (acc << fromBits) | uint32(val)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte((acc>>bits)&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			result = append(result, byte((acc<<(toBits-bits))&maxv))
+		}
+	} else if bits >= fromBits {
+		return nil, fmt.Errorf("invalid padding")
+	} else if (acc<<(toBits-bits))&maxv != 0 {
+		return nil, fmt.Errorf("non-zero padding")
+	}
+
+	return result, nil
+}
+
+// DecodeSegwitAddress decodes a segwit address for hrp into its witness
+// version and program, enforcing BIP173 and BIP350: version 0 must use
+// bech32 and later versions bech32m, programs are 2 to 40 bytes, and a
+// version 0 program is 20 or 32 bytes.
+func DecodeSegwitAddress(hrp, address string) (byte, []byte, error) {
+	gotHRP, data, enc, err := Bech32Decode(address)
+	if err != nil {
+		return 0, nil, err
+	}
+	if gotHRP != hrp {
+		return 0, nil, fmt.Errorf("address hrp %q, want %q", gotHRP, hrp)
+	}
+	if len(data) < 1 {
+		return 0, nil, fmt.Errorf("empty witness data")
+	}
+	version := data[0]
+	if version > 16 {
+		return 0, nil, fmt.Errorf("invalid witness version %d", version)
+	}
+	program, err := ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, fmt.Errorf("witness program: %w", err)
+	}
+	if len(program) < 2 || len(program) > 40 {
+		return 0, nil, fmt.Errorf("invalid witness program length %d", len(program))
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return 0, nil, fmt.Errorf("invalid witness v0 program length %d", len(program))
+	}
+	if (version == 0) != (enc == Bech32) {
+		return 0, nil, fmt.Errorf("witness v%d address uses %s checksum", version, enc)
+	}
+	return version, program, nil
+}
+
+// EncodeSegwitAddress encodes a witness version and program as a segwit
+// address for hrp, using bech32 for version 0 and bech32m otherwise.
+func EncodeSegwitAddress(hrp string, version byte, program []byte) (string, error) {
+	if version > 16 {
+		return "", fmt.Errorf("invalid witness version %d", version)
+	}
+	data, err := ConvertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	enc := Bech32m
+	if version == 0 {
+		enc = Bech32
+	}
+	addr, err := Bech32Encode(hrp, append([]byte{version}, data...), enc)
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := DecodeSegwitAddress(hrp, addr); err != nil {
+		return "", err
+	}
+	return addr, nil
+}
@@ -0,0 +1,155 @@
+package util
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Test vectors from BIP173 and BIP350.
+
+func TestBech32Decode_Valid(t *testing.T) {
+	tests := []struct {
+		s   string
+		enc Bech32Encoding
+	}{
+		{"A12UEL5L", Bech32},
+		{"a12uel5l", Bech32},
+		{"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs", Bech32},
+		{"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", Bech32},
+		{"11" + strings.Repeat("q", 82) + "c8247j", Bech32},
+		{"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w", Bech32},
+		{"?1ezyfcl", Bech32},
+		{"A1LQFN3A", Bech32m},
+		{"a1lqfn3a", Bech32m},
+		{"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6", Bech32m},
+		{"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx", Bech32m},
+		{"11" + strings.Repeat("l", 82) + "ludsr8", Bech32m},
+		{"split1checkupstagehandshakeupstreamerranterredcaperredlc445v", Bech32m},
+		{"?1v759aa", Bech32m},
+	}
+	for _, tt := range tests {
+		hrp, data, enc, err := Bech32Decode(tt.s)
+		if err != nil {
+			t.Errorf("Bech32Decode(%q): %v", tt.s, err)
+			continue
+		}
+		if enc != tt.enc {
+			t.Errorf("Bech32Decode(%q) encoding = %v, want %v", tt.s, enc, tt.enc)
+		}
+		got, err := Bech32Encode(hrp, data, enc)
+		if err != nil || got != strings.ToLower(tt.s) {
+			t.Errorf("Bech32Encode round-trip of %q = %q, %v", tt.s, got, err)
+		}
+	}
+}
+
+func TestBech32Decode_Invalid(t *testing.T) {
+	for _, s := range []string{
+		"\x201nwldj5", // hrp character out of range
+		"\x7f1axkwrx", // hrp character out of range
+		"\x801eym55h", // hrp character out of range
+		"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx", // overall max length exceeded
+		"pzry9x0s0muk",  // no separator
+		"1pzry9x0s0muk", // empty hrp
+		"x1b4n0q5v",     // invalid data character
+		"li1dgmt3",      // too short checksum
+		"de1lg7wt\xff",  // invalid character in checksum
+		"A1G7SGD8",      // checksum calculated with uppercase hrp
+		"10a06t8",       // empty hrp
+		"1qzzfhee",      // empty hrp
+		"an84characterslonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11d6pts4", // overall max length exceeded
+		"qyrz8wqd2c9m",  // no separator
+		"1qyrz8wqd2c9m", // empty hrp
+		"y1b0jsk6g",     // invalid data character
+		"lt1igcx5c0",    // invalid data character
+		"in1muywd",      // too short checksum
+		"mm1crxm3i",     // invalid character in checksum
+		"au1s5cgom",     // invalid character in checksum
+		"M1VUXWEZ",      // checksum calculated with uppercase hrp
+		"16plkw9",       // empty hrp
+		"1p2gdwpf",      // empty hrp
+		"a12UEL5L",      // mixed case
+	} {
+		if _, _, _, err := Bech32Decode(s); err == nil {
+			t.Errorf("Bech32Decode(%q): expected error", s)
+		}
+	}
+}
+
+func TestDecodeSegwitAddress_Valid(t *testing.T) {
+	tests := []struct {
+		addr   string
+		hrp    string
+		script string // witness scriptPubKey: OP_n <len> <program>
+	}{
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "bc", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "tb", "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", "bc", "5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"BC1SW50QGDZ25J", "bc", "6002751e"},
+		{"bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", "bc", "5210751e76e8199196d454941c45d1b3a323"},
+		{"tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy", "tb", "0020000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", "tb", "5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "bc", "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+	}
+	for _, tt := range tests {
+		version, program, err := DecodeSegwitAddress(tt.hrp, tt.addr)
+		if err != nil {
+			t.Errorf("DecodeSegwitAddress(%q): %v", tt.addr, err)
+			continue
+		}
+		script, _ := HexToBytes(tt.script)
+		wantVersion := script[0]
+		if wantVersion != 0 {
+			wantVersion -= 0x50
+		}
+		if version != wantVersion || !bytes.Equal(program, script[2:]) {
+			t.Errorf("DecodeSegwitAddress(%q) = v%d %x, want v%d %x", tt.addr, version, program, wantVersion, script[2:])
+		}
+		addr, err := EncodeSegwitAddress(tt.hrp, version, program)
+		if err != nil || addr != strings.ToLower(tt.addr) {
+			t.Errorf("EncodeSegwitAddress round-trip of %q = %q, %v", tt.addr, addr, err)
+		}
+	}
+}
+
+func TestDecodeSegwitAddress_Invalid(t *testing.T) {
+	for _, addr := range []string{
+		"tc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5zuyut", // invalid hrp
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", // v1 with bech32 checksum
+		"tb1z0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqglt7rf", // v2 with bech32 checksum
+		"BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL", // v16 with bech32 checksum
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh",                     // v0 with bech32m checksum
+		"tb1q0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq24jc47", // v0 with bech32m checksum
+		"bc1p38j9r5y49hruaue7wxjce0updqjuyyx0kh56v8s25huc6995vvpql3jow4", // invalid character
+		"BC130XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ7ZWS8R", // invalid witness version
+		"bc1pw5dgrnzv", // 1-byte program
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v8n0nx0muaewav253zgeav", // 41-byte program
+		"BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P",                                         // invalid v0 program length
+		"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq47Zagq",               // mixed case
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v07qwwzcrf",             // more than 4 padding bits
+		"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vpggkg4j",               // non-zero padding
+		"bc1gmk9yu", // empty data
+	} {
+		for _, hrp := range []string{"bc", "tb"} {
+			if _, _, err := DecodeSegwitAddress(hrp, addr); err == nil {
+				t.Errorf("DecodeSegwitAddress(%q, %q): expected error", hrp, addr)
+			}
+		}
+	}
+}
+
+func TestConvertBits(t *testing.T) {
+	data := []byte{0xff, 0x00, 0x42}
+	five, err := ConvertBits(data, 8, 5, true)
+	if err != nil {
+		t.Fatalf("ConvertBits(8->5): %v", err)
+	}
+	back, err := ConvertBits(five, 5, 8, false)
+	if err != nil || !bytes.Equal(back, data) {
+		t.Errorf("round-trip = %x, %v; want %x", back, err, data)
+	}
+	if _, err := ConvertBits([]byte{32}, 5, 8, true); err == nil {
+		t.Error("expected error for out-of-range 5-bit value")
+	}
+}
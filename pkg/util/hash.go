@@ -4,6 +4,8 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"math/big"
 )
 
@@ -13,6 +15,24 @@ func DoubleSHA256(data []byte) [32]byte {
 	return sha256.Sum256(first[:])
 }
 
+// DoubleSHA256Pair computes DoubleSHA256(left || right) without allocating
+// the concatenation when it fits in 64 bytes, as for merkle tree nodes.
+func DoubleSHA256Pair(left, right []byte) [32]byte {
+	var buf [64]byte
+	if len(left)+len(right) <= len(buf) {
+		n := copy(buf[:], left)
+		n += copy(buf[n:], right)
+		first := sha256.Sum256(buf[:n])
+		return sha256.Sum256(first[:])
+	}
+	h := sha256.New()
+	h.Write(left)
+	h.Write(right)
+	var first [32]byte
+	h.Sum(first[:0])
+	return sha256.Sum256(first[:])
+}
+
 // ReverseBytes returns a new slice with bytes reversed.
 func ReverseBytes(b []byte) []byte {
 	out := make([]byte, len(b))
@@ -62,6 +82,45 @@ func CompactToTarget(compact uint32) *big.Int {
 	return target
 }
 
+var (
+	// ErrNegativeTarget is returned for a compact value with the sign bit set.
+	ErrNegativeTarget = errors.New("compact target is negative")
+	// ErrTargetTooLarge is returned for a compact value whose target is
+	// above the limit it is checked against.
+	ErrTargetTooLarge = errors.New("compact target too large")
+)
+
+// CompactToTargetChecked is CompactToTarget for untrusted input. It rejects
+// negative values and targets above limit, usually the network's easiest
+// share target, checking the exponent before expanding so a hostile value
+// can't force a huge shift.
+func CompactToTargetChecked(compact uint32, limit *big.Int) (*big.Int, error) {
+	if compact&0x00800000 != 0 {
+		return nil, fmt.Errorf("%w: %08x", ErrNegativeTarget, compact)
+	}
+	// Above exponent 0x22 any nonzero mantissa overflows 256 bits.
+	if compact>>24 > 0x22 {
+		return nil, fmt.Errorf("%w: %08x", ErrTargetTooLarge, compact)
+	}
+	target := CompactToTarget(compact)
+	if target.Cmp(limit) > 0 {
+		return nil, fmt.Errorf("%w: %08x", ErrTargetTooLarge, compact)
+	}
+	return target, nil
+}
+
+// IsCanonicalCompact reports whether compact is the one encoding
+// TargetToCompact gives its target: non-negative, no redundant leading
+// zero byte in the mantissa, no mantissa high bit standing in for a sign,
+// and a target that fits in 256 bits.
+func IsCanonicalCompact(compact uint32) bool {
+	if compact&0x00800000 != 0 || compact>>24 > 0x22 {
+		return false
+	}
+	target := CompactToTarget(compact)
+	return target.BitLen() <= 256 && TargetToCompact(target) == compact
+}
+
 // TargetToCompact converts a big.Int target to Bitcoin compact (nBits) representation.
 func TargetToCompact(target *big.Int) uint32 {
 	if target.Sign() == 0 {
@@ -76,8 +135,10 @@ func TargetToCompact(target *big.Int) uint32 {
 
 	var mantissa uint32
 	if size <= 3 {
+		// Left-align short targets in the mantissa, as Core does; the
+		// exponent then shifts them back down.
 		for i, v := range b {
-			mantissa |= uint32(v) << uint(8*(2-uint32(i)-(3-size)))
+			mantissa |= uint32(v) << uint(8*(2-i))
 		}
 	} else {
 		mantissa = (uint32(b[0]) << 16) | (uint32(b[1]) << 8) | uint32(b[2])
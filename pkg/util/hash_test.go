@@ -1,6 +1,8 @@
 package util
 
 import (
+	"bytes"
+	"errors"
 	"math/big"
 	"testing"
 )
@@ -16,6 +18,17 @@ func TestDoubleSHA256(t *testing.T) {
 	}
 }
 
+func TestDoubleSHA256Pair(t *testing.T) {
+	for _, n := range [][2]int{{32, 32}, {0, 0}, {5, 7}, {64, 0}, {40, 40}, {100, 3}} {
+		left := bytes.Repeat([]byte{0xab}, n[0])
+		right := bytes.Repeat([]byte{0xcd}, n[1])
+		want := DoubleSHA256(append(append([]byte{}, left...), right...))
+		if got := DoubleSHA256Pair(left, right); got != want {
+			t.Errorf("DoubleSHA256Pair(%d, %d bytes) = %x, want %x", n[0], n[1], got, want)
+		}
+	}
+}
+
 func TestReverseBytes(t *testing.T) {
 	input := []byte{0x01, 0x02, 0x03, 0x04}
 	result := ReverseBytes(input)
@@ -65,12 +78,98 @@ func TestCompactToTarget(t *testing.T) {
 	}
 }
 
+func TestCompactToTargetChecked(t *testing.T) {
+	regtest := CompactToTarget(0x207fffff)
+	mainnet := CompactToTarget(0x1d00ffff)
+	tests := []struct {
+		name    string
+		compact uint32
+		limit   *big.Int
+		wantErr error
+	}{
+		{"difficulty 1", 0x1d00ffff, regtest, nil},
+		{"regtest limit", 0x207fffff, regtest, nil},
+		{"zero", 0x00000000, regtest, nil},
+		{"negative", 0x1d80ffff, regtest, ErrNegativeTarget},
+		{"negative small", 0x03800001, regtest, ErrNegativeTarget},
+		{"above regtest limit", 0x21008000, regtest, ErrTargetTooLarge},
+		{"256-bit overflow", 0x22010000, regtest, ErrTargetTooLarge},
+		{"max exponent", 0xff7fffff, regtest, ErrTargetTooLarge},
+		{"mainnet limit", 0x1d00ffff, mainnet, nil},
+		{"above mainnet limit", 0x1d010000, mainnet, ErrTargetTooLarge},
+		{"regtest target on mainnet", 0x207fffff, mainnet, ErrTargetTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := CompactToTargetChecked(tt.compact, tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CompactToTargetChecked(0x%08x) error = %v, want %v", tt.compact, err, tt.wantErr)
+			}
+			if err == nil && target.Cmp(CompactToTarget(tt.compact)) != 0 {
+				t.Errorf("CompactToTargetChecked(0x%08x) = %x, want %x", tt.compact, target, CompactToTarget(tt.compact))
+			}
+		})
+	}
+}
+
+func TestIsCanonicalCompact(t *testing.T) {
+	tests := []struct {
+		compact uint32
+		want    bool
+	}{
+		{0x1d00ffff, true},
+		{0x207fffff, true},
+		{0x00000000, true},
+		{0x02008000, true},  // 0x80 needs a leading zero byte
+		{0x01800000, false}, // same target with the high bit as a sign
+		{0x1d80ffff, false}, // negative
+		{0x1e0000ff, false}, // redundant leading zero byte, 0x1d00ff00
+		{0x1d000fff, false}, // unnormalized mantissa
+		{0x04000000, false}, // zero mantissa with nonzero exponent
+		{0x01003456, false}, // mantissa shifted out entirely
+		{0x22010000, false}, // 2^264, past 256 bits
+		{0xff7fffff, false}, // exponent far past 256 bits
+	}
+
+	for _, tt := range tests {
+		if got := IsCanonicalCompact(tt.compact); got != tt.want {
+			t.Errorf("IsCanonicalCompact(0x%08x) = %v, want %v", tt.compact, got, tt.want)
+		}
+	}
+}
+
+func TestTargetToCompact(t *testing.T) {
+	// Expected values match Bitcoin Core's arith_uint256::GetCompact, which
+	// left-aligns targets of three bytes or fewer in the mantissa.
+	tests := []struct {
+		target int64
+		want   uint32
+	}{
+		{0, 0x00000000},
+		{0x12, 0x01120000},
+		{0x7f, 0x017f0000},
+		{0x80, 0x02008000},
+		{0x1234, 0x02123400},
+		{0x123456, 0x03123456},
+		{0x12345678, 0x04123456},
+	}
+
+	for _, tt := range tests {
+		if got := TargetToCompact(big.NewInt(tt.target)); got != tt.want {
+			t.Errorf("TargetToCompact(0x%x) = 0x%08x, want 0x%08x", tt.target, got, tt.want)
+		}
+	}
+}
+
 func TestCompactRoundTrip(t *testing.T) {
 	tests := []uint32{
 		0x1d00ffff, // testnet
 		0x03123456,
 		0x04123456,
 		0x1b0404cb, // some mainnet difficulty
+		0x01120000, // one-byte target
+		0x02008000, // one-byte target with the high bit set
 	}
 
 	for _, compact := range tests {
@@ -123,3 +222,11 @@ func TestHashMeetsTarget(t *testing.T) {
 		t.Error("Max hash should not meet target")
 	}
 }
+
+func BenchmarkDoubleSHA256Pair(b *testing.B) {
+	left, right := make([]byte, 32), make([]byte, 32)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		DoubleSHA256Pair(left, right)
+	}
+}
@@ -0,0 +1,85 @@
+package testutil
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/djkazic/p2pool-go/internal/types"
+	"github.com/djkazic/p2pool-go/pkg/util"
+)
+
+// RegtestNetwork is the network MineShareChain builds shares for.
+var RegtestNetwork = types.RegTestParams
+
+const (
+	// RegtestMinerAddress is a valid regtest P2WPKH address used as the
+	// miner of mined test shares.
+	RegtestMinerAddress = "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"
+	// RegtestBits is regtest's proof-of-work limit in compact form; about
+	// half of all hashes meet it.
+	RegtestBits = 0x207fffff
+)
+
+// RegtestTarget returns the regtest proof-of-work limit as a target.
+func RegtestTarget() *big.Int {
+	return util.CompactToTarget(RegtestBits)
+}
+
+// MineShare brute-forces the header's nonce until its hash meets target,
+// bumping the timestamp if the nonce space is exhausted. Only practical
+// for easy targets such as RegtestTarget.
+func MineShare(header types.ShareHeader, target *big.Int) types.ShareHeader {
+	for {
+		for nonce := uint32(0); ; nonce++ {
+			header.Nonce = nonce
+			if util.HashMeetsTarget(header.Hash(), target) {
+				return header
+			}
+			if nonce == ^uint32(0) {
+				break
+			}
+		}
+		header.Timestamp++
+	}
+}
+
+// MineShareChain mines a linear chain of n regtest shares, 30 seconds
+// apart and ending near the current time. Each share commits to its
+// parent in a real coinbase paying RegtestMinerAddress, and its merkle
+// root is that coinbase's txid, so the shares pass sharechain validation
+// and can be reconstructed into blocks against a template with no other
+// transactions.
+func MineShareChain(n int) []*types.Share {
+	target := RegtestTarget()
+	builder := types.NewCoinbaseBuilder(RegtestNetwork)
+	start := uint32(time.Now().Add(-time.Duration(n) * 30 * time.Second).Unix())
+
+	shares := make([]*types.Share, 0, n)
+	var prevHash [32]byte
+	for i := 0; i < n; i++ {
+		payouts := []types.PayoutEntry{{Address: RegtestMinerAddress, Amount: 5000000000}}
+		coinbaseTx, _, err := builder.BuildCoinbase(int64(i+1), types.BuildShareCommitment(prevHash), payouts, "", 8)
+		if err != nil {
+			panic("MineShareChain: BuildCoinbase failed: " + err.Error())
+		}
+
+		header := MineShare(types.ShareHeader{
+			Version:    536870912,
+			MerkleRoot: util.DoubleSHA256(coinbaseTx),
+			Timestamp:  start + uint32(i*30),
+			Bits:       RegtestBits,
+		}, target)
+
+		s := &types.Share{
+			Header:        header,
+			ShareVersion:  1,
+			PrevShareHash: prevHash,
+			ShareTarget:   target,
+			MinerAddress:  RegtestMinerAddress,
+			CoinbaseTx:    coinbaseTx,
+		}
+		shares = append(shares, s)
+		prevHash = s.Hash()
+	}
+	return shares
+}